@@ -1,14 +1,82 @@
 package bucket
 
 import (
+	"mime"
+	"strings"
 	"time"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/config"
+	"golang.org/x/xerrors"
 )
 
 var (
 	PresignURLLifetime = 10 * time.Minute
+	// DownloadURLLifetime は非公開のbucketからダウンロードするためのURLの有効期限
+	DownloadURLLifetime = 1 * time.Minute
+	// MultipartURLLifetime はmultipart uploadの各部分をuploadするURLの有効期限。全部送り終わるまで使うので長めにする
+	MultipartURLLifetime = 1 * time.Hour
 )
 
+// MaxMultipartParts はmultipart uploadで分割できる数の上限
+const MaxMultipartParts = 10000
+
+// Object はbucketに置かれているファイル
+type Object struct {
+	Key          string    `json:"key"`
+	URL          string    `json:"url"` // GeneratePresignedURLが返すdownloadURLと同じ形
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
 type Bucket interface {
 	CreateBucket() error
 	GeneratePresignedURL(key string) (string, map[string]string, string, error) // presignedURL, multipart-form, downloadURL, error
+	List() ([]Object, error)
+	Delete(key string) error
+	// GeneratePresignedGetURL はkeyをfilenameとしてダウンロードさせる期限付きのURLを作る。非公開のbucketで使う
+	GeneratePresignedGetURL(key, filename string) (string, error)
+	// KeyFromURL はGeneratePresignedURLが返したdownloadURLからkeyを取り出す
+	KeyFromURL(url string) (string, bool)
+}
+
+// Part はmultipart uploadでuploadした1つの部分
+type Part struct {
+	Number int    `json:"partNumber"`
+	ETag   string `json:"etag"`
+}
+
+// MultipartBucket は大きなファイルを分割してuploadできるbucket
+type MultipartBucket interface {
+	Bucket
+	CreateMultipartUpload(key string) (string, error) // uploadID
+	GeneratePresignedPartURL(key, uploadID string, number int) (string, error)
+	CompleteMultipartUpload(key, uploadID string, parts []Part) (string, error) // downloadURL
+	AbortMultipartUpload(key, uploadID string) error
+}
+
+// contentDisposition はfilenameとしてダウンロードさせるContent-Dispositionの値
+func contentDisposition(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
+
+// trimKeyURL はprefixの後ろをkeyとして取り出す
+func trimKeyURL(u, prefix string) (string, bool) {
+	if !strings.HasPrefix(u, prefix) {
+		return "", false
+	}
+	key := strings.TrimPrefix(u, prefix)
+	return key, key != ""
+}
+
+// New は設定に応じたbucketを作る
+func New(c *config.BucketConfig) (Bucket, error) {
+	switch c.Type {
+	case "S3":
+		return NewS3Bucket(c.Name, c.Endpoint, c.Region, c.AccessKey, c.SecretKey, c.Insecure, c.Private)
+	case "GCS":
+		return NewGCSBucket(c.Name, c.Endpoint, c.Region, c.Insecure, c.Private)
+	case "LOCAL":
+		return NewLocalBucket(c.Dir, c.PublicURL, c.SecretKey, c.Private)
+	}
+	return nil, xerrors.Errorf("unknown bucket type: %s", c.Type)
 }
@@ -28,11 +28,13 @@ type gcsBucket struct {
 	endpoint   string
 	region     string
 	insecure   bool
+	private    bool
 	client     *storage.Client
 	creds      credential
 }
 
-func NewGCSBucket(bucketName, endpoint, region string, insecure bool) (Bucket, error) {
+// privateなら誰でも読めるようにはせず、ダウンロードは期限付きのURLで行う
+func NewGCSBucket(bucketName, endpoint, region string, insecure, private bool) (Bucket, error) {
 	ctx := context.Background()
 
 	credFile := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
@@ -55,6 +57,7 @@ func NewGCSBucket(bucketName, endpoint, region string, insecure bool) (Bucket, e
 		endpoint:   endpoint,
 		region:     region,
 		insecure:   insecure,
+		private:    private,
 		client:     client,
 		creds:      creds,
 	}, nil
@@ -87,7 +90,13 @@ func (b *gcsBucket) CreateBucket() error {
 	if err != nil {
 		return xerrors.Errorf(": %w", err)
 	}
-	if !policy.HasRole(iam.AllUsers, objectReader) {
+	if b.private && policy.HasRole(iam.AllUsers, objectReader) {
+		// 以前publicで作ったbucketでも読めないようにする
+		policy.Remove(iam.AllUsers, objectReader)
+		if err := iamHandler.SetPolicy(context.Background(), policy); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+	} else if !b.private && !policy.HasRole(iam.AllUsers, objectReader) {
 		policy.Add(iam.AllUsers, objectReader)
 		if err := iamHandler.SetPolicy(context.Background(), policy); err != nil {
 			return xerrors.Errorf(": %w", err)
@@ -112,6 +121,57 @@ func (b *gcsBucket) GeneratePresignedURL(key string) (string, map[string]string,
 	return postPolicy.URL, postPolicy.Fields, b.buildKeyURL(key), nil
 }
 
+func (b *gcsBucket) GeneratePresignedGetURL(key, filename string) (string, error) {
+	url, err := storage.SignedURL(b.bucketName, key, &storage.SignedURLOptions{
+		GoogleAccessID: b.creds.Email,
+		PrivateKey:     []byte(b.creds.PrivateKey),
+		Method:         "GET",
+		Expires:        time.Now().Add(DownloadURLLifetime),
+		Scheme:         storage.SigningSchemeV4,
+		Insecure:       b.insecure,
+		Hostname:       b.endpoint,
+		QueryParameters: map[string][]string{
+			"response-content-disposition": {contentDisposition(filename)},
+		},
+	})
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return url, nil
+}
+
+func (b *gcsBucket) KeyFromURL(url string) (string, bool) {
+	return trimKeyURL(url, b.buildKeyURL(""))
+}
+
+func (b *gcsBucket) List() ([]Object, error) {
+	objects := make([]Object, 0)
+	it := b.client.Bucket(b.bucketName).Objects(context.Background(), nil)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+		objects = append(objects, Object{
+			Key:          attrs.Name,
+			URL:          b.buildKeyURL(attrs.Name),
+			Size:         attrs.Size,
+			LastModified: attrs.Updated,
+		})
+	}
+	return objects, nil
+}
+
+func (b *gcsBucket) Delete(key string) error {
+	if err := b.client.Bucket(b.bucketName).Object(key).Delete(context.Background()); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
 func (b *gcsBucket) buildEndpoint() string {
 	if b.insecure {
 		return "http://" + b.endpoint
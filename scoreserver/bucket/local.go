@@ -0,0 +1,339 @@
+package bucket
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// LocalBucketPath はLocalBucketのファイルを配信するpath
+	LocalBucketPath = "/files/"
+
+	localBlobDir = "blobs"
+	localKeyDir  = "keys"
+)
+
+var (
+	// LocalMaxUploadSize はLocalBucketに一度にuploadできるファイルの大きさ
+	LocalMaxUploadSize int64 = 1 << 30
+)
+
+// LocalBucket は添付ファイルをdisk上に置き、server自身が配信するbucket
+// ファイルの中身はsha256の名前で blobs/ に置き、keyからsha256への対応を keys/ に置く
+type LocalBucket struct {
+	dir     string
+	baseURL string
+	secret  []byte
+	private bool // trueならダウンロードにも署名付きのURLが必要
+
+	// 同じ中身を指すkeyの追加と削除が競合してblobを消さないようにする
+	mu sync.Mutex
+}
+
+// NewLocalBucket はdirにファイルを置くbucketを作る
+// baseURLはserverの公開URLで、uploadとdownloadのURLはこれを元に作る
+// secretが空ならランダムな値でupload URLに署名する
+func NewLocalBucket(dir, baseURL, secret string, private bool) (*LocalBucket, error) {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+	}
+	return &LocalBucket{
+		dir:     dir,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		secret:  key,
+		private: private,
+	}, nil
+}
+
+func (b *LocalBucket) CreateBucket() error {
+	for _, d := range []string{localBlobDir, localKeyDir} {
+		if err := os.MkdirAll(filepath.Join(b.dir, d), 0755); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+	}
+	return nil
+}
+
+func (b *LocalBucket) GeneratePresignedURL(key string) (string, map[string]string, string, error) {
+	if !validLocalKey(key) {
+		return "", nil, "", xerrors.Errorf("invalid key: %s", key)
+	}
+	expires := time.Now().Add(PresignURLLifetime).Unix()
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("signature", b.sign(http.MethodPut, key, "", expires))
+	return b.keyURL(key) + "?" + q.Encode(), nil, b.keyURL(key), nil
+}
+
+func (b *LocalBucket) GeneratePresignedGetURL(key, filename string) (string, error) {
+	if !validLocalKey(key) {
+		return "", xerrors.Errorf("invalid key: %s", key)
+	}
+	expires := time.Now().Add(DownloadURLLifetime).Unix()
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("filename", filename)
+	q.Set("signature", b.sign(http.MethodGet, key, filename, expires))
+	return b.keyURL(key) + "?" + q.Encode(), nil
+}
+
+func (b *LocalBucket) KeyFromURL(u string) (string, bool) {
+	key, ok := trimKeyURL(u, b.baseURL+LocalBucketPath)
+	if !ok {
+		return "", false
+	}
+	key, err := url.PathUnescape(key)
+	if err != nil || !validLocalKey(key) {
+		return "", false
+	}
+	return key, true
+}
+
+func (b *LocalBucket) keyURL(key string) string {
+	return b.baseURL + LocalBucketPath + escapeKey(key)
+}
+
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+func (b *LocalBucket) sign(method, key, filename string, expires int64) string {
+	mac := hmac.New(sha256.New, b.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%d", method, key, filename, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (b *LocalBucket) verify(method, key string, q url.Values) bool {
+	e, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > e {
+		return false
+	}
+	return hmac.Equal([]byte(b.sign(method, key, q.Get("filename"), e)), []byte(q.Get("signature")))
+}
+
+// validLocalKey はdirの外を指すようなkeyを弾く
+func validLocalKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") || strings.ContainsRune(key, 0) {
+		return false
+	}
+	return path.Clean(key) == key && !strings.HasPrefix(key, "../") && key != ".."
+}
+
+func (b *LocalBucket) keyPath(key string) string {
+	return filepath.Join(b.dir, localKeyDir, filepath.FromSlash(key))
+}
+
+func (b *LocalBucket) blobPath(hash string) string {
+	return filepath.Join(b.dir, localBlobDir, hash)
+}
+
+// ServeHTTP はPUTでのupload（署名付きURL）とGETでのdownloadを受け付ける
+func (b *LocalBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), LocalBucketPath))
+	if err != nil || !validLocalKey(key) {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		name := path.Base(key)
+		if b.private {
+			// 非公開のときは署名がなければ存在しないものとして扱う
+			if !b.verify(http.MethodGet, key, r.URL.Query()) {
+				http.NotFound(w, r)
+				return
+			}
+			if f := r.URL.Query().Get("filename"); f != "" {
+				name = f
+			}
+		}
+		b.serveFile(w, r, key, name)
+	case http.MethodPut:
+		if !b.verify(http.MethodPut, key, r.URL.Query()) {
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return
+		}
+		hash, err := b.put(key, http.MaxBytesReader(w, r.Body, LocalMaxUploadSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// uploadした側が中身を確かめられるようにsha256を返す
+		w.Header().Set("ETag", `"`+hash+`"`)
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// put は中身をsha256の名前で保存し、keyからそれを指すようにする
+// 同じ中身のファイルは一つしか置かない
+func (b *LocalBucket) put(key string, r io.Reader) (string, error) {
+	tmp, err := ioutil.TempFile(filepath.Join(b.dir, localBlobDir), ".upload-")
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := os.Rename(tmp.Name(), b.blobPath(hash)); err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+
+	p := b.keyPath(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	if err := ioutil.WriteFile(p, []byte(hash), 0644); err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return hash, nil
+}
+
+// walkKeys は全てのkeyとそれが指す中身のsha256をfnに渡す
+func (b *LocalBucket) walkKeys(fn func(key, hash string, info os.FileInfo) error) error {
+	root := filepath.Join(b.dir, localKeyDir)
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		hash, err := ioutil.ReadFile(p)
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		return fn(filepath.ToSlash(rel), string(hash), info)
+	})
+}
+
+func (b *LocalBucket) List() ([]Object, error) {
+	objects := make([]Object, 0)
+	err := b.walkKeys(func(key, hash string, info os.FileInfo) error {
+		var size int64
+		if stat, err := os.Stat(b.blobPath(hash)); err == nil {
+			size = stat.Size()
+		}
+		objects = append(objects, Object{
+			Key:          key,
+			URL:          b.keyURL(key),
+			Size:         size,
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return objects, nil
+}
+
+// Delete はkeyを消し、他のkeyから指されなくなった中身も消す
+func (b *LocalBucket) Delete(key string) error {
+	if !validLocalKey(key) {
+		return xerrors.Errorf("invalid key: %s", key)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p := b.keyPath(key)
+	hash, err := ioutil.ReadFile(p)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if err := os.Remove(p); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	// 空になったディレクトリも消す。空でなければRemoveが失敗するのでそこで止める
+	root := filepath.Join(b.dir, localKeyDir)
+	for d := filepath.Dir(p); d != root; d = filepath.Dir(d) {
+		if err := os.Remove(d); err != nil {
+			break
+		}
+	}
+
+	referenced := false
+	err = b.walkKeys(func(_, h string, _ os.FileInfo) error {
+		if h == string(hash) {
+			referenced = true
+		}
+		return nil
+	})
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if referenced {
+		return nil
+	}
+	if err := os.Remove(b.blobPath(string(hash))); err != nil && !os.IsNotExist(err) {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+func (b *LocalBucket) serveFile(w http.ResponseWriter, r *http.Request, key, name string) {
+	hash, err := ioutil.ReadFile(b.keyPath(key))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(b.blobPath(string(hash)))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+string(hash)+`"`)
+	w.Header().Set("Content-Disposition", contentDisposition(name))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// RangeやIf-None-Matchの処理はServeContentに任せる
+	http.ServeContent(w, r, name, stat.ModTime(), f)
+}
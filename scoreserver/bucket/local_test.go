@@ -0,0 +1,239 @@
+package bucket
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestLocalBucket(t *testing.T, private bool) (*LocalBucket, *httptest.Server) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	b, err := NewLocalBucket(t.TempDir(), srv.URL, "secret", private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CreateBucket(); err != nil {
+		t.Fatal(err)
+	}
+	mux.Handle(LocalBucketPath, b)
+	return b, srv
+}
+
+func put(t *testing.T, url string, body []byte) *http.Response {
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestLocalBucket(t *testing.T) {
+	b, _ := newTestLocalBucket(t, false)
+	content := []byte("hello, kosenctfx")
+
+	uploadURL, form, downloadURL, err := b.GeneratePresignedURL("abc/distfiles 1.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form != nil {
+		t.Errorf("form data should be nil to upload with PUT, got %v", form)
+	}
+	resp := put(t, uploadURL, content)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload failed: %s", resp.Status)
+	}
+
+	// 中身のsha256の名前で保存され、ETagとしても返る
+	blobs, err := ioutil.ReadDir(filepath.Join(b.dir, localBlobDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	if len(blobs) != 1 || blobs[0].Name() != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected blobs: %v", blobs)
+	}
+	if etag := resp.Header.Get("ETag"); etag != `"`+hex.EncodeToString(sum[:])+`"` {
+		t.Errorf("unexpected ETag: %s", etag)
+	}
+
+	resp, err = http.Get(downloadURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Fatalf("unexpected download: %s %q", resp.Status, body)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename="distfiles 1.tar.gz"` {
+		t.Errorf("unexpected Content-Disposition: %s", cd)
+	}
+	if resp.Header.Get("ETag") == "" {
+		t.Errorf("ETag should be set")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, downloadURL, nil)
+	req.Header.Set("Range", "bytes=7-")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "kosenctfx" {
+		t.Errorf("unexpected ranged download: %s %q", resp.Status, body)
+	}
+}
+
+func TestLocalBucketRejectsInvalidUpload(t *testing.T) {
+	b, srv := newTestLocalBucket(t, false)
+
+	uploadURL, _, _, err := b.GeneratePresignedURL("abc/flag.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 別のkeyには同じ署名は使えない
+	tampered := strings.Replace(uploadURL, "flag.txt", "other.txt", 1)
+	if resp := put(t, tampered, []byte("x")); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("tampered upload should be forbidden, got %s", resp.Status)
+	}
+	if resp := put(t, srv.URL+LocalBucketPath+"abc/flag.txt", []byte("x")); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unsigned upload should be forbidden, got %s", resp.Status)
+	}
+
+	if _, _, _, err := b.GeneratePresignedURL("../etc/passwd"); err == nil {
+		t.Errorf("key outside of the bucket should be rejected")
+	}
+	resp, err := http.Get(srv.URL + LocalBucketPath + "%2e%2e/secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("path traversal should be not found, got %s", resp.Status)
+	}
+	if _, err := os.Stat(filepath.Join(b.dir, localKeyDir, "abc")); !os.IsNotExist(err) {
+		t.Errorf("nothing should be stored: %v", err)
+	}
+}
+
+func TestLocalBucketListAndDelete(t *testing.T) {
+	b, _ := newTestLocalBucket(t, false)
+
+	// 同じ中身を2つのkeyでuploadする
+	for _, key := range []string{"a/dist.tar.gz", "b/dist.tar.gz"} {
+		uploadURL, _, _, err := b.GeneratePresignedURL(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp := put(t, uploadURL, []byte("same")); resp.StatusCode != http.StatusOK {
+			t.Fatalf("upload failed: %s", resp.Status)
+		}
+	}
+
+	objects, err := b.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].Key != "a/dist.tar.gz" || objects[0].Size != 4 || objects[0].URL != b.keyURL("a/dist.tar.gz") {
+		t.Fatalf("unexpected objects: %+v", objects)
+	}
+
+	blobs := func() int {
+		fs, err := ioutil.ReadDir(filepath.Join(b.dir, localBlobDir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(fs)
+	}
+
+	// まだ他のkeyから指されているので中身は残る
+	if err := b.Delete("a/dist.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if n := blobs(); n != 1 {
+		t.Errorf("blob should be kept while referenced, got %d blobs", n)
+	}
+	if _, err := os.Stat(filepath.Join(b.dir, localKeyDir, "a")); !os.IsNotExist(err) {
+		t.Errorf("empty directory should be removed: %v", err)
+	}
+
+	if err := b.Delete("b/dist.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if n := blobs(); n != 0 {
+		t.Errorf("blob should be removed, got %d blobs", n)
+	}
+	if objects, _ := b.List(); len(objects) != 0 {
+		t.Errorf("no objects should remain: %+v", objects)
+	}
+}
+
+func TestPrivateLocalBucket(t *testing.T) {
+	b, _ := newTestLocalBucket(t, true)
+
+	uploadURL, _, downloadURL, err := b.GeneratePresignedURL("abc/flag.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := put(t, uploadURL, []byte("secret")); resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload failed: %s", resp.Status)
+	}
+
+	// URLを推測しても読めない
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unsigned download should be not found, got %s", resp.Status)
+	}
+
+	key, ok := b.KeyFromURL(downloadURL)
+	if !ok || key != "abc/flag.txt" {
+		t.Fatalf("unexpected key: %s %v", key, ok)
+	}
+	getURL, err := b.GeneratePresignedGetURL(key, "chall.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.Get(getURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "secret" {
+		t.Fatalf("unexpected download: %s %q", resp.Status, body)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename=chall.txt` {
+		t.Errorf("unexpected Content-Disposition: %s", cd)
+	}
+
+	// 署名はfilenameも含む
+	tampered := strings.Replace(getURL, "chall.txt", "other.txt", 1)
+	resp, err = http.Get(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("tampered download should be not found, got %s", resp.Status)
+	}
+}
@@ -2,6 +2,7 @@ package bucket
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -21,11 +22,13 @@ type s3Bucket struct {
 	endpoint   string
 	region     string
 	insecure   bool
+	private    bool
 	accessKey  string
 	secretKey  string
 }
 
-func NewS3Bucket(bucketName, endpoint, region, accessKey, secretKey string, insecure bool) (Bucket, error) {
+// privateなら誰でも読めるbucket policyを付けず、ダウンロードは期限付きのURLで行う
+func NewS3Bucket(bucketName, endpoint, region, accessKey, secretKey string, insecure, private bool) (Bucket, error) {
 	return &s3Bucket{
 		bucketName: bucketName,
 		endpoint:   endpoint,
@@ -33,11 +36,11 @@ func NewS3Bucket(bucketName, endpoint, region, accessKey, secretKey string, inse
 		accessKey:  accessKey,
 		secretKey:  secretKey,
 		insecure:   insecure,
+		private:    private,
 	}, nil
 }
 
-// TODO CORS
-func (b *s3Bucket) CreateBucket() error {
+func (b *s3Bucket) client() (*s3.S3, error) {
 	cred := credentials.NewStaticCredentials(b.accessKey, b.secretKey, "")
 	s, err := session.NewSession(&aws.Config{
 		Region:           &b.region,
@@ -47,10 +50,17 @@ func (b *s3Bucket) CreateBucket() error {
 		S3ForcePathStyle: aws.Bool(true),
 	})
 	if err != nil {
-		return xerrors.Errorf(": %w", err)
+		return nil, xerrors.Errorf(": %w", err)
 	}
+	return s3.New(s), nil
+}
 
-	svc := s3.New(s)
+// TODO CORS
+func (b *s3Bucket) CreateBucket() error {
+	svc, err := b.client()
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
 
 	// BucketのObjectをListしてみて、Bucketが存在するか、存在するならアクセス権があるかどうかを確認する
 	isBucketExist := false
@@ -76,6 +86,20 @@ func (b *s3Bucket) CreateBucket() error {
 		}
 	}
 
+	// 以前publicで作ったbucketでもpolicyを外して読めないようにする
+	if b.private {
+		_, err = svc.DeleteBucketPolicy(&s3.DeleteBucketPolicyInput{
+			Bucket: &b.bucketName,
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchBucketPolicy" {
+			err = nil
+		}
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		return nil
+	}
+
 	_, err = svc.PutBucketPolicy(&s3.PutBucketPolicyInput{
 		Bucket: &b.bucketName,
 		Policy: aws.String(fmt.Sprintf(s3BucketPolicyTemplate, b.bucketName)),
@@ -87,19 +111,10 @@ func (b *s3Bucket) CreateBucket() error {
 }
 
 func (b *s3Bucket) GeneratePresignedURL(key string) (string, map[string]string, string, error) {
-	cred := credentials.NewStaticCredentials(b.accessKey, b.secretKey, "")
-	s, err := session.NewSession(&aws.Config{
-		Region:           &b.region,
-		Endpoint:         b.buildEndpoint(),
-		Credentials:      cred,
-		DisableSSL:       &b.insecure,
-		S3ForcePathStyle: aws.Bool(true),
-	})
+	svc, err := b.client()
 	if err != nil {
 		return "", nil, "", xerrors.Errorf(": %w", err)
 	}
-
-	svc := s3.New(s)
 	req, _ := svc.PutObjectRequest(&s3.PutObjectInput{
 		Bucket: &b.bucketName,
 		Key:    &key,
@@ -112,6 +127,142 @@ func (b *s3Bucket) GeneratePresignedURL(key string) (string, map[string]string,
 	return url, nil, b.buildKeyURL(key), nil
 }
 
+func (b *s3Bucket) GeneratePresignedGetURL(key, filename string) (string, error) {
+	svc, err := b.client()
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket:                     &b.bucketName,
+		Key:                        &key,
+		ResponseContentDisposition: aws.String(contentDisposition(filename)),
+	})
+	url, err := req.Presign(DownloadURLLifetime)
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return url, nil
+}
+
+func (b *s3Bucket) KeyFromURL(url string) (string, bool) {
+	return trimKeyURL(url, b.buildKeyURL(""))
+}
+
+func (b *s3Bucket) List() ([]Object, error) {
+	svc, err := b.client()
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+
+	objects := make([]Object, 0)
+	err = svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucketName),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			objects = append(objects, Object{
+				Key:          aws.StringValue(o.Key),
+				URL:          b.buildKeyURL(aws.StringValue(o.Key)),
+				Size:         aws.Int64Value(o.Size),
+				LastModified: aws.TimeValue(o.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return objects, nil
+}
+
+func (b *s3Bucket) Delete(key string) error {
+	svc, err := b.client()
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if _, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucketName),
+		Key:    aws.String(key),
+	}); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+func (b *s3Bucket) CreateMultipartUpload(key string) (string, error) {
+	svc, err := b.client()
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	out, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(b.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return aws.StringValue(out.UploadId), nil
+}
+
+func (b *s3Bucket) GeneratePresignedPartURL(key, uploadID string, number int) (string, error) {
+	svc, err := b.client()
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	req, _ := svc.UploadPartRequest(&s3.UploadPartInput{
+		Bucket:     aws.String(b.bucketName),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(int64(number)),
+	})
+	url, err := req.Presign(MultipartURLLifetime)
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return url, nil
+}
+
+func (b *s3Bucket) CompleteMultipartUpload(key, uploadID string, parts []Part) (string, error) {
+	svc, err := b.client()
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	// S3は部分の番号順に並んでいないと受け付けない
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].Number < parts[j].Number
+	})
+	completed := make([]*s3.CompletedPart, 0, len(parts))
+	for _, p := range parts {
+		completed = append(completed, &s3.CompletedPart{
+			ETag:       aws.String(p.ETag),
+			PartNumber: aws.Int64(int64(p.Number)),
+		})
+	}
+	if _, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(b.bucketName),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	}); err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return b.buildKeyURL(key), nil
+}
+
+func (b *s3Bucket) AbortMultipartUpload(key, uploadID string) error {
+	svc, err := b.client()
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if _, err := svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(b.bucketName),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	}); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
 func (b *s3Bucket) buildEndpoint() *string {
 	if b.insecure {
 		return aws.String("http://" + b.endpoint)
@@ -0,0 +1,91 @@
+package bucket
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+var (
+	// TransferTimeout はPut, Getで1つのobjectを送受信するのにかける時間の上限
+	TransferTimeout = 5 * time.Minute
+)
+
+// Put はbucketのupload URLを使ってkeyにdataを置き、downloadURLを返す。kosenctfx-cliと同じ手順
+func Put(b Bucket, key string, data []byte) (string, error) {
+	uploadURL, form, downloadURL, err := b.GeneratePresignedURL(key)
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+
+	var req *http.Request
+	if form == nil {
+		req, err = http.NewRequest(http.MethodPut, uploadURL, bytes.NewReader(data))
+		if err != nil {
+			return "", xerrors.Errorf(": %w", err)
+		}
+	} else {
+		body := new(bytes.Buffer)
+		mw := multipart.NewWriter(body)
+		for k, v := range form {
+			if err := mw.WriteField(k, v); err != nil {
+				return "", xerrors.Errorf(": %w", err)
+			}
+		}
+		fw, err := mw.CreateFormFile("file", path.Base(key))
+		if err != nil {
+			return "", xerrors.Errorf(": %w", err)
+		}
+		if _, err := fw.Write(data); err != nil {
+			return "", xerrors.Errorf(": %w", err)
+		}
+		if err := mw.Close(); err != nil {
+			return "", xerrors.Errorf(": %w", err)
+		}
+		req, err = http.NewRequest(http.MethodPost, uploadURL, body)
+		if err != nil {
+			return "", xerrors.Errorf(": %w", err)
+		}
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+	}
+
+	client := &http.Client{Timeout: TransferTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1000))
+		return "", xerrors.Errorf("failed to upload %s: %s %s", key, resp.Status, string(msg))
+	}
+	return downloadURL, nil
+}
+
+// Get はkeyの中身を期限付きのURLで取ってくる。非公開のbucketでも使える
+func Get(b Bucket, key string) ([]byte, error) {
+	url, err := b.GeneratePresignedGetURL(key, path.Base(key))
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	client := &http.Client{Timeout: TransferTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("failed to download %s: %s", key, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return data, nil
+}
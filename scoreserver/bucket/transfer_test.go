@@ -0,0 +1,27 @@
+package bucket
+
+import (
+	"testing"
+)
+
+func TestPutAndGet(t *testing.T) {
+	b, _ := newTestLocalBucket(t, true)
+
+	downloadURL, err := Put(b, "variants/1/2/dist.tar.gz", []byte("team 2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if downloadURL != b.keyURL("variants/1/2/dist.tar.gz") {
+		t.Errorf("unexpected download url: %s", downloadURL)
+	}
+	data, err := Get(b, "variants/1/2/dist.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "team 2" {
+		t.Errorf("unexpected content: %q", data)
+	}
+	if _, err := Get(b, "variants/1/3/dist.tar.gz"); err == nil {
+		t.Errorf("missing object should be an error")
+	}
+}
@@ -0,0 +1,706 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/xerrors"
+)
+
+// stdout は管理用のサブコマンドが結果を書き出す先。進捗やエラーはlogに出す
+var stdout io.Writer = os.Stdout
+
+// adminCommand は管理APIを呼ぶサブコマンドに共通のflagとclient
+type adminCommand struct {
+	fs     *flag.FlagSet
+	url    string
+	token  string
+	asJSON bool
+	client *resty.Client
+}
+
+func newAdminCommand(name, usage string) *adminCommand {
+	a := &adminCommand{fs: flag.NewFlagSet(name, flag.ExitOnError)}
+	a.fs.StringVar(&a.url, "url", profile.URL, "An endpoint of scoreserver")
+	a.fs.StringVar(&a.token, "token", "", "An administrative token (default: read from the profile)")
+	a.fs.BoolVar(&a.asJSON, "json", false, "print the result as JSON")
+	a.fs.Usage = func() {
+		fmt.Printf("Usage: %s %s %s\n", os.Args[0], name, usage)
+		a.fs.PrintDefaults()
+	}
+	return a
+}
+
+// parse はflagを読み、tokenがなければprofileから読む。必須のflagがなければusageを出してfalseを返す
+func (a *adminCommand) parse(args []string) (bool, error) {
+	a.fs.Parse(args)
+	token, err := resolveToken(a.url, a.token)
+	if err != nil {
+		return false, xerrors.Errorf(": %w", err)
+	}
+	a.token = token
+	if a.url == "" || a.token == "" {
+		a.fs.Usage()
+		return false, nil
+	}
+	a.url = strings.TrimSuffix(a.url, "/")
+	a.client = resty.New().SetAuthToken(a.token)
+	return true, nil
+}
+
+// apiError はサーバが返したエラーを {"message": ...} があればその文で返す
+func apiError(resp *resty.Response) error {
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(resp.Body(), &body); err == nil && body.Message != "" {
+		return xerrors.Errorf("%s: %s", resp.Status(), body.Message)
+	}
+	return xerrors.Errorf("%s: %s", resp.Status(), strings.TrimSpace(string(resp.Body())))
+}
+
+// responseMessage はレスポンスの {"message": ...} か、JSONの文字列そのものを返す
+func responseMessage(body []byte) string {
+	var m struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &m); err == nil && m.Message != "" {
+		return m.Message
+	}
+	var s string
+	if err := json.Unmarshal(body, &s); err == nil {
+		return s
+	}
+	return strings.TrimSpace(string(body))
+}
+
+func (a *adminCommand) do(req *resty.Request, method, p string) (*resty.Response, error) {
+	resp, err := req.Execute(method, a.url+p)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if resp.IsError() {
+		return nil, apiError(resp)
+	}
+	return resp, nil
+}
+
+func (a *adminCommand) get(p string, query map[string]string, result interface{}) (*resty.Response, error) {
+	req := a.client.R().SetQueryParams(query)
+	if result != nil {
+		req.SetResult(result)
+	}
+	return a.do(req, resty.MethodGet, p)
+}
+
+func (a *adminCommand) post(p string, body, result interface{}) (*resty.Response, error) {
+	req := a.client.R().SetBody(body)
+	if result != nil {
+		req.SetResult(result)
+	}
+	return a.do(req, resty.MethodPost, p)
+}
+
+// printJSON はサーバが返したJSONを整形してそのまま出す
+func printJSON(body []byte) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, body, "", "  "); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	buf.WriteByte('\n')
+	_, err := stdout.Write(buf.Bytes())
+	return err
+}
+
+func encodeJSON(v interface{}) error {
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// selectChallenges は名前のglobとcategoryで問題を選ぶ。どちらも指定されていれば両方に一致するもの
+func selectChallenges(chals []*service.Challenge, patterns []string, category string) ([]*service.Challenge, error) {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, xerrors.Errorf("invalid pattern %q", p)
+		}
+	}
+	selected := make([]*service.Challenge, 0)
+	for _, c := range chals {
+		if category != "" && !strings.EqualFold(c.Category, category) {
+			continue
+		}
+		matched := len(patterns) == 0
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, c.Name); ok {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			selected = append(selected, c)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].Name < selected[j].Name
+	})
+	return selected, nil
+}
+
+type openResult struct {
+	Name    string `json:"name"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func runOpen(args []string) error {
+	return runOpenClose("open", true, args)
+}
+
+func runClose(args []string) error {
+	return runOpenClose("close", false, args)
+}
+
+// runOpenClose は名前、glob、categoryで選んだ問題をまとめて公開または非公開にする
+func runOpenClose(name string, open bool, args []string) error {
+	var category string
+	var dryRun bool
+	a := newAdminCommand(name, "[name or glob...]")
+	a.fs.StringVar(&category, "category", "", "select challenges in this category")
+	a.fs.BoolVar(&dryRun, "dry-run", false, "only show the selected challenges")
+	if ok, err := a.parse(args); err != nil || !ok {
+		return err
+	}
+	patterns := a.fs.Args()
+	if len(patterns) == 0 && category == "" {
+		a.fs.Usage()
+		return nil
+	}
+
+	var chals []*service.Challenge
+	if _, err := a.get("/admin/list-challenges", nil, &chals); err != nil {
+		return xerrors.Errorf("failed to list challenges: %w", err)
+	}
+	selected, err := selectChallenges(chals, patterns, category)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if len(selected) == 0 {
+		return xerrors.Errorf("no challenge matches")
+	}
+	if !dryRun {
+		if err := confirmProduction(fmt.Sprintf("%s %d challenges", name, len(selected))); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+	}
+
+	results := make([]openResult, 0, len(selected))
+	failed := 0
+	state := "closed"
+	if open {
+		state = "opened"
+	}
+	for _, c := range selected {
+		r := openResult{Name: c.Name}
+		switch {
+		case c.IsOpen == open:
+			r.Message = "already " + state
+		case dryRun:
+			r.Message = "will be " + state
+		default:
+			resp, err := a.post("/admin/"+name+"-challenge", map[string]interface{}{"name": c.Name}, nil)
+			if err != nil {
+				failed++
+				r.Error = err.Error()
+			} else {
+				r.Message = responseMessage(resp.Body())
+			}
+		}
+		results = append(results, r)
+	}
+
+	if a.asJSON {
+		if err := encodeJSON(results); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+	} else {
+		for _, r := range results {
+			if r.Error != "" {
+				fmt.Fprintf(stdout, "[-] %s: %s\n", r.Name, r.Error)
+			} else {
+				fmt.Fprintf(stdout, "[+] %s: %s\n", r.Name, r.Message)
+			}
+		}
+	}
+	if failed > 0 {
+		return xerrors.Errorf("failed to %s %d challenges", name, failed)
+	}
+	return nil
+}
+
+// configの値の型。set-configは全ての値を置き換えるので、今の値を読んでから書き換えて送る
+var configKeys = map[string]string{
+	"ctf_name":      "string",
+	"start_at":      "time",
+	"end_at":        "time",
+	"score_expr":    "string",
+	"register_open": "bool",
+	"ctf_open":      "bool",
+	"lock_second":   "int",
+	"lock_duration": "int",
+	"lock_count":    "int",
+}
+
+func parseConfigValue(key, value string) (interface{}, error) {
+	switch configKeys[key] {
+	case "string":
+		return value, nil
+	case "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, xerrors.Errorf("%s must be true or false", key)
+		}
+		return b, nil
+	case "int":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("%s must be an integer", key)
+		}
+		return n, nil
+	case "time":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n, nil
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, xerrors.Errorf("%s must be unix time or RFC3339", key)
+		}
+		return t.Unix(), nil
+	}
+	keys := make([]string, 0, len(configKeys))
+	for k := range configKeys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return nil, xerrors.Errorf("unknown key %s. Available keys: %s", key, strings.Join(keys, ", "))
+}
+
+func formatConfigValue(key string, v interface{}) string {
+	if n, ok := v.(json.Number); ok && configKeys[key] == "time" {
+		if t, err := n.Int64(); err == nil {
+			return fmt.Sprintf("%d (%s)", t, time.Unix(t, 0).Format(time.RFC3339))
+		}
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func getConfig(a *adminCommand) (map[string]interface{}, []byte, error) {
+	resp, err := a.get("/admin/get-config", nil, nil)
+	if err != nil {
+		return nil, nil, xerrors.Errorf(": %w", err)
+	}
+	conf := make(map[string]interface{})
+	dec := json.NewDecoder(bytes.NewReader(resp.Body()))
+	dec.UseNumber()
+	if err := dec.Decode(&conf); err != nil {
+		return nil, nil, xerrors.Errorf(": %w", err)
+	}
+	return conf, resp.Body(), nil
+}
+
+func printConfig(a *adminCommand, conf map[string]interface{}, body []byte, keys []string) error {
+	if len(keys) == 0 {
+		for k := range conf {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+	if a.asJSON {
+		if len(keys) == len(conf) {
+			return printJSON(body)
+		}
+		selected := make(map[string]interface{})
+		for _, k := range keys {
+			selected[k] = conf[k]
+		}
+		return encodeJSON(selected)
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s\t%s\n", k, formatConfigValue(k, conf[k]))
+	}
+	return w.Flush()
+}
+
+// runConfig はCTFの設定を読み書きする
+func runConfig(args []string) error {
+	usage := func() {
+		fmt.Printf("Usage: %s config get [key...]\n", os.Args[0])
+		fmt.Printf("       %s config set key=value...\n", os.Args[0])
+	}
+	if len(args) == 0 {
+		usage()
+		return nil
+	}
+
+	switch args[0] {
+	case "get":
+		a := newAdminCommand("config get", "[key...]")
+		if ok, err := a.parse(args[1:]); err != nil || !ok {
+			return err
+		}
+		conf, body, err := getConfig(a)
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		for _, k := range a.fs.Args() {
+			if _, ok := conf[k]; !ok {
+				return xerrors.Errorf("unknown key %s", k)
+			}
+		}
+		if err := printConfig(a, conf, body, a.fs.Args()); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		return nil
+
+	case "set":
+		a := newAdminCommand("config set", "key=value...")
+		if ok, err := a.parse(args[1:]); err != nil || !ok {
+			return err
+		}
+		if a.fs.NArg() == 0 {
+			a.fs.Usage()
+			return nil
+		}
+		changes := make(map[string]interface{})
+		for _, kv := range a.fs.Args() {
+			kvs := strings.SplitN(kv, "=", 2)
+			if len(kvs) != 2 {
+				return xerrors.Errorf("%s is not key=value", kv)
+			}
+			v, err := parseConfigValue(kvs[0], kvs[1])
+			if err != nil {
+				return xerrors.Errorf(": %w", err)
+			}
+			changes[kvs[0]] = v
+		}
+
+		if err := confirmProduction("change the CTF config"); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		conf, _, err := getConfig(a)
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		for k, v := range changes {
+			conf[k] = v
+		}
+		if _, err := a.post("/admin/set-config", conf, nil); err != nil {
+			return xerrors.Errorf("failed to set config: %w", err)
+		}
+
+		conf, body, err := getConfig(a)
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		keys := make([]string, 0, len(changes))
+		for k := range changes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if err := printConfig(a, conf, body, keys); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		return nil
+	}
+	usage()
+	return nil
+}
+
+// adminTeam は/admin/teamsが返すチーム
+type adminTeam struct {
+	ID          uint32 `json:"id"`
+	Teamname    string
+	Email       string
+	CountryCode string
+	IsAdmin     bool
+	AdminRole   string
+}
+
+type adminSubmission struct {
+	ID          uint32 `json:"id"`
+	ChallengeId *uint32
+	IsCorrect   bool
+	IsValid     bool
+	Flag        string
+	IPAddress   string
+	SubmittedAt int64
+}
+
+// runTeams はチームの一覧や1つのチームの提出を見る
+func runTeams(args []string) error {
+	usage := func() {
+		fmt.Printf("Usage: %s teams list\n", os.Args[0])
+		fmt.Printf("       %s teams show teamname\n", os.Args[0])
+	}
+	if len(args) == 0 {
+		usage()
+		return nil
+	}
+
+	switch args[0] {
+	case "list":
+		a := newAdminCommand("teams list", "")
+		if ok, err := a.parse(args[1:]); err != nil || !ok {
+			return err
+		}
+		var teams []adminTeam
+		resp, err := a.get("/admin/teams", nil, &teams)
+		if err != nil {
+			return xerrors.Errorf("failed to list teams: %w", err)
+		}
+		if a.asJSON {
+			return printJSON(resp.Body())
+		}
+		sort.Slice(teams, func(i, j int) bool {
+			return teams[i].Teamname < teams[j].Teamname
+		})
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tEMAIL\tCOUNTRY\tADMIN")
+		for _, t := range teams {
+			role := ""
+			if t.IsAdmin {
+				role = t.AdminRole
+				if role == "" {
+					role = "superadmin"
+				}
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", t.ID, t.Teamname, t.Email, t.CountryCode, role)
+		}
+		return w.Flush()
+
+	case "show":
+		a := newAdminCommand("teams show", "teamname")
+		if ok, err := a.parse(args[1:]); err != nil || !ok {
+			return err
+		}
+		if a.fs.NArg() != 1 {
+			a.fs.Usage()
+			return nil
+		}
+		var team struct {
+			Teamname    string            `json:"teamname"`
+			TeamID      uint32            `json:"team_id"`
+			Email       string            `json:"email"`
+			Country     string            `json:"country"`
+			Submissions []adminSubmission `json:"submissions"`
+		}
+		resp, err := a.get("/admin/team", map[string]string{"team": a.fs.Arg(0)}, &team)
+		if err != nil {
+			return xerrors.Errorf("failed to get the team: %w", err)
+		}
+		if a.asJSON {
+			return printJSON(resp.Body())
+		}
+
+		// 問題名が引けなければIDのまま出す
+		names := make(map[uint32]string)
+		var chals []*service.Challenge
+		if _, err := a.get("/admin/list-challenges", nil, &chals); err == nil {
+			for _, c := range chals {
+				names[c.ID] = c.Name
+			}
+		}
+
+		solved := 0
+		for _, s := range team.Submissions {
+			if s.IsCorrect {
+				solved++
+			}
+		}
+		fmt.Fprintf(stdout, "ID:          %d\n", team.TeamID)
+		fmt.Fprintf(stdout, "Name:        %s\n", team.Teamname)
+		fmt.Fprintf(stdout, "Email:       %s\n", team.Email)
+		fmt.Fprintf(stdout, "Country:     %s\n", team.Country)
+		fmt.Fprintf(stdout, "Submissions: %d (%d correct)\n\n", len(team.Submissions), solved)
+
+		sort.Slice(team.Submissions, func(i, j int) bool {
+			return team.Submissions[i].SubmittedAt < team.Submissions[j].SubmittedAt
+		})
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tCHALLENGE\tRESULT\tFLAG\tIP")
+		for _, s := range team.Submissions {
+			chal := "-"
+			if s.ChallengeId != nil {
+				chal = names[*s.ChallengeId]
+				if chal == "" {
+					chal = strconv.FormatUint(uint64(*s.ChallengeId), 10)
+				}
+			}
+			result := "wrong"
+			if s.IsCorrect && s.IsValid {
+				result = "solved"
+			} else if s.IsCorrect {
+				result = "correct"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", time.Unix(s.SubmittedAt, 0).Format(time.RFC3339), chal, result, s.Flag, s.IPAddress)
+		}
+		return w.Flush()
+	}
+	usage()
+	return nil
+}
+
+// runRecalcSeries は提出の記録から順位の推移を計算し直す
+func runRecalcSeries(args []string) error {
+	a := newAdminCommand("recalc-series", "")
+	if ok, err := a.parse(args); err != nil || !ok {
+		return err
+	}
+	if err := confirmProduction("recalculate the score series"); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	resp, err := a.post("/admin/recalc-series", nil, nil)
+	if err != nil {
+		return xerrors.Errorf("failed to recalc series: %w", err)
+	}
+	if a.asJSON {
+		return printJSON(resp.Body())
+	}
+	fmt.Fprintln(stdout, responseMessage(resp.Body()))
+	return nil
+}
+
+// runScoreEmulate は解かれた数ごとの点数を計算する。exprを省略すれば今の設定の式を使う
+func runScoreEmulate(args []string) error {
+	var expr, exprFile string
+	var maxCount int
+	a := newAdminCommand("score-emulate", "")
+	a.fs.StringVar(&expr, "expr", "", "score expression (default: the current score_expr)")
+	a.fs.StringVar(&exprFile, "expr-file", "", "file containing the score expression")
+	a.fs.IntVar(&maxCount, "max", 50, "the largest number of solves to calculate")
+	if ok, err := a.parse(args); err != nil || !ok {
+		return err
+	}
+	if exprFile != "" {
+		b, err := ioutil.ReadFile(exprFile)
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		expr = string(b)
+	}
+
+	var scores []int
+	resp, err := a.get("/admin/score-emulate", map[string]string{"maxCount": strconv.Itoa(maxCount), "expr": expr}, &scores)
+	if err != nil {
+		return xerrors.Errorf("failed to emulate score: %w", err)
+	}
+	if a.asJSON {
+		return printJSON(resp.Body())
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "SOLVES\tSCORE\t")
+	for i, s := range scores {
+		fmt.Fprintf(w, "%d\t%d\t\n", i, s)
+	}
+	return w.Flush()
+}
+
+// runSQL は管理用のSQLコンソールにqueryを送る
+func runSQL(args []string) error {
+	var file string
+	var confirm, csv bool
+	var limit int
+	a := newAdminCommand("sql", "query")
+	a.fs.StringVar(&file, "file", "", "file containing the query")
+	a.fs.BoolVar(&confirm, "confirm", false, "allow statements which modify the database")
+	a.fs.BoolVar(&csv, "csv", false, "print the result as CSV")
+	a.fs.IntVar(&limit, "limit", 0, "maximum number of rows (default: the server's default)")
+	if ok, err := a.parse(args); err != nil || !ok {
+		return err
+	}
+	query := strings.Join(a.fs.Args(), " ")
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		query = string(b)
+	}
+
+	if confirm {
+		if err := confirmProduction("run a statement which may modify the database"); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+	}
+
+	format := ""
+	if csv {
+		format = "csv"
+	}
+	resp, err := a.post("/admin/sql", map[string]interface{}{
+		"query":   query,
+		"confirm": confirm,
+		"format":  format,
+		"limit":   limit,
+	}, nil)
+	if err != nil {
+		return xerrors.Errorf("failed to run the query: %w", err)
+	}
+	if csv {
+		_, err := stdout.Write(resp.Body())
+		return err
+	}
+	if a.asJSON {
+		return printJSON(resp.Body())
+	}
+
+	var result struct {
+		Columns      []string                 `json:"columns"`
+		Rows         []map[string]interface{} `json:"rows"`
+		Truncated    bool                     `json:"truncated"`
+		RowsAffected *int64                   `json:"rows_affected"`
+	}
+	if err := json.Unmarshal(resp.Body(), &result); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if result.RowsAffected != nil {
+		fmt.Fprintf(stdout, "%d rows affected\n", *result.RowsAffected)
+		return nil
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(result.Columns, "\t"))
+	for _, row := range result.Rows {
+		values := make([]string, len(result.Columns))
+		for i, c := range result.Columns {
+			if v := row[c]; v == nil {
+				values[i] = "NULL"
+			} else {
+				values[i] = fmt.Sprintf("%v", v)
+			}
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if result.Truncated {
+		fmt.Fprintf(stdout, "(%d rows, truncated)\n", len(result.Rows))
+	} else {
+		fmt.Fprintf(stdout, "(%d rows)\n", len(result.Rows))
+	}
+	return nil
+}
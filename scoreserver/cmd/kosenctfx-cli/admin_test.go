@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+)
+
+func captureStdout(t *testing.T) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	orig := stdout
+	stdout = buf
+	t.Cleanup(func() { stdout = orig })
+	return buf
+}
+
+func TestSelectChallenges(t *testing.T) {
+	chals := []*service.Challenge{
+		{Name: "web-2", Category: "web"},
+		{Name: "web-1", Category: "web"},
+		{Name: "pwn-1", Category: "pwn"},
+		{Name: "welcome", Category: "misc"},
+	}
+	cases := []struct {
+		patterns []string
+		category string
+		expected []string
+	}{
+		{[]string{"pwn-1"}, "", []string{"pwn-1"}},
+		{[]string{"we*"}, "", []string{"web-1", "web-2", "welcome"}},
+		{nil, "WEB", []string{"web-1", "web-2"}},
+		{[]string{"*-1"}, "web", []string{"web-1"}},
+		{[]string{"nothing"}, "", []string{}},
+	}
+	for _, c := range cases {
+		selected, err := selectChallenges(chals, c.patterns, c.category)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0)
+		for _, s := range selected {
+			names = append(names, s.Name)
+		}
+		if !reflect.DeepEqual(names, c.expected) {
+			t.Errorf("%v %q: expected %v, got %v", c.patterns, c.category, c.expected, names)
+		}
+	}
+
+	if _, err := selectChallenges(chals, []string{"[web"}, ""); err == nil {
+		t.Error("expected an invalid pattern to be an error")
+	}
+}
+
+func TestRunOpen(t *testing.T) {
+	opened := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/admin/list-challenges":
+			writeJSON(w, []*service.Challenge{
+				{Name: "web-1", Category: "web"},
+				{Name: "web-2", Category: "web", IsOpen: true},
+				{Name: "pwn-1", Category: "pwn"},
+			})
+		case "/admin/open-challenge":
+			var body struct {
+				Name string `json:"name"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			opened = append(opened, body.Name)
+			writeJSON(w, map[string]string{"message": "Opened: " + body.Name})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	out := captureStdout(t)
+	if err := runOpen([]string{"-url", server.URL, "-token", "token", "-category", "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(opened, []string{"web-1"}) {
+		t.Errorf("expected only web-1 to be opened, got %v", opened)
+	}
+	if !strings.Contains(out.String(), "web-2: already opened") {
+		t.Errorf("unexpected output: %s", out.String())
+	}
+}
+
+func TestRunConfigSet(t *testing.T) {
+	conf := map[string]interface{}{
+		"ctf_name":      "KosenCTF",
+		"start_at":      100,
+		"end_at":        200,
+		"score_expr":    "500",
+		"register_open": false,
+		"ctf_open":      false,
+		"lock_second":   60,
+		"lock_duration": 60,
+		"lock_count":    5,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/admin/get-config":
+			writeJSON(w, conf)
+		case "/admin/set-config":
+			posted := make(map[string]interface{})
+			json.NewDecoder(r.Body).Decode(&posted)
+			conf = posted
+			writeJSON(w, map[string]string{"message": "Updated"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	captureStdout(t)
+	err := runConfig([]string{"set", "-url", server.URL, "-token", "token", "-json", "ctf_open=true", "end_at=2021-01-01T00:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf["ctf_open"] != true || conf["end_at"] != float64(1609459200) {
+		t.Errorf("unexpected config: %v", conf)
+	}
+	// 指定しなかった値はそのまま残る
+	if conf["ctf_name"] != "KosenCTF" || conf["lock_count"] != float64(5) {
+		t.Errorf("unexpected config: %v", conf)
+	}
+
+	if err := runConfig([]string{"set", "-url", server.URL, "-token", "token", "lock_count=many"}); err == nil {
+		t.Error("expected a non-integer lock_count to be an error")
+	}
+	if err := runConfig([]string{"set", "-url", server.URL, "-token", "token", "unknown=1"}); err == nil {
+		t.Error("expected an unknown key to be an error")
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"golang.org/x/xerrors"
+)
+
+const (
+	defaultSolutionTimeout = 60
+	// 報告するsolverの出力の上限
+	solutionOutputLimit = 2000
+)
+
+// SolutionYaml はtask.ymlのsolution。commandはsolution/ディレクトリで実行され、
+// 問題サーバの場所は環境変数HOST, PORTで渡す。出力にflagが含まれていれば解けたとみなす
+type SolutionYaml struct {
+	Command string
+	Timeout int // 秒
+}
+
+type checkResult struct {
+	Name       string
+	IsSolvable bool
+	Message    string
+}
+
+func runCheck(args []string) error {
+	var url, token, dir, only string
+	var interval time.Duration
+	var parallel int
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	fs.StringVar(&url, "url", profile.URL, "An endpoint of scoreserver. If empty, results are not reported")
+	fs.StringVar(&token, "token", "", "An administrative token (default: read from the profile)")
+	fs.StringVar(&dir, "dir", profile.Dir, "tasks directory")
+	fs.StringVar(&only, "only", "", "check only the task of this name")
+	fs.DurationVar(&interval, "interval", 0, "run checks periodically at this interval (e.g. 10m)")
+	fs.IntVar(&parallel, "parallel", 4, "number of solvers run at the same time")
+	fs.Usage = func() {
+		fmt.Printf("Usage: %s check\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if url != "" {
+		var err error
+		if token, err = resolveToken(url, token); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+	}
+	if dir == "" || (url != "" && token == "") || parallel <= 0 {
+		fs.Usage()
+		return nil
+	}
+	url = strings.TrimSuffix(url, "/")
+
+	if interval <= 0 {
+		results, err := checkTasks(dir, only, parallel)
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		if err := reportResults(url, token, results); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		for _, r := range results {
+			if !r.IsSolvable {
+				return xerrors.New("some tasks are not solvable")
+			}
+		}
+		return nil
+	}
+
+	// CTF中はずっと回しておく。一回の失敗では止めない
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		results, err := checkTasks(dir, only, parallel)
+		if err == nil {
+			err = reportResults(url, token, results)
+		}
+		if err != nil {
+			log.Printf("%+v\n", err)
+		}
+		<-ticker.C
+	}
+}
+
+// checkTasks はsolutionが書かれている問題のsolverを並列に実行する
+func checkTasks(dir, only string, parallel int) ([]*checkResult, error) {
+	type target struct {
+		dir   string
+		tasky *TaskYaml
+	}
+	targets := make([]target, 0)
+	err := walkTasks(dir, func(dirpath string, tasky *TaskYaml) error {
+		if only != "" && tasky.Name != only {
+			return nil
+		}
+		if tasky.Solution == nil || tasky.Solution.Command == "" {
+			log.Printf("[+] SKIP: %s (no solution)\n", tasky.Name)
+			return nil
+		}
+		targets = append(targets, target{dir: dirpath, tasky: tasky})
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+
+	results := make([]*checkResult, len(targets))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t target) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = checkTask(t.dir, t.tasky)
+		}(i, t)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	for _, r := range results {
+		if r.IsSolvable {
+			log.Printf("[+] OK: %s (%s)\n", r.Name, r.Message)
+		} else {
+			log.Printf("[-] NG: %s\n%s\n", r.Name, r.Message)
+		}
+	}
+	return results, nil
+}
+
+// acceptedFlags はサーバが正解とするflagを返す
+// 空のflagはどんな出力にも含まれてしまうので除く
+func acceptedFlags(tasky *TaskYaml) []string {
+	flags := make([]string, 0, len(tasky.Flags)+1)
+	for _, f := range append([]string{tasky.Flag}, tasky.Flags...) {
+		if f != "" {
+			flags = append(flags, f)
+		}
+	}
+	return flags
+}
+
+func checkTask(dir string, tasky *TaskYaml) *checkResult {
+	result := &checkResult{Name: tasky.Name}
+	flags := acceptedFlags(tasky)
+	if len(flags) == 0 {
+		result.Message = "the flag is empty"
+		return result
+	}
+	start := time.Now()
+	output, err := runSolution(filepath.Join(dir, "solution"), tasky)
+	for _, f := range flags {
+		if strings.Contains(output, f) {
+			result.IsSolvable = true
+			result.Message = fmt.Sprintf("solved in %s", time.Since(start).Round(time.Second))
+			return result
+		}
+	}
+
+	if len(output) > solutionOutputLimit {
+		output = output[len(output)-solutionOutputLimit:]
+	}
+	if err != nil {
+		result.Message = fmt.Sprintf("%v\n%s", err, output)
+	} else {
+		result.Message = fmt.Sprintf("flag not found in the output\n%s", output)
+	}
+	return result
+}
+
+// runSolution はsolverを実行して標準出力と標準エラー出力をまとめて返す
+func runSolution(dir string, tasky *TaskYaml) (string, error) {
+	timeout := tasky.Solution.Timeout
+	if timeout <= 0 {
+		timeout = defaultSolutionTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	// pipeだとsolverが起動した子プロセスが残っているときにtimeoutしても返ってこないのでファイルに書かせる
+	out, err := ioutil.TempFile("", "kosenctfx-check-")
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", tasky.Solution.Command)
+	cmd.Dir = dir
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.Env = os.Environ()
+	if tasky.Host != nil {
+		cmd.Env = append(cmd.Env, "HOST="+*tasky.Host)
+	}
+	if tasky.Port != nil {
+		cmd.Env = append(cmd.Env, "PORT="+strconv.Itoa(*tasky.Port))
+	}
+	runErr := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		runErr = xerrors.Errorf("timeout after %d seconds", timeout)
+	}
+
+	output, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return string(output), runErr
+}
+
+// reportResults は全ての結果を報告する。途中で失敗しても残りの結果は報告する
+func reportResults(url, token string, results []*checkResult) error {
+	if url == "" {
+		return nil
+	}
+	client := resty.New().SetAuthToken(token)
+	failed := make([]string, 0)
+	for _, r := range results {
+		resp, err := client.R().
+			SetBody(map[string]interface{}{
+				"name":        r.Name,
+				"is_solvable": r.IsSolvable,
+				"message":     r.Message,
+			}).
+			Post(url + "/admin/solvability")
+		if err != nil {
+			log.Printf("[-] FAILED: report %s: %v\n", r.Name, err)
+			failed = append(failed, r.Name)
+			continue
+		}
+		if resp.IsError() {
+			log.Printf("[-] FAILED: report %s: %s %s\n", r.Name, resp.Status(), string(resp.Body()))
+			failed = append(failed, r.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return xerrors.Errorf("failed to report %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTask(t *testing.T, dir, name, taskYml string) {
+	taskDir := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Join(taskDir, "solution"), 0755); err != nil {
+		t.Fatalf("failed to create task dir: %+v\n", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(taskDir, "task.yml"), []byte(taskYml), 0644); err != nil {
+		t.Fatalf("failed to write task.yml: %+v\n", err)
+	}
+}
+
+func TestCheckTasks(t *testing.T) {
+	dir := t.TempDir()
+	writeTask(t, dir, "solvable", `
+name: solvable
+flag: "KosenCTF{solvable}"
+host: "localhost"
+port: 1337
+solution:
+  command: 'echo "connecting to $HOST:$PORT"; echo "KosenCTF{solvable}"'
+`)
+	writeTask(t, dir, "broken", `
+name: broken
+flag: "KosenCTF{broken}"
+solution:
+  command: 'echo "Connection refused"; exit 1'
+`)
+	writeTask(t, dir, "timeout", `
+name: timeout
+flag: "KosenCTF{timeout}"
+solution:
+  command: 'sleep 10; echo "KosenCTF{timeout}"'
+  timeout: 1
+`)
+	writeTask(t, dir, "noflag", `
+name: noflag
+flag: ""
+solution:
+  command: 'echo "KosenCTF{noflag}"'
+`)
+	writeTask(t, dir, "alternate", `
+name: alternate
+flag: "KosenCTF{main}"
+flags:
+  - "KosenCTF{alternate}"
+solution:
+  command: 'echo "KosenCTF{alternate}"'
+`)
+	writeTask(t, dir, "onlyflags", `
+name: onlyflags
+flags:
+  - "KosenCTF{onlyflags}"
+solution:
+  command: 'echo "KosenCTF{onlyflags}"'
+`)
+	writeTask(t, dir, "nosolution", `
+name: nosolution
+flag: "KosenCTF{nosolution}"
+`)
+
+	results, err := checkTasks(dir, "", 2)
+	if err != nil {
+		t.Fatalf("failed to check tasks: %+v\n", err)
+	}
+	if len(results) != 6 {
+		t.Fatalf("expected 6 results, got %d\n", len(results))
+	}
+
+	// 名前順に並ぶ
+	alternate, broken, noflag, onlyflags, solvable, timeout := results[0], results[1], results[2], results[3], results[4], results[5]
+	// 別解のflagを出力しても解けている
+	if !alternate.IsSolvable || !onlyflags.IsSolvable {
+		t.Errorf("alternate flags should be accepted: %+v %+v\n", alternate, onlyflags)
+	}
+	if !solvable.IsSolvable {
+		t.Errorf("solvable is reported as unsolvable: %s\n", solvable.Message)
+	}
+	if broken.IsSolvable || !strings.Contains(broken.Message, "Connection refused") {
+		t.Errorf("broken should be unsolvable with its output: %+v\n", broken)
+	}
+	if noflag.IsSolvable {
+		t.Errorf("a task without a flag should be unsolvable: %+v\n", noflag)
+	}
+	if timeout.IsSolvable || !strings.Contains(timeout.Message, "timeout") {
+		t.Errorf("timeout should be unsolvable by timeout: %+v\n", timeout)
+	}
+
+	results, err = checkTasks(dir, "solvable", 2)
+	if err != nil {
+		t.Fatalf("failed to check tasks: %+v\n", err)
+	}
+	if len(results) != 1 || results[0].Name != "solvable" {
+		t.Errorf("only option is ignored: %+v\n", results)
+	}
+}
+
+func TestReportResults(t *testing.T) {
+	reported := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Name == "notowned" {
+			w.WriteHeader(http.StatusForbidden)
+			writeJSON(w, map[string]string{"message": "not owned"})
+			return
+		}
+		reported = append(reported, req.Name)
+		writeJSON(w, map[string]string{"message": "ok"})
+	}))
+	defer server.Close()
+
+	results := []*checkResult{{Name: "a"}, {Name: "notowned"}, {Name: "b", IsSolvable: true}}
+	err := reportResults(server.URL, "token", results)
+	if err == nil || !strings.Contains(err.Error(), "notowned") {
+		t.Errorf("expected the failure to be reported, got %v\n", err)
+	}
+	// 失敗した後の結果も報告する
+	if strings.Join(reported, ",") != "a,b" {
+		t.Errorf("unexpected reported results: %v\n", reported)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/xerrors"
+)
+
+type diffStatus string
+
+const (
+	diffCreate    diffStatus = "create"
+	diffUpdate    diffStatus = "update"
+	diffUnchanged diffStatus = "unchanged"
+	// サーバにだけある問題。アップロードしても触らない
+	diffServerOnly diffStatus = "server only"
+	// task directoryが消された問題。--pruneなら閉じる
+	diffPrune diffStatus = "prune"
+)
+
+// diffValueMaxLen より長い値は省略して表示する
+const diffValueMaxLen = 80
+
+type fieldDiff struct {
+	Field  string
+	Before string
+	After  string
+}
+
+type taskDiff struct {
+	Name   string
+	Status diffStatus
+	Fields []fieldDiff
+}
+
+// fetchChallenges はサーバに登録されている問題を全て取得する
+func fetchChallenges(url, token string) ([]*service.Challenge, error) {
+	var chals []*service.Challenge
+	resp, err := resty.New().SetAuthToken(token).R().
+		SetResult(&chals).
+		Get(url + "/admin/list-challenges")
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if resp.IsError() {
+		return nil, xerrors.Errorf("failed to list challenges: %s %s", resp.Status(), string(resp.Body()))
+	}
+	return chals, nil
+}
+
+// localAttachments はアップロードせずに添付ファイルのsha256と大きさだけを求める
+func localAttachments(dir string, tasky *TaskYaml) ([]service.Attachment, error) {
+	return collectAttachments(dir, tasky, func(name string, r io.ReaderAt, size int64) (service.Attachment, error) {
+		d, err := digest(r, size)
+		if err != nil {
+			return service.Attachment{}, xerrors.Errorf(": %w", err)
+		}
+		return newAttachment(name, "", d, size), nil
+	})
+}
+
+// diffValue は値を比べやすいように1行の文字列にする
+func diffValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
+func sortedStrings(xs []string) []string {
+	ys := append([]string{}, xs...)
+	sort.Strings(ys)
+	return ys
+}
+
+// attachmentKeys はURLを除いて添付ファイルを比べるための文字列にする
+// 以前のサーバはsha256を持っていないので、そのときは名前だけで比べる
+func attachmentKeys(as []service.Attachment, withHash bool) []string {
+	keys := make([]string, 0, len(as))
+	for _, a := range as {
+		key := a.Name
+		if withHash {
+			key += "@" + a.Sha256
+		}
+		if a.IsPerTeam {
+			key += " (per team)"
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func hasSha256(as []service.Attachment) bool {
+	for _, a := range as {
+		if a.Sha256 == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// diffChallenge はtask.ymlをアップロードしたときにサーバの問題のどこが変わるかを返す
+func diffChallenge(local *TaskYaml, remote *service.Challenge) []fieldDiff {
+	releaseAt := local.ReleaseAt
+	if remote.IsOpen {
+		// 公開済みの問題のrelease_atはサーバが捨てる
+		releaseAt = 0
+	}
+	withHash := hasSha256(remote.Attachments)
+
+	fields := []struct {
+		name          string
+		before, after interface{}
+	}{
+		{"category", remote.Category, local.Category},
+		{"difficulty", remote.Difficulty, local.Difficulty},
+		{"description", remote.Description, local.Description},
+		{"flag", remote.Flag, local.Flag},
+		{"flags", sortedStrings(remote.Flags), sortedStrings(local.Flags)},
+		{"author", remote.Author, local.Author},
+		{"tags", sortedStrings(remote.Tags), sortedStrings(local.Tags)},
+		{"hints", remote.Hints, local.Hints},
+		{"attachments", attachmentKeys(remote.Attachments, withHash), attachmentKeys(local.Attachments, withHash)},
+		{"host", remote.Host, local.Host},
+		{"port", remote.Port, local.Port},
+		{"healthcheck", remote.HealthCheck, local.HealthCheck},
+		{"instance", remote.InstanceConfig, local.Instance},
+		{"is_survey", remote.IsSurvey, local.IsSurvey},
+		{"release_at", remote.ReleaseAt, releaseAt},
+		{"score_expr", remote.ScoreExpr, local.ScoreExpr},
+	}
+	diffs := make([]fieldDiff, 0)
+	for _, f := range fields {
+		before, after := diffValue(f.before), diffValue(f.after)
+		// 空のリストとnullは同じ
+		if before == "[]" {
+			before = "null"
+		}
+		if after == "[]" {
+			after = "null"
+		}
+		if before != after {
+			diffs = append(diffs, fieldDiff{Field: f.name, Before: before, After: after})
+		}
+	}
+	return diffs
+}
+
+// diffTasks はローカルの問題とサーバの問題を名前で突き合わせる
+func diffTasks(locals []*TaskYaml, remotes []*service.Challenge, prune bool) []taskDiff {
+	remoteMap := make(map[string]*service.Challenge)
+	for _, r := range remotes {
+		remoteMap[r.Name] = r
+	}
+
+	diffs := make([]taskDiff, 0, len(locals)+len(remotes))
+	seen := make(map[string]bool)
+	for _, l := range locals {
+		seen[l.Name] = true
+		r, ok := remoteMap[l.Name]
+		if !ok {
+			diffs = append(diffs, taskDiff{Name: l.Name, Status: diffCreate})
+			continue
+		}
+		if fields := diffChallenge(l, r); len(fields) > 0 {
+			diffs = append(diffs, taskDiff{Name: l.Name, Status: diffUpdate, Fields: fields})
+		} else {
+			diffs = append(diffs, taskDiff{Name: l.Name, Status: diffUnchanged})
+		}
+	}
+	pruned := make(map[string]bool)
+	if prune {
+		for _, r := range pruneTargets(seen, remotes) {
+			pruned[r.Name] = true
+		}
+	}
+	for _, r := range remotes {
+		switch {
+		case pruned[r.Name]:
+			diffs = append(diffs, taskDiff{Name: r.Name, Status: diffPrune})
+		case !seen[r.Name]:
+			diffs = append(diffs, taskDiff{Name: r.Name, Status: diffServerOnly})
+		}
+	}
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return diffs[i].Name < diffs[j].Name
+	})
+	return diffs
+}
+
+func shorten(s string) string {
+	if r := []rune(s); len(r) > diffValueMaxLen {
+		return string(r[:diffValueMaxLen]) + "..."
+	}
+	return s
+}
+
+func printDiffs(w io.Writer, diffs []taskDiff) {
+	marks := map[diffStatus]string{
+		diffCreate:     "+",
+		diffUpdate:     "~",
+		diffUnchanged:  "=",
+		diffServerOnly: " ",
+		diffPrune:      "-",
+	}
+	for _, d := range diffs {
+		fmt.Fprintf(w, "%s %s (%s)\n", marks[d.Status], d.Name, d.Status)
+		for _, f := range d.Fields {
+			fmt.Fprintf(w, "    %s: %s -> %s\n", f.Field, shorten(f.Before), shorten(f.After))
+		}
+	}
+}
+
+// dryRun はアップロードせずに、アップロードしたらサーバの問題がどう変わるかを表示する
+func dryRun(w io.Writer, url, token, dir string, prune bool) error {
+	locals := make([]*TaskYaml, 0)
+	err := walkTasks(dir, func(dirpath string, tasky *TaskYaml) error {
+		attachments, err := localAttachments(dirpath, tasky)
+		if err != nil {
+			return xerrors.Errorf("%s: %w", tasky.Name, err)
+		}
+		tasky.Attachments = attachments
+		locals = append(locals, tasky)
+		return nil
+	})
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	remotes, err := fetchChallenges(url, token)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+
+	diffs := diffTasks(locals, remotes, prune)
+	printDiffs(w, diffs)
+	count := make(map[diffStatus]int)
+	for _, d := range diffs {
+		count[d.Status]++
+	}
+	log.Printf("[+] DRY RUN: %d to create, %d to update, %d unchanged, %d to prune, %d only on the server\n", count[diffCreate], count[diffUpdate], count[diffUnchanged], count[diffPrune], count[diffServerOnly])
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+)
+
+func TestDiffTasks(t *testing.T) {
+	port := 9000
+	locals := []*TaskYaml{
+		{Name: "new", Flag: "flag{new}"},
+		{Name: "same", Flag: "flag{same}", Tags: []string{"web", "easy"}, Port: &port},
+		{Name: "changed", Flag: "flag{changed}", Description: "new description", Tags: []string{"pwn"}, Attachments: []service.Attachment{{Name: "dist.tar.gz", Sha256: "bbbb"}}},
+	}
+	remotes := []*service.Challenge{
+		{Name: "same", Flag: "flag{same}", Tags: []string{"easy", "web"}, Port: &port},
+		{Name: "changed", Flag: "flag{old}", Description: "old description", Tags: []string{"pwn"}, Attachments: []service.Attachment{{Name: "dist.tar.gz", URL: "http://example.com/dist.tar.gz", Sha256: "aaaa"}}},
+		{Name: "remote", Flag: "flag{remote}"},
+	}
+
+	var buf bytes.Buffer
+	printDiffs(&buf, diffTasks(locals, remotes, false))
+	expected := `~ changed (update)
+    description: "old description" -> "new description"
+    flag: "flag{old}" -> "flag{changed}"
+    attachments: ["dist.tar.gz@aaaa"] -> ["dist.tar.gz@bbbb"]
++ new (create)
+  remote (server only)
+= same (unchanged)
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\noutput:\n%s", expected, buf.String())
+	}
+}
+
+func TestDiffChallengeReleasedChallenge(t *testing.T) {
+	// 公開済みならrelease_atは送っても捨てられるので差分にしない
+	local := &TaskYaml{Name: "a", ReleaseAt: 1000}
+	if diffs := diffChallenge(local, &service.Challenge{Name: "a", IsOpen: true}); len(diffs) != 0 {
+		t.Errorf("unexpected diffs: %v", diffs)
+	}
+	if diffs := diffChallenge(local, &service.Challenge{Name: "a"}); len(diffs) != 1 || diffs[0].Field != "release_at" {
+		t.Errorf("unexpected diffs: %v", diffs)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	dir := t.TempDir()
+	writeTask(t, dir, "task", `
+name: task
+flag: "KosenCTF{task}"
+`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/list-challenges" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		writeJSON(w, []map[string]interface{}{
+			{"name": "task", "flag": "KosenCTF{old}"},
+		})
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	if err := dryRun(&buf, server.URL, "token", dir, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `flag: "KosenCTF{old}" -> "KosenCTF{task}"`) {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	distFormatTarGz = "tar.gz"
+	distFormatZip   = "zip"
+
+	// distfilesに置くと、書かれたpatternに一致するファイルを配布しない
+	distIgnoreFile = ".distignore"
+)
+
+// 同じ中身なら誰がどこで作っても同じarchiveになるように、時刻と所有者と権限は揃える
+// zipは1980年より前の時刻を持てないのでこれに揃える
+var distEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// distIgnore は.distignoreの中身
+// /を含まないpatternはどの階層の名前とも、含むものはdistfilesからの相対pathと比べる。/で終わるものはディレクトリだけに一致する
+type distIgnore struct {
+	patterns []string
+}
+
+func loadDistIgnore(dir string) (*distIgnore, error) {
+	ignore := &distIgnore{}
+	f, err := os.Open(filepath.Join(dir, distIgnoreFile))
+	if os.IsNotExist(err) {
+		return ignore, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := path.Match(line, ""); err != nil {
+			return nil, xerrors.Errorf("%s: invalid pattern %q", distIgnoreFile, line)
+		}
+		ignore.patterns = append(ignore.patterns, line)
+	}
+	if err := s.Err(); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return ignore, nil
+}
+
+// match はdistfilesからの相対path(/区切り)が無視するものか返す
+func (ig *distIgnore) match(rel string, isDir bool) bool {
+	if rel == distIgnoreFile {
+		return true
+	}
+	for _, p := range ig.patterns {
+		dirOnly := strings.HasSuffix(p, "/")
+		p = strings.TrimSuffix(p, "/")
+		if dirOnly && !isDir {
+			continue
+		}
+		if strings.Contains(p, "/") {
+			if ok, _ := path.Match(strings.TrimPrefix(p, "/"), rel); ok {
+				return true
+			}
+		} else if ok, _ := path.Match(p, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type distFile struct {
+	path string // 読み出すファイル
+	name string // archiveの中での名前
+	mode int64
+	size int64
+}
+
+// listDistfiles はdir以下の配布するファイルを名前順に返す
+func listDistfiles(dir string) ([]distFile, error) {
+	ignore, err := loadDistIgnore(dir)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	files := make([]distFile, 0)
+	err = filepath.Walk(dir, func(p string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if ignore.match(rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// symlinkなどは配らない
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		mode := int64(0644)
+		if info.Mode()&0111 != 0 {
+			mode = 0755
+		}
+		files = append(files, distFile{path: p, name: rel, mode: mode, size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].name < files[j].name
+	})
+	return files, nil
+}
+
+// makeDistfiles はdir以下をname/の下に入れたtar.gzを作る
+func makeDistfiles(dir, name string) ([]byte, error) {
+	return makeDistArchive(dir, name, distFormatTarGz)
+}
+
+// makeDistArchive はdir以下をname/の下に入れたformatのarchiveを作る。同じ中身からは常に同じarchiveができる
+func makeDistArchive(dir, name, format string) ([]byte, error) {
+	files, err := listDistfiles(dir)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	buf := new(bytes.Buffer)
+	switch format {
+	case distFormatTarGz, "":
+		err = writeDistTarGz(buf, files, name)
+	case distFormatZip:
+		err = writeDistZip(buf, files, name)
+	default:
+		err = xerrors.Errorf("unknown distfiles format: %s", format)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func copyFile(w io.Writer, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(w, f); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+func writeDistTarGz(w io.Writer, files []distFile, name string) error {
+	// gzipのheaderにもファイル名や時刻を入れない
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, f := range files {
+		h := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     "./" + name + "/" + f.name,
+			Mode:     f.mode,
+			Size:     f.size,
+			ModTime:  distEpoch,
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		if err := copyFile(tw, f.path); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+func writeDistZip(w io.Writer, files []distFile, name string) error {
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		h := &zip.FileHeader{
+			Name:     name + "/" + f.name,
+			Method:   zip.Deflate,
+			Modified: distEpoch,
+		}
+		h.SetMode(os.FileMode(f.mode))
+		fw, err := zw.CreateHeader(h)
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		if err := copyFile(fw, f.path); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeDistfile(t *testing.T, dir, name, content string) {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMakeDistArchiveIsReproducible(t *testing.T) {
+	for _, format := range []string{distFormatTarGz, distFormatZip} {
+		dir := t.TempDir()
+		writeDistfile(t, dir, "chall/main.c", "int main() {}")
+		writeDistfile(t, dir, "chall/run.sh", "#!/bin/sh")
+		first, err := makeDistArchive(dir, "task", format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 更新時刻や権限が違っても同じarchiveになる
+		later := time.Now().Add(time.Hour)
+		os.Chtimes(filepath.Join(dir, "chall", "main.c"), later, later)
+		os.Chmod(filepath.Join(dir, "chall", "main.c"), 0600)
+		second, err := makeDistArchive(dir, "task", format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, second) {
+			t.Errorf("%s archive is not reproducible", format)
+		}
+	}
+}
+
+func TestMakeDistArchiveZip(t *testing.T) {
+	dir := t.TempDir()
+	writeDistfile(t, dir, "b.txt", "b")
+	writeDistfile(t, dir, "a/c.txt", "c")
+	data, err := makeDistArchive(dir, "task", distFormatZip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if expected := []string{"task/a/c.txt", "task/b.txt"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestListDistfilesIgnore(t *testing.T) {
+	dir := t.TempDir()
+	writeDistfile(t, dir, distIgnoreFile, "# comment\n*.pyc\n__pycache__/\nsecret/flag.txt\n")
+	for _, name := range []string{
+		"main.py",
+		"main.pyc",
+		"lib/util.pyc",
+		"__pycache__/main.cpython-39.pyc",
+		"secret/flag.txt",
+		"secret/dummy.txt",
+		"flag.txt",
+	} {
+		writeDistfile(t, dir, name, name)
+	}
+	files, err := listDistfiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, f := range files {
+		names = append(names, f.name)
+	}
+	if expected := []string{"flag.txt", "main.py", "secret/dummy.txt"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v2"
+)
+
+// exportTaskYaml はexportするtask.yml。kosenctfx-cliでそのままアップロードし直せる形にする
+type exportTaskYaml struct {
+	Version          int                     `yaml:"version"`
+	Name             string                  `yaml:"name"`
+	Category         string                  `yaml:"category,omitempty"`
+	Difficulty       string                  `yaml:"difficulty,omitempty"`
+	Author           string                  `yaml:"author,omitempty"`
+	Description      string                  `yaml:"description"`
+	Flag             string                  `yaml:"flag"`
+	Flags            []string                `yaml:"flags,omitempty"`
+	Tags             []string                `yaml:"tags,omitempty"`
+	Hints            []string                `yaml:"hints,omitempty"`
+	Host             *string                 `yaml:"host,omitempty"`
+	Port             *int                    `yaml:"port,omitempty"`
+	HealthCheck      *service.HealthCheck    `yaml:"healthcheck,omitempty"`
+	Instance         *service.InstanceConfig `yaml:"instance,omitempty"`
+	IsSurvey         bool                    `yaml:"is_survey,omitempty"`
+	Release          string                  `yaml:"release_at,omitempty"`
+	Scoring          *ScoringYaml            `yaml:"scoring,omitempty"`
+	PerTeamDistfiles bool                    `yaml:"per_team_distfiles,omitempty"`
+	DistfilesFormat  string                  `yaml:"distfiles_format,omitempty"`
+}
+
+// uncategorized はcategoryのない問題を書き出すときのcategory
+// version 2のtask.ymlはcategoryが必須なので、空のままだとアップロードし直せない
+const uncategorized = "uncategorized"
+
+func newExportTaskYaml(c *service.Challenge) *exportTaskYaml {
+	tasky := &exportTaskYaml{
+		Version:     taskYamlVersion,
+		Name:        c.Name,
+		Category:    c.Category,
+		Difficulty:  c.Difficulty,
+		Author:      c.Author,
+		Description: c.Description,
+		Flag:        c.Flag,
+		Flags:       c.Flags,
+		Tags:        c.Tags,
+		Hints:       c.Hints,
+		Host:        c.Host,
+		Port:        c.Port,
+		HealthCheck: c.HealthCheck,
+		Instance:    c.InstanceConfig,
+		IsSurvey:    c.IsSurvey,
+	}
+	if tasky.Category == "" {
+		tasky.Category = uncategorized
+	}
+	if c.ReleaseAt != 0 {
+		tasky.Release = time.Unix(c.ReleaseAt, 0).Format(time.RFC3339)
+	}
+	if c.ScoreExpr != "" {
+		tasky.Scoring = &ScoringYaml{Expr: c.ScoreExpr}
+	}
+	for _, a := range c.Attachments {
+		if a.IsPerTeam {
+			tasky.PerTeamDistfiles = true
+			if strings.HasSuffix(a.Name, "."+distFormatZip) {
+				tasky.DistfilesFormat = distFormatZip
+			}
+		}
+	}
+	return tasky
+}
+
+var unsafeDirChars = regexp.MustCompile(`[^a-z0-9_\-]+`)
+
+// taskDirName は問題名をディレクトリ名にする
+func taskDirName(name string) string {
+	s := strings.Trim(unsafeDirChars.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if s == "" {
+		return "task"
+	}
+	return s
+}
+
+// exporter はサーバの問題をtask directoryとして書き出す
+type exporter struct {
+	url    string
+	token  string
+	http   *http.Client
+	dir    string
+	noFile bool
+
+	used map[string]bool
+}
+
+// taskDir はcategory/問題名のディレクトリを返す。ディレクトリ名が重複したらIDを付ける
+func (e *exporter) taskDir(c *service.Challenge) string {
+	category := uncategorized
+	if c.Category != "" {
+		category = taskDirName(c.Category)
+	}
+	dir := filepath.Join(e.dir, category, taskDirName(c.Name))
+	if e.used[dir] {
+		dir = fmt.Sprintf("%s_%d", dir, c.ID)
+	}
+	e.used[dir] = true
+	return dir
+}
+
+// download は添付ファイルを取得してsha256を確かめる
+// adminとして取得するのでチームごとに作り直す前のものが得られ、ダウンロードとしても記録されない
+func (e *exporter) download(a service.Attachment) ([]byte, error) {
+	url := a.URL
+	if a.ID != 0 {
+		url = fmt.Sprintf("%s/admin/attachments/%d", e.url, a.ID)
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	// redirect先が別のhostならAuthorizationは送られない
+	req.Header.Set("Authorization", "Bearer "+e.token)
+	resp, err := e.http.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("failed to download %s: %s", a.Name, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if a.Sha256 != "" {
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != a.Sha256 {
+			return nil, xerrors.Errorf("%s does not match sha256 %s", a.Name, a.Sha256)
+		}
+	}
+	return data, nil
+}
+
+// exportChallenge は1つの問題をtask.ymlと添付ファイルとして書き出す
+// チームごとに作り直す添付ファイルはdistfilesに展開し、それ以外はrawdistfilesにそのまま置く
+func (e *exporter) exportChallenge(c *service.Challenge) (string, error) {
+	dir := e.taskDir(c)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	taskb, err := yaml.Marshal(newExportTaskYaml(c))
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "task.yml"), append([]byte("---\n"), taskb...), 0644); err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	if e.noFile {
+		return dir, nil
+	}
+
+	for _, a := range c.Attachments {
+		data, err := e.download(a)
+		if err != nil {
+			return "", xerrors.Errorf(": %w", err)
+		}
+		if a.IsPerTeam {
+			if err := extractDistfiles(a.Name, data, filepath.Join(dir, "distfiles")); err != nil {
+				return "", xerrors.Errorf("%s: %w", a.Name, err)
+			}
+			continue
+		}
+		rawDistdir := filepath.Join(dir, "rawdistfiles")
+		if err := os.MkdirAll(rawDistdir, 0755); err != nil {
+			return "", xerrors.Errorf(": %w", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(rawDistdir, filepath.Base(a.Name)), data, 0644); err != nil {
+			return "", xerrors.Errorf(": %w", err)
+		}
+	}
+	return dir, nil
+}
+
+// extractDistfiles はmakeDistArchiveが作ったtar.gzやzipをdirに展開する
+func extractDistfiles(name string, data []byte, dir string) error {
+	if strings.HasSuffix(name, "."+distFormatZip) {
+		return extractDistZip(data, dir)
+	}
+	return extractDistTarGz(data, dir)
+}
+
+// extractDistFile はarchiveの中のファイルを1つ書き出す
+// 先頭の <task>/ は取り除き、dirの外を指すものは展開しない
+func extractDistFile(dir, name string, mode os.FileMode, r io.Reader) error {
+	parts := strings.SplitN(strings.TrimPrefix(filepath.ToSlash(name), "./"), "/", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	rel := filepath.Clean(filepath.FromSlash(parts[1]))
+	if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return xerrors.Errorf("invalid path in the archive: %s", name)
+	}
+	path := filepath.Join(dir, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode&0755|0644)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+func extractDistZip(data []byte, dir string) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		err = extractDistFile(dir, f.Name, f.Mode(), rc)
+		rc.Close()
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+	}
+	return nil
+}
+
+func extractDistTarGz(data []byte, dir string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := extractDistFile(dir, h.Name, os.FileMode(h.Mode), tr); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+	}
+}
+
+func runExport(args []string) error {
+	var url, token, dir string
+	var noFile bool
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.StringVar(&url, "url", profile.URL, "An endpoint of scoreserver")
+	fs.StringVar(&token, "token", "", "An administrative token (default: read from the profile)")
+	fs.StringVar(&dir, "dir", "", "directory to write tasks to")
+	fs.BoolVar(&noFile, "no-attachments", false, "write task.yml only and skip downloading attachments")
+	fs.Usage = func() {
+		fmt.Printf("Usage: %s export\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	token, err := resolveToken(url, token)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if url == "" || token == "" || dir == "" {
+		fs.Usage()
+		return nil
+	}
+	url = strings.TrimSuffix(url, "/")
+
+	chals, err := fetchChallenges(url, token)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	e := &exporter{
+		url:    url,
+		token:  token,
+		http:   &http.Client{Timeout: uploadTimeout},
+		dir:    dir,
+		noFile: noFile,
+		used:   make(map[string]bool),
+	}
+	failed := 0
+	for _, c := range chals {
+		taskDir, err := e.exportChallenge(c)
+		if err != nil {
+			failed++
+			log.Printf("[-] FAILED: %s: %v\n", c.Name, err)
+			continue
+		}
+		log.Printf("[+] %s -> %s\n", c.Name, taskDir)
+	}
+	log.Printf("[+] %d tasks exported, %d failed\n", len(chals)-failed, failed)
+	if failed > 0 {
+		return xerrors.Errorf("failed to export %d tasks", failed)
+	}
+	return nil
+}
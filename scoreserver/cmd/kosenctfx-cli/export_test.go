@@ -0,0 +1,122 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func makeTarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestExport(t *testing.T) {
+	raw := []byte("raw attachment")
+	rawSum := sha256.Sum256(raw)
+	dist := makeTarGz(t, map[string]string{"./pwn1/chall.c": "int main() {}"})
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/admin/list-challenges", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, []map[string]interface{}{
+			{
+				"id": 1, "name": "Pwn 1", "flag": "KosenCTF{pwn}", "flags": []string{"KosenCTF{alt}"},
+				"category": "pwn", "difficulty": "easy", "author": "theoremoon", "description": "nc host 9001",
+				"tags": []string{"pwn"}, "host": "host", "port": 9001, "release_at": 1662000000,
+				"attachments": []map[string]interface{}{
+					{"id": 10, "name": "libc.so.6", "sha256": hex.EncodeToString(rawSum[:])},
+					{"id": 11, "name": "pwn1.tar.gz", "is_per_team": true},
+				},
+			},
+			// 管理画面から作った問題はcategoryが空のことがある
+			{"id": 2, "name": "Survey", "flag": "KosenCTF{survey}", "author": "theoremoon", "description": "thanks", "is_survey": true},
+		})
+	})
+	mux.HandleFunc("/admin/attachments/10", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/files/libc.so.6", http.StatusFound)
+	})
+	mux.HandleFunc("/files/libc.so.6", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(raw)
+	})
+	mux.HandleFunc("/admin/attachments/11", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(dist)
+	})
+
+	dir := t.TempDir()
+	if err := runExport([]string{"-url", server.URL, "-token", "token", "-dir", dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	taskDir := filepath.Join(dir, "pwn", "pwn_1")
+	tasky, err := loadTaskYaml(filepath.Join(taskDir, "task.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tasky.Name != "Pwn 1" || tasky.Flag != "KosenCTF{pwn}" || len(tasky.Flags) != 1 || tasky.Category != "pwn" ||
+		*tasky.Host != "host" || *tasky.Port != 9001 || tasky.ReleaseAt != 1662000000 || !tasky.PerTeamDistfiles {
+		t.Errorf("unexpected task.yml: %+v", tasky)
+	}
+	// exportしたものはそのままアップロードし直せる
+	if _, problems, err := lintTasks(dir); err != nil || len(problems) != 0 {
+		t.Errorf("exported task.yml has problems: %v %v", problems, err)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(taskDir, "rawdistfiles", "libc.so.6")); err != nil || !bytes.Equal(b, raw) {
+		t.Errorf("unexpected rawdistfiles: %q %v", b, err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(taskDir, "distfiles", "chall.c")); err != nil || string(b) != "int main() {}" {
+		t.Errorf("unexpected distfiles: %q %v", b, err)
+	}
+
+	tasky, err = loadTaskYaml(filepath.Join(dir, uncategorized, "survey", "task.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tasky.Category != uncategorized || !tasky.IsSurvey {
+		t.Errorf("unexpected task.yml: %+v", tasky)
+	}
+}
+
+func TestExtractDistfilesRejectsTraversal(t *testing.T) {
+	data := makeTarGz(t, map[string]string{"./task/../../evil": "evil"})
+	if err := extractDistfiles("task.tar.gz", data, t.TempDir()); err == nil {
+		t.Errorf("paths outside the directory should be rejected")
+	}
+}
+
+func TestExtractDistfilesRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	writeDistfile(t, src, "chall/main.c", "int main() {}")
+	for _, format := range []string{distFormatTarGz, distFormatZip} {
+		data, err := makeDistArchive(src, "task", format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst := t.TempDir()
+		if err := extractDistfiles("task."+format, data, dst); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := ioutil.ReadFile(filepath.Join(dst, "chall", "main.c")); err != nil || string(b) != "int main() {}" {
+			t.Errorf("%s: unexpected content: %q %v", format, b, err)
+		}
+	}
+}
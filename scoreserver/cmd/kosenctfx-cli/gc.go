@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"golang.org/x/xerrors"
+)
+
+type bucketObject struct {
+	Key          string    `json:"key"`
+	URL          string    `json:"url"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+type gcResult struct {
+	DryRun  bool              `json:"dry_run"`
+	Deleted []bucketObject    `json:"deleted"`
+	Errors  map[string]string `json:"errors"`
+}
+
+// runGC はどの問題の添付ファイルにもなっていないbucketのobjectを消す
+func runGC(args []string) error {
+	var url, token string
+	var dryRun bool
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	fs.StringVar(&url, "url", profile.URL, "An endpoint of scoreserver")
+	fs.StringVar(&token, "token", "", "An administrative token (default: read from the profile)")
+	fs.BoolVar(&dryRun, "dry-run", false, "only show orphaned objects without deleting them")
+	fs.Usage = func() {
+		fmt.Printf("Usage: %s gc\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	token, err := resolveToken(url, token)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if url == "" || token == "" {
+		fs.Usage()
+		return nil
+	}
+	url = strings.TrimSuffix(url, "/")
+	if !dryRun {
+		if err := confirmProduction("delete orphaned objects"); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+	}
+
+	var result gcResult
+	resp, err := resty.New().SetAuthToken(token).R().
+		SetBody(map[string]interface{}{"dry_run": dryRun}).
+		SetResult(&result).
+		Post(url + "/admin/gc-objects")
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if resp.IsError() {
+		return xerrors.Errorf("failed to collect garbage: %s %s", resp.Status(), string(resp.Body()))
+	}
+
+	var total int64
+	for _, o := range result.Deleted {
+		if result.DryRun {
+			log.Printf("[+] ORPHAN: %s (%d bytes)\n", o.Key, o.Size)
+		} else {
+			log.Printf("[+] DELETED: %s (%d bytes)\n", o.Key, o.Size)
+		}
+		total += o.Size
+	}
+	keys := make([]string, 0, len(result.Errors))
+	for k := range result.Errors {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		log.Printf("[-] FAILED: %s: %s\n", k, result.Errors[k])
+	}
+
+	if result.DryRun {
+		log.Printf("[+] %d orphaned objects (%d bytes). Run without -dry-run to delete them\n", len(result.Deleted), total)
+	} else {
+		log.Printf("[+] %d objects deleted (%d bytes)\n", len(result.Deleted), total)
+	}
+	if len(keys) > 0 {
+		return xerrors.Errorf("failed to delete %d objects", len(keys))
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/instancer"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v2"
+)
+
+// lintProblem はtask.ymlの問題点。Lineが0なら行は特定できなかった
+type lintProblem struct {
+	File      string
+	Line      int
+	Message   string
+	IsWarning bool
+}
+
+func (p lintProblem) String() string {
+	level := "error"
+	if p.IsWarning {
+		level = "warning"
+	}
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s: %s", p.File, level, p.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", p.File, p.Line, level, p.Message)
+}
+
+// yamlErrorLine はyamlのエラーメッセージの "line N: ..." を取り出す
+var yamlErrorLine = regexp.MustCompile(`line (\d+): (.*)`)
+
+// taskLinter は1つのtask.ymlを調べる
+type taskLinter struct {
+	file     string
+	src      []byte
+	problems []lintProblem
+}
+
+func (l *taskLinter) errorf(line int, format string, args ...interface{}) {
+	l.problems = append(l.problems, lintProblem{File: l.file, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l *taskLinter) warnf(line int, format string, args ...interface{}) {
+	l.problems = append(l.problems, lintProblem{File: l.file, Line: line, Message: fmt.Sprintf(format, args...), IsWarning: true})
+}
+
+// line はkeyが書かれている行。healthcheck.typeのようにネストしたkeyも辿り、なければ親のkeyの行
+func (l *taskLinter) line(path ...string) int {
+	for n := len(path); n > 0; n-- {
+		if line := keyLine(l.src, path[:n]...); line != 0 {
+			return line
+		}
+	}
+	return 0
+}
+
+// keyLine はYAMLの中でpathのkeyが書かれている行を返す。見つからなければ0
+// 中身を解釈するのではなく、インデントを頼りに行を探すだけ
+func keyLine(src []byte, path ...string) int {
+	depth, indent := 0, -1
+	for i, l := range strings.Split(string(src), "\n") {
+		trimmed := strings.TrimLeft(l, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "---") {
+			continue
+		}
+		ind := len(l) - len(trimmed)
+		if depth > 0 && ind <= indent {
+			// 親のkeyのブロックを抜けた
+			return 0
+		}
+		if depth == 0 && ind != 0 {
+			continue
+		}
+		key := path[depth]
+		if strings.HasPrefix(trimmed, key+":") || strings.HasPrefix(trimmed, `"`+key+`":`) {
+			depth, indent = depth+1, ind
+			if depth == len(path) {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+// itemLine はkeyのリストのidx番目の要素が書かれている行を返す。[a, b]のように1行で書かれていればkeyの行
+func itemLine(src []byte, key string, idx int) int {
+	start := keyLine(src, key)
+	if start == 0 {
+		return 0
+	}
+	lines := strings.Split(string(src), "\n")
+	n := 0
+	for i := start; i < len(lines); i++ {
+		trimmed := strings.TrimLeft(lines[i], " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		// トップレベルのリストは "- " がkeyと同じインデントに書かれることがある
+		if !strings.HasPrefix(trimmed, "-") && len(lines[i])-len(trimmed) == 0 {
+			break
+		}
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if n == idx {
+				return i + 1
+			}
+			n++
+		}
+	}
+	return start
+}
+
+// lintTaskYaml はtask.ymlを読んで書き方の誤りを返す。読めたものはtaskyとして返す
+func lintTaskYaml(file string, src []byte, dir string) (*TaskYaml, []lintProblem) {
+	l := &taskLinter{file: file, src: src}
+
+	var tasky TaskYaml
+	if err := yaml.UnmarshalStrict(src, &tasky); err != nil {
+		var messages []string
+		if terr, ok := err.(*yaml.TypeError); ok {
+			messages = terr.Errors
+		} else {
+			messages = []string{err.Error()}
+		}
+		for _, m := range messages {
+			if sub := yamlErrorLine.FindStringSubmatch(m); sub != nil {
+				line, _ := strconv.Atoi(sub[1])
+				l.errorf(line, "%s", sub[2])
+			} else {
+				l.errorf(0, "%s", strings.TrimPrefix(m, "yaml: "))
+			}
+		}
+		// 型が合わないだけなら他の項目は読めているので続けて調べる
+		if _, ok := err.(*yaml.TypeError); !ok {
+			return nil, l.problems
+		}
+	}
+
+	switch {
+	case tasky.Version > taskYamlVersion:
+		l.errorf(l.line("version"), "version %d is not supported. Update kosenctfx-cli (supported up to %d)", tasky.Version, taskYamlVersion)
+	case tasky.Version < 0:
+		l.errorf(l.line("version"), "version must be positive")
+	case tasky.Version == 0:
+		l.warnf(0, "version is not set. It is read as version 1; set version: %d to use category, hints and so on", taskYamlVersion)
+	}
+
+	if tasky.Name == "" {
+		l.errorf(l.line("name"), "name is required")
+	}
+	if tasky.Category == "" {
+		if tasky.Version >= 2 {
+			l.errorf(l.line("category"), "category is required")
+		} else {
+			l.warnf(l.line("category"), "category is not set")
+		}
+	}
+	if tasky.Difficulty != "" && !containsString(difficulties, tasky.Difficulty) {
+		l.errorf(l.line("difficulty"), "difficulty must be one of %s", strings.Join(difficulties, ", "))
+	}
+	if tasky.Author == "" {
+		l.warnf(l.line("author"), "author is not set")
+	}
+
+	if tasky.Flag == "" {
+		l.errorf(l.line("flag"), "flag is required")
+	} else if tasky.Flag != strings.TrimSpace(tasky.Flag) {
+		// サーバは提出の前後の空白を取り除くので、このflagは誰も正解できない
+		l.errorf(l.line("flag"), "flag %q has leading or trailing whitespace", tasky.Flag)
+	}
+	seen := map[string]bool{tasky.Flag: true}
+	for i, f := range tasky.Flags {
+		switch {
+		case f == "":
+			l.errorf(itemLine(src, "flags", i), "flags must not contain an empty flag")
+		case seen[f]:
+			l.errorf(itemLine(src, "flags", i), "flag %q is duplicated", f)
+		case f != strings.TrimSpace(f):
+			l.errorf(itemLine(src, "flags", i), "flag %q has leading or trailing whitespace", f)
+		}
+		seen[f] = true
+	}
+	for i, t := range tasky.Tags {
+		if t == "" {
+			l.errorf(itemLine(src, "tags", i), "tags must not contain an empty tag")
+		}
+	}
+	for i, h := range tasky.Hints {
+		if strings.TrimSpace(h) == "" {
+			l.errorf(itemLine(src, "hints", i), "hints must not contain an empty hint")
+		}
+	}
+
+	if _, err := parseReleaseAt(tasky.Release); err != nil {
+		l.errorf(l.line("release_at"), "release_at must be RFC3339 like 2006-01-02T15:04:05+09:00")
+	}
+	if s := tasky.Scoring; s != nil {
+		switch {
+		case s.Expr != "" && s.Fixed != 0:
+			l.errorf(l.line("scoring"), "scoring.expr and scoring.fixed cannot be used together")
+		case s.Fixed < 0:
+			l.errorf(l.line("scoring", "fixed"), "scoring.fixed must be positive")
+		case s.Expr != "":
+			if _, err := service.CalcChallengeScore(1, s.Expr); err != nil {
+				l.errorf(l.line("scoring", "expr"), "scoring.expr is invalid: %s", strings.TrimLeft(err.Error(), ": "))
+			}
+		case s.Fixed == 0:
+			l.errorf(l.line("scoring"), "scoring needs expr or fixed")
+		}
+	}
+
+	if tasky.Port != nil && (*tasky.Port <= 0 || *tasky.Port > 65535) {
+		l.errorf(l.line("port"), "port must be between 1 and 65535")
+	}
+	if tasky.Host == nil && tasky.Port != nil {
+		l.errorf(l.line("port"), "port is set but host is not set")
+	}
+	if tasky.Host != nil && tasky.Port == nil {
+		l.warnf(l.line("host"), "host is set but port is not set")
+	}
+	if tasky.Host == nil && (strings.Contains(tasky.Description, "{host}") || strings.Contains(tasky.Description, "{port}")) {
+		l.warnf(l.line("description"), "description refers to {host} or {port} but host is not set")
+	}
+	if h := tasky.HealthCheck; h != nil {
+		if err := service.ValidateHealthCheck(h); err != nil {
+			l.errorf(l.line("healthcheck"), "%v", err)
+		}
+		if tasky.Host == nil {
+			l.warnf(l.line("healthcheck"), "healthcheck is ignored because host is not set")
+		}
+	}
+	if i := tasky.Instance; i != nil {
+		if i.Image == "" {
+			l.errorf(l.line("instance"), "instance.image is required")
+		} else if !instancer.ValidImage(i.Image) {
+			// docker以外のbackendではdockerのimageでなくてもよい
+			l.warnf(l.line("instance", "image"), "instance.image is not a docker image reference. The default docker backend will refuse to start it")
+		}
+		if i.Port <= 0 || i.Port > 65535 {
+			l.errorf(l.line("instance", "port"), "instance.port must be between 1 and 65535")
+		}
+		if i.TTL < 0 {
+			l.errorf(l.line("instance", "ttl"), "instance.ttl must not be negative")
+		}
+	}
+
+	if s := tasky.Solution; s != nil {
+		if s.Command == "" {
+			l.errorf(l.line("solution"), "solution.command is required")
+		}
+		if s.Timeout < 0 {
+			l.errorf(l.line("solution", "timeout"), "solution.timeout must not be negative")
+		}
+	}
+	if f := tasky.DistfilesFormat; f != "" && f != distFormatTarGz && f != distFormatZip {
+		l.errorf(l.line("distfiles_format"), "distfiles_format must be %s or %s", distFormatTarGz, distFormatZip)
+	}
+	if tasky.PerTeamDistfiles {
+		if _, err := os.Stat(filepath.Join(dir, "distfiles")); err != nil {
+			l.warnf(l.line("per_team_distfiles"), "per_team_distfiles is set but there is no distfiles directory")
+		}
+	}
+	return &tasky, l.problems
+}
+
+func containsString(xs []string, s string) bool {
+	for _, x := range xs {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// lintTasks はdir以下の全てのtask.ymlを調べる。問題名やflagが他の問題と重複していないかも調べる
+func lintTasks(dir string) (int, []lintProblem, error) {
+	count := 0
+	problems := make([]lintProblem, 0)
+	names := make(map[string]string)
+	flags := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		if info.Name() != "task.yml" {
+			return nil
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		count++
+		tasky, ps := lintTaskYaml(path, src, filepath.Dir(path))
+		problems = append(problems, ps...)
+		if tasky == nil {
+			return filepath.SkipDir
+		}
+
+		if other, ok := names[tasky.Name]; ok && tasky.Name != "" {
+			problems = append(problems, lintProblem{File: path, Line: keyLine(src, "name"), Message: fmt.Sprintf("name %q is also used in %s", tasky.Name, other)})
+		}
+		names[tasky.Name] = path
+		for i, f := range append([]string{tasky.Flag}, tasky.Flags...) {
+			if f == "" {
+				continue
+			}
+			if other, ok := flags[f]; ok && other != path {
+				line := keyLine(src, "flag")
+				if i > 0 {
+					line = itemLine(src, "flags", i-1)
+				}
+				problems = append(problems, lintProblem{File: path, Line: line, Message: fmt.Sprintf("flag %q is also used in %s", f, other)})
+			}
+			flags[f] = path
+		}
+
+		// このディレクトリは深堀りしない
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return 0, nil, xerrors.Errorf(": %w", err)
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].File != problems[j].File {
+			return problems[i].File < problems[j].File
+		}
+		return problems[i].Line < problems[j].Line
+	})
+	return count, problems, nil
+}
+
+// reportLint は問題点を表示してエラーの数を返す
+func reportLint(problems []lintProblem) int {
+	errors := 0
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, p.String())
+		if !p.IsWarning {
+			errors++
+		}
+	}
+	return errors
+}
+
+func runLint(args []string) error {
+	var dir string
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	fs.StringVar(&dir, "dir", profile.Dir, "tasks directory")
+	fs.Usage = func() {
+		fmt.Printf("Usage: %s lint\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if dir == "" {
+		fs.Usage()
+		return nil
+	}
+
+	count, problems, err := lintTasks(dir)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	errors := reportLint(problems)
+	log.Printf("[+] %d tasks checked: %d errors, %d warnings\n", count, errors, len(problems)-errors)
+	if errors > 0 {
+		return xerrors.Errorf("%d errors found", errors)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeyLine(t *testing.T) {
+	src := []byte(`---
+name: "test"
+# port: 1
+instance:
+  image: "foo"
+  port: 8080
+port: 9000
+tags:
+  - web
+  - ""
+`)
+	for _, c := range []struct {
+		path []string
+		line int
+	}{
+		{[]string{"name"}, 2},
+		{[]string{"port"}, 7},
+		{[]string{"instance", "port"}, 6},
+		{[]string{"instance", "ttl"}, 0},
+		{[]string{"flag"}, 0},
+	} {
+		if line := keyLine(src, c.path...); line != c.line {
+			t.Errorf("keyLine(%v) = %d, expected %d", c.path, line, c.line)
+		}
+	}
+	if line := itemLine(src, "tags", 1); line != 10 {
+		t.Errorf("itemLine(tags, 1) = %d, expected 10", line)
+	}
+}
+
+func TestLintTasks(t *testing.T) {
+	dir := t.TempDir()
+	writeTask(t, dir, "valid", `
+version: 2
+name: valid
+category: pwn
+difficulty: easy
+flag: "KosenCTF{valid}"
+flags: ["KosenCTF{valid_alt}"]
+author: theoremoon
+hints:
+  - "read the source"
+release_at: "2022-09-01T10:00:00+09:00"
+scoring:
+  fixed: 100
+`)
+	writeTask(t, dir, "invalid", `
+version: 2
+name: invalid
+difficulty: impossible
+flag: "KosenCTF{valid_alt}"
+author: theoremoon
+prot: 9000
+release_at: tomorrow
+scoring:
+  expr: "func calc(count) {"
+instance:
+  image: "foo"
+`)
+
+	count, problems, err := lintTasks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 tasks, got %d", count)
+	}
+	got := make([]string, 0, len(problems))
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	output := strings.Join(got, "\n")
+	for _, expected := range []string{
+		"invalid/task.yml: error: category is required",
+		"invalid/task.yml:4: error: difficulty must be one of",
+		"invalid/task.yml:7: error: field prot not found",
+		"invalid/task.yml:8: error: release_at must be RFC3339",
+		"invalid/task.yml:10: error: scoring.expr is invalid: syntax error",
+		"invalid/task.yml:11: error: instance.port must be between 1 and 65535",
+		// 先に見つかった方ではなく後から見つかった方を指す
+		"valid/task.yml:7: error: flag \"KosenCTF{valid_alt}\" is also used in",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in\n%s", expected, output)
+		}
+	}
+}
+
+func TestLintTaskYamlVersion(t *testing.T) {
+	_, problems := lintTaskYaml("task.yml", []byte("version: 3\nname: a\ncategory: b\nflag: c\nauthor: d\n"), ".")
+	if len(problems) != 1 || problems[0].IsWarning || problems[0].Line != 1 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+
+	// versionがなければv1として読み、categoryがなくても警告にとどめる
+	_, problems = lintTaskYaml("task.yml", []byte("name: a\nflag: c\nauthor: d\n"), ".")
+	for _, p := range problems {
+		if !p.IsWarning {
+			t.Errorf("unexpected error: %s", p)
+		}
+	}
+	if len(problems) != 2 {
+		t.Errorf("expected 2 warnings, got %v", problems)
+	}
+}
+
+func TestLintFlagWhitespace(t *testing.T) {
+	// block scalarで書くと末尾に改行が入る
+	src := []byte(`version: 2
+name: spaced
+flag: |
+  KosenCTF{spaced}
+flags:
+  - "KosenCTF{spaced_alt} "
+`)
+	_, problems := lintTaskYaml("task.yml", src, t.TempDir())
+	got := make([]string, 0, len(problems))
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	output := strings.Join(got, "\n")
+	for _, expected := range []string{
+		`task.yml:3: error: flag "KosenCTF{spaced}\n" has leading or trailing whitespace`,
+		`task.yml:6: error: flag "KosenCTF{spaced_alt} " has leading or trailing whitespace`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in\n%s", expected, output)
+		}
+	}
+}
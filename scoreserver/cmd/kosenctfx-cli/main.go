@@ -4,87 +4,120 @@ import (
 	"bytes"
 	"crypto/md5"
 	"encoding/hex"
-	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/theoremoon/kosenctfx/scoreserver/service"
-	"golang.org/x/mod/sumdb/dirhash"
 	"golang.org/x/xerrors"
 	"gopkg.in/yaml.v2"
 )
 
+// taskYamlVersion はこのkosenctfx-cliが読めるtask.ymlの版
+// 1: name, description, flag, author, tags, host, port, healthcheck, instance, is_survey
+// 2: category, difficulty, flags, hints, release_at, scoring, distfiles_format を追加し、categoryを必須にした
+const taskYamlVersion = 2
+
+// 難易度として書けるもの
+var difficulties = []string{"warmup", "easy", "medium", "hard", "insane"}
+
 type TaskYaml struct {
+	Version     int `json:"-"`
 	Name        string
+	Category    string `json:"category"`
+	Difficulty  string `json:"difficulty"`
 	Description string
 	Flag        string
+	// Flagの他に正解とするflag
+	Flags       []string `json:"flags"`
 	Author      string
 	Tags        []string
+	Hints       []string `json:"hints"`
 	Attachments []service.Attachment
 	Host        *string
 	Port        *int
-	IsSurvey    bool `yaml:"is_survey"`
+	HealthCheck *service.HealthCheck    `yaml:"healthcheck" json:"health_check"`
+	Instance    *service.InstanceConfig `yaml:"instance" json:"instance"`
+	IsSurvey    bool                    `yaml:"is_survey" json:"is_survey"`
+
+	// RFC3339の時刻。この時刻になったらscoreserverが自動で公開する
+	Release   string `yaml:"release_at" json:"-"`
+	ReleaseAt int64  `yaml:"-" json:"release_at"`
+	// 空ならCTF全体の点数の式を使う
+	Scoring   *ScoringYaml `yaml:"scoring" json:"-"`
+	ScoreExpr string       `yaml:"-" json:"score_expr"`
+	// task directoryのdirhash。サーバに保存しておき、変わっていなければ次回はskipする
+	SyncHash string `yaml:"-" json:"sync_hash"`
+
+	// サーバには送らない
+	Solution *SolutionYaml `yaml:"solution" json:"-"`
+	// trueならdistfilesの中の __TEAM_TOKEN__ などをチームごとに置き換えて配る
+	PerTeamDistfiles bool `yaml:"per_team_distfiles" json:"-"`
+	// distfilesをまとめる形式。tar.gzかzipで、空ならtar.gz
+	DistfilesFormat string `yaml:"distfiles_format" json:"-"`
 }
 
-func uploadFile(url, token, filename string, blob []byte) (string, error) {
-	type Data struct {
-		PresignedURL string            `json:"presignedURL"`
-		FormData     map[string]string `json:"formData"`
-		DownloadURL  string            `json:"downloadURL"`
-	}
+// ScoringYaml はtask.ymlのscoring。exprとfixedはどちらか一方だけ書く
+type ScoringYaml struct {
+	Expr  string `yaml:"expr,omitempty"`  // CTF全体のscore_exprと同じ形の func calc(count) { ... }
+	Fixed int    `yaml:"fixed,omitempty"` // 解かれた数によらない点数
+}
 
-	var data Data
-	_, err := resty.New().SetAuthToken(token).R().
-		SetBody(map[string]interface{}{"key": filename}).
-		SetResult(&data).
-		Post(url + "/admin/get-presigned-url")
+// parseReleaseAt はrelease_atをunix時刻にする。空なら0
+func parseReleaseAt(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
 	if err != nil {
-		return "", xerrors.Errorf(": %w", err)
+		return 0, xerrors.Errorf("release_at must be RFC3339 like 2006-01-02T15:04:05+09:00: %w", err)
 	}
+	return t.Unix(), nil
+}
 
-	if data.FormData == nil {
-		resp, err := resty.New().R().
-			SetBody(blob).
-			Put(data.PresignedURL)
-		if err != nil {
-			return "", xerrors.Errorf(": %w", err)
-		}
-		if resp.IsError() {
-			log.Printf("Error: %s, %s\n", resp.Status(), string(resp.Body()))
-		}
-	} else {
-		resp, err := resty.New().R().
-			SetFormData(data.FormData).
-			SetFileReader("file", filename, bytes.NewBuffer(blob)).
-			Post(data.PresignedURL)
-		if err != nil {
-			return "", xerrors.Errorf(": %w", err)
-		}
-		if resp.IsError() {
-			log.Printf("Error: %s, %s\n", resp.Status(), string(resp.Body()))
-		}
+// scoreExpr はscoringをscoreserverに送る点数の式にする
+func (s *ScoringYaml) scoreExpr() string {
+	if s == nil {
+		return ""
+	}
+	if s.Fixed > 0 {
+		return fmt.Sprintf("func calc(count) { return %d }", s.Fixed)
 	}
+	return s.Expr
+}
 
-	return data.DownloadURL, nil
+// newAttachment はuploadしたファイルのsha256と大きさを付けて添付ファイルの情報を作る
+func newAttachment(name, url string, d *fileDigest, size int64) service.Attachment {
+	return service.Attachment{
+		URL:    url,
+		Name:   name,
+		Sha256: d.Sha256,
+		Size:   size,
+	}
 }
 
 func setChallenge(url, token string, taskInfo TaskYaml) error {
 	client := resty.New().SetAuthToken(token)
-	_, err := client.R().
+	resp, err := client.R().
 		SetBody(taskInfo).
 		Post(url + "/admin/new-challenge")
 	if err != nil {
 		return xerrors.Errorf(": %w", err)
 	}
+	if resp.IsError() {
+		return xerrors.Errorf("failed to register %s: %s %s", taskInfo.Name, resp.Status(), string(resp.Body()))
+	}
 	return nil
 }
 
@@ -111,52 +144,18 @@ func loadTaskYaml(path string) (*TaskYaml, error) {
 
 	r := strings.NewReplacer("{host}", hostStr, "{port}", portStr)
 	tasky.Description = r.Replace(tasky.Description)
-	return &tasky, nil
-}
 
-func makeDistfiles(dir, name string) ([]byte, error) {
-	transform := fmt.Sprintf(" --transform 's:^\\./:./%s/:'", name)
-	cmd := exec.Command("sh", "-c", "find . -type f | tar cz --files-from=- --to-stdout  --sort=name"+transform)
-	cmd.Dir = dir
-	tardata, err := cmd.Output()
+	tasky.ReleaseAt, err = parseReleaseAt(tasky.Release)
 	if err != nil {
-		return nil, xerrors.Errorf(": %w", err)
+		return nil, xerrors.Errorf("%s: %w", path, err)
 	}
-	return tardata, nil
+	tasky.ScoreExpr = tasky.Scoring.scoreExpr()
+	return &tasky, nil
 }
 
-func run() error {
-	var url, token, dir, hashfile string
-	flag.StringVar(&url, "url", "", "An endpoint of scoreserver")
-	flag.StringVar(&token, "token", "", "An administrative token")
-	flag.StringVar(&dir, "dir", "", "tasks directory")
-	flag.StringVar(&hashfile, "hashfile", "", "hash file")
-	flag.Usage = func() {
-		fmt.Printf("Usage: %s\n", os.Args[0])
-		flag.PrintDefaults()
-	}
-	flag.Parse()
-	if url == "" || token == "" || dir == "" {
-		flag.Usage()
-		return nil
-	}
-	url = strings.TrimSuffix(url, "/")
-
-	hash_entries := make(map[string]string)
-
-	if _, err := os.Stat(hashfile); err == nil {
-		data, err := ioutil.ReadFile(hashfile)
-		if err != nil {
-			return xerrors.Errorf(": %w", err)
-		}
-		if err := json.Unmarshal(data, &hash_entries); err != nil {
-			return xerrors.Errorf(": %w", err)
-		}
-	}
-
-	targets := make(map[string]*TaskYaml)
-	// walk tasks directory
-	err := filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
+// walkTasks はdir以下のtask.ymlを探して、そのディレクトリと中身をfnに渡す
+func walkTasks(dir string, fn func(dirpath string, tasky *TaskYaml) error) error {
+	return filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
 			return xerrors.Errorf(": %w", err)
 		}
@@ -167,108 +166,283 @@ func run() error {
 		if err != nil {
 			return xerrors.Errorf(": %w", err)
 		}
-
-		// hash tableに乗っていない OR 更新されていたらtargetsに乗せる
-		dirpath := filepath.Dir(path)
-		h1, _ := dirhash.HashDir(dirpath, "", dirhash.Hash1)
-		h2, exist := hash_entries[tasky.Name]
-		if !exist || h1 != h2 {
-			hash_entries[tasky.Name] = h1
-			targets[dirpath] = tasky
-
-		} else {
-			log.Printf("[+] SKIP: %s\n", tasky.Name)
+		if err := fn(filepath.Dir(path), tasky); err != nil {
+			return xerrors.Errorf(": %w", err)
 		}
 
 		// このディレクトリは深堀りしない
 		return filepath.SkipDir
 	})
+}
+
+type uploadResult struct {
+	Name     string
+	Files    int
+	Bytes    int64
+	Duration time.Duration
+	Err      error
+}
+
+// uploadTask は問題の添付ファイルを全てuploadしてから問題を登録する
+// 1つでもuploadに失敗したら壊れた添付ファイルを登録しないように問題は登録しない
+func uploadTask(u *uploader, url, token, dir string, tasky *TaskYaml) *uploadResult {
+	result := &uploadResult{Name: tasky.Name}
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+
+	attachments, err := uploadAttachments(u, dir, tasky)
 	if err != nil {
-		return xerrors.Errorf(": %w", err)
+		result.Err = xerrors.Errorf(": %w", err)
+		return result
+	}
+	for _, a := range attachments {
+		result.Files++
+		result.Bytes += a.Size
+	}
+	tasky.Attachments = attachments
+	if err := setChallenge(url, token, *tasky); err != nil {
+		result.Err = xerrors.Errorf(": %w", err)
 	}
+	return result
+}
 
-	for d, tasky := range targets {
-		taskID := filepath.Base(d)
-		attachments := make([]service.Attachment, 0, 10)
-		err = func() error {
-			distdir := filepath.Join(d, "distfiles")
-			if _, err := os.Stat(distdir); err != nil {
-				return nil
-			}
-			tardata, err := makeDistfiles(distdir, taskID)
-			md5sum := md5.Sum(tardata)
-			filename := fmt.Sprintf("%s_%s.tar.gz", taskID, hex.EncodeToString(md5sum[:]))
-			dlUrl, err := uploadFile(url, token, filename, tardata)
-			if err != nil {
-				return xerrors.Errorf(": %w", err)
-			}
-			attachments = append(attachments, service.Attachment{
-				URL:  dlUrl,
-				Name: filename,
-			})
-			return nil
-		}()
+func uploadAttachments(u *uploader, dir string, tasky *TaskYaml) ([]service.Attachment, error) {
+	return collectAttachments(dir, tasky, func(name string, r io.ReaderAt, size int64) (service.Attachment, error) {
+		return uploadAttachment(u, name, r, size)
+	})
+}
+
+// collectAttachments は問題の添付ファイルを作り、1つずつfnに渡して添付ファイルの情報を集める
+func collectAttachments(dir string, tasky *TaskYaml, fn func(name string, r io.ReaderAt, size int64) (service.Attachment, error)) ([]service.Attachment, error) {
+	taskID := filepath.Base(dir)
+	attachments := make([]service.Attachment, 0, 10)
+
+	distdir := filepath.Join(dir, "distfiles")
+	if _, err := os.Stat(distdir); err == nil {
+		format := tasky.DistfilesFormat
+		if format == "" {
+			format = distFormatTarGz
+		}
+		data, err := makeDistArchive(distdir, taskID, format)
 		if err != nil {
-			return xerrors.Errorf(": %w", err)
+			return nil, xerrors.Errorf(": %w", err)
+		}
+		md5sum := md5.Sum(data)
+		filename := fmt.Sprintf("%s_%s.%s", taskID, hex.EncodeToString(md5sum[:]), format)
+		a, err := fn(filename, bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, xerrors.Errorf(": %w", err)
 		}
+		a.IsPerTeam = tasky.PerTeamDistfiles
+		attachments = append(attachments, a)
+	}
 
-		err = func() error {
-			rawDistdir := filepath.Join(d, "rawdistfiles")
-			if _, err := os.Stat(rawDistdir); err != nil {
-				return nil
+	// rawdistfilesは大きいことがあるのでメモリに載せずにuploadする
+	rawDistdir := filepath.Join(dir, "rawdistfiles")
+	if _, err := os.Stat(rawDistdir); err == nil {
+		err := filepath.Walk(rawDistdir, func(path string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return xerrors.Errorf(": %w", err)
 			}
-			err := filepath.Walk(rawDistdir, func(path string, info fs.FileInfo, err error) error {
-				if err != nil {
-					return xerrors.Errorf(": %w", err)
-				}
-				if info.IsDir() {
-					return nil
-				}
-				blob, err := ioutil.ReadFile(path)
-				if err != nil {
-					return nil
-				}
-
-				dlUrl, err := uploadFile(url, token, info.Name(), blob)
-				if err != nil {
-					return xerrors.Errorf(": %w", err)
-				}
-				attachments = append(attachments, service.Attachment{
-					URL:  dlUrl,
-					Name: info.Name(),
-				})
+			if info.IsDir() {
 				return nil
-			})
+			}
+			f, err := os.Open(path)
 			if err != nil {
 				return xerrors.Errorf(": %w", err)
 			}
+			defer f.Close()
+			a, err := fn(info.Name(), f, info.Size())
+			if err != nil {
+				return xerrors.Errorf(": %w", err)
+			}
+			attachments = append(attachments, a)
 			return nil
-		}()
+		})
 		if err != nil {
-			return xerrors.Errorf(": %w", err)
+			return nil, xerrors.Errorf(": %w", err)
 		}
-		tasky.Attachments = attachments
-		if err := setChallenge(url, token, *tasky); err != nil {
-			return xerrors.Errorf(": %w", err)
+	}
+	return attachments, nil
+}
+
+func uploadAttachment(u *uploader, name string, r io.ReaderAt, size int64) (service.Attachment, error) {
+	d, err := digest(r, size)
+	if err != nil {
+		return service.Attachment{}, xerrors.Errorf(": %w", err)
+	}
+	url, err := u.upload(name, r, size, d)
+	if err != nil {
+		return service.Attachment{}, xerrors.Errorf("%s: %w", name, err)
+	}
+	return newAttachment(name, url, d, size), nil
+}
+
+func run() error {
+	var url, token, dir, hashfile string
+	var parallel, retries int
+	var isDryRun, prune bool
+	var multipartThreshold, partSize int64
+	flag.StringVar(&url, "url", profile.URL, "An endpoint of scoreserver")
+	flag.StringVar(&token, "token", "", "An administrative token (default: read from the profile)")
+	flag.StringVar(&dir, "dir", profile.Dir, "tasks directory")
+	flag.StringVar(&hashfile, "hashfile", "", "deprecated: the server keeps the hash of each task")
+	flag.IntVar(&parallel, "parallel", 4, "number of tasks uploaded at the same time")
+	flag.IntVar(&retries, "retries", defaultUploadRetries, "number of retries for each failed upload")
+	flag.Int64Var(&multipartThreshold, "multipart-threshold", defaultMultipartThreshold, "files of this size in bytes or larger are uploaded in parts")
+	flag.Int64Var(&partSize, "part-size", defaultPartSize, "size in bytes of each part of a multipart upload (at least 5MiB)")
+	flag.BoolVar(&isDryRun, "dry-run", false, "show what would be changed on the server without uploading anything")
+	flag.BoolVar(&prune, "prune", false, "close challenges whose task directory was removed")
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s [--profile name] [--yes] [command]\n", os.Args[0])
+		if path, err := configPath(); err == nil {
+			fmt.Printf("Profiles are read from %s (set $%s to change)\n", path, configPathEnv)
 		}
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	token, err := resolveToken(url, token)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if url == "" || token == "" || dir == "" || parallel <= 0 || retries < 0 || partSize < minPartSize {
+		flag.Usage()
+		return nil
+	}
+	url = strings.TrimSuffix(url, "/")
 
-		log.Printf("[+] %s\n", tasky.Name)
+	// 書き方の誤りがあれば1つもアップロードしない
+	_, problems, err := lintTasks(dir)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if errors := reportLint(problems); errors > 0 {
+		return xerrors.Errorf("%d errors found in task.yml. Fix them before uploading", errors)
+	}
+	if isDryRun {
+		return dryRun(os.Stdout, url, token, dir, prune)
+	}
+	if err := confirmProduction("upload tasks"); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if hashfile != "" {
+		log.Printf("[-] -hashfile is ignored. The server keeps the hash of each task\n")
 	}
 
-	// save
-	hashb, err := json.Marshal(hash_entries)
+	remotes, err := fetchChallenges(url, token)
 	if err != nil {
 		return xerrors.Errorf(": %w", err)
 	}
-	if err := ioutil.WriteFile(hashfile, hashb, 0755); err != nil {
+	targets, names, err := syncTargets(dir, remotes)
+	if err != nil {
 		return xerrors.Errorf(": %w", err)
 	}
 
+	u := newUploader(url, token)
+	u.retries = retries
+	u.multipartThreshold = multipartThreshold
+	u.partSize = partSize
+
+	results := make([]*uploadResult, 0, len(targets))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallel)
+	for d, tasky := range targets {
+		wg.Add(1)
+		go func(d string, tasky *TaskYaml) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			r := uploadTask(u, url, token, d, tasky)
+			mu.Lock()
+			defer mu.Unlock()
+			results = append(results, r)
+		}(d, tasky)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			log.Printf("[-] FAILED: %s: %v\n", r.Name, r.Err)
+		} else {
+			log.Printf("[+] %s (%d files, %d bytes, %s)\n", r.Name, r.Files, r.Bytes, r.Duration.Round(time.Millisecond))
+		}
+	}
+	log.Printf("[+] %d tasks uploaded, %d failed\n", len(results)-failed, failed)
+
+	if prune {
+		for _, chal := range pruneTargets(names, remotes) {
+			if err := pruneChallenge(url, token, chal.Name); err != nil {
+				failed++
+				log.Printf("[-] FAILED: prune %s: %v\n", chal.Name, err)
+			} else {
+				log.Printf("[+] PRUNE: %s\n", chal.Name)
+			}
+		}
+	}
+
+	if failed > 0 {
+		return xerrors.Errorf("failed to sync %d tasks", failed)
+	}
 	return nil
 }
 
+func firstArg() string {
+	if len(os.Args) < 2 {
+		return ""
+	}
+	return os.Args[1]
+}
+
+// サブコマンド。指定がなければ従来通り問題をアップロードする
+var commands = map[string]func(args []string) error{
+	"check":     runCheck,
+	"gc":        runGC,
+	"lint":      runLint,
+	"export":    runExport,
+	"watch":     runWatch,
+	"smoketest": runSmoketest,
+
+	"open":          runOpen,
+	"close":         runClose,
+	"config":        runConfig,
+	"teams":         runTeams,
+	"recalc-series": runRecalcSeries,
+	"score-emulate": runScoreEmulate,
+	"sql":           runSQL,
+	"top":           runTop,
+}
+
 func main() {
-	if err := run(); err != nil {
+	args, name, yes, err := globalOptions(os.Args[1:])
+	if err != nil {
+		log.Fatalf("%+v\n", err)
+	}
+	// 設定ファイルの場所がわからなくても、profileを指定していなければflagだけで使える
+	if path, err := configPath(); err == nil {
+		if profile, err = loadProfile(path, name); err != nil {
+			log.Fatalf("%+v\n", err)
+		}
+	} else if name != "" || os.Getenv(profileNameEnv) != "" {
+		log.Fatalf("%+v\n", err)
+	}
+	assumeYes = yes
+	os.Args = append(os.Args[:1], args...)
+
+	if cmd, ok := commands[firstArg()]; ok {
+		err = cmd(os.Args[2:])
+	} else {
+		err = run()
+	}
+	if err != nil {
 		log.Fatalf("%+v\n", err)
 	}
 }
@@ -20,16 +20,18 @@ func TestMakeDistFiles(t *testing.T) {
 		t.Fatalf("the output is not tar formatted or cannot run tar: %+v\n", err)
 	}
 
+	// 名前順に並ぶ。以前はfindが返した順で、--files-fromで渡したファイルには--sort=nameが効かないので
+	// filesystemによって変わっていた。同じ内容なら同じarchiveになるように名前順で固定する
 	expected := strings.TrimSpace(`
-./miniblog_distfiles/miniblog/userdir/.keep
-./miniblog_distfiles/miniblog/views/index.html
-./miniblog_distfiles/miniblog/views/user.html
-./miniblog_distfiles/miniblog/tmp/.keep
 ./miniblog_distfiles/miniblog/main.py
+./miniblog_distfiles/miniblog/tmp/.keep
+./miniblog_distfiles/miniblog/user_template/attachments/neko.png
 ./miniblog_distfiles/miniblog/user_template/posts/00000000000000000000000000000000
-./miniblog_distfiles/miniblog/user_template/titles/00000000000000000000000000000000
 ./miniblog_distfiles/miniblog/user_template/template
-./miniblog_distfiles/miniblog/user_template/attachments/neko.png
+./miniblog_distfiles/miniblog/user_template/titles/00000000000000000000000000000000
+./miniblog_distfiles/miniblog/userdir/.keep
+./miniblog_distfiles/miniblog/views/index.html
+./miniblog_distfiles/miniblog/views/user.html
 `)
 
 	if strings.TrimSpace(string(output)) != expected {
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	configPathEnv  = "KOSENCTFX_CONFIG"
+	profileNameEnv = "KOSENCTFX_PROFILE"
+)
+
+// Profile は1つのサーバに対する設定。flagで指定されなかった値はここから読む
+// tokenはshellの履歴に残らないように、環境変数、ファイル、コマンドの出力のいずれかから読む
+type Profile struct {
+	Name         string `yaml:"-"`
+	URL          string `yaml:"url"`
+	TokenEnv     string `yaml:"token_env"`
+	TokenFile    string `yaml:"token_file"`
+	TokenCommand string `yaml:"token_command"`
+	Dir          string `yaml:"dir"`
+	// trueなら変更を加えるコマンドの前に確認する
+	Production bool `yaml:"production"`
+}
+
+// cliConfig は設定ファイルの中身
+//
+//	default: staging
+//	profiles:
+//	  staging:
+//	    url: https://staging.example.com
+//	    token_env: KOSENCTFX_STAGING_TOKEN
+//	    dir: ./tasks
+//	  prod:
+//	    url: https://ctf.example.com
+//	    token_command: pass show kosenctfx/prod
+//	    dir: ./tasks
+//	    production: true
+type cliConfig struct {
+	Default  string              `yaml:"default"`
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+var (
+	// profile は--profileで選ばれた設定。設定ファイルがなければ空
+	profile = &Profile{}
+	// assumeYes なら確認せずに実行する
+	assumeYes bool
+	// confirmInput は確認の入力を読む先
+	confirmInput io.Reader = os.Stdin
+)
+
+func configPath() (string, error) {
+	if p := os.Getenv(configPathEnv); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return filepath.Join(dir, "kosenctfx", "config.yml"), nil
+}
+
+// expandHome は先頭の~をhome directoryにする
+func expandHome(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, p[1:])
+}
+
+// loadProfile は設定ファイルからnameのprofileを読む
+// nameが空なら環境変数、設定ファイルのdefaultの順に探し、どれもなければ空のprofileを返す
+func loadProfile(path, name string) (*Profile, error) {
+	explicit := name != ""
+	if name == "" {
+		name = os.Getenv(profileNameEnv)
+		explicit = name != ""
+	}
+
+	src, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return &Profile{}, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	var conf cliConfig
+	if err := yaml.UnmarshalStrict(src, &conf); err != nil {
+		return nil, xerrors.Errorf("%s: %w", path, err)
+	}
+	if name == "" {
+		name = conf.Default
+	}
+	if name == "" {
+		return &Profile{}, nil
+	}
+
+	p, ok := conf.Profiles[name]
+	if !ok || p == nil {
+		names := make([]string, 0, len(conf.Profiles))
+		for n := range conf.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, xerrors.Errorf("%s: no profile named %s. Available profiles: %s", path, name, strings.Join(names, ", "))
+	}
+	sources := 0
+	for _, s := range []string{p.TokenEnv, p.TokenFile, p.TokenCommand} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, xerrors.Errorf("%s: profile %s: set only one of token_env, token_file and token_command", path, name)
+	}
+	p.Name = name
+	p.Dir = expandHome(p.Dir)
+	return p, nil
+}
+
+// Token はprofileのtoken sourceからtokenを読む。token sourceがなければ空文字列
+func (p *Profile) Token() (string, error) {
+	switch {
+	case p.TokenEnv != "":
+		token := strings.TrimSpace(os.Getenv(p.TokenEnv))
+		if token == "" {
+			return "", xerrors.Errorf("profile %s: environment variable %s is empty", p.Name, p.TokenEnv)
+		}
+		return token, nil
+
+	case p.TokenFile != "":
+		path := expandHome(p.TokenFile)
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", xerrors.Errorf("profile %s: %w", p.Name, err)
+		}
+		if info.Mode().Perm()&0077 != 0 {
+			log.Printf("[-] %s can be read by other users. Run chmod 600 %s\n", path, path)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", xerrors.Errorf("profile %s: %w", p.Name, err)
+		}
+		token := strings.TrimSpace(string(b))
+		if token == "" {
+			return "", xerrors.Errorf("profile %s: %s is empty", p.Name, path)
+		}
+		return token, nil
+
+	case p.TokenCommand != "":
+		// passやsecret-toolなどpassword managerのコマンドを想定している
+		cmd := exec.Command("sh", "-c", p.TokenCommand)
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", xerrors.Errorf("profile %s: token_command failed: %w", p.Name, err)
+		}
+		token := strings.TrimSpace(string(bytes.SplitN(out, []byte("\n"), 2)[0]))
+		if token == "" {
+			return "", xerrors.Errorf("profile %s: token_command printed nothing", p.Name)
+		}
+		return token, nil
+	}
+	return "", nil
+}
+
+// resolveToken は-tokenが指定されていなければprofileからtokenを読む
+// -urlでprofileとは別のサーバを指しているときはprofileのtokenをそこへ送らないよう、-tokenを必須にする
+func resolveToken(url, token string) (string, error) {
+	if token != "" {
+		return token, nil
+	}
+	if url != "" && profile.URL != "" && strings.TrimSuffix(url, "/") != strings.TrimSuffix(profile.URL, "/") {
+		return "", xerrors.Errorf("%s is not the server of the profile %s (%s). Pass -token to use the other server", url, profile.Name, profile.URL)
+	}
+	token, err := profile.Token()
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return token, nil
+}
+
+// confirmProduction はproductionのprofileで変更を加える前にprofile名を入力させる
+func confirmProduction(action string) error {
+	if !profile.Production || assumeYes {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "You are about to %s on the production profile %q (%s).\n", action, profile.Name, profile.URL)
+	fmt.Fprintf(os.Stderr, "Type the profile name to continue: ")
+	line, err := bufio.NewReader(confirmInput).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return xerrors.Errorf(": %w", err)
+	}
+	if strings.TrimSpace(line) != profile.Name {
+		return xerrors.New("aborted")
+	}
+	return nil
+}
+
+// globalOptions はどのサブコマンドにも使える--profileと--yesをargsから取り除く
+// -- より後ろはサブコマンドの引数としてそのまま残す
+func globalOptions(args []string) ([]string, string, bool, error) {
+	rest := make([]string, 0, len(args))
+	name := ""
+	yes := false
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		switch {
+		case a == "--profile" || a == "-profile":
+			if i+1 >= len(args) {
+				return nil, "", false, xerrors.Errorf("%s requires a profile name", a)
+			}
+			i++
+			name = args[i]
+		case strings.HasPrefix(a, "--profile=") || strings.HasPrefix(a, "-profile="):
+			name = a[strings.Index(a, "=")+1:]
+		case a == "--yes" || a == "-yes":
+			yes = true
+		default:
+			rest = append(rest, a)
+		}
+	}
+	return rest, name, yes, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+default: staging
+profiles:
+  staging:
+    url: https://staging.example.com
+    token_env: KOSENCTFX_TEST_TOKEN
+    dir: ./tasks
+  prod:
+    url: https://ctf.example.com
+    token_command: echo prod-token
+    production: true
+  broken:
+    token_env: KOSENCTFX_TEST_TOKEN
+    token_file: token.txt
+`
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadProfile(t *testing.T) {
+	path := writeConfig(t, testConfig)
+	os.Unsetenv(profileNameEnv)
+
+	p, err := loadProfile(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "staging" || p.URL != "https://staging.example.com" || p.Dir != "./tasks" || p.Production {
+		t.Errorf("expected the default profile, got %+v", p)
+	}
+
+	os.Setenv(profileNameEnv, "prod")
+	defer os.Unsetenv(profileNameEnv)
+	p, err = loadProfile(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "prod" || !p.Production {
+		t.Errorf("expected the profile from the environment, got %+v", p)
+	}
+
+	if _, err := loadProfile(path, "unknown"); err == nil || !strings.Contains(err.Error(), "broken, prod, staging") {
+		t.Errorf("expected an error listing the profiles, got %v", err)
+	}
+	if _, err := loadProfile(path, "broken"); err == nil {
+		t.Error("expected two token sources to be an error")
+	}
+	if _, err := loadProfile(writeConfig(t, "profiles:\n  x:\n    tokne_env: X\n"), "x"); err == nil {
+		t.Error("expected an unknown key to be an error")
+	}
+
+	// 設定ファイルがなくてもprofileを指定しなければ動く
+	os.Unsetenv(profileNameEnv)
+	missing := filepath.Join(t.TempDir(), "config.yml")
+	if p, err := loadProfile(missing, ""); err != nil || p.URL != "" {
+		t.Errorf("expected an empty profile, got %+v, %v", p, err)
+	}
+	if _, err := loadProfile(missing, "staging"); err == nil {
+		t.Error("expected a missing config file to be an error when a profile is given")
+	}
+}
+
+func TestProfileToken(t *testing.T) {
+	os.Setenv("KOSENCTFX_TEST_TOKEN", "env-token\n")
+	defer os.Unsetenv("KOSENCTFX_TEST_TOKEN")
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		profile  Profile
+		expected string
+	}{
+		{Profile{TokenEnv: "KOSENCTFX_TEST_TOKEN"}, "env-token"},
+		{Profile{TokenFile: tokenFile}, "file-token"},
+		{Profile{TokenCommand: "echo command-token"}, "command-token"},
+		{Profile{}, ""},
+	} {
+		token, err := c.profile.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != c.expected {
+			t.Errorf("expected %q, got %q", c.expected, token)
+		}
+	}
+
+	for _, p := range []Profile{
+		{TokenEnv: "KOSENCTFX_TEST_UNSET"},
+		{TokenFile: filepath.Join(t.TempDir(), "missing")},
+		{TokenCommand: "exit 1"},
+	} {
+		if _, err := p.Token(); err == nil {
+			t.Errorf("expected an error for %+v", p)
+		}
+	}
+}
+
+func TestGlobalOptions(t *testing.T) {
+	args, name, yes, err := globalOptions([]string{"sql", "--profile", "prod", "-confirm", "--yes", "--", "--yes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "prod" || !yes || !reflect.DeepEqual(args, []string{"sql", "-confirm", "--", "--yes"}) {
+		t.Errorf("unexpected result: %v %q %v", args, name, yes)
+	}
+	if _, name, _, _ := globalOptions([]string{"-profile=staging", "top"}); name != "staging" {
+		t.Errorf("expected staging, got %q", name)
+	}
+	if _, _, _, err := globalOptions([]string{"--profile"}); err == nil {
+		t.Error("expected a missing profile name to be an error")
+	}
+}
+
+func TestConfirmProduction(t *testing.T) {
+	defer func() {
+		profile = &Profile{}
+		confirmInput = os.Stdin
+	}()
+
+	profile = &Profile{Name: "staging"}
+	if err := confirmProduction("upload tasks"); err != nil {
+		t.Errorf("expected no confirmation for staging, got %v", err)
+	}
+
+	profile = &Profile{Name: "prod", Production: true}
+	confirmInput = strings.NewReader("prod\n")
+	if err := confirmProduction("upload tasks"); err != nil {
+		t.Errorf("expected the profile name to confirm, got %v", err)
+	}
+	confirmInput = strings.NewReader("y\n")
+	if err := confirmProduction("upload tasks"); err == nil {
+		t.Error("expected other answers to abort")
+	}
+}
+
+func TestResolveToken(t *testing.T) {
+	defer func() { profile = &Profile{} }()
+	profile = &Profile{Name: "staging", URL: "https://staging.example.com", TokenCommand: "echo staging-token"}
+
+	for _, url := range []string{"", "https://staging.example.com", "https://staging.example.com/"} {
+		if token, err := resolveToken(url, ""); err != nil || token != "staging-token" {
+			t.Errorf("%q: expected the profile token, got %q %v", url, token, err)
+		}
+	}
+	// profileのtokenを別のサーバに送らない
+	if token, err := resolveToken("https://ctf.example.com", ""); err == nil {
+		t.Errorf("expected an error for another server, got %q", token)
+	}
+	if token, err := resolveToken("https://ctf.example.com", "explicit"); err != nil || token != "explicit" {
+		t.Errorf("expected the explicit token, got %q %v", token, err)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/xerrors"
+)
+
+type smokeResult struct {
+	Name    string
+	Flag    string
+	OK      bool
+	Skipped bool
+	Message string
+}
+
+// smoketester は順位表に載らないチームでtask.ymlのflagを実際に提出して確かめる
+type smoketester struct {
+	url    string
+	admin  *resty.Client
+	player *resty.Client
+	format *regexp.Regexp
+}
+
+func newSmoketester(url, token string, format *regexp.Regexp) *smoketester {
+	return &smoketester{
+		url:   url,
+		admin: resty.New().SetAuthToken(token),
+		// loginで受け取ったcookieをそのまま使う
+		player: resty.New(),
+		format: format,
+	}
+}
+
+// flagProblem は提出しなくてもわかるflagの問題を返す
+func (st *smoketester) flagProblem(f string) string {
+	if f != strings.TrimSpace(f) {
+		return "the flag has leading or trailing whitespace"
+	}
+	if strings.Contains(f, "{{") && strings.Contains(f, "}}") {
+		return "the flag looks like an unexpanded template"
+	}
+	if st.format != nil && !st.format.MatchString(f) {
+		return fmt.Sprintf("the flag does not match the format %s", st.format)
+	}
+	return ""
+}
+
+// login は順位表に載らないチームを作ってloginし、チーム名を返す
+func (st *smoketester) login() (string, error) {
+	var team struct {
+		Teamname string `json:"teamname"`
+		Password string `json:"password"`
+	}
+	resp, err := st.admin.R().SetBody(map[string]interface{}{}).SetResult(&team).Post(st.url + "/admin/new-unranked-team")
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	if resp.IsError() {
+		return "", xerrors.Errorf("failed to create a team: %s %s", resp.Status(), string(resp.Body()))
+	}
+
+	resp, err = st.player.R().SetBody(map[string]interface{}{
+		"teamname": team.Teamname,
+		"password": team.Password,
+	}).Post(st.url + "/login")
+	if err != nil {
+		return team.Teamname, xerrors.Errorf(": %w", err)
+	}
+	if resp.IsError() {
+		return team.Teamname, xerrors.Errorf("failed to login: %s %s", resp.Status(), string(resp.Body()))
+	}
+	return team.Teamname, nil
+}
+
+func (st *smoketester) deleteTeam(teamname string) error {
+	resp, err := st.admin.R().SetBody(map[string]interface{}{
+		"teamname": teamname,
+	}).Post(st.url + "/admin/delete-unranked-team")
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if resp.IsError() {
+		return xerrors.Errorf("failed to delete the team %s: %s %s", teamname, resp.Status(), string(resp.Body()))
+	}
+	return nil
+}
+
+// submit はflagを提出し、正解として扱われた問題の名前を返す
+func (st *smoketester) submit(f string) (string, error) {
+	var result struct {
+		Message   string `json:"message"`
+		Challenge string `json:"challenge"`
+	}
+	resp, err := st.player.R().SetBody(map[string]interface{}{
+		"flag": f,
+	}).SetResult(&result).Post(st.url + "/submit")
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	if resp.IsError() {
+		return "", xerrors.Errorf("%s", responseMessage(resp.Body()))
+	}
+	return result.Challenge, nil
+}
+
+// test は1つの問題の全てのflagを確かめる
+func (st *smoketester) test(tasky *TaskYaml, remote *service.Challenge) []smokeResult {
+	flags := append([]string{tasky.Flag}, tasky.Flags...)
+	results := make([]smokeResult, 0, len(flags))
+	for _, f := range flags {
+		r := smokeResult{Name: tasky.Name, Flag: f}
+		switch {
+		case remote == nil:
+			r.Message = "the challenge is not on the server"
+		case !remote.IsOpen:
+			r.Skipped = true
+			r.Message = "the challenge is not open"
+		default:
+			if problem := st.flagProblem(f); problem != "" {
+				r.Message = problem
+				break
+			}
+			chal, err := st.submit(f)
+			if err != nil {
+				r.Message = fmt.Sprintf("rejected: %v", err)
+			} else if chal != tasky.Name {
+				r.Message = fmt.Sprintf("accepted for %s", chal)
+			} else {
+				r.OK = true
+			}
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func runSmoketest(args []string) error {
+	var url, token, dir, only, format string
+	var keep bool
+	fs := flag.NewFlagSet("smoketest", flag.ExitOnError)
+	fs.StringVar(&url, "url", profile.URL, "An endpoint of a staging scoreserver")
+	fs.StringVar(&token, "token", "", "An administrative token (default: read from the profile)")
+	fs.StringVar(&dir, "dir", profile.Dir, "tasks directory")
+	fs.StringVar(&only, "only", "", "test only the task of this name")
+	fs.StringVar(&format, "format", "", "regexp every flag must match (e.g. ^KosenCTF\\{.+\\}$)")
+	fs.BoolVar(&keep, "keep", false, "keep the team after the test")
+	fs.Usage = func() {
+		fmt.Printf("Usage: %s smoketest\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	token, err := resolveToken(url, token)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if url == "" || token == "" || dir == "" {
+		fs.Usage()
+		return nil
+	}
+	url = strings.TrimSuffix(url, "/")
+	if err := confirmProduction("create a test team and submit every flag"); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+
+	var formatRe *regexp.Regexp
+	if format != "" {
+		if formatRe, err = regexp.Compile(format); err != nil {
+			return xerrors.Errorf("invalid format: %w", err)
+		}
+	}
+
+	tasks := make([]*TaskYaml, 0)
+	err = walkTasks(dir, func(dirpath string, tasky *TaskYaml) error {
+		if only == "" || tasky.Name == only {
+			tasks = append(tasks, tasky)
+		}
+		return nil
+	})
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Name < tasks[j].Name
+	})
+	remotes, err := fetchChallenges(url, token)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	byName := make(map[string]*service.Challenge)
+	for _, c := range remotes {
+		byName[c.Name] = c
+	}
+
+	st := newSmoketester(url, token, formatRe)
+	teamname, err := st.login()
+	if teamname != "" && !keep {
+		defer func() {
+			if err := st.deleteTeam(teamname); err != nil {
+				log.Printf("[-] %v\n", err)
+				return
+			}
+			log.Printf("[+] deleted the team %s\n", teamname)
+		}()
+	}
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	log.Printf("[+] submitting flags as %s\n", teamname)
+
+	ok, ng, skipped := 0, 0, 0
+	for _, tasky := range tasks {
+		for _, r := range st.test(tasky, byName[tasky.Name]) {
+			switch {
+			case r.OK:
+				ok++
+				log.Printf("[+] OK: %s %q\n", r.Name, r.Flag)
+			case r.Skipped:
+				skipped++
+				log.Printf("[+] SKIP: %s (%s)\n", r.Name, r.Message)
+			default:
+				ng++
+				log.Printf("[-] NG: %s %q: %s\n", r.Name, r.Flag, r.Message)
+			}
+		}
+	}
+	log.Printf("[+] %d flags OK, %d NG, %d skipped\n", ok, ng, skipped)
+	if ng > 0 {
+		return xerrors.Errorf("%d flags are not accepted", ng)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+)
+
+func TestSmoketestFlagProblem(t *testing.T) {
+	st := newSmoketester("", "", regexp.MustCompile(`^KosenCTF\{.+\}$`))
+	cases := map[string]bool{
+		"KosenCTF{ok}":             true,
+		"KosenCTF{ok} ":            false,
+		"KosenCTF{ok}\n":           false,
+		"KosenCTF{{{ .Flag }}}":    false,
+		"FLAG{other_format}":       false,
+		"KosenCTF{nested{braces}}": true,
+	}
+	for f, ok := range cases {
+		if problem := st.flagProblem(f); (problem == "") != ok {
+			t.Errorf("%q: unexpected problem %q", f, problem)
+		}
+	}
+}
+
+func TestRunSmoketest(t *testing.T) {
+	dir := t.TempDir()
+	writeTask(t, dir, "web", `
+name: web
+flag: "KosenCTF{web}"
+flags:
+  - "KosenCTF{web_alt}"
+`)
+	writeTask(t, dir, "typo", `
+name: typo
+flag: "KosenCTF{typo}"
+`)
+	writeTask(t, dir, "closed", `
+name: closed
+flag: "KosenCTF{closed}"
+`)
+
+	const session = "session-token"
+	deleted := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/admin/list-challenges":
+			writeJSON(w, []*service.Challenge{
+				{Name: "web", IsOpen: true},
+				{Name: "typo", IsOpen: true},
+				{Name: "closed"},
+			})
+		case "/admin/new-unranked-team":
+			writeJSON(w, map[string]string{"teamname": "unranked_test", "password": "password"})
+		case "/admin/delete-unranked-team":
+			var req struct {
+				Teamname string `json:"teamname"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			deleted = req.Teamname
+			writeJSON(w, map[string]string{"message": "The team is deleted"})
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "kosenctfx_token", Value: session})
+			writeJSON(w, map[string]string{"message": "Logged in"})
+		case "/submit":
+			if c, err := r.Cookie("kosenctfx_token"); err != nil || c.Value != session {
+				w.WriteHeader(http.StatusUnauthorized)
+				writeJSON(w, map[string]string{"message": "Login is required"})
+				return
+			}
+			var req struct {
+				Flag string `json:"flag"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			// typoのflagはサーバではwebのflagとして登録されている
+			switch req.Flag {
+			case "KosenCTF{web}", "KosenCTF{web_alt}", "KosenCTF{typo}":
+				writeJSON(w, map[string]string{"message": "Correct!", "challenge": "web"})
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				writeJSON(w, map[string]string{"message": "Wrong flag..."})
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	err := runSmoketest([]string{"-url", server.URL, "-token", "token", "-dir", dir})
+	if err == nil {
+		t.Fatal("expected the flag of typo to fail")
+	}
+	if deleted != "unranked_test" {
+		t.Errorf("expected the team to be deleted, got %q", deleted)
+	}
+
+	deleted = ""
+	if err := runSmoketest([]string{"-url", server.URL, "-token", "token", "-dir", dir, "-only", "web", "-keep"}); err != nil {
+		t.Fatal(err)
+	}
+	if deleted != "" {
+		t.Errorf("expected the team to be kept, got %q deleted", deleted)
+	}
+}
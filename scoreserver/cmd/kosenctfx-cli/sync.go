@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/xerrors"
+)
+
+// syncTargets はdir以下の問題のうち、サーバが持っているhashと中身が違うものを返す
+// どのマシンから同期しても同じ結果になるように、hashはサーバに保存したものと比べる
+func syncTargets(dir string, remotes []*service.Challenge) (map[string]*TaskYaml, map[string]bool, error) {
+	remoteHashes := make(map[string]string)
+	for _, r := range remotes {
+		remoteHashes[r.Name] = r.SyncHash
+	}
+
+	targets := make(map[string]*TaskYaml)
+	names := make(map[string]bool)
+	err := walkTasks(dir, func(dirpath string, tasky *TaskYaml) error {
+		names[tasky.Name] = true
+		h, err := dirhash.HashDir(dirpath, "", dirhash.Hash1)
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		tasky.SyncHash = h
+		if remoteHashes[tasky.Name] == h {
+			log.Printf("[+] SKIP: %s\n", tasky.Name)
+			return nil
+		}
+		targets[dirpath] = tasky
+		return nil
+	})
+	if err != nil {
+		return nil, nil, xerrors.Errorf(": %w", err)
+	}
+	return targets, names, nil
+}
+
+// pruneTargets はtask directoryが消された問題を返す
+// 管理画面から作ったり編集したりした問題はhashを持たないので触らない
+func pruneTargets(names map[string]bool, remotes []*service.Challenge) []*service.Challenge {
+	pruned := make([]*service.Challenge, 0)
+	for _, r := range remotes {
+		if r.SyncHash != "" && !names[r.Name] {
+			pruned = append(pruned, r)
+		}
+	}
+	return pruned
+}
+
+func pruneChallenge(url, token, name string) error {
+	resp, err := resty.New().SetAuthToken(token).R().
+		SetBody(map[string]interface{}{"name": name}).
+		Post(url + "/admin/prune-challenge")
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if resp.IsError() {
+		return xerrors.Errorf("failed to prune %s: %s %s", name, resp.Status(), string(resp.Body()))
+	}
+	return nil
+}
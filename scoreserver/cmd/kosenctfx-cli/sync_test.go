@@ -0,0 +1,49 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+func TestSyncTargets(t *testing.T) {
+	dir := t.TempDir()
+	writeTask(t, dir, "synced", `
+name: synced
+flag: "KosenCTF{synced}"
+`)
+	writeTask(t, dir, "changed", `
+name: changed
+flag: "KosenCTF{changed}"
+`)
+	h, err := dirhash.HashDir(filepath.Join(dir, "synced"), "", dirhash.Hash1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remotes := []*service.Challenge{
+		{Name: "synced", SyncHash: h},
+		{Name: "changed", SyncHash: "h1:old"},
+		{Name: "removed", SyncHash: "h1:removed"},
+		// 管理画面で作った問題
+		{Name: "manual"},
+	}
+
+	targets, names, err := syncTargets(dir, remotes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 {
+		t.Fatalf("expected only changed to be synced, got %v", targets)
+	}
+	tasky := targets[filepath.Join(dir, "changed")]
+	if tasky == nil || tasky.SyncHash == "" || tasky.SyncHash == "h1:old" {
+		t.Errorf("unexpected target: %+v", tasky)
+	}
+
+	pruned := pruneTargets(names, remotes)
+	if len(pruned) != 1 || pruned[0].Name != "removed" {
+		t.Errorf("expected only removed to be pruned, got %v", pruned)
+	}
+}
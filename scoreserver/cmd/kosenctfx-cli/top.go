@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/xerrors"
+)
+
+const (
+	defaultTopInterval = 5 * time.Second
+	topScoreboardSize  = 10
+	topRecentSolves    = 10
+	topNameWidth       = 24
+)
+
+// 画面の制御に使うANSI escape sequence
+const (
+	ansiAltScreen   = "\x1b[?1049h\x1b[?25l"
+	ansiMainScreen  = "\x1b[?25h\x1b[?1049l"
+	ansiClearScreen = "\x1b[H\x1b[2J"
+	ansiRed         = "\x1b[31m"
+	ansiGreen       = "\x1b[32m"
+	ansiReset       = "\x1b[0m"
+)
+
+type metricSample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// parseMetrics はPrometheusのtext formatを読む。topで使う分だけなのでtimestampなどは読み捨てる
+func parseMetrics(r io.Reader) ([]metricSample, error) {
+	samples := make([]metricSample, 0)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sample := metricSample{Labels: make(map[string]string)}
+		i := strings.IndexAny(line, "{ ")
+		if i < 0 {
+			return nil, xerrors.Errorf("invalid metric line: %s", line)
+		}
+		sample.Name = line[:i]
+		rest := line[i:]
+		if strings.HasPrefix(rest, "{") {
+			var err error
+			rest, err = parseMetricLabels(rest[1:], sample.Labels)
+			if err != nil {
+				return nil, xerrors.Errorf("%s: %w", line, err)
+			}
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, xerrors.Errorf("invalid metric line: %s", line)
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid metric line: %s", line)
+		}
+		sample.Value = v
+		samples = append(samples, sample)
+	}
+	if err := s.Err(); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return samples, nil
+}
+
+// parseMetricLabels は {の後ろから label="value",... を読み、}より後ろを返す
+func parseMetricLabels(s string, labels map[string]string) (string, error) {
+	for {
+		s = strings.TrimLeft(s, " ,")
+		if strings.HasPrefix(s, "}") {
+			return s[1:], nil
+		}
+		eq := strings.Index(s, "=\"")
+		if eq < 0 {
+			return "", xerrors.New("invalid label")
+		}
+		key := s[:eq]
+		s = s[eq+2:]
+		var value strings.Builder
+		closed := false
+		for i := 0; i < len(s); i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				if s[i] == 'n' {
+					value.WriteByte('\n')
+				} else {
+					value.WriteByte(s[i])
+				}
+				continue
+			}
+			if s[i] == '"' {
+				s = s[i+1:]
+				closed = true
+				break
+			}
+			value.WriteByte(s[i])
+		}
+		if !closed {
+			return "", xerrors.New("unterminated label value")
+		}
+		labels[key] = value.String()
+	}
+}
+
+type topSolve struct {
+	Team      string `json:"team"`
+	Challenge string `json:"challenge"`
+	SolvedAt  int64  `json:"solved_at"`
+}
+
+type topChallenge struct {
+	Name     string
+	Category string
+	Solves   int
+	Correct  int64
+	Wrong    int64
+	// 監視していない問題はnil
+	Up *bool
+}
+
+// topSnapshot はtopの1画面分の値
+type topSnapshot struct {
+	At               time.Time
+	Teams            int64
+	Sessions         int64
+	Submissions      int64
+	ValidSubmissions int64
+	Scoreboard       []*service.ScoreFeedEntry
+	Solves           []topSolve
+	Challenges       []*topChallenge
+	// 取得に失敗したものがあっても他は表示する
+	Errors []string
+}
+
+type topClient struct {
+	url    string
+	client *resty.Client
+	window time.Duration
+}
+
+func (tc *topClient) get(p string, query map[string]string) ([]byte, error) {
+	resp, err := tc.client.R().SetQueryParams(query).Get(tc.url + p)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if resp.IsError() {
+		return nil, xerrors.Errorf("%s: %s", p, apiError(resp))
+	}
+	return resp.Body(), nil
+}
+
+func (tc *topClient) challenge(challenges map[string]*topChallenge, name, category string) *topChallenge {
+	c, ok := challenges[name]
+	if !ok {
+		c = &topChallenge{Name: name, Category: category}
+		challenges[name] = c
+	}
+	if c.Category == "" {
+		c.Category = category
+	}
+	return c
+}
+
+// snapshot は/admin/metrics、/scoreboard、/admin/submission-statsから今の状態を集める
+func (tc *topClient) snapshot(now time.Time) *topSnapshot {
+	snap := &topSnapshot{At: now}
+	challenges := make(map[string]*topChallenge)
+
+	if body, err := tc.get("/admin/metrics", nil); err != nil {
+		snap.Errors = append(snap.Errors, err.Error())
+	} else if samples, err := parseMetrics(bytes.NewReader(body)); err != nil {
+		snap.Errors = append(snap.Errors, err.Error())
+	} else {
+		for _, m := range samples {
+			switch m.Name {
+			case "number_of_registered_teams":
+				snap.Teams = int64(m.Value)
+			case "number_of_active_sessions":
+				snap.Sessions = int64(m.Value)
+			case "number_of_submitted_flags":
+				snap.Submissions = int64(m.Value)
+			case "number_of_valid_flags":
+				snap.ValidSubmissions = int64(m.Value)
+			case "solve":
+				tc.challenge(challenges, m.Labels["name"], m.Labels["category"]).Solves = int(m.Value)
+			case "challenge_service_up":
+				up := m.Value > 0
+				tc.challenge(challenges, m.Labels["name"], m.Labels["category"]).Up = &up
+			}
+		}
+	}
+
+	var scoreboard []*service.ScoreFeedEntry
+	if body, err := tc.get("/scoreboard", nil); err != nil {
+		snap.Errors = append(snap.Errors, err.Error())
+	} else if err := json.Unmarshal(body, &scoreboard); err != nil {
+		snap.Errors = append(snap.Errors, err.Error())
+	} else {
+		sort.Slice(scoreboard, func(i, j int) bool {
+			return scoreboard[i].Pos < scoreboard[j].Pos
+		})
+		if len(scoreboard) > topScoreboardSize {
+			scoreboard = scoreboard[:topScoreboardSize]
+		}
+		snap.Scoreboard = scoreboard
+	}
+
+	var since int64
+	if tc.window > 0 {
+		since = now.Add(-tc.window).Unix()
+	}
+	var stats struct {
+		Solves     []topSolve `json:"solves"`
+		Challenges []struct {
+			Name     string `json:"name"`
+			Category string `json:"category"`
+			Correct  int64  `json:"correct"`
+			Wrong    int64  `json:"wrong"`
+		} `json:"challenges"`
+	}
+	query := map[string]string{
+		"since": strconv.FormatInt(since, 10),
+		"limit": strconv.Itoa(topRecentSolves),
+	}
+	if body, err := tc.get("/admin/submission-stats", query); err != nil {
+		snap.Errors = append(snap.Errors, err.Error())
+	} else if err := json.Unmarshal(body, &stats); err != nil {
+		snap.Errors = append(snap.Errors, err.Error())
+	} else {
+		snap.Solves = stats.Solves
+		for _, s := range stats.Challenges {
+			c := tc.challenge(challenges, s.Name, s.Category)
+			c.Correct = s.Correct
+			c.Wrong = s.Wrong
+		}
+	}
+
+	for _, c := range challenges {
+		snap.Challenges = append(snap.Challenges, c)
+	}
+	sort.Slice(snap.Challenges, func(i, j int) bool {
+		a, b := snap.Challenges[i], snap.Challenges[j]
+		// 落ちているサービスと間違いの多い問題を上に出す
+		if aDown, bDown := a.Up != nil && !*a.Up, b.Up != nil && !*b.Up; aDown != bDown {
+			return aDown
+		}
+		if a.Wrong != b.Wrong {
+			return a.Wrong > b.Wrong
+		}
+		return a.Name < b.Name
+	})
+	return snap
+}
+
+func truncateName(s string) string {
+	r := []rune(s)
+	if len(r) <= topNameWidth {
+		return s
+	}
+	return string(r[:topNameWidth-1]) + "…"
+}
+
+// renderTop はsnapshotを書き出す。colorならサービスの状態に色を付ける
+func renderTop(w io.Writer, title string, snap *topSnapshot, window time.Duration, color bool) error {
+	paint := func(s, c string) string {
+		if !color {
+			return s
+		}
+		return c + s + ansiReset
+	}
+
+	fmt.Fprintf(w, "%s  %s\n", title, snap.At.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(w, "teams: %d  sessions: %d  submissions: %d (valid: %d)\n\n", snap.Teams, snap.Sessions, snap.Submissions, snap.ValidSubmissions)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "POS\tTEAM\tSCORE\tLAST SUBMISSION")
+	for _, e := range snap.Scoreboard {
+		last := "-"
+		if e.LastSubmission > 0 {
+			last = time.Unix(e.LastSubmission, 0).Format("15:04:05")
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\n", e.Pos, truncateName(e.Teamname), e.Score, last)
+	}
+	if err := tw.Flush(); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	fmt.Fprintln(w)
+
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOLVED AT\tTEAM\tCHALLENGE")
+	for _, s := range snap.Solves {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", time.Unix(s.SolvedAt, 0).Format("15:04:05"), truncateName(s.Team), truncateName(s.Challenge))
+	}
+	if err := tw.Flush(); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	fmt.Fprintln(w)
+
+	span := "all time"
+	if window > 0 {
+		span = "last " + window.String()
+	}
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "CHALLENGE\tCATEGORY\tSOLVES\tWRONG (%s)\tWRONG RATE\tSERVICE\n", span)
+	for _, c := range snap.Challenges {
+		rate := "-"
+		if total := c.Correct + c.Wrong; total > 0 {
+			rate = fmt.Sprintf("%.0f%%", float64(c.Wrong)*100/float64(total))
+		}
+		// 色のescape sequenceはtabwriterの幅の計算を狂わせるので最後の列にだけ付ける
+		health := "-"
+		if c.Up != nil && *c.Up {
+			health = paint("up", ansiGreen)
+		} else if c.Up != nil {
+			health = paint("DOWN", ansiRed)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\n", truncateName(c.Name), c.Category, c.Solves, c.Wrong, rate, health)
+	}
+	if err := tw.Flush(); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+
+	for _, e := range snap.Errors {
+		fmt.Fprintf(w, "\n%s\n", paint("[-] "+e, ansiRed))
+	}
+	return nil
+}
+
+func runTop(args []string) error {
+	var url, token string
+	var interval, window time.Duration
+	var once bool
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	fs.StringVar(&url, "url", profile.URL, "An endpoint of scoreserver")
+	fs.StringVar(&token, "token", "", "An administrative token (default: read from the profile)")
+	fs.DurationVar(&interval, "interval", defaultTopInterval, "how often to refresh")
+	fs.DurationVar(&window, "window", time.Hour, "count wrong flags submitted within this duration (0 for all time)")
+	fs.BoolVar(&once, "once", false, "print the dashboard once and exit")
+	fs.Usage = func() {
+		fmt.Printf("Usage: %s top\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	token, err := resolveToken(url, token)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if url == "" || token == "" || interval <= 0 || window < 0 {
+		fs.Usage()
+		return nil
+	}
+	url = strings.TrimSuffix(url, "/")
+
+	tc := &topClient{
+		url:    url,
+		client: resty.New().SetAuthToken(token).SetTimeout(interval),
+		window: window,
+	}
+	title := "kosenctfx top - " + url
+	if once {
+		return renderTop(stdout, title, tc.snapshot(time.Now()), window, false)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Fprint(stdout, ansiAltScreen)
+	defer fmt.Fprint(stdout, ansiMainScreen)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// 取得している間も前の画面を出しておき、まとめて書き換える
+		var buf bytes.Buffer
+		buf.WriteString(ansiClearScreen)
+		if err := renderTop(&buf, title, tc.snapshot(time.Now()), window, true); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		buf.WriteString("\nCtrl-C to quit\n")
+		stdout.Write(buf.Bytes())
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+)
+
+const testMetrics = `# HELP number_of_active_sessions
+# TYPE number_of_active_sessions gauge
+number_of_active_sessions 12
+number_of_registered_teams 34
+number_of_submitted_flags 560
+number_of_valid_flags 78
+solve{category="web",name="web \"1\""} 5
+solve{category="pwn",name="pwn"} 0
+challenge_service_up{category="pwn",name="pwn"} 0
+challenge_service_up{category="web",name="web \"1\""} 1
+`
+
+func TestParseMetrics(t *testing.T) {
+	samples, err := parseMetrics(strings.NewReader(testMetrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 8 {
+		t.Fatalf("expected 8 samples, got %d", len(samples))
+	}
+	s := samples[4]
+	if s.Name != "solve" || s.Labels["name"] != `web "1"` || s.Labels["category"] != "web" || s.Value != 5 {
+		t.Errorf("unexpected sample: %+v", s)
+	}
+
+	if _, err := parseMetrics(strings.NewReader(`solve{name="web} 1`)); err == nil {
+		t.Error("expected an unterminated label to be an error")
+	}
+}
+
+func TestTopSnapshot(t *testing.T) {
+	since := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/admin/metrics":
+			w.Write([]byte(testMetrics))
+		case "/scoreboard":
+			entries := make([]*service.ScoreFeedEntry, 0)
+			for i := 12; i >= 1; i-- {
+				entries = append(entries, &service.ScoreFeedEntry{Pos: i, Teamname: "team" + strings.Repeat("x", i), Score: 1000 - i})
+			}
+			writeJSON(w, entries)
+		case "/admin/submission-stats":
+			since = r.URL.Query().Get("since")
+			writeJSON(w, map[string]interface{}{
+				"solves": []topSolve{{Team: "teamx", Challenge: `web "1"`, SolvedAt: 1600000000}},
+				"challenges": []map[string]interface{}{
+					{"name": `web "1"`, "category": "web", "correct": 5, "wrong": 15},
+					{"name": "rev", "category": "rev", "correct": 1, "wrong": 30},
+				},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tc := &topClient{url: server.URL, client: resty.New(), window: time.Hour}
+	now := time.Unix(1600003600, 0)
+	snap := tc.snapshot(now)
+	if len(snap.Errors) != 0 {
+		t.Fatalf("unexpected errors: %v", snap.Errors)
+	}
+	if since != "1600000000" {
+		t.Errorf("expected since to be an hour ago, got %s", since)
+	}
+	if snap.Sessions != 12 || snap.Teams != 34 || snap.Submissions != 560 || snap.ValidSubmissions != 78 {
+		t.Errorf("unexpected counters: %+v", snap)
+	}
+	if len(snap.Scoreboard) != topScoreboardSize || snap.Scoreboard[0].Pos != 1 {
+		t.Errorf("expected the top %d teams, got %d", topScoreboardSize, len(snap.Scoreboard))
+	}
+	// 落ちているサービス、間違いの多い問題の順に並ぶ
+	names := make([]string, 0)
+	for _, c := range snap.Challenges {
+		names = append(names, c.Name)
+	}
+	if strings.Join(names, ",") != `pwn,rev,web "1"` {
+		t.Errorf("unexpected order: %v", names)
+	}
+
+	var buf bytes.Buffer
+	if err := renderTop(&buf, "top", snap, time.Hour, false); err != nil {
+		t.Fatal(err)
+	}
+	output := buf.String()
+	for _, expected := range []string{
+		"sessions: 12",
+		"WRONG (last 1h0m0s)",
+		"DOWN",
+		"75%",
+		"teamx",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in\n%s", expected, output)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"golang.org/x/xerrors"
+)
+
+const (
+	defaultUploadRetries      = 3
+	defaultUploadBackoff      = 1 * time.Second
+	defaultMultipartThreshold = 64 << 20
+	defaultPartSize           = 16 << 20
+	// S3は最後以外の部分が5MB未満だと受け付けない
+	minPartSize = 5 << 20
+	maxParts    = 10000
+	// 1回のPUTにかける時間の上限
+	uploadTimeout = 30 * time.Minute
+)
+
+// errMultipartUnavailable はserverやbucketがmultipart uploadに対応していないときのエラー
+var errMultipartUnavailable = xerrors.New("multipart upload is not available")
+
+// uploader はファイルをbucketにuploadする
+// 大きなファイルは分割してuploadし、失敗したら間隔を倍にしながらやり直す
+type uploader struct {
+	url     string
+	client  *resty.Client
+	http    *http.Client
+	retries int
+	backoff time.Duration
+
+	multipartThreshold int64
+	partSize           int64
+}
+
+func newUploader(url, token string) *uploader {
+	return &uploader{
+		url:                url,
+		client:             resty.New().SetAuthToken(token),
+		http:               &http.Client{Timeout: uploadTimeout},
+		retries:            defaultUploadRetries,
+		backoff:            defaultUploadBackoff,
+		multipartThreshold: defaultMultipartThreshold,
+		partSize:           defaultPartSize,
+	}
+}
+
+type fileDigest struct {
+	Sha256 string
+	MD5    string
+}
+
+// digest は中身をメモリに載せずにsha256とmd5を計算する
+func digest(r io.ReaderAt, size int64) (*fileDigest, error) {
+	s, m := sha256.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(s, m), io.NewSectionReader(r, 0, size)); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return &fileDigest{
+		Sha256: hex.EncodeToString(s.Sum(nil)),
+		MD5:    hex.EncodeToString(m.Sum(nil)),
+	}, nil
+}
+
+// verifyETag はbucketが返したETagとuploadした中身が一致するか確かめる
+// S3やGCSはmd5を、LOCALはsha256を返す。分割したものなど中身のhashでないETagは確かめようがないので通す
+func verifyETag(etag string, d *fileDigest) error {
+	etag = strings.ToLower(strings.Trim(strings.TrimPrefix(etag, "W/"), `"`))
+	if _, err := hex.DecodeString(etag); err != nil {
+		return nil
+	}
+	switch {
+	case len(etag) == md5.Size*2 && etag != d.MD5:
+		return xerrors.Errorf("uploaded content does not match: expected md5 %s, got %s", d.MD5, etag)
+	case len(etag) == sha256.Size*2 && etag != d.Sha256:
+		return xerrors.Errorf("uploaded content does not match: expected sha256 %s, got %s", d.Sha256, etag)
+	}
+	return nil
+}
+
+// retry はfnが成功するまで最大u.retries回やり直す
+func (u *uploader) retry(what string, fn func() error) error {
+	wait := u.backoff
+	err := fn()
+	for i := 1; err != nil && i <= u.retries; i++ {
+		log.Printf("[-] RETRY(%d/%d): %s: %v\n", i, u.retries, what, err)
+		time.Sleep(wait)
+		wait *= 2
+		err = fn()
+	}
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+// upload はfilenameとしてrの中身をuploadし、downloadURLを返す
+func (u *uploader) upload(filename string, r io.ReaderAt, size int64, d *fileDigest) (string, error) {
+	if size >= u.multipartThreshold {
+		url, err := u.uploadMultipart(filename, r, size)
+		if err == nil {
+			return url, nil
+		}
+		if !xerrors.Is(err, errMultipartUnavailable) {
+			return "", xerrors.Errorf(": %w", err)
+		}
+		log.Printf("[-] %v. uploading %s at once\n", err, filename)
+	}
+
+	var url string
+	err := u.retry(filename, func() error {
+		var err error
+		url, err = u.uploadOnce(filename, r, size, d)
+		return err
+	})
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return url, nil
+}
+
+func (u *uploader) uploadOnce(filename string, r io.ReaderAt, size int64, d *fileDigest) (string, error) {
+	var data struct {
+		PresignedURL string            `json:"presignedURL"`
+		FormData     map[string]string `json:"formData"`
+		DownloadURL  string            `json:"downloadURL"`
+	}
+	resp, err := u.client.R().
+		SetBody(map[string]interface{}{"key": filename}).
+		SetResult(&data).
+		Post(u.url + "/admin/get-presigned-url")
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	if resp.IsError() {
+		return "", xerrors.Errorf("failed to get an upload URL: %s %s", resp.Status(), string(resp.Body()))
+	}
+
+	var req *http.Request
+	if data.FormData == nil {
+		req, err = http.NewRequest(http.MethodPut, data.PresignedURL, io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return "", xerrors.Errorf(": %w", err)
+		}
+		req.ContentLength = size
+	} else {
+		req, err = newFormUploadRequest(data.PresignedURL, data.FormData, filename, r, size)
+		if err != nil {
+			return "", xerrors.Errorf(": %w", err)
+		}
+	}
+
+	etag, err := u.send(req)
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	if err := verifyETag(etag, d); err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return data.DownloadURL, nil
+}
+
+// newFormUploadRequest はファイルをメモリに載せずにmultipart/form-dataで送るrequestを作る
+// 前後の部分だけを先に作っておけばContent-Lengthも分かる
+func newFormUploadRequest(url string, form map[string]string, filename string, r io.ReaderAt, size int64) (*http.Request, error) {
+	head := new(bytes.Buffer)
+	mw := multipart.NewWriter(head)
+	for k, v := range form {
+		if err := mw.WriteField(k, v); err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+	}
+	if _, err := mw.CreateFormFile("file", filename); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	contentType := mw.FormDataContentType()
+	headLen := int64(head.Len())
+	if err := mw.Close(); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	tail := head.Bytes()[headLen:]
+
+	body := io.MultiReader(bytes.NewReader(head.Bytes()[:headLen]), io.NewSectionReader(r, 0, size), bytes.NewReader(tail))
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	req.ContentLength = headLen + size + int64(len(tail))
+	req.Header.Set("Content-Type", contentType)
+	return req, nil
+}
+
+// send はreqを送ってETagを返す
+func (u *uploader) send(req *http.Request) (string, error) {
+	resp, err := u.http.Do(req)
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1000))
+		return "", xerrors.Errorf("upload failed: %s %s", resp.Status, string(msg))
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+type uploadPart struct {
+	Number int    `json:"partNumber"`
+	ETag   string `json:"etag"`
+}
+
+// uploadMultipart はu.partSizeずつに分けてuploadする。途中で失敗したらそれまでの部分は捨てる
+func (u *uploader) uploadMultipart(filename string, r io.ReaderAt, size int64) (string, error) {
+	partSize := u.partSize
+	if (size+partSize-1)/partSize > maxParts {
+		partSize = (size + maxParts - 1) / maxParts
+	}
+	n := int((size + partSize - 1) / partSize)
+
+	var init struct {
+		Key      string   `json:"key"`
+		UploadID string   `json:"uploadID"`
+		PartURLs []string `json:"partURLs"`
+	}
+	resp, err := u.client.R().
+		SetBody(map[string]interface{}{"key": filename, "parts": n}).
+		SetResult(&init).
+		Post(u.url + "/admin/multipart-upload")
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	if resp.StatusCode() == http.StatusBadRequest || resp.StatusCode() == http.StatusNotFound {
+		return "", xerrors.Errorf("%s: %w", strings.TrimSpace(string(resp.Body())), errMultipartUnavailable)
+	}
+	if resp.IsError() {
+		return "", xerrors.Errorf("failed to start multipart upload: %s %s", resp.Status(), string(resp.Body()))
+	}
+	if len(init.PartURLs) != n {
+		return "", xerrors.Errorf("expected %d part URLs, got %d", n, len(init.PartURLs))
+	}
+
+	parts := make([]uploadPart, 0, n)
+	for i := 0; i < n; i++ {
+		off := int64(i) * partSize
+		length := partSize
+		if off+length > size {
+			length = size - off
+		}
+		section := io.NewSectionReader(r, off, length)
+		var etag string
+		err := u.retry(fmt.Sprintf("%s (part %d/%d)", filename, i+1, n), func() error {
+			var err error
+			etag, err = u.uploadPart(init.PartURLs[i], section)
+			return err
+		})
+		if err != nil {
+			u.abortMultipart(init.Key, init.UploadID)
+			return "", xerrors.Errorf(": %w", err)
+		}
+		parts = append(parts, uploadPart{Number: i + 1, ETag: etag})
+	}
+
+	var done struct {
+		DownloadURL string `json:"downloadURL"`
+	}
+	resp, err = u.client.R().
+		SetBody(map[string]interface{}{"key": init.Key, "uploadID": init.UploadID, "parts": parts}).
+		SetResult(&done).
+		Post(u.url + "/admin/complete-multipart-upload")
+	if err != nil {
+		u.abortMultipart(init.Key, init.UploadID)
+		return "", xerrors.Errorf(": %w", err)
+	}
+	if resp.IsError() {
+		u.abortMultipart(init.Key, init.UploadID)
+		return "", xerrors.Errorf("failed to complete multipart upload: %s %s", resp.Status(), string(resp.Body()))
+	}
+	return done.DownloadURL, nil
+}
+
+// uploadPart は1つの部分を送り、ETagがその部分のmd5と一致するか確かめる
+func (u *uploader) uploadPart(url string, section *io.SectionReader) (string, error) {
+	d, err := digest(section, section.Size())
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPut, url, io.NewSectionReader(section, 0, section.Size()))
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	req.ContentLength = section.Size()
+	etag, err := u.send(req)
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	if err := verifyETag(etag, d); err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return etag, nil
+}
+
+func (u *uploader) abortMultipart(key, uploadID string) {
+	resp, err := u.client.R().
+		SetBody(map[string]interface{}{"key": key, "uploadID": uploadID}).
+		Post(u.url + "/admin/abort-multipart-upload")
+	if err != nil {
+		log.Printf("[-] failed to abort multipart upload of %s: %v\n", key, err)
+	} else if resp.IsError() {
+		log.Printf("[-] failed to abort multipart upload of %s: %s\n", key, resp.Status())
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeServer はscoreserverとbucketの代わり
+type fakeServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	objects   map[string][]byte
+	parts     map[int][]byte
+	failPuts  int  // 最初のこの回数だけPUTを失敗させる
+	badETag   bool // 中身と違うETagを返す
+	multipart bool
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	f := &fakeServer{objects: make(map[string][]byte), parts: make(map[int][]byte)}
+	mux := http.NewServeMux()
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+
+	mux.HandleFunc("/admin/get-presigned-url", func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Key string }
+		json.NewDecoder(r.Body).Decode(&req)
+		writeJSON(w, map[string]interface{}{
+			"presignedURL": f.URL + "/put/" + req.Key,
+			"downloadURL":  f.URL + "/files/" + req.Key,
+		})
+	})
+	mux.HandleFunc("/put/", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.failPuts > 0 {
+			f.failPuts--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		f.objects[strings.TrimPrefix(r.URL.Path, "/put/")] = body
+		f.writeETag(w, body)
+	})
+	mux.HandleFunc("/admin/multipart-upload", func(w http.ResponseWriter, r *http.Request) {
+		if !f.multipart {
+			http.Error(w, `{"message": "The bucket does not support multipart upload"}`, http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Key   string
+			Parts int
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		urls := make([]string, req.Parts)
+		for i := range urls {
+			urls[i] = f.URL + "/part/" + strconv.Itoa(i+1)
+		}
+		writeJSON(w, map[string]interface{}{
+			"key":      "uuid/" + req.Key,
+			"uploadID": "upload",
+			"partURLs": urls,
+		})
+	})
+	mux.HandleFunc("/part/", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/part/"))
+		body, _ := ioutil.ReadAll(r.Body)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.parts[n] = body
+		f.writeETag(w, body)
+	})
+	mux.HandleFunc("/admin/complete-multipart-upload", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Key   string
+			Parts []uploadPart
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		sort.Slice(req.Parts, func(i, j int) bool {
+			return req.Parts[i].Number < req.Parts[j].Number
+		})
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		var buf bytes.Buffer
+		for _, p := range req.Parts {
+			buf.Write(f.parts[p.Number])
+		}
+		f.objects[req.Key] = buf.Bytes()
+		writeJSON(w, map[string]interface{}{
+			"downloadURL": f.URL + "/files/" + req.Key,
+		})
+	})
+	return f
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeServer) writeETag(w http.ResponseWriter, body []byte) {
+	sum := md5.Sum(body)
+	if f.badETag {
+		sum = md5.Sum(append(body, 'x'))
+	}
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+}
+
+func newTestUploader(f *fakeServer) *uploader {
+	u := newUploader(f.URL, "token")
+	u.backoff = 0
+	u.multipartThreshold = 16
+	u.partSize = 4
+	return u
+}
+
+func testUpload(t *testing.T, u *uploader, name string, content []byte) (string, error) {
+	r := bytes.NewReader(content)
+	d, err := digest(r, r.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.upload(name, r, r.Size(), d)
+}
+
+func TestUploadRetries(t *testing.T) {
+	f := newFakeServer(t)
+	f.failPuts = 2
+	url, err := testUpload(t, newTestUploader(f), "dist.tar.gz", []byte("distfiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != f.URL+"/files/dist.tar.gz" || string(f.objects["dist.tar.gz"]) != "distfiles" {
+		t.Errorf("unexpected upload: %s %q", url, f.objects["dist.tar.gz"])
+	}
+
+	f.failPuts = defaultUploadRetries + 1
+	if _, err := testUpload(t, newTestUploader(f), "dist.tar.gz", []byte("distfiles")); err == nil {
+		t.Errorf("upload should fail after retries")
+	}
+}
+
+func TestUploadVerifiesETag(t *testing.T) {
+	f := newFakeServer(t)
+	f.badETag = true
+	if _, err := testUpload(t, newTestUploader(f), "dist.tar.gz", []byte("distfiles")); err == nil {
+		t.Errorf("upload with a mismatched ETag should fail")
+	}
+}
+
+func TestUploadMultipart(t *testing.T) {
+	f := newFakeServer(t)
+	f.multipart = true
+	content := []byte("0123456789abcdefghij")
+	url, err := testUpload(t, newTestUploader(f), "large.bin", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.parts) != 5 {
+		t.Errorf("expected 5 parts, got %d", len(f.parts))
+	}
+	if url != f.URL+"/files/uuid/large.bin" || !bytes.Equal(f.objects["uuid/large.bin"], content) {
+		t.Errorf("unexpected upload: %s %q", url, f.objects["uuid/large.bin"])
+	}
+
+	// multipart uploadに対応していなければまとめて送る
+	f.multipart = false
+	if _, err := testUpload(t, newTestUploader(f), "large.bin", content); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.objects["large.bin"], content) {
+		t.Errorf("unexpected upload: %q", f.objects["large.bin"])
+	}
+}
+
+func TestVerifyETag(t *testing.T) {
+	d := &fileDigest{Sha256: strings.Repeat("a", 64), MD5: strings.Repeat("b", 32)}
+	for etag, ok := range map[string]bool{
+		`"` + d.MD5 + `"`:    true,
+		`"` + d.Sha256 + `"`: true,
+		`W/"` + d.MD5 + `"`:  true,
+		"":                   true,
+		// multipart uploadしたもののETagは中身のhashではない
+		`"` + strings.Repeat("c", 32) + `-3"`: true,
+		`"` + strings.Repeat("c", 32) + `"`:   false,
+		`"` + strings.Repeat("c", 64) + `"`:   false,
+	} {
+		if err := verifyETag(etag, d); (err == nil) != ok {
+			t.Errorf("verifyETag(%s): %v", etag, err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/xerrors"
+)
+
+const defaultWatchInterval = 1 * time.Second
+
+// watcher はtask directoryを見張り、変わった問題をすぐにサーバへ同期する
+// 中身を毎回hashするのは重いので、ファイルの名前と大きさと更新時刻で変化を見る
+type watcher struct {
+	url   string
+	token string
+	dir   string
+	u     *uploader
+
+	// 最後に同期したときのstamp
+	stamps map[string]string
+	// 変化を見つけたstamp。書き込みの途中かもしれないので、次も同じなら同期する
+	pending map[string]string
+}
+
+func newWatcher(url, token, dir string) *watcher {
+	return &watcher{
+		url:     url,
+		token:   token,
+		dir:     dir,
+		u:       newUploader(url, token),
+		stamps:  make(map[string]string),
+		pending: make(map[string]string),
+	}
+}
+
+// findTaskDirs はdir以下のtask.ymlがあるディレクトリを返す
+func findTaskDirs(dir string) ([]string, error) {
+	dirs := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		if info.Name() != "task.yml" {
+			return nil
+		}
+		dirs = append(dirs, filepath.Dir(path))
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return dirs, nil
+}
+
+// dirStamp はdir以下のファイルの名前、大きさ、更新時刻から変化を見るための値を作る
+func dirStamp(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		if info.IsDir() {
+			return nil
+		}
+		fmt.Fprintf(h, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// init はサーバのhashと違う問題を同期し、今の状態を覚える
+func (w *watcher) init() error {
+	remotes, err := fetchChallenges(w.url, w.token)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	dirs, err := findTaskDirs(w.dir)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	for _, d := range dirs {
+		stamp, err := dirStamp(d)
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		w.stamps[d] = stamp
+	}
+	targets, _, err := syncTargets(w.dir, remotes)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	for d := range targets {
+		w.sync(d)
+	}
+	return nil
+}
+
+// poll は変化が落ち着いた問題を同期し、同期した数を返す
+func (w *watcher) poll() (int, error) {
+	dirs, err := findTaskDirs(w.dir)
+	if err != nil {
+		return 0, xerrors.Errorf(": %w", err)
+	}
+	synced := 0
+	for _, d := range dirs {
+		stamp, err := dirStamp(d)
+		if err != nil {
+			// 書き込みの途中で消えたファイルなどは次に見る
+			log.Printf("[-] %v\n", err)
+			continue
+		}
+		if stamp == w.stamps[d] {
+			delete(w.pending, d)
+			continue
+		}
+		if w.pending[d] != stamp {
+			w.pending[d] = stamp
+			continue
+		}
+		delete(w.pending, d)
+		// 失敗しても直されるまでは同じものを送り直さない
+		w.stamps[d] = stamp
+		if w.sync(d) {
+			synced++
+		}
+	}
+	return synced, nil
+}
+
+// sync は1つの問題をlintしてからアップロードする
+func (w *watcher) sync(dir string) bool {
+	path := filepath.Join(dir, "task.yml")
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("[-] FAILED: %s: %v\n", dir, err)
+		return false
+	}
+	_, problems := lintTaskYaml(path, src, dir)
+	if errors := reportLint(problems); errors > 0 {
+		log.Printf("[-] SKIP: %s has %d errors\n", dir, errors)
+		return false
+	}
+	tasky, err := loadTaskYaml(path)
+	if err != nil {
+		log.Printf("[-] FAILED: %s: %v\n", dir, err)
+		return false
+	}
+	tasky.SyncHash, err = dirhash.HashDir(dir, "", dirhash.Hash1)
+	if err != nil {
+		log.Printf("[-] FAILED: %s: %v\n", dir, err)
+		return false
+	}
+
+	r := uploadTask(w.u, w.url, w.token, dir, tasky)
+	if r.Err != nil {
+		log.Printf("[-] FAILED: %s: %v\n", r.Name, r.Err)
+		return false
+	}
+	log.Printf("[+] SYNCED: %s (%d files, %d bytes, %s)\n", r.Name, r.Files, r.Bytes, r.Duration.Round(time.Millisecond))
+	return true
+}
+
+func (w *watcher) run(ctx context.Context, interval time.Duration) error {
+	if err := w.init(); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	log.Printf("[+] watching %s\n", w.dir)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if _, err := w.poll(); err != nil {
+			log.Printf("[-] %v\n", err)
+		}
+	}
+}
+
+func runWatch(args []string) error {
+	var url, token, dir string
+	var interval time.Duration
+	var retries int
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	fs.StringVar(&url, "url", profile.URL, "An endpoint of scoreserver")
+	fs.StringVar(&token, "token", "", "An administrative token (default: read from the profile)")
+	fs.StringVar(&dir, "dir", profile.Dir, "tasks directory")
+	fs.DurationVar(&interval, "interval", defaultWatchInterval, "how often to look for changes")
+	fs.IntVar(&retries, "retries", defaultUploadRetries, "number of retries for each failed upload")
+	fs.Usage = func() {
+		fmt.Printf("Usage: %s watch\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	token, err := resolveToken(url, token)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if url == "" || token == "" || dir == "" || interval <= 0 || retries < 0 {
+		fs.Usage()
+		return nil
+	}
+	if err := confirmProduction("sync every change of the tasks"); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+
+	w := newWatcher(strings.TrimSuffix(url, "/"), token, dir)
+	w.u.retries = retries
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := w.run(ctx, interval); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
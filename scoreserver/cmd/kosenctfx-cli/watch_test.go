@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	var mu sync.Mutex
+	registered := make([]TaskYaml, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/admin/list-challenges":
+			writeJSON(w, []interface{}{})
+		case "/admin/new-challenge":
+			var tasky TaskYaml
+			json.NewDecoder(r.Body).Decode(&tasky)
+			mu.Lock()
+			registered = append(registered, tasky)
+			mu.Unlock()
+			writeJSON(w, "ok")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	writeTask(t, dir, "task", `
+name: task
+flag: "KosenCTF{task}"
+description: first
+`)
+	w := newWatcher(server.URL, "token", dir)
+	if err := w.init(); err != nil {
+		t.Fatal(err)
+	}
+	if len(registered) != 1 || registered[0].SyncHash == "" {
+		t.Fatalf("the task should be synced first: %+v", registered)
+	}
+	if n, _ := w.poll(); n != 0 {
+		t.Errorf("unchanged task should not be synced")
+	}
+
+	// 変化が落ち着いてから同期する
+	later := time.Now().Add(time.Second)
+	writeTask(t, dir, "task", `
+name: task
+flag: "KosenCTF{task}"
+description: second
+`)
+	os.Chtimes(filepath.Join(dir, "task", "task.yml"), later, later)
+	if n, _ := w.poll(); n != 0 {
+		t.Errorf("a task should not be synced while it is being written")
+	}
+	if n, _ := w.poll(); n != 1 {
+		t.Errorf("changed task should be synced")
+	}
+	if last := registered[len(registered)-1]; last.Description != "second" || last.SyncHash == registered[0].SyncHash {
+		t.Errorf("unexpected task: %+v", last)
+	}
+
+	// 誤りがあるものは送らない
+	writeTask(t, dir, "task", `
+name: task
+description: no flag
+`)
+	w.poll()
+	if n, _ := w.poll(); n != 0 || len(registered) != 2 {
+		t.Errorf("invalid task should not be synced")
+	}
+}
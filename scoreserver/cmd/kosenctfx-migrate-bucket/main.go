@@ -0,0 +1,210 @@
+// kosenctfx-migrate-bucket は添付ファイルをBUCKET_*で指定したbucketからDEST_BUCKET_*で指定したbucketにコピーし、
+// Attachment.URLを移行先のものに書き換える。-mirrorなら書き換えずにコピーだけする
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"golang.org/x/xerrors"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/bucket"
+	"github.com/theoremoon/kosenctfx/scoreserver/config"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+)
+
+type migrator struct {
+	src, dst bucket.Bucket
+	dryRun   bool
+}
+
+type copyResult struct {
+	URL    string // 移行先のdownloadURL
+	Sha256 string
+	Size   int64
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// copyObject はkeyを移行先にコピーし、移行先から取り直して中身が一致するか確かめる
+// expectSha256が空でなければ移行元の中身もそれと一致しなければならない
+func (m *migrator) copyObject(key, expectSha256 string) (*copyResult, error) {
+	data, err := bucket.Get(m.src, key)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	sum := checksum(data)
+	if expectSha256 != "" && sum != expectSha256 {
+		return nil, xerrors.Errorf("%s in the source bucket is corrupted: expected sha256 %s, got %s", key, expectSha256, sum)
+	}
+	if m.dryRun {
+		return &copyResult{Sha256: sum, Size: int64(len(data))}, nil
+	}
+
+	url, err := bucket.Put(m.dst, key, data)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	copied, err := bucket.Get(m.dst, key)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if got := checksum(copied); got != sum {
+		return nil, xerrors.Errorf("%s in the destination bucket does not match: expected sha256 %s, got %s", key, sum, got)
+	}
+	return &copyResult{URL: url, Sha256: sum, Size: int64(len(data))}, nil
+}
+
+// migrateAttachment は添付ファイルをコピーする。移行元のbucketにないものはnilを返す
+func (m *migrator) migrateAttachment(a *model.Attachment) (*copyResult, error) {
+	key, ok := m.src.KeyFromURL(a.URL)
+	if !ok {
+		return nil, nil
+	}
+	r, err := m.copyObject(key, a.Sha256)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return r, nil
+}
+
+func run() error {
+	var dryRun, mirror bool
+	var parallel int
+	flag.BoolVar(&dryRun, "dry-run", false, "only check the source objects without copying them")
+	flag.BoolVar(&mirror, "mirror", false, "copy objects without rewriting attachment URLs")
+	flag.IntVar(&parallel, "parallel", 4, "number of objects copied at the same time")
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s\n", os.Args[0])
+		fmt.Println("The source bucket is configured by BUCKET_* and the destination by DEST_BUCKET_* environment variables")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if parallel <= 0 {
+		flag.Usage()
+		return nil
+	}
+
+	dbdsn := os.Getenv("DBDSN")
+	if dbdsn == "" {
+		return xerrors.New("DBDSN is required")
+	}
+	dbdsn += "?parseTime=true&charset=utf8mb4&collation=utf8mb4_bin"
+	srcConf, err := config.LoadBucketConfig("BUCKET_")
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	dstConf, err := config.LoadBucketConfig("DEST_BUCKET_")
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	src, err := bucket.New(srcConf)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	dst, err := bucket.New(dstConf)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if !dryRun {
+		if err := dst.CreateBucket(); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+	}
+
+	db, err := gorm.Open(mysql.Open(dbdsn), &gorm.Config{})
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	var attachments []*model.Attachment
+	if err := db.Find(&attachments).Error; err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	var variants []*model.AttachmentVariant
+	if err := db.Find(&variants).Error; err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+
+	m := &migrator{src: src, dst: dst, dryRun: dryRun}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallel)
+	var copied, skipped, failed int
+	var total int64
+	report := func(name string, r *copyResult, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil:
+			failed++
+			log.Printf("[-] FAILED: %s: %v\n", name, err)
+		case r == nil:
+			skipped++
+			log.Printf("[+] SKIP: %s is not in the source bucket\n", name)
+		default:
+			copied++
+			total += r.Size
+			log.Printf("[+] OK: %s (%d bytes, sha256: %s)\n", name, r.Size, r.Sha256)
+		}
+	}
+
+	for _, a := range attachments {
+		wg.Add(1)
+		go func(a *model.Attachment) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			r, err := m.migrateAttachment(a)
+			if err == nil && r != nil && !dryRun {
+				updates := map[string]interface{}{"sha256": r.Sha256, "size": r.Size}
+				if !mirror {
+					updates["url"] = r.URL
+				}
+				err = db.Model(a).Updates(updates).Error
+			}
+			report(a.URL, r, err)
+		}(a)
+	}
+	// チームごとに作った添付ファイルはURLを持たないのでkeyのままコピーする
+	for _, v := range variants {
+		wg.Add(1)
+		go func(v *model.AttachmentVariant) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			r, err := m.copyObject(v.Key, v.Sha256)
+			report(v.Key, r, err)
+		}(v)
+	}
+	wg.Wait()
+
+	if dryRun {
+		log.Printf("[+] %d objects (%d bytes) can be copied, %d skipped, %d failed\n", copied, total, skipped, failed)
+	} else {
+		log.Printf("[+] %d objects (%d bytes) copied, %d skipped, %d failed\n", copied, total, skipped, failed)
+		if !mirror {
+			log.Printf("[+] attachment URLs are rewritten. The scoreserver reflects them within a minute\n")
+		}
+	}
+	if failed > 0 {
+		return xerrors.Errorf("failed to copy %d objects", failed)
+	}
+	return nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatalf("%+v\n", err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/bucket"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+)
+
+func newTestBucket(t *testing.T, private bool) *bucket.LocalBucket {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	b, err := bucket.NewLocalBucket(t.TempDir(), srv.URL, "secret", private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CreateBucket(); err != nil {
+		t.Fatal(err)
+	}
+	mux.Handle(bucket.LocalBucketPath, b)
+	return b
+}
+
+func TestMigrateAttachment(t *testing.T) {
+	src := newTestBucket(t, false)
+	dst := newTestBucket(t, true)
+	url, err := bucket.Put(src, "1111/dist.tar.gz", []byte("distfiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &migrator{src: src, dst: dst}
+	r, err := m.migrateAttachment(&model.Attachment{URL: url, Sha256: checksum([]byte("distfiles"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, ok := dst.KeyFromURL(r.URL); !ok || key != "1111/dist.tar.gz" {
+		t.Errorf("url should be rewritten to the destination: %s", r.URL)
+	}
+	if r.Size != 9 {
+		t.Errorf("unexpected size: %d", r.Size)
+	}
+	data, err := bucket.Get(dst, "1111/dist.tar.gz")
+	if err != nil || string(data) != "distfiles" {
+		t.Errorf("object is not copied: %q %v", data, err)
+	}
+
+	// 移行元が壊れていたらコピーしない
+	if _, err := m.migrateAttachment(&model.Attachment{URL: url, Sha256: checksum([]byte("other"))}); err == nil {
+		t.Errorf("corrupted source should be an error")
+	}
+
+	// 移行済みのものはskipする
+	if r, err := m.migrateAttachment(&model.Attachment{URL: r.URL}); err != nil || r != nil {
+		t.Errorf("attachment outside of the source bucket should be skipped: %+v %v", r, err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"time"
 
@@ -11,8 +12,10 @@ import (
 
 	"github.com/theoremoon/kosenctfx/scoreserver/bucket"
 	"github.com/theoremoon/kosenctfx/scoreserver/config"
+	"github.com/theoremoon/kosenctfx/scoreserver/instancer"
 	"github.com/theoremoon/kosenctfx/scoreserver/mailer"
 	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/oidc"
 	"github.com/theoremoon/kosenctfx/scoreserver/server"
 	"github.com/theoremoon/kosenctfx/scoreserver/service"
 	"github.com/theoremoon/kosenctfx/scoreserver/webhook"
@@ -63,7 +66,33 @@ func run() error {
 		}
 	}
 
-	app := service.New(db, mailSender)
+	opts := []service.Option{}
+	if conf.InstancerBackend != "" {
+		backend := instancer.DockerCommandBackend()
+		if conf.InstancerStartCommand != "" {
+			// 独自のコマンドではimageをdockerのimage以外の値として使うこともある
+			backend.StartCommand = conf.InstancerStartCommand
+			backend.CheckImage = false
+		}
+		if conf.InstancerStopCommand != "" {
+			backend.StopCommand = conf.InstancerStopCommand
+		}
+		if conf.InstancerStatusCommand != "" {
+			backend.StatusCommand = conf.InstancerStatusCommand
+		}
+		backend.ExtendCommand = conf.InstancerExtendCommand
+		opts = append(opts, service.WithInstancer(backend, service.InstancerConfig{
+			Host:       conf.InstancerHost,
+			PortMin:    conf.InstancerPortMin,
+			PortMax:    conf.InstancerPortMax,
+			MaxPerTeam: conf.InstancerMaxPerTeam,
+			DefaultTTL: conf.InstancerTTL,
+			// 起動コマンドのtimeoutに余裕を持たせる
+			StartTimeout: backend.Timeout + time.Minute,
+		}))
+	}
+
+	app := service.New(db, mailSender, opts...)
 
 	// admin ユーザを自動生成して適当なCTF情報を入れる
 	if _, err := app.GetAdminTeam(); err != nil {
@@ -101,6 +130,8 @@ func run() error {
 	if conf.AdminWebhookURL != "" {
 		srv.AdminWebhook = webhook.NewDiscord(conf.AdminWebhookURL, 1*time.Second)
 	}
+	srv.HealthCheckInterval = conf.HealthCheckInterval
+	srv.HealthCheckTimeout = conf.HealthCheckTimeout
 	if conf.TaskOpenWebhookURL != "" {
 		srv.TaskOpenWebhook = webhook.NewDiscord(conf.TaskOpenWebhookURL, 1*time.Second)
 	}
@@ -108,30 +139,28 @@ func run() error {
 		srv.SolveLogWebhook = webhook.NewDiscord(conf.SolveLogWebhookURL, 1*time.Second)
 	}
 
-	if conf.BucketType == "S3" {
-		b, err := bucket.NewS3Bucket(
-			conf.BucketName,
-			conf.BucketEndpoint,
-			conf.BucketRegion,
-			conf.BucketAccessKey,
-			conf.BucketSecretKey,
-			conf.InsecureBucket,
-		)
-		if err != nil {
-			return xerrors.Errorf(": %w", err)
-		}
-		srv.Bucket = b
-	} else if conf.BucketType == "GCS" {
-		b, err := bucket.NewGCSBucket(
-			conf.BucketName,
-			conf.BucketEndpoint,
-			conf.BucketRegion,
-			conf.InsecureBucket,
-		)
+	b, err := bucket.New(conf.Bucket)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	srv.Bucket = b
+	srv.PrivateAttachments = conf.Bucket.Private
+
+	if conf.OIDCIssuer != "" || conf.OIDCAuthURL != "" {
+		p, err := oidc.NewProvider(context.Background(), oidc.Config{
+			Issuer:       conf.OIDCIssuer,
+			ClientID:     conf.OIDCClientID,
+			ClientSecret: conf.OIDCClientSecret,
+			RedirectURL:  conf.OIDCRedirectURL,
+			Scopes:       conf.OIDCScopes,
+			AuthURL:      conf.OIDCAuthURL,
+			TokenURL:     conf.OIDCTokenURL,
+			UserinfoURL:  conf.OIDCUserinfoURL,
+		})
 		if err != nil {
 			return xerrors.Errorf(": %w", err)
 		}
-		srv.Bucket = b
+		srv.OIDC = p
 	}
 
 	// CreateBucketは既に存在する場合はエラーを返さない
@@ -0,0 +1,62 @@
+package config
+
+import "fmt"
+
+type BucketConfig struct {
+	Type      string // S3, GCS, LOCAL
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Name      string
+	Insecure  bool
+	Private   bool
+	Dir       string
+	PublicURL string
+}
+
+// LoadBucketConfig はprefixから始まる環境変数（BUCKET_TYPEなど）からbucketの設定を読む
+// bucketを移行するときは移行先を別のprefixで指定する
+func LoadBucketConfig(prefix string) (*BucketConfig, error) {
+	var err error
+	c := &BucketConfig{
+		Type: getEnvWithDefault(prefix+"TYPE", "S3"),
+		Dir:  getEnvWithDefault(prefix+"DIR", "./bucket"),
+	}
+	if c.Type != "S3" && c.Type != "GCS" && c.Type != "LOCAL" {
+		return nil, fmt.Errorf("%sTYPE must be 'S3', 'GCS' or 'LOCAL'", prefix)
+	}
+	// LOCALの場合は<prefix>DIRにファイルを置き、<prefix>PUBLIC_URL（このserverの公開URL）から配信する
+	// <prefix>SECRET_KEYはupload URLの署名に使い、空ならランダムにする
+	if c.Type == "LOCAL" {
+		c.PublicURL, err = getEnv(prefix + "PUBLIC_URL")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		c.Endpoint, err = getEnv(prefix + "ENDPOINT")
+		if err != nil {
+			return nil, err
+		}
+		c.Region, err = getEnv(prefix + "REGION")
+		if err != nil {
+			return nil, err
+		}
+		c.Name, err = getEnv(prefix + "NAME")
+		if err != nil {
+			return nil, err
+		}
+	}
+	// GCSの場合は、AccessKey, SecretKeyは空。かわりにGOOGLE_APPICATION_CREDENTIALSを設定する
+	c.AccessKey, _ = getEnv(prefix + "ACCESS_KEY")
+	c.SecretKey, _ = getEnv(prefix + "SECRET_KEY")
+
+	if _, err := getEnv(prefix + "INSECURE"); err == nil {
+		c.Insecure = true
+	}
+	// <prefix>PRIVATEが設定されていればbucketを公開せず、添付ファイルはscoreserverを通してダウンロードさせる
+	if _, err := getEnv(prefix + "PRIVATE"); err == nil {
+		c.Private = true
+	}
+	return c, nil
+}
@@ -3,28 +3,46 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	Dbdsn              string
-	Addr               string
-	RedisAddr          string
-	Front              string
-	MailFake           bool
-	Email              string
-	MailServer         string
-	MailPassword       string
-	AdminWebhookURL    string
-	SolveLogWebhookURL string
-	TaskOpenWebhookURL string
-	BucketEndpoint     string
-	BucketRegion       string
-	BucketAccessKey    string
-	BucketSecretKey    string
-	BucketName         string
-	BucketType         string
-	InsecureBucket     bool
-	AdminToken         string
+	Dbdsn               string
+	Addr                string
+	RedisAddr           string
+	Front               string
+	MailFake            bool
+	Email               string
+	MailServer          string
+	MailPassword        string
+	AdminWebhookURL     string
+	SolveLogWebhookURL  string
+	TaskOpenWebhookURL  string
+	Bucket              *BucketConfig
+	AdminToken          string
+	OIDCIssuer          string
+	OIDCClientID        string
+	OIDCClientSecret    string
+	OIDCRedirectURL     string
+	OIDCAuthURL         string
+	OIDCTokenURL        string
+	OIDCUserinfoURL     string
+	OIDCScopes          []string
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+
+	InstancerBackend       string
+	InstancerHost          string
+	InstancerPortMin       int
+	InstancerPortMax       int
+	InstancerMaxPerTeam    int
+	InstancerTTL           time.Duration
+	InstancerStartCommand  string
+	InstancerStopCommand   string
+	InstancerStatusCommand string
+	InstancerExtendCommand string
 }
 
 func getEnv(name string) (string, error) {
@@ -86,51 +104,99 @@ func Load() (*Config, error) {
 	solveLogWebhookURL, _ := getEnv("SOLVE_WEBHOOK")
 	taskOpenWebhookURL, _ := getEnv("TASK_OPEN_WEBHOOK")
 
-	bucketEndpoint, err := getEnv("BUCKET_ENDPOINT")
+	bucket, err := LoadBucketConfig("BUCKET_")
 	if err != nil {
 		return nil, err
 	}
-	bucketRegion, err := getEnv("BUCKET_REGION")
-	if err != nil {
-		return nil, err
+	adminToken, _ := getEnv("ADMIN_TOKEN")
+
+	// OIDC_ISSUERかOIDC_AUTH_URLが設定されているときだけOIDCでのloginを有効にする
+	oidcIssuer, _ := getEnv("OIDC_ISSUER")
+	oidcClientID, _ := getEnv("OIDC_CLIENT_ID")
+	oidcClientSecret, _ := getEnv("OIDC_CLIENT_SECRET")
+	oidcRedirectURL, _ := getEnv("OIDC_REDIRECT_URL")
+	oidcAuthURL, _ := getEnv("OIDC_AUTH_URL")
+	oidcTokenURL, _ := getEnv("OIDC_TOKEN_URL")
+	oidcUserinfoURL, _ := getEnv("OIDC_USERINFO_URL")
+	oidcScopes := strings.Fields(getEnvWithDefault("OIDC_SCOPES", "openid email profile"))
+	if (oidcIssuer != "" || oidcAuthURL != "") && (oidcClientID == "" || oidcRedirectURL == "") {
+		return nil, fmt.Errorf("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required to enable OIDC login")
 	}
-	// GCSの場合は、AccessKey, SecretKeyは空。かわりにGOOGLE_APPICATION_CREDENTIALSを設定する
-	bucketAccessKey, _ := getEnv("BUCKET_ACCESS_KEY")
-	bucketSecretKey, _ := getEnv("BUCKET_SECRET_KEY")
-	bucketName, err := getEnv("BUCKET_NAME")
+
+	// 問題サーバの死活監視。HEALTHCHECK_INTERVAL=0で無効にする
+	healthCheckInterval, err := time.ParseDuration(getEnvWithDefault("HEALTHCHECK_INTERVAL", "30s"))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("HEALTHCHECK_INTERVAL is invalid: %w", err)
 	}
-	bucketType := getEnvWithDefault("BUCKET_TYPE", "S3")
-	if bucketType != "S3" && bucketType != "GCS" {
-		return nil, fmt.Errorf("BUCKET_TYPE must be 'S3' or 'GCS'")
+	healthCheckTimeout, err := time.ParseDuration(getEnvWithDefault("HEALTHCHECK_TIMEOUT", "5s"))
+	if err != nil {
+		return nil, fmt.Errorf("HEALTHCHECK_TIMEOUT is invalid: %w", err)
 	}
 
-	insecureBucket := false
-	if _, err := getEnv("BUCKET_INSECURE"); err == nil {
-		insecureBucket = true
+	// チームごとのinstance。INSTANCER_BACKENDがdockerかcommandのときだけ有効にする
+	// dockerはcommandの既定値をdocker向けにしたもの
+	instancerBackend, _ := getEnv("INSTANCER_BACKEND")
+	if instancerBackend != "" && instancerBackend != "docker" && instancerBackend != "command" {
+		return nil, fmt.Errorf("INSTANCER_BACKEND must be 'docker' or 'command'")
+	}
+	instancerHost, _ := getEnv("INSTANCER_HOST")
+	if instancerBackend != "" && instancerHost == "" {
+		return nil, fmt.Errorf("INSTANCER_HOST is required to enable the instancer")
+	}
+	var instancerPortMin, instancerPortMax int
+	if _, err := fmt.Sscanf(getEnvWithDefault("INSTANCER_PORT_RANGE", "30000-31000"), "%d-%d", &instancerPortMin, &instancerPortMax); err != nil || instancerPortMin > instancerPortMax {
+		return nil, fmt.Errorf("INSTANCER_PORT_RANGE must be like '30000-31000'")
+	}
+	instancerMaxPerTeam, err := strconv.Atoi(getEnvWithDefault("INSTANCER_MAX_PER_TEAM", "3"))
+	if err != nil {
+		return nil, fmt.Errorf("INSTANCER_MAX_PER_TEAM is invalid: %w", err)
+	}
+	instancerTTL, err := time.ParseDuration(getEnvWithDefault("INSTANCER_TTL", "30m"))
+	if err != nil {
+		return nil, fmt.Errorf("INSTANCER_TTL is invalid: %w", err)
+	}
+	instancerStartCommand, _ := getEnv("INSTANCER_START_COMMAND")
+	instancerStopCommand, _ := getEnv("INSTANCER_STOP_COMMAND")
+	instancerStatusCommand, _ := getEnv("INSTANCER_STATUS_COMMAND")
+	instancerExtendCommand, _ := getEnv("INSTANCER_EXTEND_COMMAND")
+	if instancerBackend == "command" && (instancerStartCommand == "" || instancerStopCommand == "" || instancerStatusCommand == "") {
+		return nil, fmt.Errorf("INSTANCER_START_COMMAND, INSTANCER_STOP_COMMAND and INSTANCER_STATUS_COMMAND are required for the command backend")
 	}
-	adminToken, _ := getEnv("ADMIN_TOKEN")
 
 	return &Config{
-		Dbdsn:              dbdsn,
-		Addr:               addr,
-		RedisAddr:          redisAddr,
-		Front:              front,
-		MailFake:           mailFake,
-		Email:              mailaccount,
-		MailServer:         mailserver,
-		MailPassword:       mailpassword,
-		AdminWebhookURL:    adminWebhookURL,
-		SolveLogWebhookURL: solveLogWebhookURL,
-		TaskOpenWebhookURL: taskOpenWebhookURL,
-		BucketEndpoint:     bucketEndpoint,
-		BucketRegion:       bucketRegion,
-		BucketAccessKey:    bucketAccessKey,
-		BucketSecretKey:    bucketSecretKey,
-		BucketName:         bucketName,
-		BucketType:         bucketType,
-		InsecureBucket:     insecureBucket,
-		AdminToken:         adminToken,
+		Dbdsn:               dbdsn,
+		Addr:                addr,
+		RedisAddr:           redisAddr,
+		Front:               front,
+		MailFake:            mailFake,
+		Email:               mailaccount,
+		MailServer:          mailserver,
+		MailPassword:        mailpassword,
+		AdminWebhookURL:     adminWebhookURL,
+		SolveLogWebhookURL:  solveLogWebhookURL,
+		TaskOpenWebhookURL:  taskOpenWebhookURL,
+		Bucket:              bucket,
+		AdminToken:          adminToken,
+		OIDCIssuer:          oidcIssuer,
+		OIDCClientID:        oidcClientID,
+		OIDCClientSecret:    oidcClientSecret,
+		OIDCRedirectURL:     oidcRedirectURL,
+		OIDCAuthURL:         oidcAuthURL,
+		OIDCTokenURL:        oidcTokenURL,
+		OIDCUserinfoURL:     oidcUserinfoURL,
+		OIDCScopes:          oidcScopes,
+		HealthCheckInterval: healthCheckInterval,
+		HealthCheckTimeout:  healthCheckTimeout,
+
+		InstancerBackend:       instancerBackend,
+		InstancerHost:          instancerHost,
+		InstancerPortMin:       instancerPortMin,
+		InstancerPortMax:       instancerPortMax,
+		InstancerMaxPerTeam:    instancerMaxPerTeam,
+		InstancerTTL:           instancerTTL,
+		InstancerStartCommand:  instancerStartCommand,
+		InstancerStopCommand:   instancerStopCommand,
+		InstancerStatusCommand: instancerStatusCommand,
+		InstancerExtendCommand: instancerExtendCommand,
 	}, nil
 }
@@ -3,6 +3,7 @@ module github.com/theoremoon/kosenctfx/scoreserver
 go 1.17
 
 require (
+	cloud.google.com/go/iam v1.1.0
 	cloud.google.com/go/storage v1.31.0
 	github.com/aws/aws-sdk-go v1.30.29
 	github.com/go-redis/redis/v8 v8.11.5
@@ -17,7 +18,9 @@ require (
 	github.com/prometheus/client_golang v1.11.0
 	golang.org/x/crypto v0.9.0
 	golang.org/x/mod v0.9.0
+	golang.org/x/oauth2 v0.8.0
 	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2
+	google.golang.org/api v0.126.0
 	gopkg.in/yaml.v2 v2.4.0
 	gorm.io/driver/mysql v1.3.4
 	gorm.io/gorm v1.23.4
@@ -28,7 +31,6 @@ require (
 	cloud.google.com/go v0.110.2 // indirect
 	cloud.google.com/go/compute v1.19.3 // indirect
 	cloud.google.com/go/compute/metadata v0.2.3 // indirect
-	cloud.google.com/go/iam v1.1.0 // indirect
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible // indirect
@@ -55,10 +57,8 @@ require (
 	github.com/valyala/fasttemplate v1.1.0 // indirect
 	go.opencensus.io v0.24.0 // indirect
 	golang.org/x/net v0.10.0 // indirect
-	golang.org/x/oauth2 v0.8.0 // indirect
 	golang.org/x/sys v0.8.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
-	google.golang.org/api v0.126.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/genproto v0.0.0-20230530153820-e85fd2cbaebc // indirect
 	google.golang.org/genproto/googleapis/api v0.0.0-20230530153820-e85fd2cbaebc // indirect
@@ -0,0 +1,146 @@
+package healthcheck
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// 問題サーバの死活監視の方法
+const (
+	TypeTCP    = "tcp"    // 接続できればOK
+	TypeHTTP   = "http"   // GETして4xx/5xx以外が返ればOK。Expectがあればbodyに含まれるかも見る
+	TypeBanner = "banner" // 接続して最初に送られてくるデータにExpectが含まれればOK
+	TypeNone   = "none"   // 監視しない
+)
+
+var Types = []string{TypeTCP, TypeHTTP, TypeBanner, TypeNone}
+
+// 読み込むレスポンスの上限
+const maxReadSize = 64 * 1024
+
+type Target struct {
+	Host   string
+	Port   int
+	Type   string
+	Path   string
+	Expect string
+}
+
+func (t Target) Addr() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+func ValidateType(typ string) error {
+	if typ == "" {
+		return nil
+	}
+	for _, t := range Types {
+		if t == typ {
+			return nil
+		}
+	}
+	return xerrors.Errorf("unknown health check type: %s", typ)
+}
+
+// Probe はtargetが動いていればnilを、動いていなければ理由を返す
+func Probe(ctx context.Context, t Target, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch t.Type {
+	case "", TypeTCP:
+		return probeTCP(ctx, t)
+	case TypeHTTP:
+		return probeHTTP(ctx, t)
+	case TypeBanner:
+		return probeBanner(ctx, t)
+	case TypeNone:
+		return nil
+	}
+	return xerrors.Errorf("unknown health check type: %s", t.Type)
+}
+
+func probeTCP(ctx context.Context, t Target) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", t.Addr())
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	conn.Close()
+	return nil
+}
+
+func probeHTTP(ctx context.Context, t Target) error {
+	path := t.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+t.Addr()+path, nil)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		return xerrors.Errorf("unexpected status: %s", res.Status)
+	}
+	if t.Expect == "" {
+		return nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxReadSize))
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if !bytes.Contains(body, []byte(t.Expect)) {
+		return xerrors.Errorf("response does not contain %q", t.Expect)
+	}
+	return nil
+}
+
+func probeBanner(ctx context.Context, t Target) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", t.Addr())
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+
+	// bannerは何回かに分けて届くことがあるので、見つかるかtimeoutまで読み続ける
+	buf := make([]byte, 0, 4096)
+	chunk := make([]byte, 4096)
+	for len(buf) < maxReadSize {
+		n, err := conn.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		if bytes.Contains(buf, []byte(t.Expect)) {
+			return nil
+		}
+		if err != nil {
+			return xerrors.Errorf("banner does not contain %q: %w", t.Expect, err)
+		}
+	}
+	return xerrors.Errorf("banner does not contain %q", t.Expect)
+}
+
+// Message はadminに見せる短いエラーメッセージ。xerrorsでwrapしたときの先頭の": "は除く
+func Message(err error) string {
+	if err == nil {
+		return ""
+	}
+	return strings.TrimLeft(err.Error(), ": ")
+}
@@ -0,0 +1,122 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func targetOf(t *testing.T, addr string) Target {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("failed to split %s: %+v\n", addr, err)
+	}
+	p, _ := strconv.Atoi(port)
+	return Target{Host: host, Port: p}
+}
+
+// closedTarget は何もlistenしていないportを返す
+func closedTarget(t *testing.T) Target {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %+v\n", err)
+	}
+	target := targetOf(t, l.Addr().String())
+	l.Close()
+	return target
+}
+
+func TestProbeTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %+v\n", err)
+	}
+	defer l.Close()
+
+	if err := Probe(context.Background(), targetOf(t, l.Addr().String()), time.Second); err != nil {
+		t.Errorf("open port is reported as down: %+v\n", err)
+	}
+	if err := Probe(context.Background(), closedTarget(t), time.Second); err == nil {
+		t.Errorf("closed port is reported as up\n")
+	}
+}
+
+func TestProbeHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, "Welcome to miniblog")
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	cases := []struct {
+		path   string
+		expect string
+		up     bool
+	}{
+		{"/", "", true},
+		{"", "miniblog", true},
+		{"/", "maxiblog", false},
+		{"/broken", "", false},
+	}
+	for _, c := range cases {
+		target := targetOf(t, u.Host)
+		target.Type = TypeHTTP
+		target.Path = c.path
+		target.Expect = c.expect
+		err := Probe(context.Background(), target, time.Second)
+		if (err == nil) != c.up {
+			t.Errorf("Probe(path=%q, expect=%q) = %v, expected up=%v\n", c.path, c.expect, err, c.up)
+		}
+	}
+}
+
+func TestProbeBanner(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %+v\n", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			// bannerが分割されて届いても読めること
+			conn.Write([]byte("Welcome to "))
+			time.Sleep(10 * time.Millisecond)
+			conn.Write([]byte("pwn challenge\n> "))
+			conn.Close()
+		}
+	}()
+
+	target := targetOf(t, l.Addr().String())
+	target.Type = TypeBanner
+	target.Expect = "pwn challenge"
+	if err := Probe(context.Background(), target, time.Second); err != nil {
+		t.Errorf("expected banner is reported as down: %+v\n", err)
+	}
+
+	target.Expect = "crypto challenge"
+	if err := Probe(context.Background(), target, time.Second); err == nil {
+		t.Errorf("unexpected banner is reported as up\n")
+	}
+}
+
+func TestProbeNone(t *testing.T) {
+	target := closedTarget(t)
+	target.Type = TypeNone
+	if err := Probe(context.Background(), target, time.Second); err != nil {
+		t.Errorf("health check type none should not probe: %+v\n", err)
+	}
+}
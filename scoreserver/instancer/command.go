@@ -0,0 +1,147 @@
+package instancer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// CommandBackend はshellのコマンドでinstanceを操作するbackend
+// コマンド中の {name}, {image}, {port}, {host_port}, {challenge}, {team}, {ttl}, {id} はshell用にquoteした値に置き換えられる
+// Startの標準出力の最初の行をIDとし、空なら{name}をIDとする
+type CommandBackend struct {
+	StartCommand  string
+	StopCommand   string
+	StatusCommand string // exit codeが0なら動いているとみなす
+	ExtendCommand string // 空ならExtendは何もしない
+	Timeout       time.Duration
+	// trueならimageがdockerのimage referenceでなければ起動しない
+	// quoteしても-から始まる値はdockerのoptionとして読まれるので、dockerのコマンドでは確かめる
+	CheckImage bool
+}
+
+// DockerCommandBackend はローカルのdockerでinstanceを動かすときの設定
+func DockerCommandBackend() *CommandBackend {
+	return &CommandBackend{
+		StartCommand:  "docker run -d --rm --name {name} -p {host_port}:{port} {image}",
+		StopCommand:   "docker rm -f {id}",
+		StatusCommand: "test \"$(docker inspect -f '{{.State.Running}}' {id})\" = true",
+		Timeout:       60 * time.Second,
+		CheckImage:    true,
+	}
+}
+
+// imageRe はdockerのimage referenceの形 ([registry[:port]/]path[:tag][@digest])
+var imageRe = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*(?::[0-9]+)?(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*(?::[A-Za-z0-9_][A-Za-z0-9_.-]{0,127})?(?:@sha256:[a-f0-9]{64})?$`)
+
+// ValidImage はimageがdockerのimage referenceとして正しいかを返す
+func ValidImage(image string) bool {
+	return len(image) <= 255 && imageRe.MatchString(image)
+}
+
+// shellQuote はsh -cに渡すコマンドの中で1つの単語として扱われるようにquoteする
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (b *CommandBackend) replacer(req StartRequest, id string) *strings.Replacer {
+	return strings.NewReplacer(
+		"{name}", shellQuote(req.Name),
+		"{image}", shellQuote(req.Image),
+		"{port}", strconv.Itoa(req.Port),
+		"{host_port}", strconv.Itoa(req.HostPort),
+		"{challenge}", shellQuote(req.Challenge),
+		"{team}", strconv.FormatUint(uint64(req.TeamID), 10),
+		"{ttl}", strconv.Itoa(int(req.TTL.Seconds())),
+		"{id}", shellQuote(id),
+	)
+}
+
+func (b *CommandBackend) run(ctx context.Context, command string) (string, error) {
+	if b.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Timeout)
+		defer cancel()
+	}
+
+	// pipeだとコマンドが起動した子プロセスが残っているときにtimeoutしても返ってこないのでファイルに書かせる
+	stdout, err := ioutil.TempFile("", "kosenctfx-instancer-")
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	defer os.Remove(stdout.Name())
+	defer stdout.Close()
+	stderr, err := ioutil.TempFile("", "kosenctfx-instancer-")
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	defer os.Remove(stderr.Name())
+	defer stderr.Close()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		errout, _ := ioutil.ReadFile(stderr.Name())
+		return "", xerrors.Errorf("%s: %w: %s", command, err, strings.TrimSpace(string(errout)))
+	}
+
+	out, err := ioutil.ReadFile(stdout.Name())
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return string(out), nil
+}
+
+func (b *CommandBackend) Start(ctx context.Context, req StartRequest) (*Instance, error) {
+	if b.CheckImage && !ValidImage(req.Image) {
+		return nil, xerrors.Errorf("invalid image: %q", req.Image)
+	}
+	out, err := b.run(ctx, b.replacer(req, req.Name).Replace(b.StartCommand))
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	id := strings.TrimSpace(strings.SplitN(out, "\n", 2)[0])
+	if id == "" {
+		id = req.Name
+	}
+	return &Instance{
+		ID:   id,
+		Port: req.HostPort,
+	}, nil
+}
+
+func (b *CommandBackend) Stop(ctx context.Context, id string) error {
+	if _, err := b.run(ctx, b.replacer(StartRequest{}, id).Replace(b.StopCommand)); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+func (b *CommandBackend) Status(ctx context.Context, id string) (Status, error) {
+	if _, err := b.run(ctx, b.replacer(StartRequest{}, id).Replace(b.StatusCommand)); err != nil {
+		var exitErr *exec.ExitError
+		if xerrors.As(err, &exitErr) {
+			return StatusStopped, nil
+		}
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return StatusRunning, nil
+}
+
+func (b *CommandBackend) Extend(ctx context.Context, id string, ttl time.Duration) error {
+	if b.ExtendCommand == "" {
+		return nil
+	}
+	if _, err := b.run(ctx, b.replacer(StartRequest{TTL: ttl}, id).Replace(b.ExtendCommand)); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
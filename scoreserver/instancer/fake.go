@@ -0,0 +1,70 @@
+package instancer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// FakeBackend は何も起動せずにinstanceの状態だけを持つテスト用のbackend
+type FakeBackend struct {
+	sync.Mutex
+	Host      string
+	Instances map[string]StartRequest
+	// StartErrがnilでなければStartはそれを返す
+	StartErr error
+}
+
+func NewFakeBackend(host string) *FakeBackend {
+	return &FakeBackend{
+		Host:      host,
+		Instances: make(map[string]StartRequest),
+	}
+}
+
+func (b *FakeBackend) Start(ctx context.Context, req StartRequest) (*Instance, error) {
+	b.Lock()
+	defer b.Unlock()
+	if b.StartErr != nil {
+		return nil, b.StartErr
+	}
+	if _, ok := b.Instances[req.Name]; ok {
+		return nil, xerrors.Errorf("instance %s is already running", req.Name)
+	}
+	b.Instances[req.Name] = req
+	return &Instance{
+		ID:   req.Name,
+		Host: b.Host,
+		Port: req.HostPort,
+	}, nil
+}
+
+func (b *FakeBackend) Stop(ctx context.Context, id string) error {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.Instances, id)
+	return nil
+}
+
+func (b *FakeBackend) Status(ctx context.Context, id string) (Status, error) {
+	b.Lock()
+	defer b.Unlock()
+	if _, ok := b.Instances[id]; ok {
+		return StatusRunning, nil
+	}
+	return StatusStopped, nil
+}
+
+func (b *FakeBackend) Extend(ctx context.Context, id string, ttl time.Duration) error {
+	b.Lock()
+	defer b.Unlock()
+	req, ok := b.Instances[id]
+	if !ok {
+		return xerrors.Errorf("instance %s is not running", id)
+	}
+	req.TTL = ttl
+	b.Instances[id] = req
+	return nil
+}
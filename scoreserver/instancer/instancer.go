@@ -0,0 +1,41 @@
+package instancer
+
+import (
+	"context"
+	"time"
+)
+
+// Status はbackendから見たinstanceの状態
+type Status string
+
+const (
+	StatusRunning Status = "running"
+	StatusStopped Status = "stopped"
+)
+
+// StartRequest はinstanceを起動するのに必要な情報
+type StartRequest struct {
+	Name      string // backend上でinstanceを識別する名前。チームと問題ごとに一意
+	Challenge string
+	TeamID    uint32
+	Image     string // 問題ごとに設定されるimage（やcomposeのディレクトリなど、backendが解釈する値）
+	Port      int    // instanceの中で問題が待ち受けているport
+	HostPort  int    // チームに公開するport
+	TTL       time.Duration
+}
+
+type Instance struct {
+	ID   string // backendがinstanceを止めるときに使うID
+	Host string // 空ならinstancerの設定のhostを使う
+	Port int
+}
+
+// Backend はチームごとの問題instanceを起動、停止する仕組み
+// 期限の管理はserviceが行い、期限が切れたinstanceはStopで止める
+type Backend interface {
+	Start(ctx context.Context, req StartRequest) (*Instance, error)
+	Stop(ctx context.Context, id string) error
+	Status(ctx context.Context, id string) (Status, error)
+	// Extend はbackend側でも期限を持っている場合に延長する。持っていなければ何もしない
+	Extend(ctx context.Context, id string, ttl time.Duration) error
+}
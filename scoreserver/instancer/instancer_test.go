@@ -0,0 +1,160 @@
+package instancer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandBackend(t *testing.T) {
+	// instanceの代わりにファイルを作ったり消したりする
+	dir := t.TempDir()
+	b := &CommandBackend{
+		StartCommand:  "echo {image}:{port} > " + dir + "/{name} && echo {name}-{host_port}",
+		StopCommand:   "rm " + dir + "/$(echo {id} | cut -d- -f1)",
+		StatusCommand: "test -f " + dir + "/$(echo {id} | cut -d- -f1)",
+		Timeout:       5 * time.Second,
+	}
+	ctx := context.Background()
+
+	inst, err := b.Start(ctx, StartRequest{
+		Name:     "miniblog",
+		Image:    "miniblog:latest",
+		Port:     8080,
+		HostPort: 30001,
+		TTL:      time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("failed to start: %+v\n", err)
+	}
+	if inst.ID != "miniblog-30001" || inst.Port != 30001 {
+		t.Errorf("unexpected instance: %+v\n", inst)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "miniblog")); err != nil || string(b) != "miniblog:latest:8080\n" {
+		t.Errorf("start command is not run with the request: %q, %+v\n", b, err)
+	}
+
+	if status, err := b.Status(ctx, inst.ID); err != nil || status != StatusRunning {
+		t.Errorf("status = %v, %+v, expected running\n", status, err)
+	}
+	// ExtendCommandがなければ何もしない
+	if err := b.Extend(ctx, inst.ID, time.Hour); err != nil {
+		t.Errorf("failed to extend: %+v\n", err)
+	}
+	if err := b.Stop(ctx, inst.ID); err != nil {
+		t.Fatalf("failed to stop: %+v\n", err)
+	}
+	if status, err := b.Status(ctx, inst.ID); err != nil || status != StatusStopped {
+		t.Errorf("status = %v, %+v, expected stopped\n", status, err)
+	}
+
+	// 止まっているinstanceを止めようとしたらエラーになる
+	if err := b.Stop(ctx, inst.ID); err == nil {
+		t.Errorf("stopping a stopped instance should fail\n")
+	}
+}
+
+func TestCommandBackendTimeout(t *testing.T) {
+	b := &CommandBackend{
+		StartCommand: "sleep 10",
+		Timeout:      100 * time.Millisecond,
+	}
+	if _, err := b.Start(context.Background(), StartRequest{Name: "slow", Image: "slow"}); err == nil {
+		t.Errorf("start command should time out\n")
+	}
+}
+
+func TestCommandBackendQuote(t *testing.T) {
+	dir := t.TempDir()
+	b := &CommandBackend{
+		StartCommand: "printf %s {challenge} > " + dir + "/out",
+		Timeout:      5 * time.Second,
+	}
+	ctx := context.Background()
+
+	// 問題名などはquoteされるので、shellの構文として解釈されない
+	challenge := "it's $(touch " + dir + "/pwned); `id`"
+	if _, err := b.Start(ctx, StartRequest{Name: "a", Image: "alpine", Challenge: challenge}); err != nil {
+		t.Fatalf("failed to start: %+v\n", err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "out")); err != nil || string(b) != challenge {
+		t.Errorf("the challenge name is not passed as is: %q, %+v\n", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Errorf("the challenge name is run as a command\n")
+	}
+
+	// 独自のコマンドではimageも任意の値にできる
+	b.StartCommand = "printf %s {image} > " + dir + "/out"
+	image := "./run.sh; touch " + dir + "/pwned"
+	if _, err := b.Start(ctx, StartRequest{Name: "a", Image: image}); err != nil {
+		t.Fatalf("failed to start: %+v\n", err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "out")); err != nil || string(b) != image {
+		t.Errorf("the image is not passed as is: %q, %+v\n", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Errorf("the image is run as a command\n")
+	}
+
+	// dockerのコマンドでは-から始まるimageなどをoptionとして読ませない
+	b.CheckImage = true
+	if _, err := b.Start(ctx, StartRequest{Name: "a", Image: "-v /:/host alpine"}); err == nil {
+		t.Errorf("an invalid image should be rejected\n")
+	}
+}
+
+func TestValidImage(t *testing.T) {
+	for _, image := range []string{
+		"alpine",
+		"alpine:3.18",
+		"ghcr.io/theoremoon/miniblog:latest",
+		"localhost:5000/ctf/miniblog",
+		"registry.example.com/a_b/c-d:v1.0@sha256:" + strings.Repeat("0", 64),
+	} {
+		if !ValidImage(image) {
+			t.Errorf("%q should be valid\n", image)
+		}
+	}
+	for _, image := range []string{
+		"",
+		"Alpine",
+		"alpine; rm -rf /",
+		"alpine $(id)",
+		"-v /:/host alpine",
+		"alpine:",
+	} {
+		if ValidImage(image) {
+			t.Errorf("%q should be invalid\n", image)
+		}
+	}
+}
+
+func TestFakeBackend(t *testing.T) {
+	var b Backend = NewFakeBackend("instance.example.com")
+	ctx := context.Background()
+
+	inst, err := b.Start(ctx, StartRequest{Name: "a", HostPort: 30000})
+	if err != nil {
+		t.Fatalf("failed to start: %+v\n", err)
+	}
+	if inst.Host != "instance.example.com" || inst.Port != 30000 {
+		t.Errorf("unexpected instance: %+v\n", inst)
+	}
+	if _, err := b.Start(ctx, StartRequest{Name: "a"}); err == nil {
+		t.Errorf("the same instance should not start twice\n")
+	}
+	if status, _ := b.Status(ctx, inst.ID); status != StatusRunning {
+		t.Errorf("status = %v, expected running\n", status)
+	}
+	if err := b.Stop(ctx, inst.ID); err != nil {
+		t.Fatalf("failed to stop: %+v\n", err)
+	}
+	if status, _ := b.Status(ctx, inst.ID); status != StatusStopped {
+		t.Errorf("status = %v, expected stopped\n", status)
+	}
+}
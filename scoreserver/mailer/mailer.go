@@ -41,7 +41,7 @@ func (m *mailer) Send(to, subject, body string) error {
 		body
 	err := smtp.SendMail(m.server, auth, m.account, []string{to}, []byte(msg))
 	if err != nil {
-		fmt.Errorf("%w", err)
+		return fmt.Errorf("%w", err)
 	}
 
 	return nil
@@ -9,15 +9,24 @@ func Migrate(db *gorm.DB) error {
 	err := db.AutoMigrate(
 		&LoginToken{},
 		&PasswordResetToken{},
+		&RecoveryCode{},
 		&Team{},
 		&Challenge{},
+		&ChallengeStatus{},
+		&SolvabilityCheck{},
+		&Instance{},
 		&Tag{},
+		&ChallengeFlag{},
+		&Hint{},
 		&Attachment{},
+		&AttachmentVariant{},
+		&Download{},
 		&Submission{},
 		&ValidSubmission{},
 		&SubmissionLock{},
 		&Message{},
 		&Config{},
+		&AuditLog{},
 	)
 	if err != nil {
 		return xerrors.Errorf("migrate: %w", err)
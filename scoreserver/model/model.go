@@ -17,6 +17,23 @@ func (model *Model) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// AdminRole はadminアカウントの権限
+type AdminRole string
+
+const (
+	AdminRoleSuperAdmin AdminRole = "superadmin" // 全ての操作ができる
+	AdminRoleAuthor     AdminRole = "author"     // 自分の問題の編集と統計の閲覧ができる
+	AdminRoleSupport    AdminRole = "support"    // チームの閲覧とメールアドレスの変更ができる
+	AdminRoleReadOnly   AdminRole = "readonly"   // 閲覧だけできる
+)
+
+var AdminRoles = []AdminRole{
+	AdminRoleSuperAdmin,
+	AdminRoleAuthor,
+	AdminRoleSupport,
+	AdminRoleReadOnly,
+}
+
 type Team struct {
 	Model
 
@@ -25,7 +42,34 @@ type Team struct {
 	PasswordHash string
 	CountryCode  string
 
-	IsAdmin bool
+	IsAdmin   bool
+	AdminRole AdminRole
+
+	// 順位表に載らず、提出が点数にならないチーム。flagの動作確認に使う
+	IsUnranked bool `gorm:"not null;default:false"`
+
+	// 二要素認証。TOTPSecretが設定されていてもTOTPEnabledになるまでは登録途中
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool
+	TOTPLastStep int64 `json:"-"`
+	// 続けて間違えた二要素認証のcodeの数と、最後に試した時刻
+	TOTPFailures int   `gorm:"not null;default:0" json:"-"`
+	TOTPFailedAt int64 `gorm:"not null;default:0" json:"-"`
+
+	// OpenID Connectでloginするときのissuerとsubjectの組
+	OIDCSubject *string `gorm:"unique" json:"-"`
+}
+
+// Role はadminとしての権限を返す。adminでなければ空文字列
+// roleが設定される前からいるadminはsuperadminとして扱う
+func (t *Team) Role() AdminRole {
+	if !t.IsAdmin {
+		return ""
+	}
+	if t.AdminRole == "" {
+		return AdminRoleSuperAdmin
+	}
+	return t.AdminRole
 }
 
 type LoginToken struct {
@@ -35,6 +79,17 @@ type LoginToken struct {
 	Token     string `gorm:"unique"`
 	IPAddress string
 	ExpiresAt int64
+
+	// このsessionで二要素認証が済んでいるか
+	TwoFactorVerified bool
+}
+
+type RecoveryCode struct {
+	Model
+
+	TeamId   uint32 `gorm:"index"`
+	CodeHash string
+	UsedAt   int64
 }
 
 type PasswordResetToken struct {
@@ -56,9 +111,61 @@ type Challenge struct {
 	Host        *string `json:"host"`
 	Port        *int    `json:"port"`
 
+	// HostとPortへの死活監視の方法。空ならtcp
+	HealthCheckType   string `json:"health_check_type"`
+	HealthCheckPath   string `json:"health_check_path"`
+	HealthCheckExpect string `json:"health_check_expect"`
+
+	// チームごとにinstanceを起動する問題の設定。InstanceImageが空なら起動しない
+	InstanceImage string `json:"instance_image"`
+	InstancePort  int    `json:"instance_port"`
+	InstanceTTL   int64  `json:"instance_ttl"` // 秒。0ならinstancerの既定値
+
 	IsOpen    bool `json:"is_open"`
 	IsRunning bool `json:"is_running"`
 	IsSurvey  bool `json:"is_survey"`
+
+	// 表示用の難易度
+	Difficulty string `json:"difficulty"`
+	// 0でなければこの時刻(unix)になったら自動で公開する
+	ReleaseAt int64 `json:"release_at"`
+	// 空でなければConfig.ScoreExprの代わりにこの問題の点数の計算に使う
+	ScoreExpr string `gorm:"size:10000" json:"score_expr"`
+
+	// kosenctfx-cliが最後に同期したtask directoryのhash。管理画面から編集したら空にする
+	SyncHash string `json:"sync_hash"`
+}
+
+// ChallengeStatus は死活監視の結果が変わったときの履歴
+type ChallengeStatus struct {
+	Model
+
+	ChallengeId uint32 `gorm:"index"`
+	IsRunning   bool
+	Message     string `gorm:"size:1000"`
+	CheckedAt   int64  `gorm:"index"`
+}
+
+// Instance はチームごとに起動した問題のinstance
+type Instance struct {
+	Model
+
+	ChallengeId uint32 `gorm:"uniqueIndex:idx_instance_challenge_team"`
+	TeamId      uint32 `gorm:"uniqueIndex:idx_instance_challenge_team;index"`
+	BackendId   string // 起動中は空
+	Host        string
+	Port        int
+	ExpiresAt   int64 `gorm:"index"`
+}
+
+// SolvabilityCheck は作問者のsolverを本番の問題サーバに対して実行した結果
+type SolvabilityCheck struct {
+	Model
+
+	ChallengeId uint32 `gorm:"index"`
+	IsSolvable  bool
+	Message     string `gorm:"size:1000"`
+	CheckedAt   int64  `gorm:"index"`
 }
 
 type Tag struct {
@@ -68,12 +175,62 @@ type Tag struct {
 	Tag         string
 }
 
+// ChallengeFlag はChallenge.Flagの他に正解とするflag
+type ChallengeFlag struct {
+	Model
+
+	ChallengeId uint32 `gorm:"index"`
+	Flag        string `gorm:"unique"`
+}
+
+// Hint は問題のヒント。問題を見られるチームには全て見せる
+type Hint struct {
+	Model
+
+	ChallengeId uint32 `gorm:"index"`
+	Position    int    // 表示する順番
+	Body        string `gorm:"size:10000"`
+}
+
 type Attachment struct {
 	Model
 
 	ChallengeId uint32
 	Name        string
 	URL         string
+	Sha256      string
+	Size        int64
+	IsPerTeam   bool // trueならURLの中身をテンプレートとしてチームごとに作り直して配る
+
+	// 最後にURLから取り直して確かめた結果。VerifyErrorが空なら一致していた
+	VerifiedAt  int64
+	VerifyError string
+}
+
+// AttachmentVariant はチームごとに作った添付ファイル。流出したファイルやtokenからチームを辿るのに使う
+// 問題を更新するとAttachmentは作り直されるので名前も残しておく
+type AttachmentVariant struct {
+	Model
+
+	AttachmentId uint32 `gorm:"uniqueIndex:idx_variant_attachment_team"`
+	TeamId       uint32 `gorm:"uniqueIndex:idx_variant_attachment_team"`
+	ChallengeId  uint32
+	Name         string
+	Token        string `gorm:"index"`
+	Key          string
+	Sha256       string `gorm:"index"`
+}
+
+// Download はチームが添付ファイルをダウンロードした記録
+// 問題を更新するとAttachmentは作り直されるので名前も残しておく
+type Download struct {
+	Model
+
+	TeamId       uint32 `gorm:"index"`
+	ChallengeId  uint32 `gorm:"index"`
+	AttachmentId uint32
+	Name         string
+	DownloadedAt int64 `gorm:"index"`
 }
 
 type Submission struct {
@@ -86,6 +243,9 @@ type Submission struct {
 	Flag        string
 	IPAddress   string
 	SubmittedAt int64
+
+	// どの問題の画面から提出されたか。間違ったflagの提出を問題ごとに数えるのに使う
+	TargetChallengeId *uint32 `gorm:"index"`
 }
 
 type ValidSubmission struct {
@@ -128,3 +288,18 @@ type Config struct {
 
 	ScoreExpr string `gorm:"size:10000"`
 }
+
+// AuditLog はadminによる変更操作の記録
+type AuditLog struct {
+	Model
+
+	ActorId   uint32 `gorm:"index"`
+	Actor     string
+	IPAddress string
+	Action    string `gorm:"index"`
+	Target    string
+	Before    string `gorm:"type:text"`
+	After     string `gorm:"type:text"`
+	Diff      string `gorm:"type:text"`
+	LoggedAt  int64  `gorm:"index"`
+}
@@ -0,0 +1,194 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/xerrors"
+)
+
+// Config はOpenID Connectのproviderの設定
+// AuthURL, TokenURL, UserinfoURLを指定するとdiscoveryを使わない（GitHubのようなOAuth2だけのproviderのため）
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	AuthURL     string
+	TokenURL    string
+	UserinfoURL string
+}
+
+// Identity はproviderが保証するユーザの情報
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+type Provider struct {
+	issuer      string
+	oauth2      oauth2.Config
+	userinfoURL string
+	client      *http.Client
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+func NewProvider(ctx context.Context, conf Config) (*Provider, error) {
+	p := &Provider{
+		issuer:      strings.TrimSuffix(conf.Issuer, "/"),
+		userinfoURL: conf.UserinfoURL,
+		client:      http.DefaultClient,
+		oauth2: oauth2.Config{
+			ClientID:     conf.ClientID,
+			ClientSecret: conf.ClientSecret,
+			RedirectURL:  conf.RedirectURL,
+			Scopes:       conf.Scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  conf.AuthURL,
+				TokenURL: conf.TokenURL,
+			},
+		},
+	}
+	if len(p.oauth2.Scopes) == 0 {
+		p.oauth2.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	if conf.AuthURL == "" || conf.TokenURL == "" || conf.UserinfoURL == "" {
+		d, err := p.discover(ctx)
+		if err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+		if p.oauth2.Endpoint.AuthURL == "" {
+			p.oauth2.Endpoint.AuthURL = d.AuthorizationEndpoint
+		}
+		if p.oauth2.Endpoint.TokenURL == "" {
+			p.oauth2.Endpoint.TokenURL = d.TokenEndpoint
+		}
+		if p.userinfoURL == "" {
+			p.userinfoURL = d.UserinfoEndpoint
+		}
+	}
+	if p.oauth2.Endpoint.AuthURL == "" || p.oauth2.Endpoint.TokenURL == "" || p.userinfoURL == "" {
+		return nil, xerrors.New("OIDC provider must have authorization, token and userinfo endpoints")
+	}
+	if p.issuer == "" {
+		p.issuer = p.oauth2.Endpoint.AuthURL
+	}
+	return p, nil
+}
+
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	if p.issuer == "" {
+		return nil, xerrors.New("OIDC issuer is required when endpoints are not configured")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("OIDC discovery failed: %s", res.Status)
+	}
+
+	var d discovery
+	if err := json.NewDecoder(res.Body).Decode(&d); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	// 別のissuerを名乗るproviderは信用しない
+	if strings.TrimSuffix(d.Issuer, "/") != p.issuer {
+		return nil, xerrors.Errorf("OIDC issuer mismatch: expected %s, got %s", p.issuer, d.Issuer)
+	}
+	return &d, nil
+}
+
+// Issuer はsubjectの名前空間として使う
+func (p *Provider) Issuer() string {
+	return p.issuer
+}
+
+func (p *Provider) AuthCodeURL(state string) string {
+	return p.oauth2.AuthCodeURL(state)
+}
+
+// Identify はauthorization codeをaccess tokenに交換し、userinfo endpointからユーザの情報を得る
+// userinfoはproviderとTLSで直接やりとりした結果なのでID tokenの署名の検証は行わない
+func (p *Provider) Identify(ctx context.Context, code string) (*Identity, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	token, err := p.oauth2.Exchange(ctx, code)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.userinfoURL, nil)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	token.SetAuthHeader(req)
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return nil, xerrors.Errorf("OIDC userinfo failed: %s %s", res.Status, string(body))
+	}
+
+	var claims map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&claims); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return parseClaims(claims)
+}
+
+// parseClaims はOIDCの標準claimを読む。GitHubのようにsubやpreferred_usernameがない場合はid, loginを使う
+func parseClaims(claims map[string]interface{}) (*Identity, error) {
+	id := &Identity{
+		Subject:           claimString(claims, "sub", "id"),
+		Email:             claimString(claims, "email"),
+		Name:              claimString(claims, "name"),
+		PreferredUsername: claimString(claims, "preferred_username", "login"),
+	}
+	if v, ok := claims["email_verified"].(bool); ok {
+		id.EmailVerified = v
+	}
+	if id.Subject == "" {
+		return nil, xerrors.New("OIDC userinfo does not contain subject")
+	}
+	return id, nil
+}
+
+func claimString(claims map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		switch v := claims[k].(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case float64:
+			return fmt.Sprintf("%.0f", v)
+		}
+	}
+	return ""
+}
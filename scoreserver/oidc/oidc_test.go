@@ -0,0 +1,126 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// mockIdP はdiscovery, token, userinfoだけを持つテスト用のprovider
+func mockIdP(t *testing.T, claims map[string]interface{}) *httptest.Server {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 srv.URL,
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"userinfo_endpoint":      srv.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("failed to parse token request: %+v\n", err)
+		}
+		if r.Form.Get("code") != "valid-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "invalid_grant"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(claims)
+	})
+	return srv
+}
+
+func TestProvider(t *testing.T) {
+	idp := mockIdP(t, map[string]interface{}{
+		"sub":                "student-0001",
+		"email":              "student@example.ac.jp",
+		"email_verified":     true,
+		"name":               "Kosen Taro",
+		"preferred_username": "taro",
+	})
+	defer idp.Close()
+
+	p, err := NewProvider(context.Background(), Config{
+		Issuer:       idp.URL,
+		ClientID:     "kosenctfx",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:5000/oidc/callback",
+	})
+	if err != nil {
+		t.Fatalf("failed to create provider: %+v\n", err)
+	}
+
+	u, err := url.Parse(p.AuthCodeURL("random-state"))
+	if err != nil {
+		t.Fatalf("failed to parse auth code url: %+v\n", err)
+	}
+	if u.Path != "/authorize" || u.Query().Get("state") != "random-state" || u.Query().Get("client_id") != "kosenctfx" {
+		t.Errorf("unexpected auth code url: %s\n", u)
+	}
+
+	identity, err := p.Identify(context.Background(), "valid-code")
+	if err != nil {
+		t.Fatalf("failed to identify: %+v\n", err)
+	}
+	if identity.Subject != "student-0001" || identity.Email != "student@example.ac.jp" || !identity.EmailVerified || identity.PreferredUsername != "taro" {
+		t.Errorf("unexpected identity: %+v\n", identity)
+	}
+
+	if _, err := p.Identify(context.Background(), "invalid-code"); err == nil {
+		t.Errorf("invalid code is accepted\n")
+	}
+}
+
+func TestProviderIssuerMismatch(t *testing.T) {
+	idp := mockIdP(t, map[string]interface{}{})
+	defer idp.Close()
+
+	if _, err := NewProvider(context.Background(), Config{Issuer: idp.URL + "/other"}); err == nil {
+		t.Errorf("provider with another issuer is accepted\n")
+	}
+}
+
+// GitHubのようにdiscoveryがなく、claimの名前が違うproviderも使える
+func TestProviderWithoutDiscovery(t *testing.T) {
+	idp := mockIdP(t, map[string]interface{}{
+		"id":    12345678,
+		"login": "octocat",
+		"email": "octocat@example.com",
+	})
+	defer idp.Close()
+
+	p, err := NewProvider(context.Background(), Config{
+		ClientID:    "kosenctfx",
+		AuthURL:     idp.URL + "/authorize",
+		TokenURL:    idp.URL + "/token",
+		UserinfoURL: idp.URL + "/userinfo",
+	})
+	if err != nil {
+		t.Fatalf("failed to create provider: %+v\n", err)
+	}
+	identity, err := p.Identify(context.Background(), "valid-code")
+	if err != nil {
+		t.Fatalf("failed to identify: %+v\n", err)
+	}
+	if identity.Subject != "12345678" || identity.PreferredUsername != "octocat" || identity.EmailVerified {
+		t.Errorf("unexpected identity: %+v\n", identity)
+	}
+}
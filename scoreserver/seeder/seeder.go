@@ -99,7 +99,7 @@ func (s *seeder) Submission(t *model.Team, c *model.Challenge, submitted_at int6
 		flag = faker.Hacker().IngVerb()
 	}
 
-	_, _, is_correct, err := s.app.SubmitFlag(t, faker.Internet().IpV4Address(), flag, true, submitted_at)
+	_, _, is_correct, err := s.app.SubmitFlag(t, faker.Internet().IpV4Address(), flag, nil, true, submitted_at)
 	if err != nil {
 		return false, xerrors.Errorf(": %w", err)
 	}
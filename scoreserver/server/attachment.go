@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// 添付ファイル1つを取り直すのにかける時間の上限
+	attachmentVerifyTimeout = 5 * time.Minute
+	// 同時に取り直す添付ファイルの数
+	attachmentVerifyParallel = 4
+)
+
+// attachmentFetchURL はscoreserverが添付ファイルを取得するためのURLを返す
+// 非公開のbucketでは元のURLを直接読めないので署名付きURLにする
+func (s *server) attachmentFetchURL(a *model.Attachment) (string, error) {
+	if s.Bucket == nil {
+		return a.URL, nil
+	}
+	key, ok := s.Bucket.KeyFromURL(a.URL)
+	if !ok {
+		return a.URL, nil
+	}
+	u, err := s.Bucket.GeneratePresignedGetURL(key, a.Name)
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return u, nil
+}
+
+// fetchAttachment はurlの中身を取ってきてsha256と大きさを返す
+func fetchAttachment(ctx context.Context, client *http.Client, url string) (string, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", 0, xerrors.Errorf(": %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, xerrors.Errorf(": %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, xerrors.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(h, resp.Body)
+	if err != nil {
+		return "", 0, xerrors.Errorf(": %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// verifyAttachments は全ての添付ファイルを取り直して、記録されたsha256と大きさに一致するか確かめる
+// 一致しなかったものはadminに通知する
+func (s *server) verifyAttachments(ctx context.Context) error {
+	attachments, err := s.app.ListAllRawAttachments()
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+
+	type result struct {
+		sha256 string
+		size   int64
+		err    error
+	}
+	results := make([]result, len(attachments))
+	client := &http.Client{Timeout: attachmentVerifyTimeout}
+	sem := make(chan struct{}, attachmentVerifyParallel)
+	var wg sync.WaitGroup
+	for i, a := range attachments {
+		wg.Add(1)
+		go func(i int, a *model.Attachment) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			url, err := s.attachmentFetchURL(a)
+			if err != nil {
+				results[i] = result{err: err}
+				return
+			}
+			sum, size, err := fetchAttachment(ctx, client, url)
+			results[i] = result{sha256: sum, size: size, err: err}
+		}(i, a)
+	}
+	wg.Wait()
+
+	now := time.Now().Unix()
+	backfilled := false
+	for i, a := range attachments {
+		r := results[i]
+		verifyErr := ""
+		if r.err != nil {
+			verifyErr = r.err.Error()
+		} else {
+			verifyErr = service.CompareAttachment(a, r.sha256, r.size)
+		}
+		if err := s.app.RecordAttachmentVerification(a, r.sha256, r.size, verifyErr, now); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		if verifyErr != "" {
+			s.AdminWebhook.Post(fmt.Sprintf(AttachmentMismatchAdminMessage, a.Name, verifyErr))
+		} else if a.Sha256 == "" {
+			backfilled = true
+		}
+	}
+
+	// checksumを埋めたらplayerに見せる問題一覧にも反映する
+	if backfilled {
+		conf, err := s.app.GetCTFConfig()
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		if _, _, err := s.refreshCache(conf); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+	}
+	return nil
+}
+
+// verifyAttachmentsHandler は添付ファイルの検証をbackgroundで始める。結果はlistAttachmentVerificationsHandlerで見る
+func (s *server) verifyAttachmentsHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !atomic.CompareAndSwapInt32(&s.verifyingAttachments, 0, 1) {
+			return errorHandle(c, service.NewErrorMessage(AttachmentVerifyRunningMessage))
+		}
+		go func() {
+			defer atomic.StoreInt32(&s.verifyingAttachments, 0)
+			if err := s.verifyAttachments(context.Background()); err != nil {
+				log.Printf("%+v\n", err)
+			}
+		}()
+		return messageHandle(c, AttachmentVerifyStartedMessage)
+	}
+}
+
+func (s *server) listAttachmentVerificationsHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		vs, err := s.app.ListAttachmentVerifications()
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"running":     atomic.LoadInt32(&s.verifyingAttachments) == 1,
+			"attachments": vs,
+		})
+	}
+}
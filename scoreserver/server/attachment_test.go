@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/bucket"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+)
+
+func TestFetchAttachment(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/distfiles.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	sum, size, err := fetchAttachment(context.Background(), srv.Client(), srv.URL+"/distfiles.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" || size != 5 {
+		t.Errorf("unexpected checksum: %s %d", sum, size)
+	}
+
+	if _, _, err := fetchAttachment(context.Background(), srv.Client(), srv.URL+"/missing"); err == nil {
+		t.Errorf("missing attachment should be an error")
+	}
+}
+
+func TestAttachmentFetchURL(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	b, err := bucket.NewLocalBucket(t.TempDir(), srv.URL, "secret", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CreateBucket(); err != nil {
+		t.Fatal(err)
+	}
+	mux.Handle(bucket.LocalBucketPath, b)
+
+	upload, _, _, err := b.GeneratePresignedURL("distfiles.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPut, upload, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("failed to upload: %s", res.Status)
+	}
+
+	s := New(nil, nil, nil, "", "")
+	s.Bucket = b
+	a := &model.Attachment{Name: "distfiles.tar.gz", URL: srv.URL + bucket.LocalBucketPath + "distfiles.tar.gz"}
+
+	// 非公開のbucketでは元のURLは読めない
+	if _, _, err := fetchAttachment(context.Background(), srv.Client(), a.URL); err == nil {
+		t.Fatalf("the raw URL of a private bucket should not be readable")
+	}
+	u, err := s.attachmentFetchURL(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, size, err := fetchAttachment(context.Background(), srv.Client(), u); err != nil || size != 5 {
+		t.Errorf("failed to fetch through the presigned URL: %d, %v", size, err)
+	}
+
+	// bucketの外のURLはそのまま使う
+	a.URL = "https://example.com/distfiles.tar.gz"
+	if u, err := s.attachmentFetchURL(a); err != nil || u != a.URL {
+		t.Errorf("unexpected URL: %s, %v", u, err)
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/theoremoon/kosenctfx/scoreserver/bucket"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/xerrors"
+)
+
+// uploadしてから問題が登録されるまでの間のobjectを消さないように、新しいobjectは孤立していても残す
+const orphanGracePeriod = 1 * time.Hour
+
+// attachmentKeys は添付ファイルのURLのpathの末尾になりうるkeyを全て返す
+// bucketのendpointの設定が変わってもURLのpathの末尾はkeyのままなので、それでも照合できるようにする
+func attachmentKeys(attachments []*model.Attachment) map[string]bool {
+	keys := make(map[string]bool)
+	for _, a := range attachments {
+		keys[a.URL] = true
+		u, err := url.Parse(a.URL)
+		if err != nil {
+			continue
+		}
+		p := strings.TrimPrefix(u.Path, "/")
+		for {
+			keys[p] = true
+			i := strings.Index(p, "/")
+			if i < 0 {
+				break
+			}
+			p = p[i+1:]
+		}
+	}
+	return keys
+}
+
+// findOrphanedObjects はどの添付ファイルからも参照されていないobjectを返す
+// チームごとに作った添付ファイルは流出したときに辿れるように残す
+func findOrphanedObjects(objects []bucket.Object, attachments []*model.Attachment, variantKeys []string, now time.Time) []bucket.Object {
+	keys := attachmentKeys(attachments)
+	for _, k := range variantKeys {
+		keys[k] = true
+	}
+	orphans := make([]bucket.Object, 0)
+	for _, o := range objects {
+		if keys[o.URL] || keys[o.Key] {
+			continue
+		}
+		if now.Sub(o.LastModified) < orphanGracePeriod {
+			continue
+		}
+		orphans = append(orphans, o)
+	}
+	return orphans
+}
+
+func (s *server) orphanedObjects() ([]bucket.Object, error) {
+	if s.Bucket == nil {
+		return nil, service.NewErrorMessage(BucketNullMessage)
+	}
+	objects, err := s.Bucket.List()
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	attachments, err := s.app.ListAllRawAttachments()
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	variantKeys, err := s.app.ListAttachmentVariantKeys()
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return findOrphanedObjects(objects, attachments, variantKeys, time.Now()), nil
+}
+
+func (s *server) listOrphanedObjectsHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orphans, err := s.orphanedObjects()
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		return c.JSON(http.StatusOK, orphans)
+	}
+}
+
+// gcObjectsHandler は孤立したobjectを消す。dry_runなら消さずに対象だけを返す
+func (s *server) gcObjectsHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := new(struct {
+			DryRun bool `json:"dry_run"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		orphans, err := s.orphanedObjects()
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		if req.DryRun {
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"dry_run": true,
+				"deleted": orphans,
+				"errors":  map[string]string{},
+			})
+		}
+
+		// 一つ消せなくても残りは消す
+		deleted := make([]bucket.Object, 0, len(orphans))
+		failed := make(map[string]string)
+		for _, o := range orphans {
+			if err := s.Bucket.Delete(o.Key); err != nil {
+				failed[o.Key] = err.Error()
+				continue
+			}
+			deleted = append(deleted, o)
+		}
+		keys := make([]string, len(deleted))
+		for i, o := range deleted {
+			keys[i] = o.Key
+		}
+		s.audit(c, "gc-objects", "", nil, map[string]interface{}{"deleted": keys})
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"dry_run": false,
+			"deleted": deleted,
+			"errors":  failed,
+		})
+	}
+}
+
+// multipartBucket はmultipart uploadに対応したbucketを返す
+func (s *server) multipartBucket() (bucket.MultipartBucket, error) {
+	if s.Bucket == nil {
+		return nil, service.NewErrorMessage(BucketNullMessage)
+	}
+	mb, ok := s.Bucket.(bucket.MultipartBucket)
+	if !ok {
+		return nil, service.NewErrorMessage(MultipartUnsupportedMessage)
+	}
+	return mb, nil
+}
+
+// multipartUploadHandler は大きなファイルを分割してuploadするために、各部分の署名付きURLを返す
+// keyはget-presigned-urlと同じように重複しないものにして返す
+func (s *server) multipartUploadHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := new(struct {
+			Key   string `json:"key"`
+			Parts int    `json:"parts"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		if req.Key == "" {
+			return errorHandle(c, xerrors.Errorf(": %w", service.NewErrorMessage(PresignedURLKeyRequiredMessage)))
+		}
+		if req.Parts <= 0 || req.Parts > bucket.MaxMultipartParts {
+			return errorHandle(c, xerrors.Errorf(": %w", service.NewErrorMessage(MultipartPartsInvalidMessage)))
+		}
+		mb, err := s.multipartBucket()
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+
+		key := uuid.New().String() + "/" + req.Key
+		uploadID, err := mb.CreateMultipartUpload(key)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		urls := make([]string, req.Parts)
+		for i := range urls {
+			urls[i], err = mb.GeneratePresignedPartURL(key, uploadID, i+1)
+			if err != nil {
+				return errorHandle(c, xerrors.Errorf(": %w", err))
+			}
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"key":      key,
+			"uploadID": uploadID,
+			"partURLs": urls,
+		})
+	}
+}
+
+func (s *server) completeMultipartUploadHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := new(struct {
+			Key      string        `json:"key"`
+			UploadID string        `json:"uploadID"`
+			Parts    []bucket.Part `json:"parts"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		if len(req.Parts) == 0 || len(req.Parts) > bucket.MaxMultipartParts {
+			return errorHandle(c, xerrors.Errorf(": %w", service.NewErrorMessage(MultipartPartsInvalidMessage)))
+		}
+		mb, err := s.multipartBucket()
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		downloadURL, err := mb.CompleteMultipartUpload(req.Key, req.UploadID, req.Parts)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"downloadURL": downloadURL,
+		})
+	}
+}
+
+// abortMultipartUploadHandler は途中で失敗したmultipart uploadを捨てる
+func (s *server) abortMultipartUploadHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := new(struct {
+			Key      string `json:"key"`
+			UploadID string `json:"uploadID"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		mb, err := s.multipartBucket()
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		if err := mb.AbortMultipartUpload(req.Key, req.UploadID); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		return c.JSON(http.StatusOK, nil)
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/bucket"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+)
+
+func TestFindOrphanedObjects(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-2 * orphanGracePeriod)
+	objects := []bucket.Object{
+		{Key: "1111/used.tar.gz", URL: "https://bucket.example.com/ctf/1111/used.tar.gz", LastModified: old},
+		// endpointが変わっていてもkeyで照合できる
+		{Key: "2222/moved.tar.gz", URL: "https://new.example.com/ctf/2222/moved.tar.gz", LastModified: old},
+		{Key: "3333/orphan.tar.gz", URL: "https://bucket.example.com/ctf/3333/orphan.tar.gz", LastModified: old},
+		// uploadしたばかりのものは残す
+		{Key: "4444/uploading.tar.gz", URL: "https://bucket.example.com/ctf/4444/uploading.tar.gz", LastModified: now},
+		// チームごとに作ったもの
+		{Key: "variants/1/2/team.tar.gz", URL: "https://bucket.example.com/ctf/variants/1/2/team.tar.gz", LastModified: old},
+	}
+	attachments := []*model.Attachment{
+		{URL: "https://bucket.example.com/ctf/1111/used.tar.gz"},
+		{URL: "https://old.example.com/ctf/2222/moved.tar.gz"},
+	}
+
+	orphans := findOrphanedObjects(objects, attachments, []string{"variants/1/2/team.tar.gz"}, now)
+	if len(orphans) != 1 || orphans[0].Key != "3333/orphan.tar.gz" {
+		t.Errorf("unexpected orphans: %+v", orphans)
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+// serveAttachments は添付ファイルのURLをscoreserver経由のものに置き換える
+// privateならbucketは非公開なので全て、そうでなければチームごとに作り直すものだけを置き換える
+func serveAttachments(challenges []*service.Challenge, private bool) {
+	for _, c := range challenges {
+		for i, a := range c.Attachments {
+			if private || a.IsPerTeam {
+				c.Attachments[i].URL = fmt.Sprintf("/attachments/%d", a.ID)
+			}
+		}
+	}
+}
+
+// downloadAttachmentHandler はチームのダウンロードを記録し、短い期限付きのURLにredirectする
+// 公開前の問題の添付ファイルは、二要素認証を済ませてその問題を編集できるadmin以外には存在しないものとして扱う
+func (s *server) downloadAttachmentHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		conf, err := s.app.GetCTFConfig()
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		team, _ := s.getLoginTeam(c)
+		isAdmin := team != nil && team.IsAdmin
+		admin, err := s.sessionAdmin(c, team)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		status := service.CalcCTFStatus(conf)
+		if admin == nil && status == service.CTFNotStarted {
+			return errorMessageHandle(c, http.StatusForbidden, CTFNotStartedMessage)
+		}
+		// CTFが終わった後は誰でもダウンロードできる
+		if team == nil && status != service.CTFEnded {
+			return errorMessageHandle(c, http.StatusUnauthorized, UnauthorizedMessage)
+		}
+
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			return errorMessageHandle(c, http.StatusNotFound, NoSuchAttachmentMessage)
+		}
+		a, err := s.app.GetAttachmentByID(uint32(id))
+		if xerrors.Is(err, gorm.ErrRecordNotFound) {
+			return errorMessageHandle(c, http.StatusNotFound, NoSuchAttachmentMessage)
+		} else if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		chal, err := s.app.GetRawChallengeByID(a.ChallengeId)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		if !chal.IsOpen && (admin == nil || !canEditChallenge(admin, chal.Author)) {
+			return errorMessageHandle(c, http.StatusNotFound, NoSuchAttachmentMessage)
+		}
+
+		// adminのアカウントはチームとしては記録しない
+		if team != nil && !isAdmin {
+			if err := s.app.RecordDownload(team, a, time.Now().Unix()); err != nil {
+				// 記録に失敗してもダウンロードはさせる
+				log.Printf("%+v\n", err)
+			}
+		}
+
+		// チームごとの添付ファイルはそのチーム用に作ったものを渡す
+		if a.IsPerTeam && team != nil && !isAdmin {
+			v, err := s.teamVariant(a, team)
+			if err != nil {
+				return errorHandle(c, xerrors.Errorf(": %w", err))
+			}
+			url, err := s.Bucket.GeneratePresignedGetURL(v.Key, a.Name)
+			if err != nil {
+				return errorHandle(c, xerrors.Errorf(": %w", err))
+			}
+			c.Response().Header().Set("Cache-Control", "no-store")
+			return c.Redirect(http.StatusFound, url)
+		}
+
+		url, err := s.attachmentURL(a)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		c.Response().Header().Set("Cache-Control", "no-store")
+		return c.Redirect(http.StatusFound, url)
+	}
+}
+
+// attachmentURL は添付ファイルを取得できる短い期限付きのURLを返す
+// bucketの外に置かれた添付ファイルはそのままのURLを返す
+func (s *server) attachmentURL(a *model.Attachment) (string, error) {
+	if s.Bucket == nil {
+		return a.URL, nil
+	}
+	key, ok := s.Bucket.KeyFromURL(a.URL)
+	if !ok {
+		return a.URL, nil
+	}
+	url, err := s.Bucket.GeneratePresignedGetURL(key, a.Name)
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return url, nil
+}
+
+// adminDownloadAttachmentHandler はチームごとに作り直す前の添付ファイルにredirectする。ダウンロードとしては記録しない
+func (s *server) adminDownloadAttachmentHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			return errorMessageHandle(c, http.StatusNotFound, NoSuchAttachmentMessage)
+		}
+		a, err := s.app.GetAttachmentByID(uint32(id))
+		if xerrors.Is(err, gorm.ErrRecordNotFound) {
+			return errorMessageHandle(c, http.StatusNotFound, NoSuchAttachmentMessage)
+		} else if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		chal, err := s.app.GetRawChallengeByID(a.ChallengeId)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		lc := c.(*loginContext)
+		if !canEditChallenge(lc.Team, chal.Author) {
+			return errorMessageHandle(c, http.StatusForbidden, ChallengeNotOwnedMessage)
+		}
+
+		url, err := s.attachmentURL(a)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		c.Response().Header().Set("Cache-Control", "no-store")
+		return c.Redirect(http.StatusFound, url)
+	}
+}
+
+// listDownloadsHandler はどのチームがどの添付ファイルを取得したかを返す。challengeを指定すればその問題だけ
+func (s *server) listDownloadsHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		lc := c.(*loginContext)
+		var challengeID *uint32
+		if name := c.QueryParam("challenge"); name != "" {
+			chal, err := s.app.GetRawChallengeByName(name)
+			if xerrors.Is(err, gorm.ErrRecordNotFound) {
+				return errorHandle(c, service.NewErrorMessage(NoSuchChallengeMessage))
+			} else if err != nil {
+				return errorHandle(c, xerrors.Errorf(": %w", err))
+			}
+			if !canEditChallenge(lc.Team, chal.Author) {
+				return errorMessageHandle(c, http.StatusForbidden, ChallengeNotOwnedMessage)
+			}
+			challengeID = &chal.ID
+		}
+		entries, err := s.app.ListDownloads(challengeID)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+
+		// authorには自分の問題の記録だけを見せる
+		if lc.Team.Role() == model.AdminRoleAuthor {
+			chals, err := s.app.ListAllRawChallenges()
+			if err != nil {
+				return errorHandle(c, xerrors.Errorf(": %w", err))
+			}
+			owned := make(map[string]bool)
+			for _, chal := range chals {
+				if canEditChallenge(lc.Team, chal.Author) {
+					owned[chal.Name] = true
+				}
+			}
+			filtered := make([]*service.DownloadEntry, 0, len(entries))
+			for _, e := range entries {
+				if owned[e.Challenge] {
+					filtered = append(filtered, e)
+				}
+			}
+			entries = filtered
+		}
+		return c.JSON(http.StatusOK, entries)
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+func TestServeAttachments(t *testing.T) {
+	challenges := []*service.Challenge{
+		{Attachments: []service.Attachment{
+			{ID: 3, Name: "dist.tar.gz", URL: "https://bucket.example.com/ctf/1111/dist.tar.gz"},
+		}},
+	}
+	serveAttachments(challenges, true)
+	if a := challenges[0].Attachments[0]; a.URL != "/attachments/3" || a.Name != "dist.tar.gz" {
+		t.Errorf("unexpected attachment: %+v", a)
+	}
+
+	// 公開のbucketでもチームごとの添付ファイルはscoreserverを通す
+	challenges = []*service.Challenge{
+		{Attachments: []service.Attachment{
+			{ID: 4, URL: "https://bucket.example.com/ctf/1111/public.tar.gz"},
+			{ID: 5, URL: "https://bucket.example.com/ctf/2222/team.tar.gz", IsPerTeam: true},
+		}},
+	}
+	serveAttachments(challenges, false)
+	if a := challenges[0].Attachments; a[0].URL != "https://bucket.example.com/ctf/1111/public.tar.gz" || a[1].URL != "/attachments/5" {
+		t.Errorf("unexpected attachments: %+v", a)
+	}
+}
+
+// downloadApp はダウンロードのhandlerが使うメソッドだけを実装する
+type downloadApp struct {
+	service.App
+	teams      map[string]*model.Team
+	verified   map[string]bool
+	challenges []*model.Challenge
+	entries    []*service.DownloadEntry
+}
+
+func (app *downloadApp) GetLoginTeam(token string) (*model.Team, error) {
+	t, ok := app.teams[token]
+	if !ok {
+		return nil, xerrors.New("not found")
+	}
+	return t, nil
+}
+
+func (app *downloadApp) IsTwoFactorVerified(loginToken string) (bool, error) {
+	return app.verified[loginToken], nil
+}
+
+func (app *downloadApp) GetCTFConfig() (*model.Config, error) {
+	return &model.Config{CTFOpen: true, StartAt: 0, EndAt: time.Now().Add(time.Hour).Unix()}, nil
+}
+
+func (app *downloadApp) GetAttachmentByID(id uint32) (*model.Attachment, error) {
+	return &model.Attachment{Model: model.Model{ID: id}, ChallengeId: id, Name: "dist.tar.gz", URL: "https://example.com/dist.tar.gz"}, nil
+}
+
+func (app *downloadApp) GetRawChallengeByID(challengeID uint32) (*model.Challenge, error) {
+	for _, c := range app.challenges {
+		if c.ID == challengeID {
+			return c, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (app *downloadApp) GetRawChallengeByName(name string) (*model.Challenge, error) {
+	for _, c := range app.challenges {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (app *downloadApp) ListAllRawChallenges() ([]*model.Challenge, error) {
+	return app.challenges, nil
+}
+
+func (app *downloadApp) ListDownloads(challengeID *uint32) ([]*service.DownloadEntry, error) {
+	return app.entries, nil
+}
+
+func (app *downloadApp) RecordDownload(team *model.Team, a *model.Attachment, downloadedAt int64) error {
+	return nil
+}
+
+func TestDownloadUnreleasedAttachment(t *testing.T) {
+	admin := func(name string, role model.AdminRole, totp bool) *model.Team {
+		return &model.Team{Teamname: name, IsAdmin: true, AdminRole: role, TOTPEnabled: totp}
+	}
+	app := &downloadApp{
+		teams: map[string]*model.Team{
+			"superadmin": admin("superadmin", model.AdminRoleSuperAdmin, true),
+			"unverified": admin("unverified", model.AdminRoleSuperAdmin, true),
+			"nototp":     admin("nototp", model.AdminRoleSuperAdmin, false),
+			"alice":      admin("alice", model.AdminRoleAuthor, true),
+			"bob":        admin("bob", model.AdminRoleAuthor, true),
+			"player":     {Teamname: "player"},
+		},
+		verified: map[string]bool{"superadmin": true, "nototp": true, "alice": true, "bob": true},
+		challenges: []*model.Challenge{
+			{Model: model.Model{ID: 1}, Name: "secret", Author: "alice"},
+		},
+	}
+	s := New(app, nil, nil, "", "")
+
+	cases := []struct {
+		cookie string
+		status int
+	}{
+		{"superadmin", http.StatusFound},
+		{"alice", http.StatusFound},
+		// 二要素認証を済ませていなければ公開前の問題は見えない
+		{"unverified", http.StatusNotFound},
+		{"nototp", http.StatusNotFound},
+		// 他のauthorの問題は見えない
+		{"bob", http.StatusNotFound},
+		{"player", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/attachments/1", nil)
+		req.AddCookie(&http.Cookie{Name: s.SessionKey, Value: c.cookie})
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+		ctx.SetParamNames("id")
+		ctx.SetParamValues("1")
+		if err := s.downloadAttachmentHandler()(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != c.status {
+			t.Errorf("%s: expected %d, got %d %s", c.cookie, c.status, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestListDownloadsHandler(t *testing.T) {
+	app := &downloadApp{
+		challenges: []*model.Challenge{
+			{Model: model.Model{ID: 1}, Name: "mine", Author: "alice"},
+			{Model: model.Model{ID: 2}, Name: "others", Author: "bob"},
+		},
+		entries: []*service.DownloadEntry{
+			{TeamName: "player", Challenge: "mine", Name: "a.tar.gz"},
+			{TeamName: "player", Challenge: "others", Name: "b.tar.gz"},
+		},
+	}
+	s := New(app, nil, nil, "", "")
+
+	list := func(team *model.Team, query string) (int, []*service.DownloadEntry) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/admin/downloads"+query, nil)
+		rec := httptest.NewRecorder()
+		ctx := &loginContext{Context: e.NewContext(req, rec), Team: team}
+		if err := s.listDownloadsHandler()(ctx); err != nil {
+			t.Fatal(err)
+		}
+		var entries []*service.DownloadEntry
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, entries
+	}
+
+	alice := &model.Team{Teamname: "alice", IsAdmin: true, AdminRole: model.AdminRoleAuthor}
+	if code, entries := list(alice, ""); code != http.StatusOK || len(entries) != 1 || entries[0].Challenge != "mine" {
+		t.Errorf("author must see only own challenges: %d %+v", code, entries)
+	}
+	if code, _ := list(alice, "?challenge=others"); code != http.StatusForbidden {
+		t.Errorf("author must not see others' challenge: %d", code)
+	}
+
+	support := &model.Team{Teamname: "support", IsAdmin: true, AdminRole: model.AdminRoleSupport}
+	if code, entries := list(support, ""); code != http.StatusOK || len(entries) != 2 {
+		t.Errorf("support must see all downloads: %d %+v", code, entries)
+	}
+}
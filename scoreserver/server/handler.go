@@ -3,11 +3,15 @@ package server
 import (
 	"bytes"
 	"context"
+	"database/sql"
+	"database/sql/driver"
 	_ "embed"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
@@ -23,6 +27,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/oidc"
 	"github.com/theoremoon/kosenctfx/scoreserver/service"
 	"github.com/theoremoon/kosenctfx/scoreserver/util"
 )
@@ -34,6 +39,11 @@ const (
 	ClientSeriesMaxTeams  = 20
 	sessionSetKey         = "sessionSetKey"
 	sessionActiveDuration = 10 * time.Minute
+	oidcStateLifetime     = 10 * time.Minute
+	oidcPendingKey        = "oidcPending:"
+	sqlStatementTimeout   = 10 * time.Second
+	sqlDefaultRowLimit    = 1000
+	sqlMaxRowLimit        = 100000
 )
 
 func (s *server) registerHandler() echo.HandlerFunc {
@@ -63,6 +73,7 @@ func (s *server) loginHandler() echo.HandlerFunc {
 		req := new(struct {
 			Teamname string
 			Password string
+			OTP      string `json:"otp"`
 		})
 		if err := c.Bind(req); err != nil {
 			return c.JSON(http.StatusBadRequest, map[string]interface{}{
@@ -70,8 +81,15 @@ func (s *server) loginHandler() echo.HandlerFunc {
 			})
 		}
 
-		token, err := s.app.Login(req.Teamname, req.Password, c.RealIP())
+		token, err := s.app.Login(req.Teamname, req.Password, req.OTP, c.RealIP())
 		if err != nil {
+			// codeを入力する画面を出せるように区別して返す
+			if xerrors.Is(err, service.ErrTwoFactorRequired) {
+				return c.JSON(http.StatusUnauthorized, map[string]interface{}{
+					"message":             service.ErrTwoFactorRequired.Error(),
+					"two_factor_required": true,
+				})
+			}
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
 		c.SetCookie(s.tokenCookie(token))
@@ -93,18 +111,170 @@ func (s *server) logoutHandler() echo.HandlerFunc {
 	}
 }
 
+func (s *server) oidcLoginHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.OIDC == nil {
+			return errorMessageHandle(c, http.StatusNotFound, OIDCDisabledMessage)
+		}
+		state := uuid.New().String()
+		c.SetCookie(s.oidcCookie("_oidc_state", state))
+		return c.Redirect(http.StatusFound, s.OIDC.AuthCodeURL(state))
+	}
+}
+
+// oidcCallbackHandler はproviderから戻ってきたときにチームと紐付けてloginさせる
+// ログイン中ならそのチームに紐付け、未登録なら登録受付中のときだけ新しいチームを作る
+// 二要素認証を有効にしているチームは /oidc/verify でcodeを確認してからsessionを発行する
+func (s *server) oidcCallbackHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.OIDC == nil {
+			return errorMessageHandle(c, http.StatusNotFound, OIDCDisabledMessage)
+		}
+		fail := func(msg string) error {
+			return c.Redirect(http.StatusFound, s.FrontendURL+"/login?error="+url.QueryEscape(msg))
+		}
+
+		cookie, err := c.Cookie(s.SessionKey + "_oidc_state")
+		c.SetCookie(s.oidcCookie("_oidc_state", ""))
+		if err != nil || cookie.Value == "" || cookie.Value != c.QueryParam("state") {
+			return fail(OIDCStateInvalidMessage)
+		}
+		if msg := c.QueryParam("error"); msg != "" {
+			return fail(msg)
+		}
+
+		identity, err := s.OIDC.Identify(c.Request().Context(), c.QueryParam("code"))
+		if err != nil {
+			log.Printf("%+v\n", err)
+			return fail(OIDCStateInvalidMessage)
+		}
+		subject := s.OIDC.Issuer() + "|" + identity.Subject
+
+		// 既にloginしているチームにアカウントを紐付ける
+		if team, err := s.getLoginTeam(c); err == nil {
+			if err := s.app.LinkOIDCSubject(team, subject); err != nil {
+				var errMsg service.ErrorMessage
+				if xerrors.As(err, &errMsg) {
+					return c.Redirect(http.StatusFound, s.FrontendURL+"/profile?error="+url.QueryEscape(errMsg.Error()))
+				}
+				return errorHandle(c, xerrors.Errorf(": %w", err))
+			}
+			return c.Redirect(http.StatusFound, s.FrontendURL+"/profile")
+		}
+
+		team, err := s.oidcTeam(identity, subject)
+		if err != nil {
+			var errMsg service.ErrorMessage
+			if xerrors.As(err, &errMsg) {
+				return fail(errMsg.Error())
+			}
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+
+		if team.TOTPEnabled {
+			pending := uuid.New().String()
+			if err := s.redis.Set(c.Request().Context(), oidcPendingKey+pending, team.ID, oidcStateLifetime).Err(); err != nil {
+				return errorHandle(c, xerrors.Errorf(": %w", err))
+			}
+			c.SetCookie(s.oidcCookie("_oidc_pending", pending))
+			return c.Redirect(http.StatusFound, s.FrontendURL+"/login?two_factor=oidc")
+		}
+
+		token, err := s.app.IssueLoginToken(team, "", c.RealIP())
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		c.SetCookie(s.tokenCookie(token))
+		return c.Redirect(http.StatusFound, s.FrontendURL+"/")
+	}
+}
+
+// oidcVerifyHandler はOIDCでloginしようとしている二要素認証を有効にしたチームのcodeを確認してsessionを発行する
+func (s *server) oidcVerifyHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := new(struct {
+			Code string `json:"code"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		cookie, err := c.Cookie(s.SessionKey + "_oidc_pending")
+		if err != nil || cookie.Value == "" {
+			return errorMessageHandle(c, http.StatusBadRequest, OIDCStateInvalidMessage)
+		}
+		teamID, err := s.redis.Get(c.Request().Context(), oidcPendingKey+cookie.Value).Uint64()
+		if err != nil {
+			return errorMessageHandle(c, http.StatusBadRequest, OIDCStateInvalidMessage)
+		}
+		team, err := s.app.GetTeamByID(uint32(teamID))
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+
+		token, err := s.app.IssueLoginToken(team, req.Code, c.RealIP())
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		s.redis.Del(c.Request().Context(), oidcPendingKey+cookie.Value)
+		c.SetCookie(s.oidcCookie("_oidc_pending", ""))
+		c.SetCookie(s.tokenCookie(token))
+		return messageHandle(c, LoginMessage)
+	}
+}
+
+// oidcTeam はproviderのアカウントに紐付いたチームを返す。なければ登録受付中のときだけ新しく作る
+// メールアドレスが同じでも既存のチームには紐付けない。紐付けはloginしたチームからだけ行う
+func (s *server) oidcTeam(identity *oidc.Identity, subject string) (*model.Team, error) {
+	if team, err := s.app.GetTeamByOIDCSubject(subject); err == nil {
+		return team, nil
+	} else if !xerrors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+
+	conf, err := s.app.GetCTFConfig()
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if !conf.RegisterOpen {
+		return nil, service.NewErrorMessage(RegistrationClosedMessage)
+	}
+
+	teamname := identity.PreferredUsername
+	if teamname == "" {
+		teamname = identity.Name
+	}
+	// providerが確認していないメールアドレスは使わない
+	email := ""
+	if identity.EmailVerified {
+		email = identity.Email
+	}
+	team, err := s.app.RegisterOIDCTeam(teamname, email, subject)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return team, nil
+}
+
 func (s *server) accountHandler() echo.HandlerFunc {
 	return func(c echo.Context) error {
 		team, err := s.getLoginTeam(c)
 		if err != nil {
 			return c.JSON(http.StatusOK, nil)
 		}
+		// adminの画面で二要素認証を求めるかどうかの判断に使う
+		verified := false
+		if token, err := s.getLoginToken(c); err == nil {
+			verified, _ = s.app.IsTwoFactorVerified(token)
+		}
 
 		return c.JSON(http.StatusOK, map[string]interface{}{
-			"teamname": team.Teamname,
-			"team_id":  team.ID,
-			"country":  team.CountryCode,
-			"is_admin": team.IsAdmin,
+			"teamname":            team.Teamname,
+			"team_id":             team.ID,
+			"country":             team.CountryCode,
+			"is_admin":            team.IsAdmin,
+			"admin_role":          team.Role(),
+			"totp_enabled":        team.TOTPEnabled,
+			"two_factor_verified": verified,
 		})
 	}
 }
@@ -123,6 +293,7 @@ func (s *server) ctfHandler() echo.HandlerFunc {
 			"is_open":       conf.CTFOpen,
 			"is_running":    status == service.CTFRunning,
 			"is_over":       status == service.CTFEnded,
+			"oidc_login":    s.OIDC != nil,
 		})
 	}
 }
@@ -170,6 +341,12 @@ func (s *server) tasksHandler() echo.HandlerFunc {
 		if err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
+		if t != nil {
+			if err := s.injectInstances(t, challenges); err != nil {
+				return errorHandle(c, xerrors.Errorf(": %w", err))
+			}
+		}
+		serveAttachments(challenges, s.PrivateAttachments)
 
 		return c.JSON(http.StatusOK, challenges)
 	}
@@ -293,6 +470,103 @@ func (s *server) profileUpdateHandler() echo.HandlerFunc {
 	}
 }
 
+func (s *server) totpEnrollHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		lc := c.(*loginContext)
+		secret, url, err := s.app.EnrollTOTP(lc.Team)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"secret": secret,
+			"url":    url,
+		})
+	}
+}
+
+func (s *server) totpConfirmHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		lc := c.(*loginContext)
+		req := new(struct {
+			Code string `json:"code"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		token, err := s.getLoginToken(c)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+
+		codes, err := s.app.ConfirmTOTP(lc.Team, req.Code, token)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"message":        TOTPEnabledMessage,
+			"recovery_codes": codes,
+		})
+	}
+}
+
+func (s *server) totpVerifyHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		lc := c.(*loginContext)
+		req := new(struct {
+			Code string `json:"code"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		token, err := s.getLoginToken(c)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+
+		if err := s.app.VerifySessionTOTP(lc.Team, req.Code, token); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		return messageHandle(c, TOTPVerifiedMessage)
+	}
+}
+
+func (s *server) totpDisableHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		lc := c.(*loginContext)
+		req := new(struct {
+			Code string `json:"code"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+
+		if err := s.app.DisableTOTP(lc.Team, req.Code); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		return messageHandle(c, TOTPDisabledMessage)
+	}
+}
+
+func (s *server) totpRecoveryCodesHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		lc := c.(*loginContext)
+		req := new(struct {
+			Code string `json:"code"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+
+		codes, err := s.app.RegenerateRecoveryCodes(lc.Team, req.Code)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"recovery_codes": codes,
+		})
+	}
+}
+
 func (s *server) teamHandler() echo.HandlerFunc {
 	return func(c echo.Context) error {
 		teamIDstr := c.Param("id")
@@ -319,18 +593,21 @@ func (s *server) submitHandler() echo.HandlerFunc {
 	return func(c echo.Context) error {
 		lc := c.(*loginContext)
 		req := new(struct {
-			Flag string `json:"flag"`
+			Flag        string  `json:"flag"`
+			ChallengeID *uint32 `json:"challenge_id"`
 		})
 		if err := c.Bind(req); err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
 
 		// check Submission Lock
+		// 順位表に載らないチームは動作確認のために全ての問題へ提出するのでlockしない
+		unranked := lc.Team.IsUnranked
 		submittable, err := s.app.CheckSubmittable(lc.Team.ID)
 		if err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
-		if !submittable {
+		if !submittable && !unranked {
 			return errorHandle(c, xerrors.Errorf(": %w", service.NewErrorMessage(SubmissionLockedMessage)))
 		}
 
@@ -341,8 +618,10 @@ func (s *server) submitHandler() echo.HandlerFunc {
 		ctfStatus := service.CalcCTFStatus(conf)
 
 		// flag submission
-		flag := strings.Trim(req.Flag, " ")
-		challenge, correct, valid, err := s.app.SubmitFlag(lc.Team, lc.RealIP(), flag, ctfStatus == service.CTFRunning, time.Now().Unix())
+		// 前後の空白は改行なども含めて取り除く。task.ymlのflagに空白が残っていれば誰も正解できない
+		// 順位表に載らないチームの提出は参考記録にして点数や解いた数に影響させない
+		flag := strings.TrimSpace(req.Flag)
+		challenge, correct, valid, err := s.app.SubmitFlag(lc.Team, lc.RealIP(), flag, req.ChallengeID, ctfStatus == service.CTFRunning && !unranked, time.Now().Unix())
 		if err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
@@ -375,15 +654,19 @@ func (s *server) submitHandler() echo.HandlerFunc {
 				}
 			}()
 
-			return messageHandle(c, fmt.Sprintf(ValidSubmissionMessage, challenge.Name))
+			return submissionHandle(c, fmt.Sprintf(ValidSubmissionMessage, challenge.Name), challenge.Name)
 		} else if correct {
-			s.AdminWebhook.Post(fmt.Sprintf(
-				CorrectSubmissionAdminMessage,
-				util.DiscordString(lc.Team.Teamname),
-				challenge.Name,
-				util.DiscordString(req.Flag),
-			))
-			return messageHandle(c, fmt.Sprintf(CorrectSubmissionMessage, challenge.Name))
+			if !unranked {
+				s.AdminWebhook.Post(fmt.Sprintf(
+					CorrectSubmissionAdminMessage,
+					util.DiscordString(lc.Team.Teamname),
+					challenge.Name,
+					util.DiscordString(req.Flag),
+				))
+			}
+			return submissionHandle(c, fmt.Sprintf(CorrectSubmissionMessage, challenge.Name), challenge.Name)
+		} else if unranked {
+			return errorHandle(c, service.NewErrorMessage(WrongSubmissionMessage))
 		} else {
 			// wrong count
 			count, err := s.app.GetWrongCount(lc.Team.ID, time.Duration(conf.LockDuration)*time.Second)
@@ -406,6 +689,14 @@ func (s *server) submitHandler() echo.HandlerFunc {
 	}
 }
 
+// submissionHandle は正解した問題の名前も返す。kosenctfx-cli smoketestがflagと問題の対応を確かめるのに使う
+func submissionHandle(c echo.Context, msg, challenge string) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":   msg,
+		"challenge": challenge,
+	})
+}
+
 func (s *server) scoreEmulateHandler() echo.HandlerFunc {
 	return func(c echo.Context) error {
 		maxCountStr := c.QueryParam("maxCount")
@@ -444,21 +735,25 @@ func (s *server) getConfigHandler() echo.HandlerFunc {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
 
-		ret := make(map[string]interface{})
-		ret["ctf_name"] = conf.CTFName
-		ret["start_at"] = conf.StartAt
-		ret["end_at"] = conf.EndAt
-		ret["score_expr"] = conf.ScoreExpr
-		ret["register_open"] = conf.RegisterOpen
-		ret["ctf_open"] = conf.CTFOpen
-		ret["lock_second"] = conf.LockSecond
-		ret["lock_duration"] = conf.LockDuration
-		ret["lock_count"] = conf.LockCount
-
-		return c.JSON(http.StatusOK, ret)
+		return c.JSON(http.StatusOK, configView(conf))
 	}
 }
 
+// configView はadminに見せるconfigの値。tokenは含めない
+func configView(conf *model.Config) map[string]interface{} {
+	ret := make(map[string]interface{})
+	ret["ctf_name"] = conf.CTFName
+	ret["start_at"] = conf.StartAt
+	ret["end_at"] = conf.EndAt
+	ret["score_expr"] = conf.ScoreExpr
+	ret["register_open"] = conf.RegisterOpen
+	ret["ctf_open"] = conf.CTFOpen
+	ret["lock_second"] = conf.LockSecond
+	ret["lock_duration"] = conf.LockDuration
+	ret["lock_count"] = conf.LockCount
+	return ret
+}
+
 func (s *server) ctfConfigHandler() echo.HandlerFunc {
 	return func(c echo.Context) error {
 		req := new(struct {
@@ -486,6 +781,7 @@ func (s *server) ctfConfigHandler() echo.HandlerFunc {
 		if err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
+		before := configView(conf)
 		conf.CTFName = req.Name
 		conf.StartAt = req.StartAt
 		conf.EndAt = req.EndAt
@@ -498,6 +794,7 @@ func (s *server) ctfConfigHandler() echo.HandlerFunc {
 		if err := s.app.SetCTFConfig(conf); err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
+		s.audit(c, "set-config", "", before, configView(conf))
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"message": ConfigUpdateMessage,
 		})
@@ -524,6 +821,7 @@ func (s *server) openChallengeHandler() echo.HandlerFunc {
 		if err := s.app.OpenChallenge(chal.ID); err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
+		s.audit(c, "open-challenge", chal.Name, map[string]interface{}{"is_open": false}, map[string]interface{}{"is_open": true})
 
 		conf, err := s.app.GetCTFConfig()
 		if err != nil {
@@ -561,6 +859,7 @@ func (s *server) closeChallengeHandler() echo.HandlerFunc {
 		if err := s.app.CloseChallenge(chal.ID); err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
+		s.audit(c, "close-challenge", chal.Name, map[string]interface{}{"is_open": true}, map[string]interface{}{"is_open": false})
 
 		conf, err := s.app.GetCTFConfig()
 		if err != nil {
@@ -588,27 +887,68 @@ func (s *server) updateChallengeHandler() echo.HandlerFunc {
 			Attachments []service.Attachment
 			Host        *string
 			Port        *int
+			HealthCheck *service.HealthCheck    `json:"health_check"`
+			Instance    *service.InstanceConfig `json:"instance"`
+
+			Difficulty string   `json:"difficulty"`
+			Hints      []string `json:"hints"`
+			Flags      []string `json:"flags"`
+			ReleaseAt  int64    `json:"release_at"`
+			ScoreExpr  string   `json:"score_expr"`
 		})
 		if err := c.Bind(req); err != nil {
 			return errorHandle(c, err)
 		}
-		err := s.app.UpdateChallenge(
-			req.ID,
-			&service.Challenge{
-				Name:        req.Name,
-				Flag:        req.Flag,
-				Category:    req.Category,
-				Description: req.Description,
-				Author:      req.Author,
-				IsSurvey:    req.IsSurvey,
-				Tags:        req.Tags,
-				Attachments: req.Attachments,
-				Host:        req.Host,
-				Port:        req.Port,
-			})
+		if err := service.ValidateHealthCheck(req.HealthCheck); err != nil {
+			return errorHandle(c, err)
+		}
+		if err := service.ValidateInstanceConfig(req.Instance); err != nil {
+			return errorHandle(c, err)
+		}
+		if req.ScoreExpr != "" {
+			if _, err := service.CalcChallengeScore(10, req.ScoreExpr); err != nil {
+				return errorHandle(c, xerrors.Errorf(": %w", err))
+			}
+		}
+		lc := c.(*loginContext)
+		chal, err := s.app.GetRawChallengeByID(req.ID)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		if !canEditChallenge(lc.Team, chal.Author) || !canEditChallenge(lc.Team, req.Author) {
+			return errorMessageHandle(c, http.StatusForbidden, ChallengeNotOwnedMessage)
+		}
+		before, err := s.app.GetChallengeByID(req.ID)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		after := &service.Challenge{
+			Name:        req.Name,
+			Flag:        req.Flag,
+			Category:    req.Category,
+			Description: req.Description,
+			Author:      req.Author,
+			IsSurvey:    req.IsSurvey,
+			Tags:        req.Tags,
+			Attachments: req.Attachments,
+			Host:        req.Host,
+			Port:        req.Port,
+			HealthCheck: req.HealthCheck,
+
+			InstanceConfig: req.Instance,
+
+			Difficulty: req.Difficulty,
+			Hints:      req.Hints,
+			Flags:      req.Flags,
+			ReleaseAt:  req.ReleaseAt,
+			ScoreExpr:  req.ScoreExpr,
+		}
+		err = s.app.UpdateChallenge(req.ID, after)
 		if err != nil {
 			return errorHandle(c, err)
 		}
+		after.ID = req.ID
+		s.audit(c, "update-challenge", req.Name, challengeAuditView(before), challengeAuditView(after))
 		conf, err := s.app.GetCTFConfig()
 		if err != nil {
 			log.Printf("%+v\n", err)
@@ -632,14 +972,46 @@ func (s *server) newChallengeHandler() echo.HandlerFunc {
 			Attachments []service.Attachment
 			Host        *string
 			Port        *int
+			HealthCheck *service.HealthCheck    `json:"health_check"`
+			Instance    *service.InstanceConfig `json:"instance"`
+
+			Difficulty string   `json:"difficulty"`
+			Hints      []string `json:"hints"`
+			Flags      []string `json:"flags"`
+			ReleaseAt  int64    `json:"release_at"`
+			ScoreExpr  string   `json:"score_expr"`
+
+			SyncHash string `json:"sync_hash"`
 		})
 		if err := c.Bind(req); err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
+		if err := service.ValidateHealthCheck(req.HealthCheck); err != nil {
+			return errorHandle(c, err)
+		}
+		if err := service.ValidateInstanceConfig(req.Instance); err != nil {
+			return errorHandle(c, err)
+		}
+		if req.ScoreExpr != "" {
+			if _, err := service.CalcChallengeScore(10, req.ScoreExpr); err != nil {
+				return errorHandle(c, xerrors.Errorf(": %w", err))
+			}
+		}
+		lc := c.(*loginContext)
+		if !canEditChallenge(lc.Team, req.Author) {
+			return errorMessageHandle(c, http.StatusForbidden, ChallengeNotOwnedMessage)
+		}
 
 		if chal, err := s.app.GetRawChallengeByName(req.Name); err == nil {
 			// UPDATE
-			if err := s.app.UpdateChallenge(chal.ID, &service.Challenge{
+			if !canEditChallenge(lc.Team, chal.Author) {
+				return errorMessageHandle(c, http.StatusForbidden, ChallengeNotOwnedMessage)
+			}
+			before, err := s.app.GetChallengeByID(chal.ID)
+			if err != nil {
+				return errorHandle(c, xerrors.Errorf(": %w", err))
+			}
+			after := &service.Challenge{
 				Name:        req.Name,
 				Flag:        req.Flag,
 				Category:    req.Category,
@@ -651,9 +1023,27 @@ func (s *server) newChallengeHandler() echo.HandlerFunc {
 				IsOpen:      chal.IsOpen,
 				Host:        req.Host,
 				Port:        req.Port,
-			}); err != nil {
+				HealthCheck: req.HealthCheck,
+
+				InstanceConfig: req.Instance,
+
+				Difficulty: req.Difficulty,
+				Hints:      req.Hints,
+				Flags:      req.Flags,
+				ReleaseAt:  req.ReleaseAt,
+				ScoreExpr:  req.ScoreExpr,
+
+				SyncHash: req.SyncHash,
+			}
+			// 公開済みの問題はrelease_atで公開し直さない
+			if chal.IsOpen {
+				after.ReleaseAt = 0
+			}
+			if err := s.app.UpdateChallenge(chal.ID, after); err != nil {
 				return errorHandle(c, xerrors.Errorf(": %w", err))
 			}
+			after.ID = chal.ID
+			s.audit(c, "new-challenge", req.Name, challengeAuditView(before), challengeAuditView(after))
 			conf, err := s.app.GetCTFConfig()
 			if err != nil {
 				log.Printf("%+v\n", err)
@@ -663,7 +1053,7 @@ func (s *server) newChallengeHandler() echo.HandlerFunc {
 			return c.JSON(http.StatusOK, fmt.Sprintf(ChallengeUpdateTemplate, req.Name))
 		} else {
 			// ADD
-			if err := s.app.AddChallenge(&service.Challenge{
+			after := &service.Challenge{
 				Name:        req.Name,
 				Flag:        req.Flag,
 				Category:    req.Category,
@@ -674,90 +1064,174 @@ func (s *server) newChallengeHandler() echo.HandlerFunc {
 				Attachments: req.Attachments,
 				Host:        req.Host,
 				Port:        req.Port,
-			}); err != nil {
+				HealthCheck: req.HealthCheck,
+
+				InstanceConfig: req.Instance,
+
+				Difficulty: req.Difficulty,
+				Hints:      req.Hints,
+				Flags:      req.Flags,
+				ReleaseAt:  req.ReleaseAt,
+				ScoreExpr:  req.ScoreExpr,
+
+				SyncHash: req.SyncHash,
+			}
+			if err := s.app.AddChallenge(after); err != nil {
 				return errorHandle(c, xerrors.Errorf(": %w", err))
 			}
+			s.audit(c, "new-challenge", req.Name, nil, challengeAuditView(after))
 			return c.JSON(http.StatusOK, fmt.Sprintf(ChallengeAddTemplate, req.Name))
 		}
 	}
 }
 
-func (s *server) listChallengesHandler() echo.HandlerFunc {
+// pruneChallengeHandler はtask directoryが消された問題を閉じる。kosenctfx-cli --pruneが呼ぶ
+func (s *server) pruneChallengeHandler() echo.HandlerFunc {
 	return func(c echo.Context) error {
-		chals, err := s.app.ListAllRawChallenges()
-		if err != nil {
+		req := new(struct {
+			Name string `json:"name"`
+		})
+		if err := c.Bind(req); err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
-		teams, err := s.app.ListTeams()
+		chal, err := s.app.GetRawChallengeByName(req.Name)
 		if err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
-		submissions, err := s.app.ListValidSubmissions()
+		lc := c.(*loginContext)
+		if !canEditChallenge(lc.Team, chal.Author) {
+			return errorMessageHandle(c, http.StatusForbidden, ChallengeNotOwnedMessage)
+		}
+		// 公開中の問題を閉じられるのはclose-challengeと同じくsuperadminだけ
+		if chal.IsOpen && lc.Team.Role() != model.AdminRoleSuperAdmin {
+			return errorMessageHandle(c, http.StatusForbidden, ChallengePruneOpenMessage)
+		}
 
-		challenges, _, err := s.app.ScoreFeed(chals, teams, submissions)
+		if err := s.app.PruneChallenge(chal.ID); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		s.audit(c, "prune-challenge", chal.Name,
+			map[string]interface{}{"is_open": chal.IsOpen, "release_at": chal.ReleaseAt, "sync_hash": chal.SyncHash},
+			map[string]interface{}{"is_open": false, "release_at": 0, "sync_hash": ""})
+
+		conf, err := s.app.GetCTFConfig()
 		if err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
-		return c.JSON(http.StatusOK, challenges)
+		s.refreshCache(conf)
+		if chal.IsOpen {
+			s.AdminWebhook.Post(fmt.Sprintf(ChallengeClosedAdminMessage, chal.Name))
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"message": fmt.Sprintf(ChallengePruneTemplate, chal.Name),
+		})
 	}
 }
 
-//go:embed task.md.tpl
-var taskTemplate string
+// challengeAuditView はaudit logに残す問題の値。集計値は変更ではないので含めない
+func challengeAuditView(c *service.Challenge) map[string]interface{} {
+	return map[string]interface{}{
+		"name":         c.Name,
+		"flag":         c.Flag,
+		"category":     c.Category,
+		"description":  c.Description,
+		"author":       c.Author,
+		"is_open":      c.IsOpen,
+		"is_survey":    c.IsSurvey,
+		"tags":         c.Tags,
+		"attachments":  c.Attachments,
+		"host":         c.Host,
+		"port":         c.Port,
+		"health_check": c.HealthCheck,
+		"instance":     c.InstanceConfig,
+		"difficulty":   c.Difficulty,
+		"hints":        c.Hints,
+		"flags":        c.Flags,
+		"release_at":   c.ReleaseAt,
+		"score_expr":   c.ScoreExpr,
+	}
+}
 
-func (s *server) tasksMDHandler() echo.HandlerFunc {
+// canEditChallenge はauthorのroleを持つadminには自分の問題だけを触らせる
+func canEditChallenge(team *model.Team, author string) bool {
+	if team.Role() != model.AdminRoleAuthor {
+		return true
+	}
+	return author == team.Teamname
+}
+
+func (s *server) listChallengesHandler() echo.HandlerFunc {
 	return func(c echo.Context) error {
-		conf, err := s.app.GetCTFConfig()
+		lc := c.(*loginContext)
+		chals, err := s.app.ListAllRawChallenges()
 		if err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
-
-		chals, err := s.getChallenges(conf)
+		// authorには自分の問題とその解答状況だけを見せる
+		if lc.Team.Role() == model.AdminRoleAuthor {
+			owned := make([]*model.Challenge, 0, len(chals))
+			for _, chal := range chals {
+				if canEditChallenge(lc.Team, chal.Author) {
+					owned = append(owned, chal)
+				}
+			}
+			chals = owned
+		}
+		teams, err := s.app.ListTeams()
 		if err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
+		submissions, err := s.app.ListValidSubmissions()
 
-		// これは起動時に一回だけ読んで失敗したらpanicとかでもいいかも
-		t, err := template.New("task.md").Parse(taskTemplate)
+		challenges, _, err := s.app.ScoreFeed(chals, teams, submissions)
 		if err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
-
-		buf := bytes.NewBuffer([]byte{})
+		// ScoreFeedは接続先やinstanceの設定を持たないので、task.ymlと比べられるように足す
+		rawMap := make(map[uint32]*model.Challenge)
 		for _, chal := range chals {
-			if err := t.Execute(buf, chal); err != nil {
-				return errorHandle(c, xerrors.Errorf(": %w", err))
+			rawMap[chal.ID] = chal
+		}
+		for _, chal := range challenges {
+			if raw, ok := rawMap[chal.ID]; ok {
+				chal.Host = raw.Host
+				chal.Port = raw.Port
+				chal.HealthCheck = service.HealthCheckOf(raw)
+				chal.InstanceConfig = service.InstanceConfigOf(raw)
+				chal.SyncHash = raw.SyncHash
 			}
-			buf.WriteString("\n")
 		}
-
-		return c.JSON(http.StatusOK, map[string]interface{}{
-			"text": buf.String(),
-		})
+		return c.JSON(http.StatusOK, challenges)
 	}
 }
 
-//go:embed check-port.py.tpl
-var checkPortPyTemplate string
+//go:embed task.md.tpl
+var taskTemplate string
 
-func (s *server) checkPortPyHandler() echo.HandlerFunc {
+func (s *server) tasksMDHandler() echo.HandlerFunc {
 	return func(c echo.Context) error {
-		chals, err := s.app.ListAllRawChallenges()
+		conf, err := s.app.GetCTFConfig()
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+
+		chals, err := s.getChallenges(conf)
 		if err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
 
 		// これは起動時に一回だけ読んで失敗したらpanicとかでもいいかも
-		t, err := template.New("check-port.py").Parse(checkPortPyTemplate)
+		t, err := template.New("task.md").Parse(taskTemplate)
 		if err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
 
 		buf := bytes.NewBuffer([]byte{})
-		if err := t.Execute(buf, map[string]interface{}{
-			"Challenges": chals,
-		}); err != nil {
-			return errorHandle(c, xerrors.Errorf(": %w", err))
+		for _, chal := range chals {
+			if err := t.Execute(buf, chal); err != nil {
+				return errorHandle(c, xerrors.Errorf(": %w", err))
+			}
+			buf.WriteString("\n")
 		}
 
 		return c.JSON(http.StatusOK, map[string]interface{}{
@@ -805,6 +1279,103 @@ func (s *server) listTeamHandler() echo.HandlerFunc {
 	}
 }
 
+func (s *server) listAdminsHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		admins, err := s.app.ListAdmins()
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+
+		res := make([]map[string]interface{}, len(admins))
+		for i, a := range admins {
+			res[i] = map[string]interface{}{
+				"id":    a.ID,
+				"name":  a.Teamname,
+				"email": a.Email,
+				"role":  a.Role(),
+			}
+		}
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+func (s *server) newAdminHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := new(struct {
+			Name     string          `json:"name"`
+			Email    string          `json:"email"`
+			Password string          `json:"password"`
+			Role     model.AdminRole `json:"role"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+
+		admin, err := s.app.RegisterAdmin(req.Name, req.Password, req.Email, req.Role)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		s.audit(c, "new-admin", admin.Teamname, nil, map[string]interface{}{
+			"email": admin.Email,
+			"role":  admin.Role(),
+		})
+		return messageHandle(c, AdminRegisteredMessage)
+	}
+}
+
+func (s *server) updateAdminRoleHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := new(struct {
+			ID   uint32          `json:"id"`
+			Role model.AdminRole `json:"role"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		team, err := s.app.GetTeamByID(req.ID)
+		if err != nil && xerrors.Is(err, gorm.ErrRecordNotFound) {
+			return errorHandle(c, service.NewErrorMessage(NoSuchTeamMessage))
+		} else if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		// 普通のチームをadminにしてしまうと順位表から消えるので、admin同士のrole変更だけを許す
+		if !team.IsAdmin {
+			return errorHandle(c, service.NewErrorMessage(AdminUnauthorizedMessage))
+		}
+
+		before := team.Role()
+		if err := s.app.SetAdminRole(team, req.Role); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		s.audit(c, "update-admin-role", team.Teamname, map[string]interface{}{"role": before}, map[string]interface{}{"role": team.Role()})
+		return messageHandle(c, AdminRoleUpdateMessage)
+	}
+}
+
+func (s *server) resetTOTPHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := new(struct {
+			ID uint32 `json:"id"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		team, err := s.app.GetTeamByID(req.ID)
+		if err != nil && xerrors.Is(err, gorm.ErrRecordNotFound) {
+			return errorHandle(c, service.NewErrorMessage(NoSuchTeamMessage))
+		} else if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+
+		before := team.TOTPEnabled
+		if err := s.app.ResetTOTP(team); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		s.audit(c, "reset-totp", team.Teamname, map[string]interface{}{"totp_enabled": before}, map[string]interface{}{"totp_enabled": false})
+		return messageHandle(c, TOTPDisabledMessage)
+	}
+}
+
 func (s *server) updateTeamEmail() echo.HandlerFunc {
 	return func(c echo.Context) error {
 		req := new(struct {
@@ -819,14 +1390,67 @@ func (s *server) updateTeamEmail() echo.HandlerFunc {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
 
+		before := team.Email
 		if err := s.app.UpdateEmail(team, req.Email); err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
+		s.audit(c, "update-email", team.Teamname, map[string]interface{}{"email": before}, map[string]interface{}{"email": req.Email})
 
 		return messageHandle(c, ProfileUpdateMessage)
 	}
 }
 
+// newUnrankedTeamHandler は順位表に載らないチームを作り、loginに使うパスワードを返す
+func (s *server) newUnrankedTeamHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := new(struct {
+			Teamname string `json:"teamname"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		if req.Teamname == "" {
+			req.Teamname = "unranked_" + uuid.New().String()[:8]
+		}
+		password := uuid.New().String()
+		team, err := s.app.RegisterUnrankedTeam(req.Teamname, password)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		s.audit(c, "new-unranked-team", team.Teamname, nil, nil)
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"teamname": team.Teamname,
+			"team_id":  team.ID,
+			"password": password,
+		})
+	}
+}
+
+func (s *server) deleteUnrankedTeamHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := new(struct {
+			Teamname string `json:"teamname"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		team, err := s.app.GetTeamByName(req.Teamname)
+		if err != nil {
+			if xerrors.Is(err, gorm.ErrRecordNotFound) {
+				return errorMessageHandle(c, http.StatusNotFound, NoSuchTeamMessage)
+			}
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		if err := s.app.DeleteUnrankedTeam(team); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		s.audit(c, "delete-unranked-team", team.Teamname, nil, nil)
+
+		return messageHandle(c, TeamDeletedMessage)
+	}
+}
+
 // こんなところにロジックを書くなんてと思いつつ書く
 func (s *server) recalcSeries() echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -869,6 +1493,7 @@ func (s *server) recalcSeries() echo.HandlerFunc {
 			}
 		}
 
+		s.audit(c, "recalc-series", "", nil, nil)
 		return messageHandle(c, "Recalc Score")
 	}
 }
@@ -930,11 +1555,16 @@ func (s *server) getPresignedURLHandler() echo.HandlerFunc {
 	}
 }
 
+// sqlHandler はadmin用のSQLコンソール
+// 既定では読み取り専用のtransactionで実行し、変更を伴う文はconfirmを付けたときだけ実行する
+// login_tokensやteams.totp_secretも読めてしまうのでsuperadminだけに使わせる
 func (s *server) sqlHandler() echo.HandlerFunc {
-
 	return func(c echo.Context) error {
 		req := new(struct {
-			Query string `json:"query"`
+			Query   string `json:"query"`
+			Confirm bool   `json:"confirm"`
+			Format  string `json:"format"`
+			Limit   int    `json:"limit"`
 		})
 		if err := c.Bind(req); err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
@@ -943,14 +1573,158 @@ func (s *server) sqlHandler() echo.HandlerFunc {
 		if req.Query == "" {
 			req.Query = "SELECT * FROM information_schema.tables WHERE table_schema=database()"
 		}
+		limit := req.Limit
+		if limit <= 0 {
+			limit = sqlDefaultRowLimit
+		}
+		if limit > sqlMaxRowLimit {
+			limit = sqlMaxRowLimit
+		}
 
-		cols, rows, err := s.doQuery(req.Query)
+		ctx, cancel := context.WithTimeout(c.Request().Context(), sqlStatementTimeout)
+		defer cancel()
+
+		if !isReadOnlyQuery(req.Query) {
+			if !req.Confirm {
+				return errorMessageHandle(c, http.StatusBadRequest, SQLConfirmRequiredMessage)
+			}
+
+			// 失敗したqueryも何をしようとしたかを残しておく
+			s.audit(c, "sql", "", nil, map[string]interface{}{"query": req.Query})
+			affected, err := s.doExec(ctx, req.Query)
+			if err != nil {
+				return errorHandle(c, xerrors.Errorf(": %w", service.NewErrorMessage(err.Error())))
+			}
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"rows_affected": affected,
+			})
+		}
+
+		cols, rows, truncated, err := s.doQuery(ctx, req.Query, limit)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", service.NewErrorMessage(err.Error())))
+		}
+
+		if req.Format == "csv" {
+			data, err := queryResultToCSV(cols, rows)
+			if err != nil {
+				return errorHandle(c, xerrors.Errorf(": %w", err))
+			}
+			c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="query.csv"`)
+			return c.Blob(http.StatusOK, "text/csv; charset=utf-8", data)
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"columns":   cols,
+			"rows":      rows,
+			"truncated": truncated,
+		})
+	}
+}
+
+func (s *server) auditLogHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := new(struct {
+			Actor  string `query:"actor"`
+			Action string `query:"action"`
+			Target string `query:"target"`
+			Since  int64  `query:"since"`
+			Until  int64  `query:"until"`
+			Offset int    `query:"offset"`
+			Limit  int    `query:"limit"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+
+		logs, err := s.app.ListAuditLogs(service.AuditLogFilter{
+			Actor:  req.Actor,
+			Action: req.Action,
+			Target: req.Target,
+			Since:  req.Since,
+			Until:  req.Until,
+			Offset: req.Offset,
+			Limit:  req.Limit,
+		})
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		return c.JSON(http.StatusOK, logs)
+	}
+}
+
+// submissionStatsHandler は最近解かれた問題と、問題ごとの正解と不正解の提出数を返す
+// kosenctfx-cli topが/admin/metricsや/scoreboardと合わせて表示する
+func (s *server) submissionStatsHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := new(struct {
+			Since int64 `query:"since"`
+			Limit int   `query:"limit"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		if req.Limit <= 0 {
+			req.Limit = 10
+		}
+
+		chals, err := s.app.ListAllRawChallenges()
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		chalMap := make(map[uint32]*model.Challenge)
+		for _, chal := range chals {
+			chalMap[chal.ID] = chal
+		}
+		teams, err := s.app.ListTeams()
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		teamMap := make(map[uint32]*model.Team)
+		for _, t := range teams {
+			teamMap[t.ID] = t
+		}
+
+		type solve struct {
+			Team      string `json:"team"`
+			Challenge string `json:"challenge"`
+			SolvedAt  int64  `json:"solved_at"`
+		}
+		recent, err := s.app.ListRecentSolves(req.Limit)
 		if err != nil {
 			return errorHandle(c, xerrors.Errorf(": %w", err))
 		}
+		solves := make([]solve, 0, len(recent))
+		for _, v := range recent {
+			t, ok := teamMap[v.TeamId]
+			chal, ok2 := chalMap[v.ChallengeId]
+			if !ok || !ok2 {
+				continue
+			}
+			solves = append(solves, solve{Team: t.Teamname, Challenge: chal.Name, SolvedAt: v.CreatedAt})
+		}
+
+		type challengeStat struct {
+			Name     string `json:"name"`
+			Category string `json:"category"`
+			Correct  int64  `json:"correct"`
+			Wrong    int64  `json:"wrong"`
+		}
+		counts, err := s.app.CountSubmissionsByTarget(req.Since)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		stats := make([]challengeStat, 0, len(counts))
+		for _, cnt := range counts {
+			chal, ok := chalMap[cnt.ChallengeId]
+			if !ok {
+				continue
+			}
+			stats = append(stats, challengeStat{Name: chal.Name, Category: chal.Category, Correct: cnt.Correct, Wrong: cnt.Wrong})
+		}
+
 		return c.JSON(http.StatusOK, map[string]interface{}{
-			"columns": cols,
-			"rows":    rows,
+			"solves":     solves,
+			"challenges": stats,
 		})
 	}
 }
@@ -990,6 +1764,14 @@ func (s *server) metricsHandler() echo.HandlerFunc {
 	})
 	reg.MustRegister(solveCollector)
 
+	serviceUpCollector := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "challenge_service_up",
+	}, []string{
+		"name",
+		"category",
+	})
+	reg.MustRegister(serviceUpCollector)
+
 	// 別にmetricsとして見たいかと言われればそうでもないので
 	// scoreCollector := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 	// 	Name: "score",
@@ -1039,6 +1821,24 @@ func (s *server) metricsHandler() echo.HandlerFunc {
 			}).Set(float64(count))
 		}
 
+		chals, err := s.app.ListAllRawChallenges()
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		for _, chal := range chals {
+			if !service.IsMonitored(chal) {
+				continue
+			}
+			up := 0.0
+			if chal.IsRunning {
+				up = 1.0
+			}
+			serviceUpCollector.With(prometheus.Labels{
+				"name":     chal.Name,
+				"category": chal.Category,
+			}).Set(up)
+		}
+
 		// conf, err := s.app.GetCTFConfig()
 		// if err != nil {
 		// 	return errorHandle(c, xerrors.Errorf(": %w", err))
@@ -1062,25 +1862,116 @@ func (s *server) metricsHandler() echo.HandlerFunc {
 
 // ---
 
-func (s *server) doQuery(query string) ([]string, []map[string]interface{}, error) {
-	rows, err := s.db.Raw(query).Rows()
+// isReadOnlyQuery はqueryがデータを変更しない文かどうかを先頭のキーワードで判定する
+// MySQLではDDLが暗黙にcommitするのでread onlyのtransactionだけでは防げない
+func isReadOnlyQuery(query string) bool {
+	q := strings.TrimSpace(stripSQLComments(query))
+	q = strings.TrimSuffix(q, ";")
+	// 複数の文は受け付けない
+	if strings.Contains(q, ";") {
+		return false
+	}
+	upper := strings.ToUpper(q)
+	if strings.Contains(upper, "INTO OUTFILE") || strings.Contains(upper, "INTO DUMPFILE") {
+		return false
+	}
+
+	fields := strings.Fields(upper)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.TrimLeft(fields[0], "(") {
+	case "SELECT", "SHOW", "DESCRIBE", "DESC", "EXPLAIN", "WITH":
+		return true
+	}
+	return false
+}
+
+func stripSQLComments(query string) string {
+	var b strings.Builder
+	for i := 0; i < len(query); i++ {
+		switch {
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end == -1 {
+				return b.String()
+			}
+			i += end + 3
+			b.WriteByte(' ')
+		case strings.HasPrefix(query[i:], "-- "), query[i] == '#':
+			end := strings.IndexByte(query[i:], '\n')
+			if end == -1 {
+				return b.String()
+			}
+			i += end
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(query[i])
+		}
+	}
+	return b.String()
+}
+
+// releaseSQLConn はsessionの設定を戻してから接続をpoolに返す
+// 戻せなかった接続は他のqueryに使われないように捨てる
+func releaseSQLConn(conn *sql.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), sqlStatementTimeout)
+	defer cancel()
+	if _, err := conn.ExecContext(ctx, "SET SESSION max_execution_time = DEFAULT"); err != nil {
+		conn.Raw(func(interface{}) error {
+			return driver.ErrBadConn
+		})
+	}
+	conn.Close()
+}
+
+// doQuery は読み取り専用のtransactionでqueryを実行し、最大limit行を返す
+func (s *server) doQuery(ctx context.Context, query string, limit int) ([]string, []map[string]interface{}, bool, error) {
+	rawdb, err := s.db.DB()
 	if err != nil {
-		return nil, nil, xerrors.Errorf(": %w", err)
+		return nil, nil, false, xerrors.Errorf(": %w", err)
 	}
+	// sessionの設定を変えるので専用の接続を使う
+	conn, err := rawdb.Conn(ctx)
+	if err != nil {
+		return nil, nil, false, xerrors.Errorf(": %w", err)
+	}
+	defer releaseSQLConn(conn)
+
+	// contextのtimeoutはクライアント側で打ち切るだけなので、サーバ側でも打ち切らせる
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET SESSION max_execution_time = %d", sqlStatementTimeout.Milliseconds())); err != nil {
+		return nil, nil, false, xerrors.Errorf(": %w", err)
+	}
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, nil, false, xerrors.Errorf(": %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, nil, false, xerrors.Errorf(": %w", err)
+	}
+	defer rows.Close()
 
 	cols, err := rows.Columns()
 	if err != nil {
-		return nil, nil, xerrors.Errorf(": %w", err)
+		return nil, nil, false, xerrors.Errorf(": %w", err)
 	}
 	result := make([]map[string]interface{}, 0)
+	truncated := false
 	for rows.Next() {
+		if len(result) >= limit {
+			truncated = true
+			break
+		}
 		row := make([]interface{}, len(cols))
 		row_ptr := make([]interface{}, len(cols))
 		for i := 0; i < len(row); i++ {
 			row_ptr[i] = &row[i]
 		}
 		if err := rows.Scan(row_ptr...); err != nil {
-			return nil, nil, xerrors.Errorf(": %w", err)
+			return nil, nil, false, xerrors.Errorf(": %w", err)
 		}
 
 		result_row := make(map[string]interface{})
@@ -1097,7 +1988,51 @@ func (s *server) doQuery(query string) ([]string, []map[string]interface{}, erro
 
 		result = append(result, result_row)
 	}
-	return cols, result, nil
+	if err := rows.Err(); err != nil {
+		return nil, nil, false, xerrors.Errorf(": %w", err)
+	}
+	return cols, result, truncated, nil
+}
+
+// doExec は変更を伴う文を実行する
+func (s *server) doExec(ctx context.Context, query string) (int64, error) {
+	rawdb, err := s.db.DB()
+	if err != nil {
+		return 0, xerrors.Errorf(": %w", err)
+	}
+	res, err := rawdb.ExecContext(ctx, query)
+	if err != nil {
+		return 0, xerrors.Errorf(": %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, xerrors.Errorf(": %w", err)
+	}
+	return affected, nil
+}
+
+func queryResultToCSV(cols []string, rows []map[string]interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte{})
+	w := csv.NewWriter(buf)
+	if err := w.Write(cols); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	for _, row := range rows {
+		record := make([]string, len(cols))
+		for i, col := range cols {
+			if row[col] != nil {
+				record[i] = fmt.Sprintf("%v", row[col])
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return buf.Bytes(), nil
 }
 
 func (s *server) refreshCache(config *model.Config) ([]*service.Challenge, []*service.ScoreFeedEntry, error) {
@@ -1182,6 +2117,8 @@ func (s *server) getNonsensitiveChallenges(config *model.Config) ([]*service.Cha
 		challenges[i].Description = ""
 		challenges[i].Tags = []string{}
 		challenges[i].Flag = ""
+		challenges[i].Flags = nil
+		challenges[i].Hints = nil
 		challenges[i].Author = ""
 		challenges[i].Attachments = []service.Attachment{}
 	}
@@ -1197,6 +2134,7 @@ func (s *server) getChallenges(config *model.Config) ([]*service.Challenge, erro
 
 	for i := 0; i < len(challenges); i++ {
 		challenges[i].Flag = ""
+		challenges[i].Flags = nil
 	}
 	return challenges, nil
 }
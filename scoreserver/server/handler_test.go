@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/xerrors"
+)
+
+func TestIsReadOnlyQuery(t *testing.T) {
+	cases := []struct {
+		query    string
+		readOnly bool
+	}{
+		{"SELECT * FROM teams", true},
+		{"  select id from challenges;", true},
+		{"/* count */ SELECT COUNT(*) FROM submissions", true},
+		{"-- check\nSHOW TABLES", true},
+		{"DESC teams", true},
+		{"EXPLAIN SELECT * FROM teams", true},
+		{"WITH t AS (SELECT 1) SELECT * FROM t", true},
+		{"(SELECT 1) UNION (SELECT 2)", true},
+		{"DROP TABLE submissions", false},
+		{"UPDATE challenges SET is_open = 1", false},
+		{"/* SELECT */ DELETE FROM teams", false},
+		{"SELECT 1; DROP TABLE teams", false},
+		{"SELECT * FROM teams INTO OUTFILE '/tmp/teams'", false},
+		{"", false},
+	}
+
+	for _, c := range cases {
+		if got := isReadOnlyQuery(c.query); got != c.readOnly {
+			t.Errorf("isReadOnlyQuery(%q) = %v, expected %v\n", c.query, got, c.readOnly)
+		}
+	}
+}
+
+// pruneApp はpruneChallengeHandlerが権限を確かめるまでに使うメソッドだけを実装する
+type pruneApp struct {
+	service.App
+	challenges map[string]*model.Challenge
+	pruned     []uint32
+}
+
+func (app *pruneApp) GetRawChallengeByName(name string) (*model.Challenge, error) {
+	if c, ok := app.challenges[name]; ok {
+		return c, nil
+	}
+	return nil, service.NewErrorMessage("No such challenge")
+}
+
+func (app *pruneApp) PruneChallenge(challengeID uint32) error {
+	app.pruned = append(app.pruned, challengeID)
+	return xerrors.New("stop here")
+}
+
+func TestPruneChallengeHandler(t *testing.T) {
+	author := &model.Team{Teamname: "alice", IsAdmin: true, AdminRole: model.AdminRoleAuthor}
+	superadmin := &model.Team{Teamname: "root", IsAdmin: true, AdminRole: model.AdminRoleSuperAdmin}
+	app := &pruneApp{challenges: map[string]*model.Challenge{
+		"open":   {Model: model.Model{ID: 1}, Name: "open", Author: "alice", IsOpen: true},
+		"closed": {Model: model.Model{ID: 2}, Name: "closed", Author: "alice"},
+		"bob":    {Model: model.Model{ID: 3}, Name: "bob", Author: "bob"},
+	}}
+	s := New(app, nil, nil, "", "")
+
+	cases := []struct {
+		team   *model.Team
+		name   string
+		pruned bool
+	}{
+		// authorは自分の公開前の問題だけ
+		{author, "closed", true},
+		{author, "open", false},
+		{author, "bob", false},
+		{superadmin, "open", true},
+	}
+	for _, c := range cases {
+		app.pruned = nil
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/admin/prune-challenge", strings.NewReader(`{"name":"`+c.name+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		ctx := &loginContext{Context: e.NewContext(req, rec), Team: c.team}
+		if err := s.pruneChallengeHandler()(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if pruned := len(app.pruned) > 0; pruned != c.pruned {
+			t.Errorf("%s pruning %s: expected pruned=%v, got %v (%d)", c.team.Teamname, c.name, c.pruned, pruned, rec.Code)
+		}
+		if !c.pruned && rec.Code != http.StatusForbidden {
+			t.Errorf("%s pruning %s: expected 403, got %d", c.team.Teamname, c.name, rec.Code)
+		}
+	}
+}
+
+// submitApp はsubmitHandlerが使うメソッドだけを実装する
+type submitApp struct {
+	service.App
+	flag      string
+	submitted []string
+}
+
+func (app *submitApp) CheckSubmittable(teamID uint32) (bool, error) {
+	return true, nil
+}
+
+func (app *submitApp) GetCTFConfig() (*model.Config, error) {
+	return &model.Config{CTFOpen: true, EndAt: time.Now().Add(time.Hour).Unix()}, nil
+}
+
+func (app *submitApp) SubmitFlag(team *model.Team, ipaddress string, flag string, target *uint32, ctfRunning bool, submitted_at int64) (*model.Challenge, bool, bool, error) {
+	app.submitted = append(app.submitted, flag)
+	if flag != app.flag {
+		return nil, false, false, nil
+	}
+	return &model.Challenge{Name: "welcome"}, true, false, nil
+}
+
+func TestSubmitHandlerTrimsFlag(t *testing.T) {
+	app := &submitApp{flag: "KosenCTF{welcome}"}
+	s := New(app, nil, nil, "", "")
+
+	// 順位表に載らないチームならwebhookや提出のlockを通らない
+	team := &model.Team{Teamname: "alice", IsUnranked: true}
+	for _, flag := range []string{"KosenCTF{welcome}", " KosenCTF{welcome} ", "KosenCTF{welcome}\n", "\tKosenCTF{welcome}\r\n"} {
+		e := echo.New()
+		body, _ := json.Marshal(map[string]string{"flag": flag})
+		req := httptest.NewRequest(http.MethodPost, "/submit", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		ctx := &loginContext{Context: e.NewContext(req, rec), Team: team}
+		if err := s.submitHandler()(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Errorf("%q: expected correct, got %d %s", flag, rec.Code, rec.Body.String())
+		}
+	}
+	for _, f := range app.submitted {
+		if f != "KosenCTF{welcome}" {
+			t.Errorf("flag is not trimmed: %q", f)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/theoremoon/kosenctfx/scoreserver/healthcheck"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// 一時的な失敗でalertしないように、失敗したら少し待ってもう一度だけ試す
+	healthCheckRetryWait = 1 * time.Second
+	// /admin/service-statusで返す履歴の数
+	serviceStatusHistoryLimit = 20
+)
+
+// runHealthCheck はHealthCheckIntervalごとに全ての問題サーバの死活監視を行う
+func (s *server) runHealthCheck(ctx context.Context) {
+	ticker := time.NewTicker(s.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.checkChallengeServices(ctx); err != nil {
+			log.Printf("%+v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *server) probeChallenge(ctx context.Context, c *model.Challenge) error {
+	target := service.HealthCheckTarget(c)
+	err := healthcheck.Probe(ctx, target, s.HealthCheckTimeout)
+	if err == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return err
+	case <-time.After(healthCheckRetryWait):
+	}
+	return healthcheck.Probe(ctx, target, s.HealthCheckTimeout)
+}
+
+// checkChallengeServices は監視対象の問題を並列にprobeして結果を保存する
+// 状態が変わったらadminに通知し、playerに見せる問題一覧のcacheも作り直す
+func (s *server) checkChallengeServices(ctx context.Context) error {
+	chals, err := s.app.ListAllRawChallenges()
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+
+	results := make([]error, len(chals))
+	var wg sync.WaitGroup
+	for i, c := range chals {
+		if !service.IsMonitored(c) {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, c *model.Challenge) {
+			defer wg.Done()
+			results[i] = s.probeChallenge(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	now := time.Now().Unix()
+	changed := false
+	for i, c := range chals {
+		if !service.IsMonitored(c) {
+			continue
+		}
+		isRunning := results[i] == nil
+		message := healthcheck.Message(results[i])
+		ok, err := s.app.RecordChallengeStatus(c, isRunning, message, now)
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		if !ok {
+			continue
+		}
+		changed = true
+
+		// 公開前の問題が落ちているのは普通なので通知しない
+		if !c.IsOpen {
+			continue
+		}
+		if isRunning {
+			s.AdminWebhook.Post(fmt.Sprintf(ServiceUpAdminMessage, c.Name))
+		} else {
+			s.AdminWebhook.Post(fmt.Sprintf(ServiceDownAdminMessage, c.Name, message))
+		}
+	}
+
+	if changed {
+		conf, err := s.app.GetCTFConfig()
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		if _, _, err := s.refreshCache(conf); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+	}
+	return nil
+}
+
+type serviceStatusEntry struct {
+	ID          uint32               `json:"id"`
+	Name        string               `json:"name"`
+	Host        *string              `json:"host"`
+	Port        *int                 `json:"port"`
+	HealthCheck string               `json:"health_check"`
+	IsOpen      bool                 `json:"is_open"`
+	IsRunning   bool                 `json:"is_running"`
+	History     []serviceStatusEvent `json:"history"`
+}
+
+type serviceStatusEvent struct {
+	IsRunning bool   `json:"is_running"`
+	Message   string `json:"message"`
+	CheckedAt int64  `json:"checked_at"`
+}
+
+// serviceStatusHandler は監視対象の問題の現在の状態と最近の変化を返す
+func (s *server) serviceStatusHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		chals, err := s.app.ListAllRawChallenges()
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+
+		entries := make([]serviceStatusEntry, 0, len(chals))
+		for _, chal := range chals {
+			if !service.IsMonitored(chal) {
+				continue
+			}
+			statuses, err := s.app.ListChallengeStatuses(chal.ID, serviceStatusHistoryLimit)
+			if err != nil {
+				return errorHandle(c, xerrors.Errorf(": %w", err))
+			}
+			history := make([]serviceStatusEvent, len(statuses))
+			for i, st := range statuses {
+				history[i] = serviceStatusEvent{
+					IsRunning: st.IsRunning,
+					Message:   st.Message,
+					CheckedAt: st.CheckedAt,
+				}
+			}
+
+			typ := chal.HealthCheckType
+			if typ == "" {
+				typ = healthcheck.TypeTCP
+			}
+			entries = append(entries, serviceStatusEntry{
+				ID:          chal.ID,
+				Name:        chal.Name,
+				Host:        chal.Host,
+				Port:        chal.Port,
+				HealthCheck: typ,
+				IsOpen:      chal.IsOpen,
+				IsRunning:   chal.IsRunning,
+				History:     history,
+			})
+		}
+		return c.JSON(http.StatusOK, entries)
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/xerrors"
+)
+
+// 期限切れのinstanceを片付ける間隔
+const instanceReapInterval = 30 * time.Second
+
+func (s *server) runInstanceReaper(ctx context.Context) {
+	ticker := time.NewTicker(instanceReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n, err := s.app.ReapInstances()
+		if err != nil {
+			log.Printf("%+v\n", err)
+		}
+		if n > 0 {
+			log.Printf("reaped %d instances\n", n)
+		}
+	}
+}
+
+// instanceChallenge は公開中の問題だけを返す。非公開の問題は存在しないものとして扱う
+func (s *server) instanceChallenge(name string) (*model.Challenge, error) {
+	chal, err := s.app.GetRawChallengeByName(name)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if !chal.IsOpen {
+		return nil, service.NewErrorMessage(NoSuchChallengeMessage)
+	}
+	return chal, nil
+}
+
+func (s *server) startInstanceHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		lc := c.(*loginContext)
+		req := new(struct {
+			Name string `json:"name"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		chal, err := s.instanceChallenge(req.Name)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		i, err := s.app.StartInstance(lc.Team, chal)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"message":  InstanceStartedMessage,
+			"instance": service.NewInstanceView(i),
+		})
+	}
+}
+
+func (s *server) stopInstanceHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		lc := c.(*loginContext)
+		req := new(struct {
+			Name string `json:"name"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		// 閉じた問題でも起動済みのinstanceは止められるようにする
+		chal, err := s.app.GetRawChallengeByName(req.Name)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		if err := s.app.StopInstance(lc.Team, chal); err != nil {
+			if !chal.IsOpen {
+				// instanceがなければ非公開の問題は存在しないものとして扱う
+				return errorHandle(c, service.NewErrorMessage(NoSuchChallengeMessage))
+			}
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		return messageHandle(c, InstanceStoppedMessage)
+	}
+}
+
+func (s *server) extendInstanceHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		lc := c.(*loginContext)
+		req := new(struct {
+			Name string `json:"name"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		chal, err := s.instanceChallenge(req.Name)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		i, err := s.app.ExtendInstance(lc.Team, chal)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"message":  InstanceExtendedMessage,
+			"instance": service.NewInstanceView(i),
+		})
+	}
+}
+
+// teamInstances はチームのinstanceを問題のIDごとに返す
+func (s *server) teamInstances(team *model.Team) (map[uint32]*service.InstanceView, error) {
+	instances, err := s.app.ListTeamInstances(team)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	views := make(map[uint32]*service.InstanceView)
+	for _, i := range instances {
+		views[i.ChallengeId] = service.NewInstanceView(i)
+	}
+	return views, nil
+}
+
+// injectInstances はチームのinstanceの接続先を問題に埋め込む
+func (s *server) injectInstances(team *model.Team, challenges []*service.Challenge) error {
+	views, err := s.teamInstances(team)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	for _, c := range challenges {
+		if !c.IsInstanced {
+			continue
+		}
+		if v, ok := views[c.ID]; ok && v.IsReady {
+			host, port := v.Host, v.Port
+			c.Host = &host
+			c.Port = &port
+			c.Instance = v
+		} else if ok {
+			c.Instance = v
+		}
+	}
+	return nil
+}
+
+func (s *server) listInstancesHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		lc := c.(*loginContext)
+		views, err := s.teamInstances(lc.Team)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		return c.JSON(http.StatusOK, views)
+	}
+}
+
+// adminStopInstanceHandler はチームのinstanceを問題が公開中かどうかに関わらず止める
+func (s *server) adminStopInstanceHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := new(struct {
+			ID uint32 `json:"id"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		i, err := s.app.StopInstanceByID(req.ID)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		s.audit(c, "stop-instance", strconv.FormatUint(uint64(req.ID), 10), i, nil)
+		return messageHandle(c, InstanceStoppedMessage)
+	}
+}
+
+func (s *server) adminListInstancesHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		instances, err := s.app.ListAllInstances()
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		return c.JSON(http.StatusOK, instances)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+)
+
+// instanceApp はstopInstanceHandlerが使うメソッドだけを実装する
+type instanceApp struct {
+	service.App
+	challenges map[string]*model.Challenge
+	running    map[uint32]bool
+}
+
+func (app *instanceApp) GetRawChallengeByName(name string) (*model.Challenge, error) {
+	if c, ok := app.challenges[name]; ok {
+		return c, nil
+	}
+	return nil, service.NewErrorMessage(NoSuchChallengeMessage)
+}
+
+func (app *instanceApp) StopInstance(team *model.Team, chal *model.Challenge) error {
+	if !app.running[chal.ID] {
+		return service.NewErrorMessage("No instance is running")
+	}
+	delete(app.running, chal.ID)
+	return nil
+}
+
+func TestStopInstanceHandler(t *testing.T) {
+	app := &instanceApp{
+		challenges: map[string]*model.Challenge{
+			"open":   {Model: model.Model{ID: 1}, Name: "open", IsOpen: true},
+			"closed": {Model: model.Model{ID: 2}, Name: "closed"},
+			"secret": {Model: model.Model{ID: 3}, Name: "secret"},
+		},
+		running: map[uint32]bool{1: true, 2: true},
+	}
+	s := New(app, nil, nil, "", "")
+
+	stop := func(name string) (int, string) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/instance/stop", strings.NewReader(`{"name":"`+name+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		ctx := &loginContext{Context: e.NewContext(req, rec), Team: &model.Team{Teamname: "alice"}}
+		if err := s.stopInstanceHandler()(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, rec.Body.String()
+	}
+
+	if code, body := stop("open"); code != http.StatusOK {
+		t.Errorf("failed to stop an instance of an open challenge: %d %s", code, body)
+	}
+	// 問題を閉じた後でも起動済みのinstanceは止められる
+	if code, body := stop("closed"); code != http.StatusOK || app.running[2] {
+		t.Errorf("failed to stop an instance of a closed challenge: %d %s", code, body)
+	}
+	// instanceがなければ非公開の問題があることは見せない
+	if code, body := stop("secret"); code == http.StatusOK || !strings.Contains(body, NoSuchChallengeMessage) {
+		t.Errorf("expected no such challenge, got %d %s", code, body)
+	}
+}
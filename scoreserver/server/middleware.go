@@ -11,6 +11,7 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/labstack/echo/v4"
 	"github.com/theoremoon/kosenctfx/scoreserver/loader"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
 	"github.com/theoremoon/kosenctfx/scoreserver/service"
 	"golang.org/x/xerrors"
 )
@@ -50,47 +51,99 @@ func (s *server) loginMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
-func (s *server) adminMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		authorized := false
-		// tokenによる認証
-		auth := c.Request().Header.Get(echo.HeaderAuthorization)
-		if strings.HasPrefix(auth, "Bearer ") {
-			if auth[len("Bearer "):] == s.Token {
-				authorized = true
+// adminMiddleware はadminとして認証し、rolesが指定されていればそのいずれかのroleを要求する
+// tokenで認証したときはsuperadminとして扱う
+func (s *server) adminMiddleware(roles ...model.AdminRole) echo.MiddlewareFunc {
+	return func(h echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authorized := false
+			// tokenによる認証
+			auth := c.Request().Header.Get(echo.HeaderAuthorization)
+			if strings.HasPrefix(auth, "Bearer ") {
+				if auth[len("Bearer "):] == s.Token {
+					authorized = true
+				}
 			}
-		}
 
-		// basic認証
-		_, password, isBasic := c.Request().BasicAuth()
-		if isBasic {
-			if password == s.Token {
-				authorized = true
+			// basic認証
+			_, password, isBasic := c.Request().BasicAuth()
+			if isBasic {
+				if password == s.Token {
+					authorized = true
+				}
+			}
+
+			if authorized {
+				team, err := s.app.GetAdminTeam()
+				if err != nil {
+					return errorHandle(c, xerrors.Errorf(": %w", err))
+				}
+				return h(&loginContext{c, team})
 			}
-		}
 
-		if authorized {
-			team, err := s.app.GetAdminTeam()
+			// admin loginによる認証
+			team, err := s.getLoginTeam(c)
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]interface{}{
+					"message": UnauthorizedMessage,
+				})
+			}
+			if !team.IsAdmin {
+				return c.JSON(http.StatusUnauthorized, map[string]interface{}{
+					"message": AdminUnauthorizedMessage,
+				})
+			}
+			verified, err := s.isTwoFactorVerified(c, team)
 			if err != nil {
 				return errorHandle(c, xerrors.Errorf(": %w", err))
 			}
+			if !verified {
+				return c.JSON(http.StatusForbidden, map[string]interface{}{
+					"message":             TOTPRequiredForAdminMessage,
+					"two_factor_required": true,
+				})
+			}
+			if !service.HasAdminRole(team, roles...) {
+				return c.JSON(http.StatusForbidden, map[string]interface{}{
+					"message": AdminForbiddenMessage,
+				})
+			}
 			return h(&loginContext{c, team})
 		}
+	}
+}
 
-		// admin loginによる認証
-		team, err := s.getLoginTeam(c)
-		if err != nil {
-			return c.JSON(http.StatusUnauthorized, map[string]interface{}{
-				"message": UnauthorizedMessage,
-			})
-		}
-		if !team.IsAdmin {
-			return c.JSON(http.StatusUnauthorized, map[string]interface{}{
-				"message": AdminUnauthorizedMessage,
-			})
-		}
-		return h(&loginContext{c, team})
+// isTwoFactorVerified はsessionが二要素認証を済ませているかを返す
+// sessionでadminになるには二要素認証を済ませていなければならない
+func (s *server) isTwoFactorVerified(c echo.Context, team *model.Team) (bool, error) {
+	if !team.TOTPEnabled {
+		return false, nil
+	}
+	token, err := s.getLoginToken(c)
+	if err != nil {
+		return false, xerrors.Errorf(": %w", err)
+	}
+	verified, err := s.app.IsTwoFactorVerified(token)
+	if err != nil {
+		return false, xerrors.Errorf(": %w", err)
+	}
+	return verified, nil
+}
+
+// sessionAdmin はsessionのチームが二要素認証を済ませたadminならそのチームを返す
+// adminMiddlewareを通らないhandlerでadminとして扱うかを決めるのに使う
+func (s *server) sessionAdmin(c echo.Context, team *model.Team) (*model.Team, error) {
+	if team == nil || !team.IsAdmin {
+		return nil, nil
+	}
+	verified, err := s.isTwoFactorVerified(c, team)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if !verified {
+		return nil, nil
 	}
+	return team, nil
 }
 
 func (s *server) notLoginMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/xerrors"
+)
+
+// middlewareApp はadminMiddlewareが使うメソッドだけを実装する
+type middlewareApp struct {
+	service.App
+	teams map[string]*model.Team
+	admin *model.Team
+}
+
+func (app *middlewareApp) GetLoginTeam(token string) (*model.Team, error) {
+	t, ok := app.teams[token]
+	if !ok {
+		return nil, xerrors.New("not found")
+	}
+	return t, nil
+}
+
+func (app *middlewareApp) IsTwoFactorVerified(loginToken string) (bool, error) {
+	return true, nil
+}
+
+func (app *middlewareApp) GetAdminTeam() (*model.Team, error) {
+	return app.admin, nil
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	admin := func(role model.AdminRole) *model.Team {
+		return &model.Team{Teamname: string(role), IsAdmin: true, AdminRole: role, TOTPEnabled: true}
+	}
+	app := &middlewareApp{
+		teams: map[string]*model.Team{
+			"superadmin": admin(model.AdminRoleSuperAdmin),
+			"legacy":     admin(""),
+			"author":     admin(model.AdminRoleAuthor),
+			"support":    admin(model.AdminRoleSupport),
+			"readonly":   admin(model.AdminRoleReadOnly),
+			"player":     {Teamname: "player"},
+		},
+		admin: admin(model.AdminRoleSuperAdmin),
+	}
+	s := New(app, nil, nil, "", "secret")
+
+	cases := []struct {
+		roles  []model.AdminRole
+		cookie string
+		bearer string
+		status int
+	}{
+		{[]model.AdminRole{model.AdminRoleSuperAdmin}, "superadmin", "", http.StatusOK},
+		// roleが設定される前のadminはsuperadmin
+		{[]model.AdminRole{model.AdminRoleSuperAdmin}, "legacy", "", http.StatusOK},
+		{[]model.AdminRole{model.AdminRoleSuperAdmin}, "author", "", http.StatusForbidden},
+		{[]model.AdminRole{model.AdminRoleAuthor}, "author", "", http.StatusOK},
+		{[]model.AdminRole{model.AdminRoleAuthor}, "readonly", "", http.StatusForbidden},
+		{[]model.AdminRole{model.AdminRoleSupport, model.AdminRoleReadOnly}, "support", "", http.StatusOK},
+		{[]model.AdminRole{model.AdminRoleSupport, model.AdminRoleReadOnly}, "readonly", "", http.StatusOK},
+		{[]model.AdminRole{model.AdminRoleSupport, model.AdminRoleReadOnly}, "author", "", http.StatusForbidden},
+		{[]model.AdminRole{model.AdminRoleReadOnly}, "player", "", http.StatusUnauthorized},
+		{[]model.AdminRole{model.AdminRoleReadOnly}, "", "", http.StatusUnauthorized},
+		// tokenはsuperadminとして扱う
+		{[]model.AdminRole{model.AdminRoleSuperAdmin}, "", "secret", http.StatusOK},
+		{[]model.AdminRole{model.AdminRoleSuperAdmin}, "", "wrong", http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/admin/test", nil)
+		if c.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: s.SessionKey, Value: c.cookie})
+		}
+		if c.bearer != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+c.bearer)
+		}
+		rec := httptest.NewRecorder()
+		h := s.adminMiddleware(c.roles...)(func(c echo.Context) error {
+			return c.String(http.StatusOK, c.(*loginContext).Team.Teamname)
+		})
+		if err := h(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != c.status {
+			t.Errorf("roles %v, cookie %q, bearer %q: expected %d, got %d", c.roles, c.cookie, c.bearer, c.status, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/oidc"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"gorm.io/gorm"
+)
+
+// oidcApp はoidcTeamが使うメソッドだけを実装する
+// GetTeamByEmailなど他のメソッドを呼ぶとpanicする
+type oidcApp struct {
+	service.App
+	linked       map[string]*model.Team
+	registerOpen bool
+	registered   []string
+}
+
+func (app *oidcApp) GetTeamByOIDCSubject(subject string) (*model.Team, error) {
+	if t, ok := app.linked[subject]; ok {
+		return t, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (app *oidcApp) GetCTFConfig() (*model.Config, error) {
+	return &model.Config{RegisterOpen: app.registerOpen}, nil
+}
+
+func (app *oidcApp) RegisterOIDCTeam(teamname, email, subject string) (*model.Team, error) {
+	app.registered = append(app.registered, email)
+	return &model.Team{Teamname: teamname, Email: email}, nil
+}
+
+func TestOIDCTeam(t *testing.T) {
+	alice := &model.Team{Teamname: "alice"}
+	app := &oidcApp{linked: map[string]*model.Team{"issuer|alice": alice}}
+	s := New(app, nil, nil, "", "")
+
+	team, err := s.oidcTeam(&oidc.Identity{Subject: "alice"}, "issuer|alice")
+	if err != nil || team != alice {
+		t.Fatalf("expected the linked team, got %v, %v", team, err)
+	}
+
+	// メールアドレスが確認済みでも既存のチームには紐付けず、登録受付中でなければ断る
+	identity := &oidc.Identity{Subject: "bob", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "bob"}
+	if _, err := s.oidcTeam(identity, "issuer|bob"); err == nil {
+		t.Errorf("expected an error while registration is closed")
+	}
+
+	app.registerOpen = true
+	team, err = s.oidcTeam(identity, "issuer|bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if team.Teamname != "bob" || team.Email != "alice@example.com" {
+		t.Errorf("unexpected team: %+v", team)
+	}
+
+	// 確認されていないメールアドレスは使わない
+	identity = &oidc.Identity{Subject: "carol", Email: "carol@example.com", Name: "carol"}
+	if _, err := s.oidcTeam(identity, "issuer|carol"); err != nil {
+		t.Fatal(err)
+	}
+	if email := app.registered[len(app.registered)-1]; email != "" {
+		t.Errorf("expected an unverified email to be dropped, got %s", email)
+	}
+}
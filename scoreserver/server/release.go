@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"golang.org/x/xerrors"
+)
+
+// release_atを過ぎた問題を探す間隔
+const releaseCheckInterval = 10 * time.Second
+
+// dueReleases はrelease_atを過ぎてまだ公開されていない問題を返す
+func dueReleases(chals []*model.Challenge, now time.Time) []*model.Challenge {
+	due := make([]*model.Challenge, 0)
+	for _, c := range chals {
+		if !c.IsOpen && c.ReleaseAt != 0 && c.ReleaseAt <= now.Unix() {
+			due = append(due, c)
+		}
+	}
+	return due
+}
+
+// releaseChallenges はrelease_atを過ぎた問題を公開する。公開した数を返す
+func (s *server) releaseChallenges(now time.Time) (int, error) {
+	chals, err := s.app.ListAllRawChallenges()
+	if err != nil {
+		return 0, xerrors.Errorf(": %w", err)
+	}
+	due := dueReleases(chals, now)
+	if len(due) == 0 {
+		return 0, nil
+	}
+	conf, err := s.app.GetCTFConfig()
+	if err != nil {
+		return 0, xerrors.Errorf(": %w", err)
+	}
+
+	released := 0
+	for _, c := range due {
+		if err := s.app.ReleaseChallenge(c.ID); err != nil {
+			log.Printf("%+v\n", err)
+			continue
+		}
+		released++
+		if err := s.app.RecordAudit(nil, "", "release-challenge", c.Name, map[string]interface{}{"is_open": false}, map[string]interface{}{"is_open": true}, now.Unix()); err != nil {
+			log.Printf("%+v\n", err)
+		}
+		if service.CalcCTFStatus(conf) == service.CTFRunning {
+			s.TaskOpenWebhook.Post(fmt.Sprintf(ChallengeOpenSystemMessage, c.Name))
+		}
+		s.AdminWebhook.Post(fmt.Sprintf(ChallengeOpenAdminMessage, c.Name))
+	}
+	s.refreshCache(conf)
+	return released, nil
+}
+
+// runScheduledRelease はrelease_atが設定された問題をその時刻に公開する
+func (s *server) runScheduledRelease(ctx context.Context) {
+	ticker := time.NewTicker(releaseCheckInterval)
+	defer ticker.Stop()
+	for {
+		if n, err := s.releaseChallenges(time.Now()); err != nil {
+			log.Printf("%+v\n", err)
+		} else if n > 0 {
+			log.Printf("released %d challenges\n", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
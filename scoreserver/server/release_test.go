@@ -0,0 +1,22 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+)
+
+func TestDueReleases(t *testing.T) {
+	now := time.Unix(1000, 0)
+	chals := []*model.Challenge{
+		{Name: "due", ReleaseAt: 1000},
+		{Name: "future", ReleaseAt: 1001},
+		{Name: "unscheduled"},
+		{Name: "already-open", IsOpen: true, ReleaseAt: 900},
+	}
+	due := dueReleases(chals, now)
+	if len(due) != 1 || due[0].Name != "due" {
+		t.Errorf("unexpected due challenges: %v", due)
+	}
+}
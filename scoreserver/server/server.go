@@ -1,8 +1,10 @@
 package server
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -10,6 +12,7 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/theoremoon/kosenctfx/scoreserver/bucket"
 	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/oidc"
 	"github.com/theoremoon/kosenctfx/scoreserver/service"
 	"github.com/theoremoon/kosenctfx/scoreserver/webhook"
 	"golang.org/x/xerrors"
@@ -17,8 +20,14 @@ import (
 )
 
 var (
+	AdminForbiddenMessage               = "You don't have permission to do this"
+	AdminRegisteredMessage              = "Admin account is registered"
+	AdminRoleUpdateMessage              = "Admin role is updated"
 	AdminUnauthorizedMessage            = "You are not admin"
 	AlreadyAuthorizedMessage            = "You are already logged in"
+	AttachmentMismatchAdminMessage      = ":warning: attachment `%s` does not match: %s"
+	AttachmentVerifyRunningMessage      = "Attachment verification is already running"
+	AttachmentVerifyStartedMessage      = "Attachment verification is started"
 	BucketNullMessage                   = "Bucket information is not registered to the server"
 	CTFAlreadyStartedMessage            = "CTF has already started"
 	CTFClosedMessage                    = "Competition is closed now"
@@ -31,31 +40,53 @@ var (
 	ChallengeClosedAdminMessage         = "Challenge `%s` closed"
 	ChallengeOpenAdminMessage           = "Challenge `%s` opened!"
 	ChallengeOpenSystemMessage          = "Challenge `%s` opened!"
+	ChallengeNotOwnedMessage            = "You can only edit your own challenges"
+	ChallengePruneOpenMessage           = "Only superadmin can prune an open challenge"
 	ChallengeOpenTemplate               = "`%s` is opened"
+	ChallengePruneTemplate              = "`%s` is pruned"
 	ChallengeUpdateTemplate             = "Updated the challenge: `%s`"
 	ConfigUpdateMessage                 = "Config is updated"
 	CorrectSubmissionAdminMessage       = "`%s` solved `%s`: `%s`"
 	CorrectSubmissionMessage            = "Correct! You solved `%s`"
+	InstanceExtendedMessage             = "The instance is extended"
+	InstanceStartedMessage              = "The instance is started"
+	InstanceStoppedMessage              = "The instance is stopped"
 	InvalidRequestMessage               = "Invalid request"
 	LoginMessage                        = "Logged in"
 	LogoutMessage                       = "Logged out"
+	MultipartPartsInvalidMessage        = "The number of parts is invalid"
+	MultipartUnsupportedMessage         = "The bucket does not support multipart upload"
 	NotImplementedMessage               = "Not Implemented"
+	OIDCDisabledMessage                 = "OpenID Connect login is not enabled"
+	OIDCStateInvalidMessage             = "OpenID Connect login session is expired. Please try again"
 	PasswordResetEmailSentMessage       = "We've sent you the password reset token"
 	PasswordUpdateMessage               = "Password is successfully reset"
 	PresignedURLKeyRequiredMessage      = "Key is required"
 	ProfileUpdateMessage                = "Team profile is successfully updated"
 	RegisteredMessage                   = "Registered!"
 	RegistrationClosedMessage           = "Registration is closed now"
+	SQLConfirmRequiredMessage           = "This statement may modify the database. Set confirm to run it"
 	ScoreEmulateMaxCountTooSmallMessage = "maxCount should be larger than 0"
+	ServiceDownAdminMessage             = ":red_circle: `%s` is down: %s"
+	ServiceUpAdminMessage               = ":green_circle: `%s` is up"
 	SolvabilityCheckedSolveMessage      = ":heavy_check_mark: `%s`"
 	SolvabilityFailedSystemMessage      = ":warning: `%s`"
+	SolvabilityReportedMessage          = "Solvability check is reported"
 	SubmissionLockedMessage             = "Your submission is currently locked. Please wait for minutes."
+	TeamDeletedMessage                  = "The team is deleted"
+	TOTPDisabledMessage                 = "Two-factor authentication is disabled"
+	TOTPEnabledMessage                  = "Two-factor authentication is enabled"
+	TOTPRequiredForAdminMessage         = "Two-factor authentication is required for admin accounts"
+	TOTPVerifiedMessage                 = "Two-factor authentication is verified"
+	TraceQueryRequiredMessage           = "token or sha256 is required"
 	UnauthorizedMessage                 = "Login is required"
 	ValidSubmissionAdminMessage         = "`%s` solved `%s` :100:, `%s`"
 	ValidSubmissionMessage              = "Correct! You solved `%s`"
 	ValidSubmissionSystemMessage        = "`%s` solved `%s` :100:"
 	WrongSubmissionAdminMessage         = "`%s` submits a wrong flag: `%s`"
 	WrongSubmissionMessage              = "Wrong flag..."
+	NoSuchAttachmentMessage             = "No such attachment"
+	NoSuchChallengeMessage              = "No such challenge"
 	NoSuchTeamMessage                   = "No such team"
 )
 
@@ -77,6 +108,21 @@ type server struct {
 	SolveLogWebhook webhook.Webhook
 	TaskOpenWebhook webhook.Webhook
 	Bucket          bucket.Bucket
+	OIDC            *oidc.Provider
+
+	// trueなら添付ファイルはscoreserverを通してダウンロードさせ、誰が取得したかを記録する
+	PrivateAttachments bool
+
+	// 問題サーバの死活監視の間隔。0なら監視しない
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+
+	// 添付ファイルの検証中なら1
+	verifyingAttachments int32
+
+	// チームごとの添付ファイルを作っている間、同じ添付ファイルとチームの組を待たせる
+	variantMu    sync.Mutex
+	variantLocks map[string]*variantLock
 }
 
 func New(app service.App, db *gorm.DB, redis *redis.Client, frontendURL, token string) *server {
@@ -90,6 +136,8 @@ func New(app service.App, db *gorm.DB, redis *redis.Client, frontendURL, token s
 		AdminWebhook:    webhook.Dummy("ADMIN"),
 		TaskOpenWebhook: webhook.Dummy("TASK OPEN"),
 		SolveLogWebhook: webhook.Dummy("SOLVE"),
+
+		HealthCheckTimeout: 5 * time.Second,
 	}
 }
 
@@ -108,6 +156,9 @@ func (s *server) build(isTest bool) *echo.Echo {
 	e.POST("/register", s.registerHandler(), s.notLoginMiddleware, s.registerableMiddleware)
 	e.POST("/login", s.loginHandler())
 	e.POST("/logout", s.logoutHandler())
+	e.GET("/oidc/login", s.oidcLoginHandler())
+	e.GET("/oidc/callback", s.oidcCallbackHandler())
+	e.POST("/oidc/verify", s.oidcVerifyHandler(), s.notLoginMiddleware)
 	e.GET("/ctf", s.ctfHandler())
 	e.GET("/account", s.accountHandler())
 	e.GET("/scoreboard", s.scoreboardHandler())
@@ -118,36 +169,88 @@ func (s *server) build(isTest bool) *echo.Echo {
 	e.POST("/passwordreset", s.passwordresetHandler(), s.notLoginMiddleware)
 	e.POST("/update-profile", s.profileUpdateHandler(), s.loginMiddleware)
 
+	e.POST("/totp/enroll", s.totpEnrollHandler(), s.loginMiddleware)
+	e.POST("/totp/confirm", s.totpConfirmHandler(), s.loginMiddleware)
+	e.POST("/totp/verify", s.totpVerifyHandler(), s.loginMiddleware)
+	e.POST("/totp/disable", s.totpDisableHandler(), s.loginMiddleware)
+	e.POST("/totp/recovery-codes", s.totpRecoveryCodesHandler(), s.loginMiddleware)
+
 	e.GET("/team/:id", s.teamHandler())
+	e.GET("/attachments/:id", s.downloadAttachmentHandler())
 
 	e.POST("/submit", s.submitHandler(), s.loginMiddleware, s.ctfStartedMiddleware)
 
-	e.GET("/admin/score-emulate", s.scoreEmulateHandler(), s.adminMiddleware)
-	e.GET("/admin/get-config", s.getConfigHandler(), s.adminMiddleware)
-	e.POST("/admin/set-config", s.ctfConfigHandler(), s.adminMiddleware)
-	e.POST("/admin/open-challenge", s.openChallengeHandler(), s.adminMiddleware)
-	e.POST("/admin/close-challenge", s.closeChallengeHandler(), s.adminMiddleware)
-	e.POST("/admin/update-challenge", s.updateChallengeHandler(), s.adminMiddleware)
-	e.POST("/admin/new-challenge", s.newChallengeHandler(), s.adminMiddleware)
-	e.GET("/admin/list-challenges", s.listChallengesHandler(), s.adminMiddleware)
-	e.GET("/admin/tasks.md", s.tasksMDHandler(), s.adminMiddleware)
-	e.GET("/admin/check-port.py", s.checkPortPyHandler(), s.adminMiddleware)
-	e.GET("/admin/team", s.adminTeamHandler(), s.adminMiddleware)
-	e.GET("/admin/teams", s.listTeamHandler(), s.adminMiddleware)
-	e.POST("/admin/update-email", s.updateTeamEmail(), s.adminMiddleware)
-	e.POST("/admin/recalc-series", s.recalcSeries(), s.adminMiddleware)
-	e.GET("/admin/all-team-series", s.allTeamSeries(), s.adminMiddleware)
-	e.POST("/admin/get-presigned-url", s.getPresignedURLHandler(), s.adminMiddleware)
-	e.POST("/admin/sql", s.sqlHandler(), s.adminMiddleware)
+	e.GET("/instances", s.listInstancesHandler(), s.loginMiddleware)
+	e.POST("/instance/start", s.startInstanceHandler(), s.loginMiddleware, s.ctfStartedMiddleware)
+	e.POST("/instance/stop", s.stopInstanceHandler(), s.loginMiddleware)
+	e.POST("/instance/extend", s.extendInstanceHandler(), s.loginMiddleware, s.ctfStartedMiddleware)
+
+	superadmin := model.AdminRoleSuperAdmin
+	author := model.AdminRoleAuthor
+	support := model.AdminRoleSupport
+	readonly := model.AdminRoleReadOnly
+
+	e.GET("/admin/score-emulate", s.scoreEmulateHandler(), s.adminMiddleware(readonly))
+	e.GET("/admin/get-config", s.getConfigHandler(), s.adminMiddleware(author, support, readonly))
+	e.POST("/admin/set-config", s.ctfConfigHandler(), s.adminMiddleware(superadmin))
+	e.POST("/admin/open-challenge", s.openChallengeHandler(), s.adminMiddleware(superadmin))
+	e.POST("/admin/close-challenge", s.closeChallengeHandler(), s.adminMiddleware(superadmin))
+	e.POST("/admin/update-challenge", s.updateChallengeHandler(), s.adminMiddleware(author))
+	e.POST("/admin/new-challenge", s.newChallengeHandler(), s.adminMiddleware(author))
+	e.POST("/admin/prune-challenge", s.pruneChallengeHandler(), s.adminMiddleware(author))
+	e.GET("/admin/list-challenges", s.listChallengesHandler(), s.adminMiddleware(author, readonly))
+	e.GET("/admin/tasks.md", s.tasksMDHandler(), s.adminMiddleware(readonly))
+	e.GET("/admin/service-status", s.serviceStatusHandler(), s.adminMiddleware(support, readonly))
+	e.GET("/admin/solvability", s.listSolvabilityHandler(), s.adminMiddleware(author, readonly))
+	e.POST("/admin/solvability", s.reportSolvabilityHandler(), s.adminMiddleware(author))
+	e.GET("/admin/instances", s.adminListInstancesHandler(), s.adminMiddleware(support, readonly))
+	e.POST("/admin/stop-instance", s.adminStopInstanceHandler(), s.adminMiddleware(support))
+	e.GET("/admin/team", s.adminTeamHandler(), s.adminMiddleware(support, readonly))
+	e.GET("/admin/teams", s.listTeamHandler(), s.adminMiddleware(support, readonly))
+	e.POST("/admin/update-email", s.updateTeamEmail(), s.adminMiddleware(support))
+	e.POST("/admin/new-unranked-team", s.newUnrankedTeamHandler(), s.adminMiddleware(superadmin))
+	e.POST("/admin/delete-unranked-team", s.deleteUnrankedTeamHandler(), s.adminMiddleware(superadmin))
+	e.POST("/admin/recalc-series", s.recalcSeries(), s.adminMiddleware(superadmin))
+	e.GET("/admin/all-team-series", s.allTeamSeries(), s.adminMiddleware(support, readonly))
+	e.GET("/admin/submission-stats", s.submissionStatsHandler(), s.adminMiddleware(support, readonly))
+	e.POST("/admin/get-presigned-url", s.getPresignedURLHandler(), s.adminMiddleware(author))
+	e.POST("/admin/multipart-upload", s.multipartUploadHandler(), s.adminMiddleware(author))
+	e.POST("/admin/complete-multipart-upload", s.completeMultipartUploadHandler(), s.adminMiddleware(author))
+	e.POST("/admin/abort-multipart-upload", s.abortMultipartUploadHandler(), s.adminMiddleware(author))
+	e.GET("/admin/downloads", s.listDownloadsHandler(), s.adminMiddleware(author, support, readonly))
+	e.GET("/admin/attachments/:id", s.adminDownloadAttachmentHandler(), s.adminMiddleware(author, readonly))
+	e.GET("/admin/trace-attachment", s.traceAttachmentHandler(), s.adminMiddleware(author, support, readonly))
+	e.GET("/admin/orphaned-objects", s.listOrphanedObjectsHandler(), s.adminMiddleware(author, readonly))
+	e.POST("/admin/gc-objects", s.gcObjectsHandler(), s.adminMiddleware(superadmin))
+	e.POST("/admin/verify-attachments", s.verifyAttachmentsHandler(), s.adminMiddleware(author))
+	e.GET("/admin/verify-attachments", s.listAttachmentVerificationsHandler(), s.adminMiddleware(readonly))
+	e.POST("/admin/sql", s.sqlHandler(), s.adminMiddleware(superadmin))
+	e.GET("/admin/admins", s.listAdminsHandler(), s.adminMiddleware(superadmin))
+	e.GET("/admin/audit-log", s.auditLogHandler(), s.adminMiddleware(readonly))
+	e.POST("/admin/new-admin", s.newAdminHandler(), s.adminMiddleware(superadmin))
+	e.POST("/admin/update-admin-role", s.updateAdminRoleHandler(), s.adminMiddleware(superadmin))
+	e.POST("/admin/reset-totp", s.resetTOTPHandler(), s.adminMiddleware(superadmin))
 
 	// prometheus exporter
-	e.GET("/admin/metrics", s.metricsHandler(), s.adminMiddleware)
+	e.GET("/admin/metrics", s.metricsHandler(), s.adminMiddleware(support, readonly))
+
+	// LOCALのbucketはserver自身が添付ファイルを配信する。uploadは署名付きURLで受け付ける
+	if b, ok := s.Bucket.(*bucket.LocalBucket); ok {
+		e.Any(bucket.LocalBucketPath+"*", echo.WrapHandler(b))
+	}
 
 	return e
 }
 
 func (s *server) Start(addr string) error {
 	e := s.build(false)
+	if s.HealthCheckInterval > 0 {
+		go s.runHealthCheck(context.Background())
+	}
+	if s.app.InstancerEnabled() {
+		go s.runInstanceReaper(context.Background())
+	}
+	go s.runScheduledRelease(context.Background())
 	return e.Start(addr)
 }
 
@@ -181,6 +284,17 @@ func messageHandleWithStatus(c echo.Context, status int, msg string) error {
 	})
 }
 
+// audit はadminによる変更をaudit logに残す。記録に失敗しても操作自体は成功しているのでログに出すだけにする
+func (s *server) audit(c echo.Context, action, target string, before, after interface{}) {
+	var actor *model.Team
+	if lc, ok := c.(*loginContext); ok {
+		actor = lc.Team
+	}
+	if err := s.app.RecordAudit(actor, c.RealIP(), action, target, before, after, time.Now().Unix()); err != nil {
+		log.Printf("%+v\n", err)
+	}
+}
+
 func (s *server) tokenCookie(token *model.LoginToken) *http.Cookie {
 	return &http.Cookie{
 		Name:     s.SessionKey,
@@ -191,6 +305,22 @@ func (s *server) tokenCookie(token *model.LoginToken) *http.Cookie {
 	}
 }
 
+// oidcCookie はproviderへのredirectとcallback、callbackと二要素認証を結びつけるためのcookie。valueが空なら削除する
+func (s *server) oidcCookie(suffix, value string) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     s.SessionKey + suffix,
+		Value:    value,
+		Expires:  time.Now().Add(oidcStateLifetime),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		cookie.Expires = time.Time{}
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
 func (s *server) removeTokenCookie() *http.Cookie {
 	return &http.Cookie{
 		Name:     s.SessionKey,
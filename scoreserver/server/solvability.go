@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/xerrors"
+)
+
+// webhookに載せるsolverの出力の上限
+const solvabilityMessageLimit = 500
+
+type solvabilityEntry struct {
+	Name       string `json:"name"`
+	IsSolvable bool   `json:"is_solvable"`
+	Message    string `json:"message"`
+	CheckedAt  int64  `json:"checked_at"`
+}
+
+// reportSolvabilityHandler は kosenctfx-cli check の結果を受け取る
+// 結果が変わったとき（と初回）だけadminに通知する
+func (s *server) reportSolvabilityHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := new(struct {
+			Name       string `json:"name"`
+			IsSolvable bool   `json:"is_solvable"`
+			Message    string `json:"message"`
+		})
+		if err := c.Bind(req); err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		chal, err := s.app.GetRawChallengeByName(req.Name)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		lc := c.(*loginContext)
+		if !canEditChallenge(lc.Team, chal.Author) {
+			return errorMessageHandle(c, http.StatusForbidden, ChallengeNotOwnedMessage)
+		}
+
+		message := req.Message
+		if len(message) > solvabilityMessageLimit {
+			// 失敗の理由は大抵最後の方に出るので末尾を残す
+			message = strings.ToValidUTF8(message[len(message)-solvabilityMessageLimit:], "")
+		}
+		changed, err := s.app.RecordSolvability(chal, req.IsSolvable, message, time.Now().Unix())
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		if changed {
+			if req.IsSolvable {
+				s.AdminWebhook.Post(fmt.Sprintf(SolvabilityCheckedSolveMessage, chal.Name))
+			} else {
+				s.AdminWebhook.Post(fmt.Sprintf(SolvabilityFailedSystemMessage, chal.Name) + "\n```\n" + message + "\n```")
+			}
+		}
+		return messageHandle(c, SolvabilityReportedMessage)
+	}
+}
+
+func (s *server) listSolvabilityHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		lc := c.(*loginContext)
+		checks, err := s.app.ListLatestSolvabilities()
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		chals, err := s.app.ListAllRawChallenges()
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		names := make(map[uint32]string)
+		for _, chal := range chals {
+			// authorには自分の問題の結果だけを見せる
+			if canEditChallenge(lc.Team, chal.Author) {
+				names[chal.ID] = chal.Name
+			}
+		}
+
+		entries := make([]solvabilityEntry, 0, len(checks))
+		for _, check := range checks {
+			name, ok := names[check.ChallengeId]
+			if !ok {
+				continue
+			}
+			entries = append(entries, solvabilityEntry{
+				Name:       name,
+				IsSolvable: check.IsSolvable,
+				Message:    check.Message,
+				CheckedAt:  check.CheckedAt,
+			})
+		}
+		return c.JSON(http.StatusOK, entries)
+	}
+}
@@ -10,7 +10,7 @@ author: {{ .Author }}
 {{ if gt $len 0 }}
 ### Attachments
 {{ range $idx, $a := .Attachments }}
-- [{{- $a.Name -}}]({{- $a.URL -}})
+- [{{- $a.Name -}}]({{- $a.URL -}}){{ if $a.Sha256 }} ({{ $a.Size }} bytes, sha256: `{{- $a.Sha256 -}}`){{ end }}
 {{ end }}
 {{ end }}
 
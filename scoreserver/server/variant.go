@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/theoremoon/kosenctfx/scoreserver/bucket"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/service"
+	"github.com/theoremoon/kosenctfx/scoreserver/watermark"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// チームごとに作り直す添付ファイルの大きさの上限。メモリ上で展開するので大きいものは扱わない
+	variantMaxSize = 100 << 20
+	variantTimeout = 1 * time.Minute
+)
+
+// teamToken はチームと問題ごとに決まるtoken。流出したファイルに含まれていればどのチームのものかわかる
+func teamToken(secret string, challengeID, teamID uint32) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d:%d", challengeID, teamID)
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// variantLock は一つの添付ファイルとチームの組に対するlock。使っている数が0になったら捨てる
+type variantLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lockVariant は添付ファイルとチームの組ごとにlockをとり、解放する関数を返す
+// 他のチームや添付ファイルの作成は待たせない
+func (s *server) lockVariant(attachmentID, teamID uint32) func() {
+	name := fmt.Sprintf("%d/%d", attachmentID, teamID)
+
+	s.variantMu.Lock()
+	if s.variantLocks == nil {
+		s.variantLocks = make(map[string]*variantLock)
+	}
+	l, ok := s.variantLocks[name]
+	if !ok {
+		l = &variantLock{}
+		s.variantLocks[name] = l
+	}
+	l.refs++
+	s.variantMu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		s.variantMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(s.variantLocks, name)
+		}
+		s.variantMu.Unlock()
+	}
+}
+
+// fetchAttachmentData は添付ファイルの中身を取ってくる。非公開のbucketにあれば期限付きのURLを使う
+func (s *server) fetchAttachmentData(a *model.Attachment) ([]byte, error) {
+	url, err := s.attachmentFetchURL(a)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+
+	client := &http.Client{Timeout: variantTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("failed to fetch %s: %s", a.Name, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, variantMaxSize+1))
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if len(data) > variantMaxSize {
+		return nil, xerrors.Errorf("%s is too large to render per team", a.Name)
+	}
+	return data, nil
+}
+
+// teamVariant はチーム用の添付ファイルを返す。まだなければテンプレートから作ってbucketに置く
+func (s *server) teamVariant(a *model.Attachment, team *model.Team) (*model.AttachmentVariant, error) {
+	if s.Bucket == nil {
+		return nil, service.NewErrorMessage(BucketNullMessage)
+	}
+	// 同じチームが連打しても一つしか作らないようにする
+	unlock := s.lockVariant(a.ID, team.ID)
+	defer unlock()
+
+	v, err := s.app.GetAttachmentVariant(a, team)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if v != nil {
+		return v, nil
+	}
+
+	tmpl, err := s.fetchAttachmentData(a)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	token := teamToken(s.Token, a.ChallengeId, team.ID)
+	data, err := watermark.Render(a.Name, tmpl, watermark.Vars{
+		TeamToken: token,
+		TeamID:    strconv.FormatUint(uint64(team.ID), 10),
+		TeamName:  team.Teamname,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+
+	// 公開のbucketでは他のチームのファイルをURLから推測されないようにランダムな値を挟む
+	key := fmt.Sprintf("variants/%d/%d/%s/%s", a.ID, team.ID, uuid.New().String(), path.Base(a.Name))
+	if _, err := bucket.Put(s.Bucket, key, data); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	sum := sha256.Sum256(data)
+	v = &model.AttachmentVariant{
+		AttachmentId: a.ID,
+		TeamId:       team.ID,
+		ChallengeId:  a.ChallengeId,
+		Name:         a.Name,
+		Token:        token,
+		Key:          key,
+		Sha256:       hex.EncodeToString(sum[:]),
+	}
+	if err := s.app.RecordAttachmentVariant(v); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return v, nil
+}
+
+// traceAttachmentHandler は流出したファイルのtokenかsha256から、それを配ったチームを探す
+func (s *server) traceAttachmentHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := c.QueryParam("token")
+		sum := c.QueryParam("sha256")
+		if token == "" && sum == "" {
+			return errorMessageHandle(c, http.StatusBadRequest, TraceQueryRequiredMessage)
+		}
+		entries, err := s.app.FindAttachmentVariants(token, sum)
+		if err != nil {
+			return errorHandle(c, xerrors.Errorf(": %w", err))
+		}
+		return c.JSON(http.StatusOK, entries)
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTeamToken(t *testing.T) {
+	a := teamToken("secret", 1, 2)
+	if a != teamToken("secret", 1, 2) {
+		t.Errorf("token should be deterministic")
+	}
+	if a == teamToken("secret", 1, 3) || a == teamToken("secret", 2, 2) || a == teamToken("other", 1, 2) {
+		t.Errorf("token should differ between teams, challenges and secrets")
+	}
+}
+
+func TestLockVariant(t *testing.T) {
+	s := New(nil, nil, nil, "", "")
+
+	unlock := s.lockVariant(1, 2)
+	// 別のチームや添付ファイルは待たずにlockできる
+	done := make(chan struct{})
+	go func() {
+		s.lockVariant(1, 3)()
+		s.lockVariant(2, 2)()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("other variants should not wait")
+	}
+
+	// 同じ組は解放されるまで待つ
+	locked := make(chan struct{})
+	go func() {
+		s.lockVariant(1, 2)()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("the same variant should wait for the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+
+	if len(s.variantLocks) != 0 {
+		t.Errorf("unused locks should be removed: %v", s.variantLocks)
+	}
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"golang.org/x/xerrors"
+)
+
+type AdminApp interface {
+	ListAdmins() ([]*model.Team, error)
+	RegisterAdmin(name, password, email string, role model.AdminRole) (*model.Team, error)
+	SetAdminRole(t *model.Team, role model.AdminRole) error
+}
+
+func ValidateAdminRole(role model.AdminRole) error {
+	for _, r := range model.AdminRoles {
+		if r == role {
+			return nil
+		}
+	}
+	return NewErrorMessage(adminRoleInvalidMessage)
+}
+
+// HasAdminRole はteamがrolesのいずれかを持っているかを返す。rolesが空ならadminであればよい
+// superadminは常に許可する
+func HasAdminRole(team *model.Team, roles ...model.AdminRole) bool {
+	role := team.Role()
+	if role == "" {
+		return false
+	}
+	if role == model.AdminRoleSuperAdmin || len(roles) == 0 {
+		return true
+	}
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *app) ListAdmins() ([]*model.Team, error) {
+	var teams []*model.Team
+	if err := app.db.Where("is_admin = ?", true).Find(&teams).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return teams, nil
+}
+
+// RegisterAdmin はadmin用のアカウントを作る。adminはチームとしては順位表に載らない
+func (app *app) RegisterAdmin(name, password, email string, role model.AdminRole) (*model.Team, error) {
+	if err := ValidateAdminRole(role); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	t, err := app.RegisterTeam(name, password, email, "")
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if err := app.SetAdminRole(t, role); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return t, nil
+}
+
+func (app *app) SetAdminRole(t *model.Team, role model.AdminRole) error {
+	if err := ValidateAdminRole(role); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	err := app.db.Model(t).Updates(map[string]interface{}{
+		"is_admin":   true,
+		"admin_role": role,
+	}).Error
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	t.IsAdmin = true
+	t.AdminRole = role
+	return nil
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"golang.org/x/xerrors"
+)
+
+// AttachmentVerification は添付ファイルを取り直して確かめた結果
+type AttachmentVerification struct {
+	ChallengeID uint32 `json:"challenge_id"`
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	Sha256      string `json:"sha256"`
+	Size        int64  `json:"size"`
+	VerifiedAt  int64  `json:"verified_at"`
+	Error       string `json:"error"`
+}
+
+type AttachmentApp interface {
+	ListAllRawAttachments() ([]*model.Attachment, error)
+	ListAttachmentVerifications() ([]*AttachmentVerification, error)
+	RecordAttachmentVerification(a *model.Attachment, sha256 string, size int64, verifyErr string, at int64) error
+}
+
+// CompareAttachment は取り直した中身のsha256と大きさを記録されたものと比べる
+// 一致していれば空文字列を返す
+func CompareAttachment(a *model.Attachment, sha256 string, size int64) string {
+	if a.Sha256 != "" && a.Sha256 != sha256 {
+		return fmt.Sprintf("sha256 mismatch: expected %s, got %s", a.Sha256, sha256)
+	}
+	if a.Size != 0 && a.Size != size {
+		return fmt.Sprintf("size mismatch: expected %d, got %d", a.Size, size)
+	}
+	return ""
+}
+
+func (app *app) ListAllRawAttachments() ([]*model.Attachment, error) {
+	var attachments []*model.Attachment
+	if err := app.db.Order("challenge_id asc, id asc").Find(&attachments).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return attachments, nil
+}
+
+func (app *app) ListAttachmentVerifications() ([]*AttachmentVerification, error) {
+	attachments, err := app.ListAllRawAttachments()
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	vs := make([]*AttachmentVerification, len(attachments))
+	for i, a := range attachments {
+		vs[i] = &AttachmentVerification{
+			ChallengeID: a.ChallengeId,
+			Name:        a.Name,
+			URL:         a.URL,
+			Sha256:      a.Sha256,
+			Size:        a.Size,
+			VerifiedAt:  a.VerifiedAt,
+			Error:       a.VerifyError,
+		}
+	}
+	return vs, nil
+}
+
+// RecordAttachmentVerification は取り直した結果を記録する。verifyErrが空なら一致していたとみなす
+// checksumを持っていない古い添付ファイルは取り直したものを正として埋める
+func (app *app) RecordAttachmentVerification(a *model.Attachment, sha256 string, size int64, verifyErr string, at int64) error {
+	updates := map[string]interface{}{
+		"verified_at":  at,
+		"verify_error": verifyErr,
+	}
+	if a.Sha256 == "" && verifyErr == "" {
+		updates["sha256"] = sha256
+		updates["size"] = size
+	}
+	if err := app.db.Model(a).Updates(updates).Error; err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+)
+
+func TestCompareAttachment(t *testing.T) {
+	cases := []struct {
+		name     string
+		a        model.Attachment
+		sha256   string
+		size     int64
+		mismatch bool
+	}{
+		{"match", model.Attachment{Sha256: "aa", Size: 3}, "aa", 3, false},
+		{"legacy attachment without checksum", model.Attachment{}, "aa", 3, false},
+		{"sha256 mismatch", model.Attachment{Sha256: "aa", Size: 3}, "bb", 3, true},
+		{"size mismatch", model.Attachment{Sha256: "aa", Size: 3}, "aa", 4, true},
+	}
+	for _, c := range cases {
+		if got := CompareAttachment(&c.a, c.sha256, c.size); (got != "") != c.mismatch {
+			t.Errorf("%s: unexpected result %q", c.name, got)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"golang.org/x/xerrors"
+)
+
+const (
+	DefaultAuditLogLimit = 100
+	MaxAuditLogLimit     = 1000
+)
+
+type AuditApp interface {
+	RecordAudit(actor *model.Team, ipaddress, action, target string, before, after interface{}, loggedAt int64) error
+	ListAuditLogs(filter AuditLogFilter) ([]*AuditLog, error)
+}
+
+type AuditLogFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Since  int64
+	Until  int64
+	Offset int
+	Limit  int
+}
+
+type AuditDiffEntry struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type AuditLog struct {
+	ID        uint32                    `json:"id"`
+	Actor     string                    `json:"actor"`
+	ActorID   uint32                    `json:"actor_id"`
+	IPAddress string                    `json:"ip_address"`
+	Action    string                    `json:"action"`
+	Target    string                    `json:"target"`
+	Before    json.RawMessage           `json:"before"`
+	After     json.RawMessage           `json:"after"`
+	Diff      map[string]AuditDiffEntry `json:"diff"`
+	LoggedAt  int64                     `json:"logged_at"`
+}
+
+// AuditDiff はbeforeとafterをJSONにしたときのトップレベルのフィールドを比較して、変更があったものを返す
+func AuditDiff(before, after interface{}) (map[string]AuditDiffEntry, error) {
+	b, err := toJSONObject(before)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	a, err := toJSONObject(after)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+
+	diff := make(map[string]AuditDiffEntry)
+	for k, bv := range b {
+		if av, ok := a[k]; !ok || !reflect.DeepEqual(av, bv) {
+			diff[k] = AuditDiffEntry{Before: bv, After: a[k]}
+		}
+	}
+	for k, av := range a {
+		if _, ok := b[k]; !ok {
+			diff[k] = AuditDiffEntry{Before: nil, After: av}
+		}
+	}
+	return diff, nil
+}
+
+func toJSONObject(v interface{}) (map[string]interface{}, error) {
+	obj := make(map[string]interface{})
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return obj, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return obj, nil
+}
+
+func marshalAuditValue(v interface{}) (string, error) {
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return "", nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return string(data), nil
+}
+
+func (app *app) RecordAudit(actor *model.Team, ipaddress, action, target string, before, after interface{}, loggedAt int64) error {
+	beforeStr, err := marshalAuditValue(before)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	afterStr, err := marshalAuditValue(after)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	diff, err := AuditDiff(before, after)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	diffStr, err := json.Marshal(diff)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+
+	l := model.AuditLog{
+		IPAddress: ipaddress,
+		Action:    action,
+		Target:    target,
+		Before:    beforeStr,
+		After:     afterStr,
+		Diff:      string(diffStr),
+		LoggedAt:  loggedAt,
+	}
+	if actor != nil {
+		l.ActorId = actor.ID
+		l.Actor = actor.Teamname
+	}
+	if err := app.db.Create(&l).Error; err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+func (app *app) ListAuditLogs(filter AuditLogFilter) ([]*AuditLog, error) {
+	q := app.db.Order("logged_at desc")
+	if filter.Actor != "" {
+		q = q.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		q = q.Where("action = ?", filter.Action)
+	}
+	if filter.Target != "" {
+		q = q.Where("target = ?", filter.Target)
+	}
+	if filter.Since != 0 {
+		q = q.Where("logged_at >= ?", filter.Since)
+	}
+	if filter.Until != 0 {
+		q = q.Where("logged_at < ?", filter.Until)
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultAuditLogLimit
+	}
+	if limit > MaxAuditLogLimit {
+		limit = MaxAuditLogLimit
+	}
+
+	var logs []*model.AuditLog
+	if err := q.Offset(filter.Offset).Limit(limit).Find(&logs).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+
+	res := make([]*AuditLog, len(logs))
+	for i, l := range logs {
+		diff := make(map[string]AuditDiffEntry)
+		if l.Diff != "" {
+			if err := json.Unmarshal([]byte(l.Diff), &diff); err != nil {
+				return nil, xerrors.Errorf(": %w", err)
+			}
+		}
+		res[i] = &AuditLog{
+			ID:        l.ID,
+			Actor:     l.Actor,
+			ActorID:   l.ActorId,
+			IPAddress: l.IPAddress,
+			Action:    l.Action,
+			Target:    l.Target,
+			Before:    rawJSON(l.Before),
+			After:     rawJSON(l.After),
+			Diff:      diff,
+			LoggedAt:  l.LoggedAt,
+		}
+	}
+	return res, nil
+}
+
+func rawJSON(s string) json.RawMessage {
+	if s == "" {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(s)
+}
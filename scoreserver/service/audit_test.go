@@ -0,0 +1,47 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestAuditDiff(t *testing.T) {
+	before := map[string]interface{}{
+		"name":    "miniblog",
+		"flag":    "KosenCTF{old}",
+		"tags":    []string{"web"},
+		"is_open": false,
+	}
+	after := map[string]interface{}{
+		"name":    "miniblog",
+		"flag":    "KosenCTF{new}",
+		"tags":    []string{"web"},
+		"is_open": true,
+		"port":    14000,
+	}
+
+	diff, err := AuditDiff(before, after)
+	if err != nil {
+		t.Fatalf("failed to run AuditDiff: %+v\n", err)
+	}
+	if len(diff) != 3 {
+		t.Fatalf("expected 3 changed fields, got %d: %+v\n", len(diff), diff)
+	}
+	if diff["flag"].Before != "KosenCTF{old}" || diff["flag"].After != "KosenCTF{new}" {
+		t.Errorf("unexpected flag diff: %+v\n", diff["flag"])
+	}
+	if diff["is_open"].Before != false || diff["is_open"].After != true {
+		t.Errorf("unexpected is_open diff: %+v\n", diff["is_open"])
+	}
+	if diff["port"].Before != nil || diff["port"].After != float64(14000) {
+		t.Errorf("unexpected port diff: %+v\n", diff["port"])
+	}
+
+	// 新規作成のときは全てのフィールドが差分になる
+	diff, err = AuditDiff(nil, after)
+	if err != nil {
+		t.Fatalf("failed to run AuditDiff: %+v\n", err)
+	}
+	if len(diff) != len(after) {
+		t.Errorf("expected %d changed fields, got %d\n", len(after), len(diff))
+	}
+}
@@ -14,8 +14,13 @@ import (
 )
 
 type Attachment struct {
-	Name string `json:"name"`
-	URL  string `json:"url"`
+	ID     uint32 `json:"id,omitempty"`
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Sha256 string `json:"sha256,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+
+	IsPerTeam bool `json:"is_per_team,omitempty"`
 }
 
 type SolvedBy struct {
@@ -36,12 +41,28 @@ type Challenge struct {
 	Attachments []Attachment `json:"attachments"`
 	SolvedBy    []SolvedBy   `json:"solved_by"`
 
-	Host *string `json:"host"`
-	Port *int    `json:"port"`
+	Host        *string      `json:"host"`
+	Port        *int         `json:"port"`
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+
+	// InstanceConfigはadmin向けの設定、Instanceはログインしているチームのinstance
+	InstanceConfig *InstanceConfig `json:"instance_config,omitempty"`
+	Instance       *InstanceView   `json:"instance,omitempty"`
+
+	IsOpen      bool `json:"is_open"`
+	IsRunning   bool `json:"is_running"`
+	IsMonitored bool `json:"is_monitored"`
+	IsInstanced bool `json:"is_instanced"`
+	IsSurvey    bool `json:"is_survey"`
 
-	IsOpen    bool `json:"is_open"`
-	IsRunning bool `json:"is_running"`
-	IsSurvey  bool `json:"is_survey"`
+	// FlagsはFlagの他に正解とするflag。ScoreExprが空ならCTF全体の式で点数を計算する
+	Difficulty string   `json:"difficulty,omitempty"`
+	Hints      []string `json:"hints,omitempty"`
+	Flags      []string `json:"flags,omitempty"`
+	ReleaseAt  int64    `json:"release_at,omitempty"`
+	ScoreExpr  string   `json:"score_expr,omitempty"`
+
+	SyncHash string `json:"sync_hash,omitempty"`
 }
 
 type ChallengeApp interface {
@@ -55,10 +76,12 @@ type ChallengeApp interface {
 
 	AddChallenge(c *Challenge) error
 	OpenChallenge(challengeID uint32) error
+	ReleaseChallenge(challengeID uint32) error
 	CloseChallenge(challengeID uint32) error
+	PruneChallenge(challengeID uint32) error
 	UpdateChallenge(challengeID uint32, c *Challenge) error
 
-	SubmitFlag(team *model.Team, ipaddress string, flag string, ctfRunning bool, submitted_at int64) (*model.Challenge, bool, bool, error)
+	SubmitFlag(team *model.Team, ipaddress string, flag string, target *uint32, ctfRunning bool, submitted_at int64) (*model.Challenge, bool, bool, error)
 }
 
 func (app *app) insertSubmission(s *model.Submission) error {
@@ -151,6 +174,62 @@ func (app *app) listAllAttachments() ([]*model.Attachment, error) {
 	return attachments, nil
 }
 
+func (app *app) listFlagsByChallengeIDs(ids []uint32) ([]*model.ChallengeFlag, error) {
+	var flags []*model.ChallengeFlag
+	if err := app.db.Order("challenge_id asc").Where("challenge_id IN ?", ids).Find(&flags).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return flags, nil
+}
+
+func (app *app) listHintsByChallengeIDs(ids []uint32) ([]*model.Hint, error) {
+	var hints []*model.Hint
+	if err := app.db.Order("challenge_id asc, position asc").Where("challenge_id IN ?", ids).Find(&hints).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return hints, nil
+}
+
+func (app *app) listAllChallengeFlags() ([]*model.ChallengeFlag, error) {
+	var flags []*model.ChallengeFlag
+	if err := app.db.Find(&flags).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return flags, nil
+}
+
+func (app *app) listAllHints() ([]*model.Hint, error) {
+	var hints []*model.Hint
+	if err := app.db.Order("position asc").Find(&hints).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return hints, nil
+}
+
+func challengeFlagMap(flags []*model.ChallengeFlag) map[uint32][]string {
+	m := make(map[uint32][]string)
+	for _, f := range flags {
+		m[f.ChallengeId] = append(m[f.ChallengeId], f.Flag)
+	}
+	return m
+}
+
+func hintMap(hints []*model.Hint) map[uint32][]string {
+	m := make(map[uint32][]string)
+	for _, h := range hints {
+		m[h.ChallengeId] = append(m[h.ChallengeId], h.Body)
+	}
+	return m
+}
+
+// scoreExprOf は問題の点数の計算に使う式を返す
+func scoreExprOf(c *model.Challenge, conf *model.Config) string {
+	if c.ScoreExpr != "" {
+		return c.ScoreExpr
+	}
+	return conf.ScoreExpr
+}
+
 func (app *app) rawChallengesToChallenges(cs []*model.Challenge) ([]*Challenge, error) {
 	ids := make([]uint32, len(cs))
 	for i, c := range cs {
@@ -167,6 +246,17 @@ func (app *app) rawChallengesToChallenges(cs []*model.Challenge) ([]*Challenge,
 		return nil, xerrors.Errorf(": %w", err)
 	}
 
+	flags, err := app.listFlagsByChallengeIDs(ids)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	hints, err := app.listHintsByChallengeIDs(ids)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	flagMap := challengeFlagMap(flags)
+	hintMap := hintMap(hints)
+
 	tagMap := make(map[uint32][]string)
 	for _, id := range ids {
 		tagMap[id] = make([]string, 0)
@@ -181,8 +271,13 @@ func (app *app) rawChallengesToChallenges(cs []*model.Challenge) ([]*Challenge,
 	}
 	for _, a := range attachments {
 		attachmentMap[a.ChallengeId] = append(attachmentMap[a.ChallengeId], Attachment{
-			Name: a.Name,
-			URL:  a.URL,
+			ID:     a.ID,
+			Name:   a.Name,
+			URL:    a.URL,
+			Sha256: a.Sha256,
+			Size:   a.Size,
+
+			IsPerTeam: a.IsPerTeam,
 		})
 	}
 
@@ -192,18 +287,32 @@ func (app *app) rawChallengesToChallenges(cs []*model.Challenge) ([]*Challenge,
 			ID:          c.ID,
 			Name:        c.Name,
 			Flag:        c.Flag,
+			Category:    c.Category,
 			Description: c.Description,
 			Author:      c.Author,
 			Score:       0,            //TODO
 			SolvedBy:    []SolvedBy{}, // TODO
 			IsOpen:      c.IsOpen,
-			IsRunning:   false, // TODO
+			IsRunning:   c.IsRunning,
+			IsMonitored: IsMonitored(c),
+			IsInstanced: IsInstanced(c),
 			IsSurvey:    c.IsSurvey,
 			Host:        c.Host,
 			Port:        c.Port,
+			HealthCheck: HealthCheckOf(c),
+
+			InstanceConfig: InstanceConfigOf(c),
 
 			Tags:        tagMap[c.ID],
 			Attachments: attachmentMap[c.ID],
+
+			Difficulty: c.Difficulty,
+			Hints:      hintMap[c.ID],
+			Flags:      flagMap[c.ID],
+			ReleaseAt:  c.ReleaseAt,
+			ScoreExpr:  c.ScoreExpr,
+
+			SyncHash: c.SyncHash,
 		}
 	}
 	return chals, nil
@@ -214,15 +323,27 @@ func (app *app) rawChallengeToChallenge(c *model.Challenge) (*Challenge, error)
 		ID:          c.ID,
 		Name:        c.Name,
 		Flag:        c.Flag,
+		Category:    c.Category,
 		Description: c.Description,
 		Author:      c.Author,
 		Score:       0,            //TODO
 		SolvedBy:    []SolvedBy{}, // TODO
 		IsOpen:      c.IsOpen,
-		IsRunning:   false, // TODO
+		IsRunning:   c.IsRunning,
+		IsMonitored: IsMonitored(c),
+		IsInstanced: IsInstanced(c),
 		IsSurvey:    c.IsSurvey,
 		Host:        c.Host,
 		Port:        c.Port,
+		HealthCheck: HealthCheckOf(c),
+
+		InstanceConfig: InstanceConfigOf(c),
+
+		Difficulty: c.Difficulty,
+		ReleaseAt:  c.ReleaseAt,
+		ScoreExpr:  c.ScoreExpr,
+
+		SyncHash: c.SyncHash,
 	}
 
 	tags, err := app.listTagsByChallengeIDs([]uint32{c.ID})
@@ -241,11 +362,27 @@ func (app *app) rawChallengeToChallenge(c *model.Challenge) (*Challenge, error)
 	chal.Attachments = make([]Attachment, len(attachments))
 	for i := range attachments {
 		chal.Attachments[i] = Attachment{
-			Name: attachments[i].Name,
-			URL:  attachments[i].URL,
+			ID:     attachments[i].ID,
+			Name:   attachments[i].Name,
+			URL:    attachments[i].URL,
+			Sha256: attachments[i].Sha256,
+			Size:   attachments[i].Size,
+
+			IsPerTeam: attachments[i].IsPerTeam,
 		}
 	}
 
+	flags, err := app.listFlagsByChallengeIDs([]uint32{c.ID})
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	chal.Flags = challengeFlagMap(flags)[c.ID]
+	hints, err := app.listHintsByChallengeIDs([]uint32{c.ID})
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	chal.Hints = hintMap(hints)[c.ID]
+
 	// TODO
 	return &chal, nil
 }
@@ -280,7 +417,20 @@ func (app *app) GetChallengeByName(name string) (*Challenge, error) {
 
 func (app *app) GetChallengeByFlag(flag string) (*model.Challenge, error) {
 	var c model.Challenge
-	if err := app.db.Where("flag = ?", flag).First(&c).Error; err != nil {
+	err := app.db.Where("flag = ?", flag).First(&c).Error
+	if err == nil {
+		return &c, nil
+	}
+	if !xerrors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// 別解のflag
+	var f model.ChallengeFlag
+	if err := app.db.Where("flag = ?", flag).First(&f).Error; err != nil {
+		return nil, err
+	}
+	if err := app.db.Where("id = ?", f.ChallengeId).First(&c).Error; err != nil {
 		return nil, err
 	}
 	return &c, nil
@@ -334,6 +484,18 @@ func (app *app) OpenChallenge(challengeID uint32) error {
 	return nil
 }
 
+// ReleaseChallenge はrelease_atになった問題を公開する
+// 公開した後にcloseしたら再び公開しないようにrelease_atは消す
+func (app *app) ReleaseChallenge(challengeID uint32) error {
+	err := app.db.Model(&model.Challenge{}).
+		Where("id = ? AND release_at <> 0", challengeID).
+		Updates(map[string]interface{}{"is_open": true, "release_at": 0}).Error
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
 func (app *app) CloseChallenge(challengeID uint32) error {
 	err := app.db.Model(&model.Challenge{}).
 		Where("id = ?", challengeID).
@@ -344,72 +506,170 @@ func (app *app) CloseChallenge(challengeID uint32) error {
 	return nil
 }
 
-func (app *app) addChallengeTag(t *model.Tag) error {
-	if err := app.db.Create(t).Error; err != nil {
+// PruneChallenge はtask directoryが消された問題を閉じる
+// release_atで再び公開されないようにし、同期済みでもなくす
+func (app *app) PruneChallenge(challengeID uint32) error {
+	err := app.db.Model(&model.Challenge{}).
+		Where("id = ?", challengeID).
+		Updates(map[string]interface{}{"is_open": false, "release_at": 0, "sync_hash": ""}).Error
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+func (app *app) addChallengeTag(tx *gorm.DB, t *model.Tag) error {
+	if err := tx.Create(t).Error; err != nil {
 		return err
 	}
 	return nil
 }
 
-func (app *app) deleteTagByChallengeId(challengeId uint32) error {
-	if err := app.db.Where("challenge_id = ?", challengeId).Delete(&model.Tag{}).Error; err != nil {
+func (app *app) deleteTagByChallengeId(tx *gorm.DB, challengeId uint32) error {
+	if err := tx.Where("challenge_id = ?", challengeId).Delete(&model.Tag{}).Error; err != nil {
 		return xerrors.Errorf(": %w", err)
 	}
 	return nil
 }
 
-func (app *app) addChallengeAttachment(a *model.Attachment) error {
-	if err := app.db.Create(a).Error; err != nil {
-		return xerrors.Errorf(": %w", err)
+// checkChallengeFlags はflagと別解が他の問題のflagや別解と重なっていないか確かめる
+// unique制約は同じtableの中でしか効かないので、flagと別解の間の重複はここで見る
+func (app *app) checkChallengeFlags(tx *gorm.DB, challengeID uint32, flag string, flags []string) error {
+	for _, f := range append([]string{flag}, flags...) {
+		var count int64
+		if err := tx.Model(&model.Challenge{}).Where("flag = ? AND id <> ?", f, challengeID).Count(&count).Error; err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		if count == 0 {
+			if err := tx.Model(&model.ChallengeFlag{}).Where("flag = ? AND challenge_id <> ?", f, challengeID).Count(&count).Error; err != nil {
+				return xerrors.Errorf(": %w", err)
+			}
+		}
+		if count > 0 {
+			return NewErrorMessage(fmt.Sprintf(flagDuplicatedMessage, f))
+		}
 	}
 	return nil
 }
 
-func (app *app) deleteAttachmentByChallengeId(challengeId uint32) error {
-	if err := app.db.Where("challenge_id = ?", challengeId).Delete(&model.Attachment{}).Error; err != nil {
+// setChallengeFlags は別解のflagを置き換える。同じflagをまた登録できるように消すときは物理削除する
+func (app *app) setChallengeFlags(tx *gorm.DB, challengeID uint32, flags []string) error {
+	if err := tx.Unscoped().Where("challenge_id = ?", challengeID).Delete(&model.ChallengeFlag{}).Error; err != nil {
 		return xerrors.Errorf(": %w", err)
 	}
+	for _, f := range flags {
+		err := tx.Create(&model.ChallengeFlag{
+			ChallengeId: challengeID,
+			Flag:        f,
+		}).Error
+		if isDuplicatedError(err) {
+			return NewErrorMessage(fmt.Sprintf(flagDuplicatedMessage, f))
+		}
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+	}
 	return nil
 }
 
-func (app *app) AddChallenge(c *Challenge) error {
-	chal := model.Challenge{
-		Name:        c.Name,
-		Flag:        c.Flag,
-		Category:    c.Category,
-		Description: c.Description,
-		Author:      c.Author,
-		IsOpen:      false,
-		IsSurvey:    c.IsSurvey,
-		Host:        c.Host,
-		Port:        c.Port,
+func (app *app) setChallengeHints(tx *gorm.DB, challengeID uint32, hints []string) error {
+	if err := tx.Where("challenge_id = ?", challengeID).Delete(&model.Hint{}).Error; err != nil {
+		return xerrors.Errorf(": %w", err)
 	}
-	if err := app.db.Create(&chal).Error; err != nil {
-		if isDuplicatedError(err) {
-			return NewErrorMessage(fmt.Sprintf(challengeDuplicatedMessage, c.Name))
+	for i, h := range hints {
+		err := tx.Create(&model.Hint{
+			ChallengeId: challengeID,
+			Position:    i,
+			Body:        h,
+		}).Error
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
 		}
+	}
+	return nil
+}
+
+func (app *app) addChallengeAttachment(tx *gorm.DB, a *model.Attachment) error {
+	if err := tx.Create(a).Error; err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+func (app *app) deleteAttachmentByChallengeId(tx *gorm.DB, challengeId uint32) error {
+	if err := tx.Where("challenge_id = ?", challengeId).Delete(&model.Attachment{}).Error; err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+// addChallengeDetails はtag、添付ファイル、別解とヒントを登録する
+func (app *app) addChallengeDetails(tx *gorm.DB, challengeID uint32, c *Challenge) error {
+	if err := app.setChallengeFlags(tx, challengeID, c.Flags); err != nil {
+		return err
+	}
+	if err := app.setChallengeHints(tx, challengeID, c.Hints); err != nil {
 		return err
 	}
 
 	for _, t := range c.Tags {
 		// do not care about error of this
-		_ = app.addChallengeTag(&model.Tag{
-			ChallengeId: chal.ID,
+		_ = app.addChallengeTag(tx, &model.Tag{
+			ChallengeId: challengeID,
 			Tag:         t,
 		})
 	}
 
 	for _, a := range c.Attachments {
 		// do not care about error of this
-		_ = app.addChallengeAttachment(&model.Attachment{
-			ChallengeId: chal.ID,
+		_ = app.addChallengeAttachment(tx, &model.Attachment{
+			ChallengeId: challengeID,
 			Name:        a.Name,
 			URL:         a.URL,
+			Sha256:      a.Sha256,
+			Size:        a.Size,
+			IsPerTeam:   a.IsPerTeam,
 		})
 	}
 	return nil
 }
 
+func (app *app) AddChallenge(c *Challenge) error {
+	chal := model.Challenge{
+		Name:        c.Name,
+		Flag:        c.Flag,
+		Category:    c.Category,
+		Description: c.Description,
+		Author:      c.Author,
+		IsOpen:      false,
+		IsSurvey:    c.IsSurvey,
+		Host:        c.Host,
+		Port:        c.Port,
+
+		Difficulty: c.Difficulty,
+		ReleaseAt:  c.ReleaseAt,
+		ScoreExpr:  c.ScoreExpr,
+
+		SyncHash: c.SyncHash,
+	}
+	setHealthCheck(&chal, c.HealthCheck)
+	setInstanceConfig(&chal, c.InstanceConfig)
+
+	// flagが使えなければ何も作らない
+	return app.db.Transaction(func(tx *gorm.DB) error {
+		if err := app.checkChallengeFlags(tx, 0, c.Flag, c.Flags); err != nil {
+			return err
+		}
+		if err := tx.Create(&chal).Error; err != nil {
+			if isDuplicatedError(err) {
+				return NewErrorMessage(fmt.Sprintf(challengeDuplicatedMessage, c.Name))
+			}
+			return err
+		}
+		return app.addChallengeDetails(tx, chal.ID, c)
+	})
+}
+
 func (app *app) UpdateChallenge(challengeID uint32, c *Challenge) error {
 	chal := model.Challenge{
 		Name:        c.Name,
@@ -421,42 +681,42 @@ func (app *app) UpdateChallenge(challengeID uint32, c *Challenge) error {
 		IsOpen:      c.IsOpen,
 		Host:        c.Host,
 		Port:        c.Port,
-	}
-	chal.ID = challengeID
 
-	if err := app.db.Save(&chal).Error; err != nil {
-		return err
-	}
+		Difficulty: c.Difficulty,
+		ReleaseAt:  c.ReleaseAt,
+		ScoreExpr:  c.ScoreExpr,
 
-	// TODO: remove remote tags and attachments
-	if err := app.deleteTagByChallengeId(challengeID); err != nil {
-		return err
-	}
-	if err := app.deleteAttachmentByChallengeId(challengeID); err != nil {
-		return err
+		SyncHash: c.SyncHash,
 	}
+	setHealthCheck(&chal, c.HealthCheck)
+	setInstanceConfig(&chal, c.InstanceConfig)
+	chal.ID = challengeID
 
-	for _, t := range c.Tags {
-		// do not care about error of this
-		_ = app.addChallengeTag(&model.Tag{
-			ChallengeId: chal.ID,
-			Tag:         t,
-		})
-	}
+	// 途中で失敗したときにtagや添付ファイルだけ消えた状態にならないよう、まとめて行う
+	return app.db.Transaction(func(tx *gorm.DB) error {
+		if err := app.checkChallengeFlags(tx, challengeID, c.Flag, c.Flags); err != nil {
+			return err
+		}
 
-	for _, a := range c.Attachments {
-		// do not care about error of this
-		_ = app.addChallengeAttachment(&model.Attachment{
-			ChallengeId: chal.ID,
-			Name:        a.Name,
-			URL:         a.URL,
-		})
-	}
-	return nil
+		// is_runningは死活監視が管理するので上書きしない
+		if err := tx.Omit("is_running").Save(&chal).Error; err != nil {
+			return err
+		}
+
+		// TODO: remove remote tags and attachments
+		if err := app.deleteTagByChallengeId(tx, challengeID); err != nil {
+			return err
+		}
+		if err := app.deleteAttachmentByChallengeId(tx, challengeID); err != nil {
+			return err
+		}
+		return app.addChallengeDetails(tx, challengeID, c)
+	})
 }
 
 /// 返り値は 解いたchallenge（is_correctがfalseならnil)、 is_correct, is_valid, error
-func (app *app) SubmitFlag(team *model.Team, ipaddress string, flag string, ctfRunning bool, submitted_at int64) (*model.Challenge, bool, bool, error) {
+/// targetは提出した画面の問題。わからなければnilで、正解ならその問題にする
+func (app *app) SubmitFlag(team *model.Team, ipaddress string, flag string, target *uint32, ctfRunning bool, submitted_at int64) (*model.Challenge, bool, bool, error) {
 	chal, err := app.GetChallengeByFlag(flag)
 	if err != nil && !xerrors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, false, false, xerrors.Errorf(": %w", err)
@@ -469,6 +729,8 @@ func (app *app) SubmitFlag(team *model.Team, ipaddress string, flag string, ctfR
 		Flag:        flag,
 		IPAddress:   ipaddress,
 		SubmittedAt: submitted_at,
+
+		TargetChallengeId: target,
 	}
 	if chal == nil || !chal.IsOpen {
 		// wrong
@@ -480,6 +742,9 @@ func (app *app) SubmitFlag(team *model.Team, ipaddress string, flag string, ctfR
 		// correct
 		s.ChallengeId = &chal.ID
 		s.IsCorrect = true
+		if s.TargetChallengeId == nil {
+			s.TargetChallengeId = &chal.ID
+		}
 
 		if ctfRunning {
 			// ctfRunningがtrueなときは初回の提出だけvalidになる。ここトランザクションかけておく
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"golang.org/x/xerrors"
+)
+
+// DownloadEntry はadminに見せるダウンロードの記録
+type DownloadEntry struct {
+	TeamID       uint32 `json:"team_id"`
+	TeamName     string `json:"team_name"`
+	Challenge    string `json:"challenge"`
+	Name         string `json:"name"`
+	DownloadedAt int64  `json:"downloaded_at"`
+}
+
+type DownloadApp interface {
+	GetAttachmentByID(id uint32) (*model.Attachment, error)
+	RecordDownload(team *model.Team, a *model.Attachment, at int64) error
+	ListDownloads(challengeID *uint32) ([]*DownloadEntry, error)
+}
+
+func (app *app) GetAttachmentByID(id uint32) (*model.Attachment, error) {
+	var a model.Attachment
+	if err := app.db.Where("id = ?", id).First(&a).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return &a, nil
+}
+
+func (app *app) RecordDownload(team *model.Team, a *model.Attachment, at int64) error {
+	if err := app.db.Create(&model.Download{
+		TeamId:       team.ID,
+		ChallengeId:  a.ChallengeId,
+		AttachmentId: a.ID,
+		Name:         a.Name,
+		DownloadedAt: at,
+	}).Error; err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+// ListDownloads はダウンロードの記録を新しい順に返す。challengeIDがnilなら全ての問題について返す
+func (app *app) ListDownloads(challengeID *uint32) ([]*DownloadEntry, error) {
+	q := app.db.Table("downloads").
+		Select("downloads.team_id, teams.teamname AS team_name, challenges.name AS challenge, downloads.name, downloads.downloaded_at").
+		Joins("LEFT JOIN teams ON teams.id = downloads.team_id").
+		Joins("LEFT JOIN challenges ON challenges.id = downloads.challenge_id").
+		Where("downloads.deleted_at IS NULL").
+		Order("downloads.downloaded_at desc")
+	if challengeID != nil {
+		q = q.Where("downloads.challenge_id = ?", *challengeID)
+	}
+	entries := make([]*DownloadEntry, 0)
+	if err := q.Scan(&entries).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"github.com/theoremoon/kosenctfx/scoreserver/healthcheck"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"golang.org/x/xerrors"
+)
+
+// HealthCheck は問題サーバの死活監視の設定
+type HealthCheck struct {
+	Type   string `json:"type" yaml:"type"`
+	Path   string `json:"path,omitempty" yaml:"path"`
+	Expect string `json:"expect,omitempty" yaml:"expect"`
+}
+
+type HealthApp interface {
+	RecordChallengeStatus(c *model.Challenge, isRunning bool, message string, checkedAt int64) (bool, error)
+	ListChallengeStatuses(challengeID uint32, limit int) ([]*model.ChallengeStatus, error)
+}
+
+func ValidateHealthCheck(h *HealthCheck) error {
+	if h == nil {
+		return nil
+	}
+	if err := healthcheck.ValidateType(h.Type); err != nil {
+		return NewErrorMessage(healthCheckInvalidMessage)
+	}
+	if h.Type == healthcheck.TypeBanner && h.Expect == "" {
+		return NewErrorMessage(healthCheckInvalidMessage)
+	}
+	return nil
+}
+
+// IsMonitored はchallengeが死活監視の対象かどうか
+func IsMonitored(c *model.Challenge) bool {
+	return c.Host != nil && c.Port != nil && c.HealthCheckType != healthcheck.TypeNone
+}
+
+func HealthCheckTarget(c *model.Challenge) healthcheck.Target {
+	t := healthcheck.Target{
+		Type:   c.HealthCheckType,
+		Path:   c.HealthCheckPath,
+		Expect: c.HealthCheckExpect,
+	}
+	if c.Host != nil {
+		t.Host = *c.Host
+	}
+	if c.Port != nil {
+		t.Port = *c.Port
+	}
+	return t
+}
+
+func HealthCheckOf(c *model.Challenge) *HealthCheck {
+	if c.HealthCheckType == "" && c.HealthCheckPath == "" && c.HealthCheckExpect == "" {
+		return nil
+	}
+	return &HealthCheck{
+		Type:   c.HealthCheckType,
+		Path:   c.HealthCheckPath,
+		Expect: c.HealthCheckExpect,
+	}
+}
+
+func setHealthCheck(c *model.Challenge, h *HealthCheck) {
+	if h == nil {
+		return
+	}
+	c.HealthCheckType = h.Type
+	c.HealthCheckPath = h.Path
+	c.HealthCheckExpect = h.Expect
+}
+
+// RecordChallengeStatus は死活監視の結果を保存し、状態が変わったかどうかを返す
+// 履歴は状態が変わったときと初回だけ残す
+func (app *app) RecordChallengeStatus(c *model.Challenge, isRunning bool, message string, checkedAt int64) (bool, error) {
+	var count int64
+	if err := app.db.Model(&model.ChallengeStatus{}).Where("challenge_id = ?", c.ID).Count(&count).Error; err != nil {
+		return false, xerrors.Errorf(": %w", err)
+	}
+	if count > 0 && c.IsRunning == isRunning {
+		return false, nil
+	}
+
+	if err := app.db.Create(&model.ChallengeStatus{
+		ChallengeId: c.ID,
+		IsRunning:   isRunning,
+		Message:     message,
+		CheckedAt:   checkedAt,
+	}).Error; err != nil {
+		return false, xerrors.Errorf(": %w", err)
+	}
+	// 問題の更新日時は変えない
+	if err := app.db.Model(c).UpdateColumn("is_running", isRunning).Error; err != nil {
+		return false, xerrors.Errorf(": %w", err)
+	}
+	c.IsRunning = isRunning
+	return true, nil
+}
+
+func (app *app) ListChallengeStatuses(challengeID uint32, limit int) ([]*model.ChallengeStatus, error) {
+	var statuses []*model.ChallengeStatus
+	if err := app.db.Where("challenge_id = ?", challengeID).Order("checked_at desc").Limit(limit).Find(&statuses).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,420 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/instancer"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+// InstanceConfig は問題ごとのinstanceの設定
+type InstanceConfig struct {
+	Image string `json:"image" yaml:"image"`
+	Port  int    `json:"port" yaml:"port"`
+	TTL   int64  `json:"ttl,omitempty" yaml:"ttl"` // 秒
+}
+
+// InstanceView はチームに見せるinstanceの情報
+type InstanceView struct {
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	ExpiresAt int64  `json:"expires_at"`
+	IsReady   bool   `json:"is_ready"`
+}
+
+// InstancerConfig はinstancer全体の設定
+type InstancerConfig struct {
+	Host       string // チームに見せるhost
+	PortMin    int
+	PortMax    int
+	MaxPerTeam int // 1チームが同時に起動できるinstanceの数
+	DefaultTTL time.Duration
+	// backendの起動にかかる時間の上限。これより古い起動中の予約はプロセスが落ちて残ったものとみなして消す
+	StartTimeout time.Duration
+}
+
+const defaultInstanceStartTimeout = 5 * time.Minute
+
+type InstanceApp interface {
+	InstancerEnabled() bool
+	StartInstance(team *model.Team, chal *model.Challenge) (*model.Instance, error)
+	StopInstance(team *model.Team, chal *model.Challenge) error
+	StopInstanceByID(instanceID uint32) (*model.Instance, error)
+	ExtendInstance(team *model.Team, chal *model.Challenge) (*model.Instance, error)
+	ListTeamInstances(team *model.Team) ([]*model.Instance, error)
+	ListAllInstances() ([]*model.Instance, error)
+	ReapInstances() (int, error)
+}
+
+// WithInstancer はチームごとのinstanceを起動するbackendを設定する
+func WithInstancer(backend instancer.Backend, conf InstancerConfig) Option {
+	return func(app *app) {
+		app.instancer = backend
+		app.instancerConf = conf
+	}
+}
+
+func IsInstanced(c *model.Challenge) bool {
+	return c.InstanceImage != ""
+}
+
+func InstanceConfigOf(c *model.Challenge) *InstanceConfig {
+	if !IsInstanced(c) {
+		return nil
+	}
+	return &InstanceConfig{
+		Image: c.InstanceImage,
+		Port:  c.InstancePort,
+		TTL:   c.InstanceTTL,
+	}
+}
+
+// ValidateInstanceConfig はportとttlが正しい範囲にあるかを確かめる
+// imageはbackendによって解釈が違うので、確かめるのはbackendに任せる
+func ValidateInstanceConfig(conf *InstanceConfig) error {
+	if conf == nil || conf.Image == "" {
+		return nil
+	}
+	if conf.Port < 1 || conf.Port > 65535 || conf.TTL < 0 {
+		return NewErrorMessage(instanceConfigInvalidMessage)
+	}
+	return nil
+}
+
+func setInstanceConfig(c *model.Challenge, conf *InstanceConfig) {
+	if conf == nil {
+		return
+	}
+	c.InstanceImage = conf.Image
+	c.InstancePort = conf.Port
+	c.InstanceTTL = conf.TTL
+}
+
+func NewInstanceView(i *model.Instance) *InstanceView {
+	return &InstanceView{
+		Host:      i.Host,
+		Port:      i.Port,
+		ExpiresAt: i.ExpiresAt,
+		IsReady:   i.BackendId != "",
+	}
+}
+
+func (app *app) InstancerEnabled() bool {
+	return app.instancer != nil
+}
+
+func (app *app) instanceTTL(chal *model.Challenge) time.Duration {
+	if chal.InstanceTTL > 0 {
+		return time.Duration(chal.InstanceTTL) * time.Second
+	}
+	return app.instancerConf.DefaultTTL
+}
+
+func (app *app) instanceStartTimeout() time.Duration {
+	if app.instancerConf.StartTimeout > 0 {
+		return app.instancerConf.StartTimeout
+	}
+	return defaultInstanceStartTimeout
+}
+
+func isStaleReservation(i *model.Instance, deadline int64) bool {
+	return i.BackendId == "" && i.CreatedAt < deadline
+}
+
+// expireReservations は起動に時間がかかりすぎている予約を消す。teamがnilなら全てのチームが対象
+// 予約したままプロセスが落ちると、ReapInstancesにもStopInstanceにも触れられずに上限の数に含まれ続けてしまう
+func (app *app) expireReservations(team *model.Team) (int, error) {
+	deadline := app.clock.Now().Add(-app.instanceStartTimeout()).Unix()
+	q := app.db.Unscoped().Where("backend_id = ? AND created_at < ?", "", deadline)
+	if team != nil {
+		q = q.Where("team_id = ?", team.ID)
+	}
+	res := q.Delete(&model.Instance{})
+	if res.Error != nil {
+		return 0, xerrors.Errorf(": %w", res.Error)
+	}
+	return int(res.RowsAffected), nil
+}
+
+func instanceName(team *model.Team, chal *model.Challenge) string {
+	return fmt.Sprintf("kosenctfx-%d-%d", chal.ID, team.ID)
+}
+
+// pickPort はusedに含まれないportを[min, max]からランダムに選ぶ
+func pickPort(used map[int]bool, min, max int) (int, bool) {
+	free := make([]int, 0)
+	for p := min; p <= max; p++ {
+		if !used[p] {
+			free = append(free, p)
+		}
+	}
+	if len(free) == 0 {
+		return 0, false
+	}
+	return free[rand.Intn(len(free))], true
+}
+
+// findInstance はチームの問題のinstanceを返す。なければnil
+func (app *app) findInstance(team *model.Team, chal *model.Challenge) (*model.Instance, error) {
+	var i model.Instance
+	if err := app.db.Where("team_id = ? AND challenge_id = ?", team.ID, chal.ID).First(&i).Error; err != nil {
+		if xerrors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return &i, nil
+}
+
+func (app *app) getInstance(team *model.Team, chal *model.Challenge) (*model.Instance, error) {
+	i, err := app.findInstance(team, chal)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if i == nil {
+		return nil, NewErrorMessage(instanceNotFoundMessage)
+	}
+	return i, nil
+}
+
+// reserveInstance はportを選んでinstanceの行を先に作っておく
+// backendの起動には時間がかかるので、lockはこの予約の間だけ取る
+func (app *app) reserveInstance(team *model.Team, chal *model.Challenge) (*model.Instance, bool, error) {
+	app.instanceMu.Lock()
+	defer app.instanceMu.Unlock()
+
+	if _, err := app.expireReservations(team); err != nil {
+		return nil, false, xerrors.Errorf(": %w", err)
+	}
+	if i, err := app.findInstance(team, chal); err != nil {
+		return nil, false, xerrors.Errorf(": %w", err)
+	} else if i != nil {
+		return i, false, nil
+	}
+
+	var count int64
+	if err := app.db.Model(&model.Instance{}).Where("team_id = ?", team.ID).Count(&count).Error; err != nil {
+		return nil, false, xerrors.Errorf(": %w", err)
+	}
+	if app.instancerConf.MaxPerTeam > 0 && count >= int64(app.instancerConf.MaxPerTeam) {
+		return nil, false, NewErrorMessage(fmt.Sprintf(instanceLimitMessage, app.instancerConf.MaxPerTeam))
+	}
+
+	var ports []int
+	if err := app.db.Model(&model.Instance{}).Pluck("port", &ports).Error; err != nil {
+		return nil, false, xerrors.Errorf(": %w", err)
+	}
+	used := make(map[int]bool)
+	for _, p := range ports {
+		used[p] = true
+	}
+	port, ok := pickPort(used, app.instancerConf.PortMin, app.instancerConf.PortMax)
+	if !ok {
+		return nil, false, NewErrorMessage(instancePortExhaustedMessage)
+	}
+
+	i := &model.Instance{
+		ChallengeId: chal.ID,
+		TeamId:      team.ID,
+		Host:        app.instancerConf.Host,
+		Port:        port,
+		ExpiresAt:   app.clock.Now().Add(app.instanceTTL(chal)).Unix(),
+	}
+	if err := app.db.Create(i).Error; err != nil {
+		return nil, false, xerrors.Errorf(": %w", err)
+	}
+	return i, true, nil
+}
+
+// StartInstance はチームのinstanceを起動する。既に起動していればそれを返す
+func (app *app) StartInstance(team *model.Team, chal *model.Challenge) (*model.Instance, error) {
+	if !app.InstancerEnabled() {
+		return nil, NewErrorMessage(instancerDisabledMessage)
+	}
+	if !IsInstanced(chal) {
+		return nil, NewErrorMessage(challengeNotInstancedMessage)
+	}
+
+	i, created, err := app.reserveInstance(team, chal)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if !created {
+		return i, nil
+	}
+
+	inst, err := app.instancer.Start(context.Background(), instancer.StartRequest{
+		Name:      instanceName(team, chal),
+		Challenge: chal.Name,
+		TeamID:    team.ID,
+		Image:     chal.InstanceImage,
+		Port:      chal.InstancePort,
+		HostPort:  i.Port,
+		TTL:       app.instanceTTL(chal),
+	})
+	if err != nil {
+		app.db.Unscoped().Delete(i)
+		return nil, xerrors.Errorf(": %w", err)
+	}
+
+	i.BackendId = inst.ID
+	if inst.Host != "" {
+		i.Host = inst.Host
+	}
+	if inst.Port != 0 {
+		i.Port = inst.Port
+	}
+	res := app.db.Model(i).Updates(map[string]interface{}{
+		"backend_id": i.BackendId,
+		"host":       i.Host,
+		"port":       i.Port,
+	})
+	if res.Error != nil {
+		app.instancer.Stop(context.Background(), inst.ID)
+		app.db.Unscoped().Delete(i)
+		return nil, xerrors.Errorf(": %w", res.Error)
+	}
+	// 起動に時間がかかりすぎて予約が消されていたら、起動したinstanceは誰も管理できないので止める
+	if res.RowsAffected == 0 {
+		app.instancer.Stop(context.Background(), inst.ID)
+		return nil, xerrors.Errorf("the reservation of %s expired while starting", instanceName(team, chal))
+	}
+	return i, nil
+}
+
+func (app *app) stopInstance(i *model.Instance) error {
+	if i.BackendId != "" {
+		if err := app.instancer.Stop(context.Background(), i.BackendId); err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+	}
+	if err := app.db.Unscoped().Delete(i).Error; err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+func (app *app) StopInstance(team *model.Team, chal *model.Challenge) error {
+	if !app.InstancerEnabled() {
+		return NewErrorMessage(instancerDisabledMessage)
+	}
+	i, err := app.getInstance(team, chal)
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if i.BackendId == "" {
+		deadline := app.clock.Now().Add(-app.instanceStartTimeout()).Unix()
+		if !isStaleReservation(i, deadline) {
+			return NewErrorMessage(instanceStartingMessage)
+		}
+	}
+	if err := app.stopInstance(i); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+// StopInstanceByID はadminがチームのinstanceを止める
+// 起動中の予約も消してよい。StartInstanceは行が消えていれば起動したものを止める
+func (app *app) StopInstanceByID(instanceID uint32) (*model.Instance, error) {
+	if !app.InstancerEnabled() {
+		return nil, NewErrorMessage(instancerDisabledMessage)
+	}
+	var i model.Instance
+	if err := app.db.Where("id = ?", instanceID).First(&i).Error; err != nil {
+		if xerrors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewErrorMessage(instanceNotFoundMessage)
+		}
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if err := app.stopInstance(&i); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return &i, nil
+}
+
+// ExtendInstance は期限を今からTTL後までのばす
+func (app *app) ExtendInstance(team *model.Team, chal *model.Challenge) (*model.Instance, error) {
+	if !app.InstancerEnabled() {
+		return nil, NewErrorMessage(instancerDisabledMessage)
+	}
+	i, err := app.getInstance(team, chal)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if i.BackendId == "" {
+		return nil, NewErrorMessage(instanceStartingMessage)
+	}
+	ttl := app.instanceTTL(chal)
+	if err := app.instancer.Extend(context.Background(), i.BackendId, ttl); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	i.ExpiresAt = app.clock.Now().Add(ttl).Unix()
+	if err := app.db.Model(i).Update("expires_at", i.ExpiresAt).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return i, nil
+}
+
+func (app *app) ListTeamInstances(team *model.Team) ([]*model.Instance, error) {
+	var instances []*model.Instance
+	if err := app.db.Where("team_id = ?", team.ID).Find(&instances).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return instances, nil
+}
+
+func (app *app) ListAllInstances() ([]*model.Instance, error) {
+	var instances []*model.Instance
+	if err := app.db.Order("expires_at asc").Find(&instances).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return instances, nil
+}
+
+// ReapInstances は期限が切れたinstanceと、backend上で既に止まっているinstanceと、古い予約を片付ける
+// 起動中のinstanceは触らない。片付けに失敗したinstanceは飛ばして残りを続け、最後にまとめてエラーにする
+func (app *app) ReapInstances() (int, error) {
+	if !app.InstancerEnabled() {
+		return 0, nil
+	}
+	reaped, err := app.expireReservations(nil)
+	if err != nil {
+		return 0, xerrors.Errorf(": %w", err)
+	}
+
+	var instances []*model.Instance
+	if err := app.db.Where("backend_id <> ?", "").Find(&instances).Error; err != nil {
+		return reaped, xerrors.Errorf(": %w", err)
+	}
+
+	now := app.clock.Now().Unix()
+	failures := make([]string, 0)
+	for _, i := range instances {
+		if i.ExpiresAt > now {
+			status, err := app.instancer.Status(context.Background(), i.BackendId)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", i.BackendId, err))
+				continue
+			}
+			if status == instancer.StatusRunning {
+				continue
+			}
+		}
+		if err := app.stopInstance(i); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", i.BackendId, err))
+			continue
+		}
+		reaped++
+	}
+	if len(failures) > 0 {
+		return reaped, xerrors.Errorf("failed to reap %d instances:\n%s", len(failures), strings.Join(failures, "\n"))
+	}
+	return reaped, nil
+}
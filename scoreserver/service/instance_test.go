@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+)
+
+func TestPickPort(t *testing.T) {
+	used := map[int]bool{30000: true, 30002: true}
+	for i := 0; i < 100; i++ {
+		p, ok := pickPort(used, 30000, 30002)
+		if !ok || p != 30001 {
+			t.Fatalf("pickPort = %d, %v, expected 30001\n", p, ok)
+		}
+	}
+
+	used[30001] = true
+	if _, ok := pickPort(used, 30000, 30002); ok {
+		t.Errorf("pickPort should fail when all ports are used\n")
+	}
+}
+
+func TestIsStaleReservation(t *testing.T) {
+	deadline := int64(1000)
+	cases := []struct {
+		instance model.Instance
+		stale    bool
+	}{
+		{model.Instance{Model: model.Model{CreatedAt: 999}}, true},
+		{model.Instance{Model: model.Model{CreatedAt: 1000}}, false},
+		// 起動が終わったinstanceはReapInstancesが期限で片付ける
+		{model.Instance{Model: model.Model{CreatedAt: 999}, BackendId: "abc"}, false},
+	}
+	for _, c := range cases {
+		if got := isStaleReservation(&c.instance, deadline); got != c.stale {
+			t.Errorf("isStaleReservation(%+v) = %v, expected %v\n", c.instance, got, c.stale)
+		}
+	}
+}
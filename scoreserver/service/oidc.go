@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"golang.org/x/xerrors"
+)
+
+const oidcTeamnameRetry = 5
+
+type OIDCApp interface {
+	GetTeamByOIDCSubject(subject string) (*model.Team, error)
+	LinkOIDCSubject(team *model.Team, subject string) error
+	RegisterOIDCTeam(teamname, email, subject string) (*model.Team, error)
+	IssueLoginToken(team *model.Team, otp, ipaddress string) (*model.LoginToken, error)
+}
+
+func (app *app) GetTeamByOIDCSubject(subject string) (*model.Team, error) {
+	var t model.Team
+	if err := app.db.Where("oidc_subject = ?", subject).First(&t).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (app *app) LinkOIDCSubject(team *model.Team, subject string) error {
+	if err := app.db.Model(team).Update("oidc_subject", subject).Error; err != nil {
+		if isDuplicatedError(err) {
+			return NewErrorMessage(oidcAlreadyLinkedMessage)
+		}
+		return xerrors.Errorf(": %w", err)
+	}
+	team.OIDCSubject = &subject
+	return nil
+}
+
+// RegisterOIDCTeam はproviderの情報からチームを作る。パスワードは使わないので推測できない値にしておく
+// チーム名が既に使われているときは後ろに適当な文字列を付ける
+// providerがメールアドレスを教えてくれないときは届かないアドレスで登録する
+func (app *app) RegisterOIDCTeam(teamname, email, subject string) (*model.Team, error) {
+	if email == "" {
+		email = newToken() + "@oidc.invalid"
+	}
+	name := teamname
+	var lastErr error
+	for i := 0; i < oidcTeamnameRetry; i++ {
+		t, err := app.RegisterTeam(name, newToken(), email, "")
+		if err == nil {
+			if err := app.LinkOIDCSubject(t, subject); err != nil {
+				return nil, xerrors.Errorf(": %w", err)
+			}
+			return t, nil
+		}
+		// 同じメールアドレスのチームがあっても勝手には紐付けない
+		if xerrors.Is(err, NewErrorMessage(emailDuplicatedMessage)) {
+			return nil, NewErrorMessage(oidcEmailUsedMessage)
+		}
+		if !xerrors.Is(err, NewErrorMessage(teamnameDuplicatedMessage)) {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+		lastErr = err
+		name = fmt.Sprintf("%s_%s", teamname, uuid.New().String()[:4])
+	}
+	return nil, xerrors.Errorf(": %w", lastErr)
+}
+
+// IssueLoginToken はパスワードを使わない認証の後にsessionを発行する
+// 二要素認証を有効にしているチームはLoginと同じくcodeも必要
+func (app *app) IssueLoginToken(team *model.Team, otp, ipaddress string) (*model.LoginToken, error) {
+	if team.TOTPEnabled {
+		if err := app.verifySecondFactor(team, otp); err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+	}
+	return app.newLoginToken(team, ipaddress, team.TOTPEnabled)
+}
+
+func (app *app) newLoginToken(team *model.Team, ipaddress string, twoFactorVerified bool) (*model.LoginToken, error) {
+	token := model.LoginToken{
+		TeamId:            team.ID,
+		Token:             newToken(),
+		ExpiresAt:         tokenExpiredTime().Unix(),
+		IPAddress:         ipaddress,
+		TwoFactorVerified: twoFactorVerified,
+	}
+
+	if err := app.db.Create(&token).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return &token, nil
+}
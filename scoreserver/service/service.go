@@ -3,16 +3,20 @@ package service
 import (
 	"path/filepath"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/theoremoon/kosenctfx/scoreserver/instancer"
 	"github.com/theoremoon/kosenctfx/scoreserver/mailer"
 	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/totp"
 	"golang.org/x/xerrors"
 	"gorm.io/gorm"
 )
 
 const (
+	adminRoleInvalidMessage          = "Invalid admin role"
 	challengeNotfoundMessage         = "No such challenge"
 	challengeDuplicatedMessage       = "Challenge %s exists"
 	countrycodeInvalidMessage        = "Invalid country code (Not valid as ISO 3166-1 alpha-2)"
@@ -21,20 +25,48 @@ const (
 	emailNotfoundMessage             = "Invalid email address"
 	emailRequiredMessage             = "Email is required"
 	emailTooLongMessage              = "Maximum length of email is 128"
+	flagDuplicatedMessage            = "Flag %s is used by another challenge"
+	challengeNotInstancedMessage     = "This challenge does not have per-team instances"
+	healthCheckInvalidMessage        = "Invalid health check (type must be tcp, http, banner or none, and banner requires expect)"
+	instanceConfigInvalidMessage     = "Invalid instance (port must be between 1 and 65535 and ttl must not be negative)"
+	instanceLimitMessage             = "You can run at most %d instances at the same time. Stop another instance first"
+	instanceNotFoundMessage          = "No instance is running"
+	instancePortExhaustedMessage     = "No port is available for a new instance. Please try again later"
+	instanceStartingMessage          = "The instance is starting. Please wait a moment"
+	instancerDisabledMessage         = "Per-team instances are not enabled"
+	oidcAlreadyLinkedMessage         = "This account is already linked to another team"
+	oidcEmailUsedMessage             = "This email address is already used by a team. Login to the team and link the account from the profile page"
 	passwordRequiredMessage          = "Password is required"
 	passwordResetMailBody            = "Your password reset token is: %s"
 	passwordResetMailTitle           = "Password Reset Token"
 	passwordResetTokenInvalidMessage = "Password reset token is invalid"
 	teamNotfoundMessage              = "No such team"
+	teamNotUnrankedMessage           = "Only unranked teams can be deleted"
 	teamnameDuplicatedMessage        = "This team name has already been taken"
 	teamnameRequiredMessage          = "Team name is required"
 	teamnameTooLongMessage           = "Maximum length of your team name is 128"
 	tokenInvalidMessage              = "Invalid token"
+	totpAlreadyEnabledMessage        = "Two-factor authentication is already enabled"
+	totpCodeInvalidMessage           = "Invalid two-factor authentication code"
+	totpLockedMessage                = "Too many invalid two-factor authentication codes. Please wait for minutes"
+	totpNotEnabledMessage            = "Two-factor authentication is not enabled"
+	totpNotEnrolledMessage           = "Start two-factor authentication enrollment first"
+	totpRequiredMessage              = "Two-factor authentication code is required"
 	wrongPasswordMessage             = "Wrong password"
 )
 
 type App interface {
 	TeamApp
+	AdminApp
+	AuditApp
+	TwoFactorApp
+	OIDCApp
+	HealthApp
+	SolvabilityApp
+	InstanceApp
+	AttachmentApp
+	DownloadApp
+	VariantApp
 	ChallengeApp
 	CTFApp
 	SubmissionApp
@@ -45,13 +77,32 @@ type App interface {
 type app struct {
 	db     *gorm.DB
 	mailer mailer.Mailer
+	clock  totp.Clock
+
+	instancer     instancer.Backend
+	instancerConf InstancerConfig
+	instanceMu    sync.Mutex
 }
 
-func New(db *gorm.DB, mailer mailer.Mailer) App {
-	return &app{
+type Option func(*app)
+
+// WithClock は二要素認証で使う時計を差し替える
+func WithClock(clock totp.Clock) Option {
+	return func(app *app) {
+		app.clock = clock
+	}
+}
+
+func New(db *gorm.DB, mailer mailer.Mailer, opts ...Option) App {
+	a := &app{
 		mailer: mailer,
 		db:     db,
+		clock:  totp.RealClock,
+	}
+	for _, opt := range opts {
+		opt(a)
 	}
+	return a
 }
 
 var LoginTokenLifeSpan = 7 * 24 * time.Hour // default is 1week
@@ -95,6 +146,16 @@ func (app *app) ScoreFeed(chals []*model.Challenge, teams []*model.Team, submiss
 	if err != nil {
 		return nil, nil, xerrors.Errorf(": %w", err)
 	}
+	flags, err := app.listAllChallengeFlags()
+	if err != nil {
+		return nil, nil, xerrors.Errorf(": %w", err)
+	}
+	hints, err := app.listAllHints()
+	if err != nil {
+		return nil, nil, xerrors.Errorf(": %w", err)
+	}
+	flagMap := challengeFlagMap(flags)
+	hintMap := hintMap(hints)
 
 	// make mapping as challenge id is the key
 	tagMap := make(map[uint32][]string)
@@ -111,8 +172,13 @@ func (app *app) ScoreFeed(chals []*model.Challenge, teams []*model.Team, submiss
 	}
 	for _, a := range attachments {
 		attachmentMap[a.ChallengeId] = append(attachmentMap[a.ChallengeId], Attachment{
-			Name: filepath.Base(a.URL),
-			URL:  a.URL,
+			ID:     a.ID,
+			Name:   filepath.Base(a.URL),
+			URL:    a.URL,
+			Sha256: a.Sha256,
+			Size:   a.Size,
+
+			IsPerTeam: a.IsPerTeam,
 		})
 	}
 	teamMap := make(map[uint32]string)
@@ -136,7 +202,7 @@ func (app *app) ScoreFeed(chals []*model.Challenge, teams []*model.Team, submiss
 	// make structure
 	challenges := make([]*Challenge, len(chals))
 	for i, c := range chals {
-		score, err := CalcChallengeScore(int(len(solvedByMap[c.ID])), conf.ScoreExpr)
+		score, err := CalcChallengeScore(int(len(solvedByMap[c.ID])), scoreExprOf(c, conf))
 		if err != nil {
 			return nil, nil, xerrors.Errorf(": %w", err)
 		}
@@ -152,7 +218,16 @@ func (app *app) ScoreFeed(chals []*model.Challenge, teams []*model.Team, submiss
 			Attachments: attachmentMap[c.ID],
 			SolvedBy:    solvedByMap[c.ID],
 			IsOpen:      c.IsOpen,
+			IsRunning:   c.IsRunning,
+			IsMonitored: IsMonitored(c),
+			IsInstanced: IsInstanced(c),
 			IsSurvey:    c.IsSurvey,
+
+			Difficulty: c.Difficulty,
+			Hints:      hintMap[c.ID],
+			Flags:      flagMap[c.ID],
+			ReleaseAt:  c.ReleaseAt,
+			ScoreExpr:  c.ScoreExpr,
 		}
 	}
 
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+// 問題ごとに残しておく結果の数。定期的に実行されるので古いものは消す
+const solvabilityHistoryLimit = 100
+
+type SolvabilityApp interface {
+	RecordSolvability(c *model.Challenge, isSolvable bool, message string, checkedAt int64) (bool, error)
+	ListLatestSolvabilities() ([]*model.SolvabilityCheck, error)
+}
+
+// RecordSolvability はsolverの実行結果を保存し、前回の結果から変わったかどうかを返す。初回は変わったものとして扱う
+func (app *app) RecordSolvability(c *model.Challenge, isSolvable bool, message string, checkedAt int64) (bool, error) {
+	changed := true
+	var last model.SolvabilityCheck
+	err := app.db.Where("challenge_id = ?", c.ID).Order("checked_at desc").First(&last).Error
+	if err == nil {
+		changed = last.IsSolvable != isSolvable
+	} else if !xerrors.Is(err, gorm.ErrRecordNotFound) {
+		return false, xerrors.Errorf(": %w", err)
+	}
+
+	if err := app.db.Create(&model.SolvabilityCheck{
+		ChallengeId: c.ID,
+		IsSolvable:  isSolvable,
+		Message:     message,
+		CheckedAt:   checkedAt,
+	}).Error; err != nil {
+		return false, xerrors.Errorf(": %w", err)
+	}
+	if err := app.pruneSolvabilities(c); err != nil {
+		return false, xerrors.Errorf(": %w", err)
+	}
+	return changed, nil
+}
+
+// pruneSolvabilities は問題ごとに新しいsolvabilityHistoryLimit件だけを残す
+func (app *app) pruneSolvabilities(c *model.Challenge) error {
+	var oldest []*model.SolvabilityCheck
+	if err := app.db.Where("challenge_id = ?", c.ID).Order("checked_at desc").Offset(solvabilityHistoryLimit - 1).Limit(1).Find(&oldest).Error; err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if len(oldest) == 0 {
+		return nil
+	}
+	if err := app.db.Unscoped().Where("challenge_id = ? AND checked_at < ?", c.ID, oldest[0].CheckedAt).Delete(&model.SolvabilityCheck{}).Error; err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+// ListLatestSolvabilities は問題ごとに最新の結果を返す
+func (app *app) ListLatestSolvabilities() ([]*model.SolvabilityCheck, error) {
+	latest := app.db.Model(&model.SolvabilityCheck{}).Select("challenge_id, MAX(checked_at)").Group("challenge_id")
+	var checks []*model.SolvabilityCheck
+	if err := app.db.Where("(challenge_id, checked_at) IN (?)", latest).Find(&checks).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return checks, nil
+}
@@ -14,6 +14,8 @@ type SubmissionApp interface {
 	ListTeamSubmissions(teamID uint32) ([]*model.Submission, error)
 	CountSubmissions() (int64, error)
 	CountValidSubmissions() (int64, error)
+	ListRecentSolves(limit int) ([]*model.ValidSubmission, error)
+	CountSubmissionsByTarget(since int64) ([]*TargetSubmissionCount, error)
 
 	GetWrongCount(teamID uint32, duration time.Duration) (int64, error)
 	LockSubmission(teamID uint32, duration time.Duration) error
@@ -86,6 +88,56 @@ func (app *app) CountValidSubmissions() (int64, error) {
 	return count, nil
 }
 
+// ListRecentSolves は新しい順にlimit件のvalidな提出を返す
+func (app *app) ListRecentSolves(limit int) ([]*model.ValidSubmission, error) {
+	var submissions []*model.ValidSubmission
+	if err := app.db.Order("created_at desc").Limit(limit).Find(&submissions).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return submissions, nil
+}
+
+// TargetSubmissionCount はある問題の画面から提出されたflagの数
+type TargetSubmissionCount struct {
+	ChallengeId uint32 `json:"challenge_id"`
+	Correct     int64  `json:"correct"`
+	Wrong       int64  `json:"wrong"`
+}
+
+// CountSubmissionsByTarget はsince以降の提出を、提出した画面の問題ごとに正解と不正解に分けて数える
+func (app *app) CountSubmissionsByTarget(since int64) ([]*TargetSubmissionCount, error) {
+	var rows []struct {
+		TargetChallengeId uint32
+		IsCorrect         bool
+		Count             int64
+	}
+	err := app.db.Model(&model.Submission{}).
+		Select("target_challenge_id, is_correct, count(*) as count").
+		Where("target_challenge_id IS NOT NULL AND submitted_at >= ?", since).
+		Group("target_challenge_id, is_correct").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+
+	counts := make(map[uint32]*TargetSubmissionCount)
+	result := make([]*TargetSubmissionCount, 0)
+	for _, r := range rows {
+		c, ok := counts[r.TargetChallengeId]
+		if !ok {
+			c = &TargetSubmissionCount{ChallengeId: r.TargetChallengeId}
+			counts[r.TargetChallengeId] = c
+			result = append(result, c)
+		}
+		if r.IsCorrect {
+			c.Correct += r.Count
+		} else {
+			c.Wrong += r.Count
+		}
+	}
+	return result, nil
+}
+
 func (app *app) GetWrongCount(teamID uint32, duration time.Duration) (int64, error) {
 	t := time.Now().Add(-duration).Unix()
 	var count int64
@@ -16,8 +16,10 @@ import (
 )
 
 type TeamApp interface {
-	Login(teamname, password, ipaddress string) (*model.LoginToken, error)
+	Login(teamname, password, otp, ipaddress string) (*model.LoginToken, error)
 	RegisterTeam(teamname, password, email, countryCode string) (*model.Team, error)
+	RegisterUnrankedTeam(teamname, password string) (*model.Team, error)
+	DeleteUnrankedTeam(t *model.Team) error
 	ListTeams() ([]*model.Team, error)
 	ListAllTeams() ([]*model.Team, error)
 	CountTeams() (int64, error)
@@ -25,6 +27,7 @@ type TeamApp interface {
 	MakeTeamAdmin(t *model.Team) error
 	GetTeamByID(teamID uint32) (*model.Team, error)
 	GetTeamByName(teamName string) (*model.Team, error)
+	GetTeamByEmail(email string) (*model.Team, error)
 	GetLoginTeam(token string) (*model.Team, error)
 	PasswordResetRequest(email string) error
 	PasswordReset(token, newpassword string) error
@@ -70,14 +73,76 @@ func (app *app) RegisterTeam(teamname, password, email, countryCode string) (*mo
 	return &t, nil
 }
 
+// RegisterUnrankedTeam は順位表に載らないチームを作る。メールは送らないので存在しないアドレスにしておく
+func (app *app) RegisterUnrankedTeam(teamname, password string) (*model.Team, error) {
+	t, err := app.RegisterTeam(teamname, password, teamname+"@unranked.invalid", "")
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if err := app.db.Model(t).Update("is_unranked", true).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	t.IsUnranked = true
+	return t, nil
+}
+
+// DeleteUnrankedTeam は順位表に載らないチームを提出やsessionごと消す
+// 同じ名前でまた作れるように論理削除はしない
+// チーム用の添付ファイルはbucketのgcで、どのチームのものでもなくなったものとして消える
+func (app *app) DeleteUnrankedTeam(t *model.Team) error {
+	if !t.IsUnranked {
+		return NewErrorMessage(teamNotUnrankedMessage)
+	}
+
+	// 起動中のinstanceは止めてから消す。止められなければチームも残して後でやり直せるようにする
+	if app.InstancerEnabled() {
+		instances, err := app.ListTeamInstances(t)
+		if err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		for _, i := range instances {
+			if err := app.stopInstance(i); err != nil {
+				return xerrors.Errorf(": %w", err)
+			}
+		}
+	}
+
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		for _, m := range []interface{}{
+			&model.Submission{},
+			&model.ValidSubmission{},
+			&model.SubmissionLock{},
+			&model.Download{},
+			&model.LoginToken{},
+			&model.RecoveryCode{},
+			&model.Instance{},
+			&model.AttachmentVariant{},
+		} {
+			if err := tx.Unscoped().Where("team_id = ?", t.ID).Delete(m).Error; err != nil {
+				return xerrors.Errorf(": %w", err)
+			}
+		}
+		if err := tx.Unscoped().Delete(t).Error; err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+// listTeams はadmin以外のチームを返す。allでなければ順位表に載らないチームも除く
+// is_unrankedが追加される前からあるチームはNULLになっていることがあるのでIS NOT TRUEで比べる
 func (app *app) listTeams(all bool) ([]*model.Team, error) {
-	cond := make(map[string]interface{})
+	q := app.db.Where("is_admin = ?", false)
 	if !all {
-		cond["is_admin"] = false
+		q = q.Where("is_unranked IS NOT TRUE")
 	}
 
 	var teams []*model.Team
-	if err := app.db.Where("is_admin = ?", false).Find(&teams).Error; err != nil {
+	if err := q.Find(&teams).Error; err != nil {
 		return nil, xerrors.Errorf(": %w", err)
 	}
 	return teams, nil
@@ -93,26 +158,25 @@ func (app *app) ListAllTeams() ([]*model.Team, error) {
 
 func (app *app) CountTeams() (int64, error) {
 	var count int64
-	if err := app.db.Model(&model.Team{}).Where("is_admin = ?", false).Count(&count).Error; err != nil {
+	if err := app.db.Model(&model.Team{}).Where("is_admin = ? AND is_unranked IS NOT TRUE", false).Count(&count).Error; err != nil {
 		return 0, xerrors.Errorf(": %w", err)
 	}
 	return count, nil
 }
 
+// GetAdminTeam はtokenで認証されたときに使うsuperadminのチームを返す
 func (app *app) GetAdminTeam() (*model.Team, error) {
-
 	var t model.Team
-	if err := app.db.Where("is_admin = ?", true).First(&t).Error; err != nil {
+	roles := []model.AdminRole{"", model.AdminRoleSuperAdmin}
+	// admin_roleが追加される前からあるadminはNULLになっている
+	if err := app.db.Where("is_admin = ? AND (admin_role IN ? OR admin_role IS NULL)", true, roles).Order("created_at asc").First(&t).Error; err != nil {
 		return nil, err
 	}
 	return &t, nil
 }
 
 func (app *app) MakeTeamAdmin(t *model.Team) error {
-	if err := app.db.Model(t).Update("is_admin", true).Error; err != nil {
-		return xerrors.Errorf(": %w", err)
-	}
-	return nil
+	return app.SetAdminRole(t, model.AdminRoleSuperAdmin)
 }
 
 func (app *app) GetTeamByID(teamID uint32) (*model.Team, error) {
@@ -131,6 +195,10 @@ func (app *app) GetTeamByName(teamName string) (*model.Team, error) {
 	return &t, nil
 }
 
+func (app *app) GetTeamByEmail(email string) (*model.Team, error) {
+	return app.getTeamByEmail(email)
+}
+
 func (app *app) getTeamByEmail(email string) (*model.Team, error) {
 	var t model.Team
 	if err := app.db.Where("email = ?", email).First(&t).Error; err != nil {
@@ -153,7 +221,8 @@ func (app *app) GetLoginTeam(token string) (*model.Team, error) {
 	return &t, nil
 }
 
-func (app *app) Login(teamname, password, ipaddress string) (*model.LoginToken, error) {
+// Login は二要素認証が有効なチームに対してはotpとしてTOTPのcodeかrecovery codeを要求する
+func (app *app) Login(teamname, password, otp, ipaddress string) (*model.LoginToken, error) {
 	t, err := app.GetTeamByName(teamname)
 	if err != nil && xerrors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, NewErrorMessage(teamNotfoundMessage)
@@ -163,18 +232,13 @@ func (app *app) Login(teamname, password, ipaddress string) (*model.LoginToken,
 	if !checkPassword(password, []byte(t.PasswordHash)) {
 		return nil, NewErrorMessage(wrongPasswordMessage)
 	}
-
-	token := model.LoginToken{
-		TeamId:    t.ID,
-		Token:     newToken(),
-		ExpiresAt: tokenExpiredTime().Unix(),
-		IPAddress: ipaddress,
+	if t.TOTPEnabled {
+		if err := app.verifySecondFactor(t, otp); err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
 	}
 
-	if err := app.db.Create(&token).Error; err != nil {
-		return nil, err
-	}
-	return &token, nil
+	return app.newLoginToken(t, ipaddress, t.TOTPEnabled)
 }
 
 func (app *app) PasswordResetRequest(email string) error {
@@ -0,0 +1,282 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/totp"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	RecoveryCodeCount = 10
+
+	// 二要素認証のcodeを続けてこの回数間違えると、最後の試行からtotpLockDurationの間は正しいcodeも受け付けない
+	totpMaxFailures  = 5
+	totpLockDuration = 15 * time.Minute
+)
+
+// ErrTwoFactorRequired はloginに二要素認証のcodeが必要なときに返す
+var ErrTwoFactorRequired = NewErrorMessage(totpRequiredMessage)
+
+type TwoFactorApp interface {
+	EnrollTOTP(team *model.Team) (string, string, error) // secret, otpauth URL, error
+	ConfirmTOTP(team *model.Team, code, loginToken string) ([]string, error)
+	VerifySessionTOTP(team *model.Team, code, loginToken string) error
+	DisableTOTP(team *model.Team, code string) error
+	RegenerateRecoveryCodes(team *model.Team, code string) ([]string, error)
+	ResetTOTP(team *model.Team) error
+	IsTwoFactorVerified(loginToken string) (bool, error)
+}
+
+// EnrollTOTP は新しいsecretを発行する。ConfirmTOTPで正しいcodeが送られるまでは有効にならない
+func (app *app) EnrollTOTP(team *model.Team) (string, string, error) {
+	if team.TOTPEnabled {
+		return "", "", NewErrorMessage(totpAlreadyEnabledMessage)
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", "", xerrors.Errorf(": %w", err)
+	}
+	if err := app.db.Model(team).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		return "", "", xerrors.Errorf(": %w", err)
+	}
+	team.TOTPSecret = secret
+	team.TOTPLastStep = 0
+
+	issuer := "kosenctfx"
+	if conf, err := app.GetCTFConfig(); err == nil && conf.CTFName != "" {
+		issuer = conf.CTFName
+	}
+	return secret, totp.URL(issuer, team.Teamname, secret), nil
+}
+
+// ConfirmTOTP は登録途中のsecretに対するcodeを確認して二要素認証を有効にし、recovery codeを返す
+// 確認に使ったsessionは二要素認証済みとして扱う
+func (app *app) ConfirmTOTP(team *model.Team, code, loginToken string) ([]string, error) {
+	if team.TOTPEnabled {
+		return nil, NewErrorMessage(totpAlreadyEnabledMessage)
+	}
+	if team.TOTPSecret == "" {
+		return nil, NewErrorMessage(totpNotEnrolledMessage)
+	}
+	if err := app.verifyTOTPCode(team, code); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if err := app.db.Model(team).Update("totp_enabled", true).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	team.TOTPEnabled = true
+
+	codes, err := app.replaceRecoveryCodes(team)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if err := app.markTwoFactorVerified(team, loginToken); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return codes, nil
+}
+
+// VerifySessionTOTP はログイン済みのsessionを二要素認証済みにする
+func (app *app) VerifySessionTOTP(team *model.Team, code, loginToken string) error {
+	if !team.TOTPEnabled {
+		return NewErrorMessage(totpNotEnabledMessage)
+	}
+	if err := app.verifySecondFactor(team, code); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if err := app.markTwoFactorVerified(team, loginToken); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+func (app *app) DisableTOTP(team *model.Team, code string) error {
+	if !team.TOTPEnabled {
+		return NewErrorMessage(totpNotEnabledMessage)
+	}
+	if err := app.verifySecondFactor(team, code); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return app.ResetTOTP(team)
+}
+
+func (app *app) RegenerateRecoveryCodes(team *model.Team, code string) ([]string, error) {
+	if !team.TOTPEnabled {
+		return nil, NewErrorMessage(totpNotEnabledMessage)
+	}
+	if err := app.verifySecondFactor(team, code); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	codes, err := app.replaceRecoveryCodes(team)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return codes, nil
+}
+
+// ResetTOTP は確認なしで二要素認証を解除する。端末もrecovery codeも失くしたときにadminが使う
+func (app *app) ResetTOTP(team *model.Team) error {
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(team).Updates(map[string]interface{}{
+			"totp_secret":    "",
+			"totp_enabled":   false,
+			"totp_last_step": 0,
+			"totp_failures":  0,
+		}).Error; err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		if err := tx.Where("team_id = ?", team.ID).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	team.TOTPSecret = ""
+	team.TOTPEnabled = false
+	team.TOTPLastStep = 0
+	team.TOTPFailures = 0
+	return nil
+}
+
+func (app *app) IsTwoFactorVerified(loginToken string) (bool, error) {
+	var token model.LoginToken
+	if err := app.db.Where("token = ?", loginToken).First(&token).Error; err != nil {
+		return false, xerrors.Errorf(": %w", err)
+	}
+	return token.TwoFactorVerified, nil
+}
+
+func (app *app) markTwoFactorVerified(team *model.Team, loginToken string) error {
+	if err := app.db.Model(&model.LoginToken{}).Where("token = ? AND team_id = ?", loginToken, team.ID).Update("two_factor_verified", true).Error; err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+// verifySecondFactor はTOTPのcodeかrecovery codeのどちらかを受け付ける
+// codeを総当たりされないように、続けて間違えた数を数えて一定回数を超えたらしばらく受け付けない
+func (app *app) verifySecondFactor(team *model.Team, code string) error {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrTwoFactorRequired
+	}
+	if err := app.countSecondFactorAttempt(team); err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+
+	var err error
+	if len(code) == totp.Digits {
+		err = app.verifyTOTPCode(team, code)
+	} else {
+		err = app.useRecoveryCode(team, code)
+	}
+	if err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	if err := app.db.Model(&model.Team{}).Where("id = ?", team.ID).Update("totp_failures", 0).Error; err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	team.TOTPFailures = 0
+	return nil
+}
+
+// countSecondFactorAttempt は試行を失敗として先に数えておき、成功したらverifySecondFactorが戻す
+// 並列に送られても上限を超えて試せないように、数えるまでteamの行をlockする
+func (app *app) countSecondFactorAttempt(team *model.Team) error {
+	now := app.clock.Now().Unix()
+	return app.db.Transaction(func(tx *gorm.DB) error {
+		var t model.Team
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", team.ID).First(&t).Error; err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		failures := t.TOTPFailures
+		if t.TOTPFailedAt+int64(totpLockDuration/time.Second) <= now {
+			failures = 0
+		}
+		if failures >= totpMaxFailures {
+			return NewErrorMessage(totpLockedMessage)
+		}
+		if err := tx.Model(&t).Updates(map[string]interface{}{
+			"totp_failures":  failures + 1,
+			"totp_failed_at": now,
+		}).Error; err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		team.TOTPFailures = failures + 1
+		team.TOTPFailedAt = now
+		return nil
+	})
+}
+
+func (app *app) verifyTOTPCode(team *model.Team, code string) error {
+	step, ok := totp.Validate(team.TOTPSecret, code, app.clock.Now(), team.TOTPLastStep)
+	if !ok {
+		return NewErrorMessage(totpCodeInvalidMessage)
+	}
+
+	// 同じcodeを二回使えないように、使ったstepより前のcodeは以降受け付けない
+	res := app.db.Model(&model.Team{}).Where("id = ? AND totp_last_step < ?", team.ID, step).Update("totp_last_step", step)
+	if res.Error != nil {
+		return xerrors.Errorf(": %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return NewErrorMessage(totpCodeInvalidMessage)
+	}
+	team.TOTPLastStep = step
+	return nil
+}
+
+func hashRecoveryCode(code string) string {
+	h := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(h[:])
+}
+
+func (app *app) useRecoveryCode(team *model.Team, code string) error {
+	res := app.db.Model(&model.RecoveryCode{}).
+		Where("team_id = ? AND code_hash = ? AND used_at = ?", team.ID, hashRecoveryCode(code), 0).
+		Update("used_at", app.clock.Now().Unix())
+	if res.Error != nil {
+		return xerrors.Errorf(": %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return NewErrorMessage(totpCodeInvalidMessage)
+	}
+	return nil
+}
+
+func (app *app) replaceRecoveryCodes(team *model.Team) ([]string, error) {
+	codes, err := totp.GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	err = app.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("team_id = ?", team.ID).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return xerrors.Errorf(": %w", err)
+		}
+		for _, c := range codes {
+			if err := tx.Create(&model.RecoveryCode{
+				TeamId:   team.ID,
+				CodeHash: hashRecoveryCode(c),
+			}).Error; err != nil {
+				return xerrors.Errorf(": %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return codes, nil
+}
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"github.com/theoremoon/kosenctfx/scoreserver/totp"
+	"golang.org/x/xerrors"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeTeamDB はLoginとTOTPの処理が発行するqueryだけを解釈する、teamが1つだけのdatabase
+// teams以外のtableへの変更は記録するだけで成功したことにする
+type fakeTeamDB struct {
+	mu   sync.Mutex
+	team model.Team
+}
+
+var (
+	fakeTeamDBs   = map[string]*fakeTeamDB{}
+	fakeTeamDBsMu sync.Mutex
+)
+
+func init() {
+	sql.Register("kosenctfx-fake", fakeDriver{})
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeTeamDBsMu.Lock()
+	defer fakeTeamDBsMu.Unlock()
+	db, ok := fakeTeamDBs[name]
+	if !ok {
+		return nil, xerrors.Errorf("unknown database %s", name)
+	}
+	return &fakeConn{db}, nil
+}
+
+type fakeConn struct {
+	db *fakeTeamDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, xerrors.New("prepare is not supported")
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *fakeConn) Commit() error             { return nil }
+func (c *fakeConn) Rollback() error           { return nil }
+
+var (
+	fakeUpdateRe = regexp.MustCompile("^UPDATE `(\\w+)` SET (.+?) WHERE (.+)$")
+	fakeSetRe    = regexp.MustCompile("`(\\w+)`=\\?")
+)
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	m := fakeUpdateRe.FindStringSubmatch(query)
+	if m == nil || m[1] != "teams" {
+		return fakeResult(1), nil
+	}
+	cols := fakeSetRe.FindAllStringSubmatch(m[2], -1)
+	where := args[len(cols):]
+	if len(where) == 0 || where[0].Value != int64(c.db.team.ID) {
+		return fakeResult(0), nil
+	}
+	if strings.Contains(m[3], "totp_last_step < ?") && c.db.team.TOTPLastStep >= where[1].Value.(int64) {
+		return fakeResult(0), nil
+	}
+	for i, col := range cols {
+		switch v := args[i].Value; col[1] {
+		case "totp_secret":
+			c.db.team.TOTPSecret = v.(string)
+		case "totp_enabled":
+			c.db.team.TOTPEnabled = v.(bool)
+		case "totp_last_step":
+			c.db.team.TOTPLastStep = v.(int64)
+		case "totp_failures":
+			c.db.team.TOTPFailures = int(v.(int64))
+		case "totp_failed_at":
+			c.db.team.TOTPFailedAt = v.(int64)
+		}
+	}
+	return fakeResult(1), nil
+}
+
+type fakeResult int64
+
+func (r fakeResult) LastInsertId() (int64, error) { return 0, nil }
+func (r fakeResult) RowsAffected() (int64, error) { return int64(r), nil }
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	rows := &fakeRows{columns: []string{"id", "teamname", "password_hash", "totp_secret", "totp_enabled", "totp_last_step", "totp_failures", "totp_failed_at"}}
+	if strings.Contains(query, "FROM `teams`") && len(args) > 0 && (args[0].Value == c.db.team.Teamname || args[0].Value == int64(c.db.team.ID)) {
+		t := c.db.team
+		rows.values = [][]driver.Value{{int64(t.ID), t.Teamname, t.PasswordHash, t.TOTPSecret, t.TOTPEnabled, t.TOTPLastStep, int64(t.TOTPFailures), t.TOTPFailedAt}}
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func newFakeTeamApp(t *testing.T, team model.Team, clock totp.Clock) (App, *fakeTeamDB) {
+	fdb := &fakeTeamDB{team: team}
+	name := fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano())
+	fakeTeamDBsMu.Lock()
+	fakeTeamDBs[name] = fdb
+	fakeTeamDBsMu.Unlock()
+
+	sqlDB, err := sql.Open("kosenctfx-fake", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(db, nil, WithClock(clock)), fdb
+}
+
+func TestLoginWithTOTP(t *testing.T) {
+	clock := &totp.FakeClock{T: time.Unix(1700000000, 0)}
+	app, fdb := newFakeTeamApp(t, model.Team{
+		Model:        model.Model{ID: 1},
+		Teamname:     "alice",
+		PasswordHash: hashPassword("password"),
+	}, clock)
+
+	// 登録途中は二要素認証なしでloginできる
+	token, err := app.Login("alice", "password", "", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("failed to login: %+v\n", err)
+	}
+	if token.TwoFactorVerified {
+		t.Errorf("the session should not be two-factor verified\n")
+	}
+
+	team, err := app.GetTeamByName("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, url, err := app.EnrollTOTP(team)
+	if err != nil {
+		t.Fatalf("failed to enroll: %+v\n", err)
+	}
+	if fdb.team.TOTPSecret != secret || fdb.team.TOTPEnabled {
+		t.Fatalf("the secret is not stored: %+v\n", fdb.team)
+	}
+	if !strings.HasPrefix(url, "otpauth://totp/kosenctfx:alice?") {
+		t.Errorf("unexpected otpauth URL: %s\n", url)
+	}
+	fdb.team.TOTPEnabled = true
+	team.TOTPEnabled = true
+
+	if _, err := app.Login("alice", "password", "", "127.0.0.1"); !xerrors.Is(err, ErrTwoFactorRequired) {
+		t.Errorf("expected ErrTwoFactorRequired, got %v\n", err)
+	}
+
+	code, err := totp.Code(secret, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err = app.Login("alice", "password", code, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("failed to login with the code: %+v\n", err)
+	}
+	if !token.TwoFactorVerified {
+		t.Errorf("the session should be two-factor verified\n")
+	}
+	if fdb.team.TOTPLastStep != totp.Step(clock.Now()) {
+		t.Errorf("the used step is not recorded: %d\n", fdb.team.TOTPLastStep)
+	}
+
+	// OIDCなどパスワードを使わないloginでもcodeが必要
+	if _, err := app.IssueLoginToken(team, "", "127.0.0.1"); !xerrors.Is(err, ErrTwoFactorRequired) {
+		t.Errorf("expected ErrTwoFactorRequired from IssueLoginToken, got %v\n", err)
+	}
+
+	// 同じcodeは二回使えない
+	if _, err := app.Login("alice", "password", code, "127.0.0.1"); err == nil {
+		t.Errorf("a replayed code should be rejected\n")
+	}
+
+	// 時計を進めると次のcodeが使える
+	clock.Advance(totp.Period * time.Second)
+	code, err = totp.Code(secret, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	team.TOTPLastStep = fdb.team.TOTPLastStep
+	token, err = app.IssueLoginToken(team, code, "127.0.0.1")
+	if err != nil {
+		t.Errorf("failed to issue a token with the next code: %+v\n", err)
+	} else if !token.TwoFactorVerified {
+		t.Errorf("the issued session should be two-factor verified\n")
+	}
+
+	// 古すぎるcodeは受け付けない
+	old, err := totp.Code(secret, clock.Now().Add(-5*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(totp.Period * time.Second)
+	if _, err := app.Login("alice", "password", old, "127.0.0.1"); err == nil {
+		t.Errorf("an expired code should be rejected\n")
+	}
+}
+
+func TestTOTPLockout(t *testing.T) {
+	clock := &totp.FakeClock{T: time.Unix(1700000000, 0)}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, fdb := newFakeTeamApp(t, model.Team{
+		Model:        model.Model{ID: 1},
+		Teamname:     "alice",
+		PasswordHash: hashPassword("password"),
+		TOTPSecret:   secret,
+		TOTPEnabled:  true,
+	}, clock)
+
+	code, err := totp.Code(secret, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := "000000"
+	if wrong == code {
+		wrong = "111111"
+	}
+
+	// 間違えても成功すれば数え直す
+	if _, err := app.Login("alice", "password", wrong, "127.0.0.1"); err == nil {
+		t.Fatalf("a wrong code should be rejected\n")
+	}
+	if _, err := app.Login("alice", "password", code, "127.0.0.1"); err != nil {
+		t.Fatalf("failed to login with the code: %+v\n", err)
+	}
+	if fdb.team.TOTPFailures != 0 {
+		t.Errorf("failures should be reset on success: %d\n", fdb.team.TOTPFailures)
+	}
+
+	clock.Advance(totp.Period * time.Second)
+	for i := 0; i < totpMaxFailures; i++ {
+		if _, err := app.Login("alice", "password", wrong, "127.0.0.1"); err == nil {
+			t.Fatalf("a wrong code should be rejected\n")
+		}
+	}
+
+	// 上限に達したら正しいcodeもrecovery codeも受け付けない
+	code, err = totp.Code(secret, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.Login("alice", "password", code, "127.0.0.1"); !xerrors.Is(err, NewErrorMessage(totpLockedMessage)) {
+		t.Errorf("expected the lockout, got %v\n", err)
+	}
+	team, err := app.GetTeamByName("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.IssueLoginToken(team, code, "127.0.0.1"); err == nil {
+		t.Errorf("IssueLoginToken should also be locked\n")
+	}
+
+	// 時間が経てばまた試せる
+	clock.Advance(totpLockDuration)
+	code, err = totp.Code(secret, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.Login("alice", "password", code, "127.0.0.1"); err != nil {
+		t.Errorf("failed to login after the lockout: %+v\n", err)
+	}
+}
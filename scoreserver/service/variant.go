@@ -0,0 +1,74 @@
+package service
+
+import (
+	"github.com/theoremoon/kosenctfx/scoreserver/model"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+// VariantEntry はadminに見せるチームごとの添付ファイルの記録
+type VariantEntry struct {
+	TeamID    uint32 `json:"team_id"`
+	TeamName  string `json:"team_name"`
+	Challenge string `json:"challenge"`
+	Name      string `json:"name"`
+	Token     string `json:"token"`
+	Sha256    string `json:"sha256"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type VariantApp interface {
+	// GetAttachmentVariant はチームのために作った添付ファイルを返す。まだ作っていなければnil
+	GetAttachmentVariant(a *model.Attachment, team *model.Team) (*model.AttachmentVariant, error)
+	RecordAttachmentVariant(v *model.AttachmentVariant) error
+	ListAttachmentVariantKeys() ([]string, error)
+	FindAttachmentVariants(token, sha256 string) ([]*VariantEntry, error)
+}
+
+func (app *app) GetAttachmentVariant(a *model.Attachment, team *model.Team) (*model.AttachmentVariant, error) {
+	var v model.AttachmentVariant
+	if err := app.db.Where("attachment_id = ? AND team_id = ?", a.ID, team.ID).First(&v).Error; err != nil {
+		if xerrors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return &v, nil
+}
+
+func (app *app) RecordAttachmentVariant(v *model.AttachmentVariant) error {
+	if err := app.db.Create(v).Error; err != nil {
+		return xerrors.Errorf(": %w", err)
+	}
+	return nil
+}
+
+// ListAttachmentVariantKeys はbucketに置いたチームごとの添付ファイルのkeyを返す。消してはいけないobjectを調べるのに使う
+func (app *app) ListAttachmentVariantKeys() ([]string, error) {
+	var keys []string
+	if err := app.db.Model(&model.AttachmentVariant{}).Pluck("key", &keys).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return keys, nil
+}
+
+// FindAttachmentVariants はtokenかsha256が一致する添付ファイルを誰に配ったかを返す
+func (app *app) FindAttachmentVariants(token, sha256 string) ([]*VariantEntry, error) {
+	q := app.db.Table("attachment_variants").
+		Select("attachment_variants.team_id, teams.teamname AS team_name, challenges.name AS challenge, attachment_variants.name, attachment_variants.token, attachment_variants.sha256, attachment_variants.created_at").
+		Joins("LEFT JOIN teams ON teams.id = attachment_variants.team_id").
+		Joins("LEFT JOIN challenges ON challenges.id = attachment_variants.challenge_id").
+		Where("attachment_variants.deleted_at IS NULL").
+		Order("attachment_variants.created_at desc")
+	if token != "" {
+		q = q.Where("attachment_variants.token = ?", token)
+	}
+	if sha256 != "" {
+		q = q.Where("attachment_variants.sha256 = ?", sha256)
+	}
+	entries := make([]*VariantEntry, 0)
+	if err := q.Scan(&entries).Error; err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,146 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// RFC 6238 のTOTP。Google Authenticatorなどと互換にするためSHA1、6桁、30秒で固定する
+const (
+	Digits     = 6
+	Period     = 30
+	SecretSize = 20
+
+	// 時計のずれを考慮して前後何step分のcodeを受け付けるか
+	Skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Clock は現在時刻を返す。テストでは固定の時刻を返すものに差し替える
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+var RealClock Clock = realClock{}
+
+// FakeClock はテスト用の時刻を自由に進められるClock
+type FakeClock struct {
+	T time.Time
+}
+
+func (c *FakeClock) Now() time.Time {
+	return c.T
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.T = c.T.Add(d)
+}
+
+func GenerateSecret() (string, error) {
+	b := make([]byte, SecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Step はtの時刻に対応するTOTPのカウンタ
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return key, nil
+}
+
+func codeAt(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1000000)
+}
+
+// Code はtの時刻におけるcodeを返す
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", xerrors.Errorf(": %w", err)
+	}
+	return codeAt(key, Step(t)), nil
+}
+
+// Validate はcodeがtの時刻に対して正しいかを確認し、一致したstepを返す
+// 同じcodeの使い回しを防ぐため、呼び出し側はlastStep以前のstepを拒否できるようにする
+func Validate(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+	for i := -Skew; i <= Skew; i++ {
+		step := current + int64(i)
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(codeAt(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// URL は認証アプリに読み込ませるためのotpauth URIを返す
+func URL(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(Period))
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// GenerateRecoveryCodes は認証アプリを失くしたとき用の使い捨てのcodeをn個作る
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+		c := strings.ToLower(encoding.EncodeToString(b))
+		codes[i] = c[:4] + "-" + c[4:]
+	}
+	return codes, nil
+}
@@ -0,0 +1,91 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 Appendix B のSHA1のテストベクタ（下6桁）
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, c := range cases {
+		code, err := Code(rfcSecret, time.Unix(c.unix, 0))
+		if err != nil {
+			t.Fatalf("failed to run Code: %+v\n", err)
+		}
+		if code != c.code {
+			t.Errorf("Code at %d = %s, expected %s\n", c.unix, code, c.code)
+		}
+	}
+}
+
+func TestEnrollAndLoginWithFakeClock(t *testing.T) {
+	clock := &FakeClock{T: time.Unix(1700000000, 0)}
+
+	// 登録：secretを発行して、認証アプリが表示したcodeで確認する
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %+v\n", err)
+	}
+	if !strings.Contains(URL("KosenCTF X", "admin", secret), "secret="+secret) {
+		t.Errorf("otpauth URL does not contain the secret\n")
+	}
+	code, _ := Code(secret, clock.Now())
+	lastStep, ok := Validate(secret, code, clock.Now(), 0)
+	if !ok {
+		t.Fatalf("failed to validate the enrollment code\n")
+	}
+
+	// 同じcodeの使い回しは拒否する
+	if _, ok := Validate(secret, code, clock.Now(), lastStep); ok {
+		t.Errorf("the same code is accepted twice\n")
+	}
+
+	// 1step分の時計のずれは許容する
+	clock.Advance(Period * time.Second)
+	if _, ok := Validate(secret, code, clock.Now(), 0); !ok {
+		t.Errorf("the previous step code is rejected\n")
+	}
+
+	// 次のloginでは新しいcodeが通る
+	code, _ = Code(secret, clock.Now())
+	if _, ok := Validate(secret, code, clock.Now(), lastStep); !ok {
+		t.Errorf("the next step code is rejected\n")
+	}
+
+	// 古すぎるcodeは拒否する
+	old, _ := Code(secret, clock.Now())
+	clock.Advance(5 * time.Minute)
+	if _, ok := Validate(secret, old, clock.Now(), 0); ok {
+		t.Errorf("an expired code is accepted\n")
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("failed to generate recovery codes: %+v\n", err)
+	}
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		if len(c) == Digits {
+			t.Errorf("recovery code %s is indistinguishable from a TOTP code\n", c)
+		}
+		if seen[c] {
+			t.Errorf("recovery code %s is duplicated\n", c)
+		}
+		seen[c] = true
+	}
+}
@@ -0,0 +1,156 @@
+// Package watermark はチームごとに中身を変えた添付ファイルを作る
+// アーカイブの中のテキストファイルに含まれるplaceholderをチームごとの値に置き換える
+package watermark
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	TeamTokenPlaceholder = "__TEAM_TOKEN__"
+	TeamIDPlaceholder    = "__TEAM_ID__"
+	TeamNamePlaceholder  = "__TEAM_NAME__"
+)
+
+// Vars はplaceholderに埋め込むチームごとの値
+type Vars struct {
+	TeamToken string
+	TeamID    string
+	TeamName  string
+}
+
+func (v Vars) replacer() *strings.Replacer {
+	return strings.NewReplacer(
+		TeamTokenPlaceholder, v.TeamToken,
+		TeamIDPlaceholder, v.TeamID,
+		TeamNamePlaceholder, v.TeamName,
+	)
+}
+
+// isText はNULを含まないUTF-8ならテキストとみなす。バイナリは書き換えると壊れるので触らない
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
+
+func replace(r *strings.Replacer, data []byte) []byte {
+	if !isText(data) {
+		return data
+	}
+	return []byte(r.Replace(string(data)))
+}
+
+// Render はnameの拡張子を見て、tar.gzやzipなら中のファイルを、それ以外ならファイルそのものを書き換える
+func Render(name string, data []byte, vars Vars) ([]byte, error) {
+	r := vars.replacer()
+	switch {
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		out, err := renderTarGz(data, r)
+		if err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+		return out, nil
+	case strings.HasSuffix(name, ".zip"):
+		out, err := renderZip(data, r)
+		if err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+		return out, nil
+	default:
+		return replace(r, data), nil
+	}
+}
+
+func renderTarGz(data []byte, r *strings.Replacer) ([]byte, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	tr := tar.NewReader(gr)
+
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			if err := tw.WriteHeader(hdr); err != nil {
+				return nil, xerrors.Errorf(": %w", err)
+			}
+			continue
+		}
+		body, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+		body = replace(r, body)
+		hdr.Size = int64(len(body))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+		if _, err := tw.Write(body); err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func renderZip(data []byte, r *strings.Replacer) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, f := range zr.File {
+		hdr := f.FileHeader
+		if f.FileInfo().IsDir() {
+			if _, err := zw.CreateHeader(&hdr); err != nil {
+				return nil, xerrors.Errorf(": %w", err)
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+		body, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+		body = replace(r, body)
+		w, err := zw.CreateHeader(&hdr)
+		if err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+		if _, err := w.Write(body); err != nil {
+			return nil, xerrors.Errorf(": %w", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, xerrors.Errorf(": %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,98 @@
+package watermark
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+var testVars = Vars{TeamToken: "deadbeef", TeamID: "42", TeamName: "zer0pts"}
+
+func TestRenderTarGz(t *testing.T) {
+	binary := []byte{0x7f, 'E', 'L', 'F', 0, '_', '_', 'T', 'E', 'A', 'M', '_', 'T', 'O', 'K', 'E', 'N', '_', '_'}
+
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	files := map[string][]byte{
+		"./chall/flag.txt": []byte("token: __TEAM_TOKEN__ by __TEAM_NAME__ (__TEAM_ID__)\n"),
+		"./chall/chall":    binary,
+	}
+	for _, name := range []string{"./chall/flag.txt", "./chall/chall"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg})
+		tw.Write(files[name])
+	}
+	tw.Close()
+	gw.Close()
+
+	out, err := Render("chall.tar.gz", buf.Bytes(), testVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	got := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(tr)
+		got[hdr.Name] = string(body)
+	}
+	if got["./chall/flag.txt"] != "token: deadbeef by zer0pts (42)\n" {
+		t.Errorf("text file is not rendered: %q", got["./chall/flag.txt"])
+	}
+	if got["./chall/chall"] != string(binary) {
+		t.Errorf("binary file should not be modified: %q", got["./chall/chall"])
+	}
+}
+
+func TestRenderZip(t *testing.T) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	w, _ := zw.Create("chall/README")
+	w.Write([]byte("your token is __TEAM_TOKEN__"))
+	zw.Close()
+
+	out, err := Render("chall.zip", buf.Bytes(), testVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "your token is deadbeef" {
+		t.Errorf("unexpected content: %q", body)
+	}
+}
+
+func TestRenderPlainFile(t *testing.T) {
+	out, err := Render("output.txt", []byte("__TEAM_ID__"), testVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "42" {
+		t.Errorf("unexpected content: %q", out)
+	}
+}
@@ -3,6 +3,7 @@ import NextLink from "next/link";
 import "bootstrap/dist/css/bootstrap.min.css";
 import { ToastContainer, toast } from "react-toastify";
 import { ToastContext } from "lib/useMessage";
+import TwoFactorGate from "components/twoFactorGate";
 import "react-toastify/dist/ReactToastify.css";
 
 const toastProvider = {
@@ -60,7 +61,7 @@ const AdminLayout = (page: React.ReactNode) => {
               </li>
             </ul>
           </nav>
-          {page}
+          <TwoFactorGate>{page}</TwoFactorGate>
         </div>
         <ToastContainer />
       </ToastContext.Provider>
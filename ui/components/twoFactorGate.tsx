@@ -0,0 +1,147 @@
+import React, { useState } from "react";
+import { api } from "lib/api";
+import useAccount from "lib/api/account";
+import useMessage from "lib/useMessage";
+
+type Enrollment = {
+  secret: string;
+  url: string;
+};
+
+// adminの画面は二要素認証を済ませたsessionでないと使えないので、
+// 未登録なら登録を、未確認ならcodeの入力を先に求める
+const TwoFactorGate = ({ children }: { children: React.ReactNode }) => {
+  const { data: account, mutate } = useAccount(null);
+  const { message, error } = useMessage();
+  const [enrollment, setEnrollment] = useState<Enrollment | null>(null);
+  const [recoveryCodes, setRecoveryCodes] = useState<string[] | null>(null);
+  const [code, setCode] = useState("");
+
+  if (account === undefined) {
+    return <></>;
+  }
+
+  // recovery codeを控えるまでは元の画面に戻さない
+  if (recoveryCodes !== null) {
+    return (
+      <>
+        <h5 className="mt-4">Recovery codes</h5>
+        <p>
+          Save these codes somewhere safe. Each code can be used once instead
+          of a two-factor code when you lose your authenticator.
+        </p>
+        <pre className="border p-2">{recoveryCodes.join("\n")}</pre>
+        <button
+          type="button"
+          className="btn btn-primary"
+          onClick={() => setRecoveryCodes(null)}
+        >
+          I saved the codes
+        </button>
+      </>
+    );
+  }
+
+  if (
+    !account?.is_admin ||
+    (account.totp_enabled && account.two_factor_verified)
+  ) {
+    return <>{children}</>;
+  }
+
+  const enroll = async () => {
+    try {
+      const res = await api.post<Enrollment>("/totp/enroll");
+      setEnrollment(res.data);
+    } catch (e) {
+      error(e);
+    }
+  };
+
+  const confirm = async (ev: React.FormEvent) => {
+    ev.preventDefault();
+    try {
+      const res = await api.post("/totp/confirm", { code });
+      message(res);
+      setCode("");
+      setEnrollment(null);
+      setRecoveryCodes(res.data.recovery_codes);
+      mutate();
+    } catch (e) {
+      error(e);
+    }
+  };
+
+  const verify = async (ev: React.FormEvent) => {
+    ev.preventDefault();
+    try {
+      await api.post("/totp/verify", { code });
+      setCode("");
+      mutate();
+    } catch (e) {
+      error(e);
+    }
+  };
+
+  const codeInput = (
+    <div className="mb-3">
+      <label htmlFor="totp-code" className="form-label">
+        Two-factor code
+      </label>
+      <input
+        id="totp-code"
+        className="form-control"
+        autoComplete="one-time-code"
+        value={code}
+        onChange={(e) => setCode(e.target.value)}
+      />
+    </div>
+  );
+
+  if (account.totp_enabled) {
+    return (
+      <>
+        <h5 className="mt-4">Two-factor authentication</h5>
+        <p>
+          Enter the code from your authenticator app, or one of your recovery
+          codes.
+        </p>
+        <form onSubmit={verify}>
+          {codeInput}
+          <button type="submit" className="btn btn-primary">
+            Verify
+          </button>
+        </form>
+      </>
+    );
+  }
+
+  return (
+    <>
+      <h5 className="mt-4">Two-factor authentication</h5>
+      <p>
+        Admin pages require two-factor authentication. Register this account to
+        an authenticator app to continue.
+      </p>
+      {enrollment === null ? (
+        <button type="button" className="btn btn-primary" onClick={enroll}>
+          Set up two-factor authentication
+        </button>
+      ) : (
+        <form onSubmit={confirm}>
+          <p>
+            Open <a href={enrollment.url}>this link</a> on a device with an
+            authenticator app, or enter the secret manually.
+          </p>
+          <pre className="border p-2">{enrollment.secret}</pre>
+          {codeInput}
+          <button type="submit" className="btn btn-primary">
+            Enable
+          </button>
+        </form>
+      )}
+    </>
+  );
+};
+
+export default TwoFactorGate;
@@ -7,6 +7,9 @@ export interface Account {
   team_id: number;
   country: string;
   is_admin: boolean;
+  admin_role?: string;
+  totp_enabled?: boolean;
+  two_factor_verified?: boolean;
 }
 
 const useAccount = (staticValue: Account | null) => {
@@ -0,0 +1,12 @@
+import useSWR from "swr";
+
+export interface Solvability {
+  name: string;
+  is_solvable: boolean;
+  message: string;
+  checked_at: number;
+}
+
+const useSolvability = () => useSWR<Solvability[]>("/admin/solvability");
+
+export default useSolvability;
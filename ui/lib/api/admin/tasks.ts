@@ -3,6 +3,8 @@ import useSWR from "swr";
 export interface Attachment {
   name: string;
   url: string;
+  sha256?: string;
+  size?: number;
 }
 
 export interface SolvedBy {
@@ -23,7 +25,12 @@ export interface Task {
   attachments: Attachment[];
   solved_by: SolvedBy[];
 
+  host: string | null;
+  port: number | null;
+
   is_open: boolean;
+  is_running: boolean;
+  is_monitored: boolean;
   is_survey: boolean;
 }
 
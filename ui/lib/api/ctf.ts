@@ -9,6 +9,7 @@ export interface CTF {
   is_open: boolean;
   is_running: boolean;
   is_over: boolean;
+  oidc_login?: boolean;
 }
 
 const useCTF = (fallback: CTF) => {
@@ -1,18 +1,36 @@
-import { makeSWRResponse, ssrFetcher } from "lib/api";
+import { api, makeSWRResponse, ssrFetcher } from "lib/api";
 import { isStaticMode } from "lib/static";
 import useSWR from "swr";
 
 export interface Attachment {
+  id?: number;
   name: string;
   url: string;
+  sha256?: string;
+  size?: number;
 }
 
+// 非公開のbucketのときはscoreserverを通してダウンロードするので、APIのpathが返ってくる
+export const attachmentURL = (a: Attachment): string =>
+  a.url.startsWith("/") ? `${api.defaults.baseURL}${a.url}` : a.url;
+
+// attachmentTitle はダウンロードしたファイルが壊れていないか確かめるための情報
+export const attachmentTitle = (a: Attachment): string | undefined =>
+  a.sha256 ? `${a.size} bytes\nsha256: ${a.sha256}` : undefined;
+
 export interface SolvedBy {
   solved_at: number;
   team_id: number;
   team_name: string;
 }
 
+export interface Instance {
+  host: string;
+  port: number;
+  expires_at: number;
+  is_ready: boolean;
+}
+
 export interface Task {
   id: number;
   name: string;
@@ -23,11 +41,21 @@ export interface Task {
   tags: string[];
   attachments: Attachment[];
   solved_by: SolvedBy[];
+  instance?: Instance;
+  difficulty?: string;
+  hints?: string[];
 
   is_open: boolean;
+  is_instanced: boolean;
+  is_running: boolean;
+  is_monitored: boolean;
   is_survey: boolean;
 }
 
+// 問題サーバがあるのに死活監視で応答がない
+export const isServiceDown = (task: Task) =>
+  task.is_monitored && !task.is_running;
+
 const useTasks = (staticValue: Task[]) => {
   return isStaticMode ? makeSWRResponse(staticValue) : useSWR<Task[]>("/tasks");
 };
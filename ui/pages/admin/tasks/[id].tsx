@@ -45,6 +45,9 @@ const Task = () => {
         onFlagSubmit={() => {
           // noop
         }}
+        onInstanceAction={() => {
+          // noop
+        }}
         isSolved={false}
       />
     </>
@@ -2,12 +2,14 @@ import Loading from "components/loading";
 import useMessage from "lib/useMessage";
 import React, { useCallback, useState } from "react";
 import useAdminTasks, { Task } from "../../../lib/api/admin/tasks";
+import useSolvability, { Solvability } from "lib/api/admin/solvability";
 import { useRouter } from "next/router";
 import AdminLayout from "components/adminLayout";
 import { api } from "lib/api";
 
 type taskElementProps = {
   task: Task;
+  solvability?: Solvability;
   isOpened: boolean;
   onUpdateOpened: (opened: boolean) => void;
   onClickCallback: () => void;
@@ -15,6 +17,7 @@ type taskElementProps = {
 
 const TaskElement = ({
   task,
+  solvability,
   isOpened,
   onUpdateOpened,
   onClickCallback,
@@ -38,6 +41,12 @@ const TaskElement = ({
           }}
         />
       </td>
+      <td title={task.host ? `${task.host}:${task.port}` : undefined}>
+        {task.is_monitored && (task.is_running ? "🟢" : "🔴")}
+      </td>
+      <td title={solvability?.message}>
+        {solvability && (solvability.is_solvable ? "✔️" : "⚠️")}
+      </td>
       <td>{task.is_survey && "🗒️"}</td>
       <td style={{ cursor: "pointer" }} onClick={onClickCallback}>
         👀
@@ -61,6 +70,7 @@ interface TasksMD {
 
 const Tasks = ({ tasks }: TasksProps) => {
   const { mutate } = useAdminTasks();
+  const { data: solvabilities } = useSolvability();
   const { message, error, text: textMessage } = useMessage();
 
   const openState = new Map(tasks.map((t) => [t.id, t.is_open]));
@@ -95,22 +105,6 @@ const Tasks = ({ tasks }: TasksProps) => {
     textMessage("Copied tasks.md to clipboard");
   }, [api, textMessage]);
 
-  const generateCheckPortPy = useCallback(async () => {
-    const res = await api.get<TasksMD>("/admin/check-port.py");
-
-    const link = document.createElement("a");
-    link.href =
-      "data:text/plain;charset=utf-8," + encodeURIComponent(res.data.text);
-    link.download = "check-port.py";
-    document.body.appendChild(link);
-    link.click();
-    setTimeout(() => {
-      if (link.parentNode) {
-        link.parentNode.removeChild(link);
-      }
-    }, 1000);
-  }, [api]);
-
   return (
     <>
       <h5 className="mt-4">Download Scripts</h5>
@@ -122,13 +116,6 @@ const Tasks = ({ tasks }: TasksProps) => {
         >
           tasks.md
         </button>
-        <button
-          type="button"
-          className="btn btn-primary"
-          onClick={generateCheckPortPy}
-        >
-          check-port.py
-        </button>
       </div>
 
       <h5 className="mt-4">Tasks</h5>
@@ -141,6 +128,8 @@ const Tasks = ({ tasks }: TasksProps) => {
             <th>Category</th>
             <th>Author</th>
             <th>Is Open?</th>
+            <th>Service</th>
+            <th>Solvable?</th>
             <th>Is Survey?</th>
             <th>Preview</th>
             <th>Flag</th>
@@ -151,6 +140,7 @@ const Tasks = ({ tasks }: TasksProps) => {
             <tr key={task.name}>
               <TaskElement
                 task={task}
+                solvability={solvabilities?.find((s) => s.name === task.name)}
                 isOpened={taskOpenState.get(task.id) || false}
                 onUpdateOpened={(isOpen) => {
                   setTaskOpenState((prev) =>
@@ -1,27 +1,40 @@
 import { api } from "lib/api";
 import useMessage from "lib/useMessage";
 import { useRouter } from "next/router";
+import { useEffect } from "react";
 import { SubmitHandler, useForm } from "react-hook-form";
 import useAccount from "lib/api/account";
 import LoginView from "theme/login";
-import { fetchCTF } from "lib/api/ctf";
+import useCTF, { fetchCTF } from "lib/api/ctf";
 import { AllPageProps } from "lib/pages";
 import { GetStaticProps } from "next";
 import { LoginParams } from "props/login";
 import { isStaticMode, revalidateInterval } from "lib/static";
 
-const Login = () => {
+const Login = ({ ctf: ctfProps }: AllPageProps) => {
   const router = useRouter();
+  const { data: ctf } = useCTF(ctfProps);
   const { mutate } = useAccount(null);
   const { message, error } = useMessage();
+  const oidcTwoFactor = router.query.two_factor === "oidc";
+
+  // OpenID Connectのloginに失敗するとerrorを付けてここに戻ってくる
+  useEffect(() => {
+    if (typeof router.query.error === "string") {
+      error({ data: { message: router.query.error } });
+    }
+  }, [router.query.error]);
 
   const { register, handleSubmit } = useForm<LoginParams>();
   const onSubmit: SubmitHandler<LoginParams> = async (values) => {
     try {
-      const res = await api.post("/login", {
-        teamname: values.teamname,
-        password: values.password,
-      });
+      const res = oidcTwoFactor
+        ? await api.post("/oidc/verify", { code: values.otp })
+        : await api.post("/login", {
+            teamname: values.teamname,
+            password: values.password,
+            otp: values.otp,
+          });
       message(res);
 
       mutate();
@@ -32,7 +45,12 @@ const Login = () => {
     }
   };
 
-  return LoginView({ register, onSubmit: handleSubmit(onSubmit) });
+  return LoginView({
+    register,
+    onSubmit: handleSubmit(onSubmit),
+    oidcLoginURL: ctf?.oidc_login ? "/api/oidc/login" : undefined,
+    oidcTwoFactor,
+  });
 };
 
 export const getStaticProps: GetStaticProps<AllPageProps> = async () => {
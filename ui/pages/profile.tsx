@@ -1,4 +1,5 @@
 import { GetServerSideProps, GetStaticProps } from "next";
+import { useRouter } from "next/router";
 import { ProfileUpdateParams } from "props/profile";
 import { useEffect, useState } from "react";
 import { SubmitHandler, useForm } from "react-hook-form";
@@ -7,11 +8,13 @@ import { api } from "../lib/api";
 import useAccount from "../lib/api/account";
 import useMessage from "../lib/useMessage";
 import ProfileView from "theme/profile";
-import { fetchCTF } from "lib/api/ctf";
+import useCTF, { fetchCTF } from "lib/api/ctf";
 import { AllPageProps } from "lib/pages";
 import { isStaticMode, revalidateInterval } from "lib/static";
 
-const Profile = () => {
+const Profile = ({ ctf: ctfProps }: AllPageProps) => {
+  const router = useRouter();
+  const { data: ctf } = useCTF(ctfProps);
   const { message, error } = useMessage();
   const { data: account, mutate } = useAccount(null);
   const [country, setCountry] = useState(account?.country || "");
@@ -36,6 +39,13 @@ const Profile = () => {
     }
   };
 
+  // OpenID Connectのアカウントの紐付けに失敗するとerrorを付けてここに戻ってくる
+  useEffect(() => {
+    if (typeof router.query.error === "string") {
+      error({ data: { message: router.query.error } });
+    }
+  }, [router.query.error]);
+
   useEffect(() => {
     reset({
       teamname: account?.teamname || "",
@@ -52,6 +62,7 @@ const Profile = () => {
     onSubmit: handleSubmit(onSubmit),
     country,
     setCountry,
+    oidcLinkURL: ctf?.oidc_login ? "/api/oidc/login" : undefined,
   });
 };
 
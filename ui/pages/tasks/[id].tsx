@@ -15,7 +15,7 @@ import { useCallback } from "react";
 
 import TasksView from "theme/tasks";
 import TaskModalView from "theme/taskmodal";
-import { FlagSubmitParams } from "props/taskmodal";
+import { FlagSubmitParams, InstanceAction } from "props/taskmodal";
 
 type taskProps = {
   taskID: number;
@@ -37,6 +37,19 @@ const TasksDefault = ({ taskID, tasks: defaultTasks }: taskProps) => {
     try {
       const res = await api.post("/submit", {
         flag: values.flag,
+        challenge_id: taskID,
+      });
+      message(res);
+      mutate();
+    } catch (e) {
+      error(e);
+    }
+  };
+
+  const onInstanceAction = async (name: string, action: InstanceAction) => {
+    try {
+      const res = await api.post(`/instance/${action}`, {
+        name: name,
       });
       message(res);
       mutate();
@@ -62,6 +75,7 @@ const TasksDefault = ({ taskID, tasks: defaultTasks }: taskProps) => {
             shallow: true,
           })
         }
+        onInstanceAction={(action) => onInstanceAction(task.name, action)}
         registerFlag={register}
         onFlagSubmit={handleSubmit(onSubmit)}
         isSolved={isSolvedByTeam(task)}
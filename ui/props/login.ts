@@ -4,9 +4,14 @@ import { UseFormRegister } from "react-hook-form";
 export type LoginParams = {
   teamname: string;
   password: string;
+  otp: string;
 };
 
 export interface LoginProps {
   register: UseFormRegister<LoginParams>;
   onSubmit: FormEventHandler<HTMLFormElement>;
+  // OpenID Connectでloginできるときのlogin先
+  oidcLoginURL?: string;
+  // OpenID Connectでloginした後の二要素認証のcodeだけを入力させる
+  oidcTwoFactor: boolean;
 }
@@ -11,4 +11,6 @@ export interface ProfileProps {
   onSubmit: FormEventHandler<HTMLFormElement>;
   country: string;
   setCountry: (country: string) => void;
+  // OpenID Connectのアカウントを紐付けるときのlogin先
+  oidcLinkURL?: string;
 }
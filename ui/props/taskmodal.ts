@@ -7,9 +7,12 @@ export type FlagSubmitParams = {
   flag: string;
 };
 
+export type InstanceAction = "start" | "stop" | "extend";
+
 export interface TaskModalProps {
   task: Task;
   onClose: () => void;
+  onInstanceAction: (action: InstanceAction) => void;
   registerFlag: UseFormRegister<FlagSubmitParams>;
   onFlagSubmit: FormEventHandler<HTMLFormElement>;
   isSolved: boolean;
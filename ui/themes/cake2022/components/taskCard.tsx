@@ -1,4 +1,4 @@
-import { Task } from "lib/api/tasks";
+import { Task, isServiceDown } from "lib/api/tasks";
 import { useRouter } from "next/router";
 import React from "react";
 import styles from "./taskCard.module.scss";
@@ -27,6 +27,9 @@ const TaskCard = ({ task, isSolved }: TaskCardProps) => {
       <div className={styles["task-cover"]}>
         <div className={styles["task-upper"]}>
           <div className={styles["task-name"]}>{task.name}</div>
+          {isServiceDown(task) && (
+            <div className={styles["task-down"]}>service down</div>
+          )}
         </div>
         <div className={styles["task-middle"]}>
           <div className={styles["task-score"]}>{task.score}</div>
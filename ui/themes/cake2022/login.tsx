@@ -5,7 +5,33 @@ import Button from "./components/button";
 import Link from "next/link";
 import Right from "./components/right";
 
-const Login = ({ register, onSubmit }: LoginProps) => {
+const Login = ({
+  register,
+  onSubmit,
+  oidcLoginURL,
+  oidcTwoFactor,
+}: LoginProps) => {
+  if (oidcTwoFactor) {
+    return (
+      <form onSubmit={onSubmit} className="input-form">
+        <div className="form-item">
+          <label htmlFor="otp">two-factor code</label>
+          <Input
+            className="form-input"
+            id="otp"
+            autoComplete="one-time-code"
+            {...register("otp", { required: true })}
+          />
+        </div>
+        <div className="form-item">
+          <Right>
+            <Button type="submit">Login</Button>
+          </Right>
+        </div>
+      </form>
+    );
+  }
+
   return (
     <form onSubmit={onSubmit} className="input-form">
       <div className="form-item">
@@ -28,11 +54,27 @@ const Login = ({ register, onSubmit }: LoginProps) => {
           {...register("password", { required: true })}
         />
       </div>
+      <div className="form-item">
+        <label htmlFor="otp">two-factor code (only if enabled)</label>
+        <Input
+          className="form-input"
+          id="otp"
+          autoComplete="one-time-code"
+          {...register("otp")}
+        />
+      </div>
       <div className="form-item">
         <Right>
           <Button type="submit">Login</Button>
         </Right>
       </div>
+      {oidcLoginURL && (
+        <p>
+          <Right>
+            <a href={oidcLoginURL}>Login with OpenID Connect</a>
+          </Right>
+        </p>
+      )}
       <p>
         <Right>
           <Link href="/passwordreset_request">
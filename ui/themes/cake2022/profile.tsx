@@ -4,7 +4,13 @@ import Input from "./components/input";
 import Button from "./components/button";
 import Right from "./components/right";
 
-const Profile = ({ register, onSubmit, country, setCountry }: ProfileProps) => {
+const Profile = ({
+  register,
+  onSubmit,
+  country,
+  setCountry,
+  oidcLinkURL,
+}: ProfileProps) => {
   return (
     <form onSubmit={onSubmit} className="input-form">
       <div className="form-item">
@@ -41,6 +47,13 @@ const Profile = ({ register, onSubmit, country, setCountry }: ProfileProps) => {
           <Button type="submit">Update</Button>
         </Right>
       </div>
+      {oidcLinkURL && (
+        <p>
+          <Right>
+            <a href={oidcLinkURL}>Link an OpenID Connect account</a>
+          </Right>
+        </p>
+      )}
     </form>
   );
 };
@@ -4,12 +4,15 @@ import Button from "./components/button";
 import styles from "./taskmodal.module.scss";
 import Link from "next/link";
 import { orderBy } from "lodash";
+import { dateFormat } from "lib/date";
+import { attachmentTitle, attachmentURL } from "lib/api/tasks";
 
 const TaskModal = ({
   task,
   registerFlag,
   onFlagSubmit,
   onClose,
+  onInstanceAction,
   isSolved,
 }: TaskModalProps) => {
   const solvedBy = orderBy(task.solved_by, ["solved_at"], ["asc"]);
@@ -23,6 +26,9 @@ const TaskModal = ({
               {task.name} - {task.score}
             </div>
             <div className={styles["dialog-tags"]}>
+              {task.difficulty && (
+                <div className={styles["dialog-tag"]}>{task.difficulty}</div>
+              )}
               {task.tags.map((tag) => (
                 <div className={styles["dialog-tag"]} key={tag}>
                   {tag}
@@ -33,14 +39,52 @@ const TaskModal = ({
               className={styles["dialog-description"]}
               dangerouslySetInnerHTML={{ __html: task.description }}
             ></div>
+            {task.hints?.map((hint, i) => (
+              <details className={styles["dialog-hint"]} key={i}>
+                <summary>hint {i + 1}</summary>
+                <p>{hint}</p>
+              </details>
+            ))}
             <div className={styles["dialog-attachments"]}>
               {task.attachments &&
                 task.attachments.map((a) => (
-                  <a key={a.name} href={a.url} download>
+                  <a
+                    key={a.name}
+                    href={attachmentURL(a)}
+                    download
+                    title={attachmentTitle(a)}
+                  >
                     {a.name}
                   </a>
                 ))}
             </div>
+            {task.is_instanced && (
+              <div className={styles["dialog-instance"]}>
+                {task.instance ? (
+                  <>
+                    <span>
+                      {task.instance.is_ready
+                        ? `${task.instance.host}:${task.instance.port}`
+                        : "starting..."}{" "}
+                      (until {dateFormat(task.instance.expires_at)})
+                    </span>
+                    <Button
+                      type="button"
+                      onClick={() => onInstanceAction("extend")}
+                    >
+                      Extend
+                    </Button>
+                    <Button type="button" onClick={() => onInstanceAction("stop")}>
+                      Stop
+                    </Button>
+                  </>
+                ) : (
+                  <Button type="button" onClick={() => onInstanceAction("start")}>
+                    Start your instance
+                  </Button>
+                )}
+              </div>
+            )}
             <div className={styles["dialog-author"]}>author: {task.author}</div>
             <form onSubmit={onFlagSubmit}>
               <Input
@@ -1,7 +1,15 @@
-import { ChakraProps, Icon, Link, Box, Flex, Heading } from "@chakra-ui/react";
+import {
+  Badge,
+  ChakraProps,
+  Icon,
+  Link,
+  Box,
+  Flex,
+  Heading,
+} from "@chakra-ui/react";
 import { faCheck } from "@fortawesome/free-solid-svg-icons";
 import { FontAwesomeIcon } from "@fortawesome/react-fontawesome";
-import { Task } from "lib/api/tasks";
+import { Task, isServiceDown } from "lib/api/tasks";
 import { useRouter } from "next/router";
 import React from "react";
 import Tags from "./tags";
@@ -48,6 +56,7 @@ const TaskCard = ({ task, isSolved }: TaskCardProps) => {
           )}{" "}
           {task.name}
         </Heading>
+        {isServiceDown(task) && <Badge colorScheme="red">service down</Badge>}
         <Flex justify="space-around" m={0}>
           <Box color="#000">
             <Box fontSize="2xl" sx={{ display: "inline" }}>
@@ -11,7 +11,36 @@ import { Link } from "@chakra-ui/next-js";
 import Right from "./components/right";
 import { LoginProps } from "props/login";
 
-const Login = ({ register, onSubmit }: LoginProps) => {
+const Login = ({
+  register,
+  onSubmit,
+  oidcLoginURL,
+  oidcTwoFactor,
+}: LoginProps) => {
+  if (oidcTwoFactor) {
+    return (
+      <Box w="sm" mx="auto" mt="10">
+        <form onSubmit={onSubmit}>
+          <VStack>
+            <FormControl>
+              <FormLabel htmlFor="otp">two-factor code</FormLabel>
+              <Input
+                id="otp"
+                autoComplete="one-time-code"
+                {...register("otp", { required: true })}
+              ></Input>
+            </FormControl>
+            <FormControl>
+              <Right>
+                <Button type="submit">Login</Button>
+              </Right>
+            </FormControl>
+          </VStack>
+        </form>
+      </Box>
+    );
+  }
+
   return (
     <Box w="sm" mx="auto" mt="10">
       <form onSubmit={onSubmit}>
@@ -32,11 +61,30 @@ const Login = ({ register, onSubmit }: LoginProps) => {
               {...register("password", { required: true })}
             ></Input>
           </FormControl>
+          <FormControl>
+            <FormLabel htmlFor="otp">
+              two-factor code (only if enabled)
+            </FormLabel>
+            <Input
+              id="otp"
+              autoComplete="one-time-code"
+              {...register("otp")}
+            ></Input>
+          </FormControl>
           <FormControl>
             <Right>
               <Button type="submit">Login</Button>
             </Right>
           </FormControl>
+          {oidcLoginURL && (
+            <FormControl>
+              <Right>
+                <Button as="a" href={oidcLoginURL}>
+                  Login with OpenID Connect
+                </Button>
+              </Right>
+            </FormControl>
+          )}
           <Text>
             <Link href="/passwordreset_request">
               Forgot your password? You can reset your password here.
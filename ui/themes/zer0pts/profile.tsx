@@ -10,7 +10,13 @@ import { ProfileProps } from "props/profile";
 import Right from "./components/right";
 import CountrySelector from "./components/countrySelector";
 
-const Profile = ({ register, onSubmit, country, setCountry }: ProfileProps) => {
+const Profile = ({
+  register,
+  onSubmit,
+  country,
+  setCountry,
+  oidcLinkURL,
+}: ProfileProps) => {
   return (
     <Box w="sm" mx="auto" mt="10">
       <form onSubmit={onSubmit}>
@@ -47,6 +53,15 @@ const Profile = ({ register, onSubmit, country, setCountry }: ProfileProps) => {
               <Button type="submit">Update</Button>
             </Right>
           </FormControl>
+          {oidcLinkURL && (
+            <FormControl>
+              <Right>
+                <Button as="a" href={oidcLinkURL}>
+                  Link an OpenID Connect account
+                </Button>
+              </Right>
+            </FormControl>
+          )}
         </VStack>
       </form>
     </Box>
@@ -20,6 +20,8 @@ import {
 import { faDownload } from "@fortawesome/free-solid-svg-icons";
 import { FontAwesomeIcon } from "@fortawesome/react-fontawesome";
 import NextLink from "next/link";
+import { dateFormat } from "lib/date";
+import { attachmentTitle, attachmentURL } from "lib/api/tasks";
 
 import Tags from "./components/tags";
 import Right from "./components/right";
@@ -29,6 +31,7 @@ const TaskModal = ({
   registerFlag,
   onFlagSubmit,
   onClose,
+  onInstanceAction,
 }: TaskModalProps) => {
   const { onClose: closeModal } = useDisclosure();
   return (
@@ -54,9 +57,9 @@ const TaskModal = ({
             <Flex>
               <Stack w="70%" pl={1} spacing={1}>
                 <Tags
-                  tags={
-                    task.category ? [task.category, ...task.tags] : task.tags
-                  }
+                  tags={[task.category, task.difficulty, ...task.tags].filter(
+                    (t): t is string => !!t
+                  )}
                 />
                 <HStack>
                   <Box color="#000">
@@ -83,9 +86,20 @@ const TaskModal = ({
                   }}
                   dangerouslySetInnerHTML={{ __html: task.description }}
                 />
+                {task.hints?.map((hint, i) => (
+                  <details key={i}>
+                    <summary>hint {i + 1}</summary>
+                    <Text pl={2}>{hint}</Text>
+                  </details>
+                ))}
                 <HStack minH="4em">
                   {task.attachments.map((a) => (
-                    <a href={a.url} download key={a.url}>
+                    <a
+                      href={attachmentURL(a)}
+                      download
+                      key={a.url}
+                      title={attachmentTitle(a)}
+                    >
                       <Tag colorScheme="blackAlpha" variant="solid" maxW="10em">
                         <FontAwesomeIcon icon={faDownload} />
                         <TagLabel isTruncated>{a.name}</TagLabel>
@@ -93,6 +107,41 @@ const TaskModal = ({
                     </a>
                   ))}
                 </HStack>
+                {task.is_instanced && (
+                  <HStack>
+                    {task.instance ? (
+                      <>
+                        <Text>
+                          {task.instance.is_ready
+                            ? `${task.instance.host}:${task.instance.port}`
+                            : "starting..."}{" "}
+                          (until {dateFormat(task.instance.expires_at)})
+                        </Text>
+                        <Button
+                          size="sm"
+                          onClick={() => onInstanceAction("extend")}
+                        >
+                          Extend
+                        </Button>
+                        <Button
+                          size="sm"
+                          colorScheme="red"
+                          onClick={() => onInstanceAction("stop")}
+                        >
+                          Stop
+                        </Button>
+                      </>
+                    ) : (
+                      <Button
+                        size="sm"
+                        colorScheme="blue"
+                        onClick={() => onInstanceAction("start")}
+                      >
+                        Start your instance
+                      </Button>
+                    )}
+                  </HStack>
+                )}
                 {task.author && <Right>author: {task.author}</Right>}
                 <form onSubmit={onFlagSubmit}>
                   <HStack>